@@ -0,0 +1,23 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"pashmina-backend/services"
+)
+
+// Prints a new VAPID key pair for browser push notifications
+func main() {
+	publicKey, privateKey, err := services.GenerateVAPIDKeys()
+	if err != nil {
+		log.Fatalf("Failed to generate VAPID keys: %v", err)
+	}
+
+	fmt.Println("Add these to backend/.env:")
+	fmt.Printf("VAPID_PUBLIC_KEY=%s\n", publicKey)
+	fmt.Printf("VAPID_PRIVATE_KEY=%s\n", privateKey)
+	fmt.Println()
+	fmt.Println("And the public key to frontend/.env.local:")
+	fmt.Printf("NEXT_PUBLIC_VAPID_PUBLIC_KEY=%s\n", publicKey)
+}
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"pashmina-backend/config"
+	"pashmina-backend/models"
+	"pashmina-backend/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// auditEntry starts an audit entry for an action taken in this request
+func auditEntry(c *gin.Context) *models.AuditLog {
+	entry := &models.AuditLog{
+		IPAddress: c.ClientIP(),
+		RequestID: c.GetString("request_id"),
+	}
+	if userID, exists := c.Get("user_id"); exists {
+		id := userID.(uint)
+		entry.ActorID = &id
+	}
+	return entry
+}
+
+// GetAuditLog lists admin actions, newest first, optionally for one entity, action
+// or actor
+func GetAuditLog(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	page, limit = utils.ValidatePagination(page, limit)
+	offset := (page - 1) * limit
+
+	query := config.DB.Model(&models.AuditLog{})
+	if entityType := c.Query("entity_type"); entityType != "" {
+		query = query.Where("entity_type = ?", entityType)
+	}
+	if entityID := c.Query("entity_id"); entityID != "" {
+		query = query.Where("entity_id = ?", entityID)
+	}
+	if action := c.Query("action"); action != "" {
+		query = query.Where("action = ?", action)
+	}
+	if actorID := c.Query("actor_id"); actorID != "" {
+		query = query.Where("actor_id = ?", actorID)
+	}
+
+	var total int64
+	query.Count(&total)
+
+	var logs []models.AuditLog
+	query.Order("created_at DESC, id DESC").Offset(offset).Limit(limit).Find(&logs)
+
+	c.JSON(http.StatusOK, gin.H{
+		"logs":  logs,
+		"total": total,
+		"page":  page,
+		"limit": limit,
+	})
+}
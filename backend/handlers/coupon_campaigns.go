@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"pashmina-backend/config"
+	"pashmina-backend/models"
+	"pashmina-backend/services"
+	"pashmina-backend/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// campaignResponse is a campaign with the usage of its codes
+type campaignResponse struct {
+	models.CouponCampaign
+	Stats services.CampaignStats `json:"stats"`
+}
+
+// CreateCouponCampaign generates a batch of single-use coupon codes from a
+// template, all sharing the discount and rules in coupon
+func CreateCouponCampaign(c *gin.Context) {
+	var input struct {
+		Name        string        `json:"name" binding:"required"`
+		Description string        `json:"description"`
+		Template    string        `json:"template" binding:"required"`
+		Count       int           `json:"count" binding:"required"`
+		Coupon      models.Coupon `json:"coupon"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	template, err := services.NormalizeCodeTemplate(input.Template)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateCouponTerms(&input.Coupon); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	audit := auditEntry(c)
+	campaign := models.CouponCampaign{
+		Name:        utils.SanitizeString(input.Name, 200),
+		Description: utils.SanitizeString(input.Description, 1000),
+		Template:    template,
+		CreatedBy:   audit.ActorID,
+	}
+	err = services.CreateCouponCampaign(config.DB, &campaign, input.Coupon, input.Count, audit)
+	switch {
+	case errors.Is(err, services.ErrCampaignCodeCount), errors.Is(err, services.ErrCodeTemplateTooSmall):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		utils.Error("Failed to create coupon campaign", map[string]interface{}{"error": err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create coupon campaign"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, campaignResponse{
+		CouponCampaign: campaign,
+		Stats:          services.CampaignStats{Codes: int64(campaign.CodeCount)},
+	})
+}
+
+// GetCouponCampaigns lists campaigns, newest first, with the usage of their codes
+func GetCouponCampaigns(c *gin.Context) {
+	var campaigns []models.CouponCampaign
+	if err := config.DB.Order("created_at DESC").Find(&campaigns).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load coupon campaigns"})
+		return
+	}
+
+	ids := make([]uint, len(campaigns))
+	for i, campaign := range campaigns {
+		ids[i] = campaign.ID
+	}
+	stats, err := services.CampaignStatistics(config.DB, ids)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load coupon campaigns"})
+		return
+	}
+
+	response := make([]campaignResponse, len(campaigns))
+	for i, campaign := range campaigns {
+		response[i] = campaignResponse{CouponCampaign: campaign, Stats: stats[campaign.ID]}
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// GetCouponCampaign returns a campaign with the usage of its codes
+func GetCouponCampaign(c *gin.Context) {
+	campaign, ok := findCouponCampaign(c)
+	if !ok {
+		return
+	}
+
+	stats, err := services.CampaignStatistics(config.DB, []uint{campaign.ID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load coupon campaign"})
+		return
+	}
+	c.JSON(http.StatusOK, campaignResponse{CouponCampaign: campaign, Stats: stats[campaign.ID]})
+}
+
+// ExportCouponCampaign downloads a campaign's codes as CSV
+func ExportCouponCampaign(c *gin.Context) {
+	campaign, ok := findCouponCampaign(c)
+	if !ok {
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := services.ExportCampaignCodes(config.DB, &buf, campaign.ID); err != nil {
+		utils.Error("Failed to export coupon campaign", map[string]interface{}{"campaign_id": campaign.ID, "error": err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export coupon codes"})
+		return
+	}
+
+	filename := fmt.Sprintf("coupon-campaign-%d.csv", campaign.ID)
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+	c.Data(http.StatusOK, "text/csv; charset=utf-8", buf.Bytes())
+}
+
+func findCouponCampaign(c *gin.Context) (models.CouponCampaign, bool) {
+	var campaign models.CouponCampaign
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid campaign ID"})
+		return campaign, false
+	}
+	if err := config.DB.First(&campaign, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Coupon campaign not found"})
+		return campaign, false
+	}
+	return campaign, true
+}
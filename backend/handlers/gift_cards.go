@@ -0,0 +1,574 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"pashmina-backend/config"
+	"pashmina-backend/models"
+	"pashmina-backend/services"
+	"pashmina-backend/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// giftCardErrors are the reasons a gift card can't be spent, told to the customer
+var giftCardErrors = []error{
+	services.ErrGiftCardNotFound,
+	services.ErrGiftCardInactive,
+	services.ErrGiftCardExpired,
+	services.ErrGiftCardEmpty,
+}
+
+func isGiftCardError(err error) bool {
+	for _, target := range giftCardErrors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// payWithCredit pays what it can of a new order with a gift card and then store
+// credit, returning the status to respond with when it fails
+func payWithCredit(tx *gorm.DB, order *models.Order, giftCardCode string, useStoreCredit bool) (int, error) {
+	if strings.TrimSpace(giftCardCode) != "" {
+		if err := services.ApplyGiftCard(tx, order, giftCardCode); err != nil {
+			if isGiftCardError(err) {
+				return http.StatusBadRequest, err
+			}
+			return http.StatusInternalServerError, errors.New("Failed to apply gift card")
+		}
+	}
+	if useStoreCredit {
+		if err := services.ApplyStoreCredit(tx, order); err != nil {
+			return http.StatusInternalServerError, errors.New("Failed to apply store credit")
+		}
+	}
+	if order.GiftCardAmount == 0 && order.StoreCreditAmount == 0 {
+		return 0, nil
+	}
+
+	if err := tx.Model(order).Updates(map[string]interface{}{
+		"gift_card_id":        order.GiftCardID,
+		"gift_card_amount":    order.GiftCardAmount,
+		"store_credit_amount": order.StoreCreditAmount,
+	}).Error; err != nil {
+		return http.StatusInternalServerError, errors.New("Failed to create order")
+	}
+	return 0, nil
+}
+
+// payOrderInFull marks a new order paid when gift card and store credit cover all
+// of it
+func payOrderInFull(tx *gorm.DB, order *models.Order) error {
+	if err := services.CommitReservations(tx, order.ID); err != nil {
+		return err
+	}
+	if err := services.TransitionOrder(tx, order, "paid", services.StatusChange{
+		Source:  services.StatusSourceCustomer,
+		ActorID: &order.UserID,
+		Reason:  "Paid with gift card and store credit",
+	}); err != nil {
+		return err
+	}
+	order.PaymentStatus = "paid"
+	return tx.Model(order).Updates(map[string]interface{}{
+		"status":         order.Status,
+		"payment_status": order.PaymentStatus,
+	}).Error
+}
+
+// settleOrderCredit takes back credit an expired order released and issues the gift
+// cards bought with it, once the order is paid
+func settleOrderCredit(tx *gorm.DB, order *models.Order) ([]models.GiftCard, error) {
+	if err := services.ReclaimOrderCredit(tx, order); err != nil {
+		return nil, err
+	}
+	return services.ActivateOrderGiftCards(tx, order)
+}
+
+// sendGiftCards emails newly issued gift cards to their recipients
+func sendGiftCards(cards []models.GiftCard, order *models.Order) {
+	for i := range cards {
+		services.SendGiftCard(config.DB, &cards[i], order.ShippingName)
+	}
+}
+
+// PurchaseGiftCard creates a gift card and the order that pays for it. The card
+// is issued and sent to its recipient once the order is paid through Razorpay.
+func PurchaseGiftCard(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	uid := userID.(uint)
+
+	var input struct {
+		Amount         float64 `json:"amount" binding:"required"`
+		RecipientName  string  `json:"recipient_name" binding:"required"`
+		RecipientEmail string  `json:"recipient_email" binding:"required"`
+		Message        string  `json:"message"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	amount := services.RoundMoney(input.Amount)
+	if amount < services.GiftCardMinAmount() || amount > services.GiftCardMaxAmount() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Gift cards can be worth between %.2f and %.2f",
+			services.GiftCardMinAmount(), services.GiftCardMaxAmount())})
+		return
+	}
+	if err := utils.ValidateEmail(input.RecipientEmail); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	recipientName := utils.SanitizeString(input.RecipientName, 100)
+	if err := utils.ValidateName(recipientName); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := config.DB.First(&user, uid).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	order := models.Order{
+		UserID:        uid,
+		Status:        "pending_payment",
+		Subtotal:      amount,
+		TotalAmount:   amount,
+		Currency:      "INR",
+		ShippingName:  user.Name,
+		ShippingEmail: user.Email,
+		ShippingPhone: user.Phone,
+		Notes:         "Gift card for " + recipientName,
+	}
+	card := models.GiftCard{
+		InitialValue:   amount,
+		Currency:       "INR",
+		Status:         services.GiftCardPending,
+		PurchaserID:    &uid,
+		RecipientName:  recipientName,
+		RecipientEmail: strings.TrimSpace(input.RecipientEmail),
+		Message:        utils.SanitizeString(input.Message, 500),
+	}
+
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&order).Error; err != nil {
+			return err
+		}
+		code, err := services.NewGiftCardCode(tx)
+		if err != nil {
+			return err
+		}
+		card.Code = code
+		card.OrderID = &order.ID
+		if err := tx.Create(&card).Error; err != nil {
+			return err
+		}
+		return services.RecordOrderStatus(tx, order.ID, "", order.Status, services.StatusChange{
+			Source:  services.StatusSourceCustomer,
+			ActorID: &uid,
+			Reason:  "Gift card ordered",
+		})
+	})
+	if err != nil {
+		utils.Error("Failed to create gift card order", map[string]interface{}{"user_id": uid, "error": err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create gift card order"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"order_id":   order.ID,
+		"status":     order.Status,
+		"amount_due": services.AmountDue(&order),
+		"gift_card":  card,
+		"message":    "Gift card order created. Complete payment to send it.",
+	})
+}
+
+// GetMyGiftCards lists the gift cards the customer has bought
+func GetMyGiftCards(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	uid := userID.(uint)
+
+	var cards []models.GiftCard
+	if err := config.DB.Where("purchaser_id = ?", uid).Order("created_at DESC").Find(&cards).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load gift cards"})
+		return
+	}
+	c.JSON(http.StatusOK, cards)
+}
+
+// CheckGiftCard returns what is left on a gift card. The code is posted rather
+// than put in the URL so it stays out of logs.
+func CheckGiftCard(c *gin.Context) {
+	var input struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	card, err := services.CheckGiftCard(config.DB, input.Code)
+	if errors.Is(err, services.ErrGiftCardNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil && !isGiftCardError(err) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check gift card"})
+		return
+	}
+
+	response := gin.H{
+		"valid":      err == nil,
+		"balance":    card.Balance,
+		"currency":   card.Currency,
+		"expires_at": card.ExpiresAt,
+	}
+	if err != nil {
+		response["error"] = err.Error()
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// GetStoreCredit returns the customer's store credit and their latest ledger
+// entries
+func GetStoreCredit(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	uid := userID.(uint)
+	respondStoreCredit(c, uid)
+}
+
+func respondStoreCredit(c *gin.Context, userID uint) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	_, limit = utils.ValidatePagination(1, limit)
+
+	balance, err := services.StoreCreditBalance(config.DB, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load store credit"})
+		return
+	}
+	entries, err := services.StoreCreditLedger(config.DB, userID, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load store credit"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"balance": balance, "entries": entries})
+}
+
+// GetGiftCards lists gift cards, newest first, optionally by status or code
+func GetGiftCards(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	page, limit = utils.ValidatePagination(page, limit)
+
+	query := config.DB.Model(&models.GiftCard{})
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if code := c.Query("code"); code != "" {
+		query = query.Where("code LIKE ?", services.NormalizeGiftCardCode(code)+"%")
+	}
+
+	var total int64
+	query.Count(&total)
+
+	var cards []models.GiftCard
+	if err := query.Order("created_at DESC").Offset((page - 1) * limit).Limit(limit).Find(&cards).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load gift cards"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"gift_cards": cards, "total": total, "page": page, "limit": limit})
+}
+
+// GetGiftCard returns a gift card with its ledger
+func GetGiftCard(c *gin.Context) {
+	var card models.GiftCard
+	if err := config.DB.First(&card, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Gift card not found"})
+		return
+	}
+	entries, err := services.GiftCardLedger(config.DB, card.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load gift card"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"gift_card": card, "entries": entries})
+}
+
+// IssueGiftCard issues a gift card on the shop's behalf, such as for a goodwill
+// gesture or a giveaway
+func IssueGiftCard(c *gin.Context) {
+	var input struct {
+		Amount         float64    `json:"amount" binding:"required"`
+		RecipientName  string     `json:"recipient_name"`
+		RecipientEmail string     `json:"recipient_email"`
+		Message        string     `json:"message"`
+		ExpiresAt      *time.Time `json:"expires_at"`
+		Reason         string     `json:"reason" binding:"required"`
+		Send           bool       `json:"send"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	amount := services.RoundMoney(input.Amount)
+	reason := utils.SanitizeString(input.Reason, 500)
+	if amount <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "amount must be greater than 0"})
+		return
+	}
+	if reason == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "reason is required"})
+		return
+	}
+	if input.ExpiresAt != nil && !input.ExpiresAt.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expires_at must be in the future"})
+		return
+	}
+	if input.RecipientEmail != "" {
+		if err := utils.ValidateEmail(input.RecipientEmail); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	} else if input.Send {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "recipient_email is required to send the gift card"})
+		return
+	}
+
+	audit := auditEntry(c)
+	card := models.GiftCard{
+		InitialValue:   amount,
+		Currency:       "INR",
+		ExpiresAt:      input.ExpiresAt,
+		IssuedBy:       audit.ActorID,
+		RecipientName:  utils.SanitizeString(input.RecipientName, 100),
+		RecipientEmail: strings.TrimSpace(input.RecipientEmail),
+		Message:        utils.SanitizeString(input.Message, 500),
+	}
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := services.IssueGiftCard(tx, &card, services.LedgerChange{Reason: reason, ActorID: audit.ActorID}); err != nil {
+			return err
+		}
+		return services.RecordAudit(tx, audit, "gift_card.issue", "gift_card", card.ID, models.JSONB{
+			"amount": amount,
+			"reason": reason,
+		})
+	})
+	if err != nil {
+		utils.Error("Failed to issue gift card", map[string]interface{}{"error": err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue gift card"})
+		return
+	}
+
+	if input.Send {
+		services.SendGiftCard(config.DB, &card, "Pashmiya")
+	}
+	c.JSON(http.StatusCreated, card)
+}
+
+// ledgerAdjustment is an admin's change to a store credit or gift card balance
+type ledgerAdjustment struct {
+	Amount float64 `json:"amount" binding:"required"`
+	Reason string  `json:"reason" binding:"required"`
+}
+
+// bindAdjustment reads an adjustment, which needs a non-zero amount and a reason
+func bindAdjustment(c *gin.Context) (ledgerAdjustment, bool) {
+	var input ledgerAdjustment
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return input, false
+	}
+	input.Amount = services.RoundMoney(input.Amount)
+	input.Reason = utils.SanitizeString(input.Reason, 500)
+	if input.Amount == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "amount must not be 0"})
+		return input, false
+	}
+	if input.Reason == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "reason is required"})
+		return input, false
+	}
+	return input, true
+}
+
+// AdjustGiftCard adds to or takes from a gift card's balance
+func AdjustGiftCard(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid gift card ID"})
+		return
+	}
+	input, ok := bindAdjustment(c)
+	if !ok {
+		return
+	}
+
+	audit := auditEntry(c)
+	var entry *models.GiftCardEntry
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		entry, err = services.AdjustGiftCard(tx, uint(id), services.LedgerChange{
+			Amount:  input.Amount,
+			Kind:    services.LedgerAdjustment,
+			Reason:  input.Reason,
+			ActorID: audit.ActorID,
+		})
+		if err != nil {
+			return err
+		}
+		return services.RecordAudit(tx, audit, "gift_card.adjust", "gift_card", uint(id), models.JSONB{
+			"amount":        input.Amount,
+			"balance_after": entry.BalanceAfter,
+			"reason":        input.Reason,
+		})
+	})
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Gift card not found"})
+		return
+	case errors.Is(err, services.ErrInsufficientBalance):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to adjust gift card"})
+		return
+	}
+
+	c.JSON(http.StatusOK, entry)
+}
+
+// UpdateGiftCardStatus disables a gift card, such as one reported stolen, or
+// enables it again
+func UpdateGiftCardStatus(c *gin.Context) {
+	var input struct {
+		Status string `json:"status" binding:"required"`
+		Reason string `json:"reason" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if input.Status != services.GiftCardActive && input.Status != services.GiftCardDisabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be active or disabled"})
+		return
+	}
+	reason := utils.SanitizeString(input.Reason, 500)
+	if reason == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "reason is required"})
+		return
+	}
+
+	audit := auditEntry(c)
+	var card models.GiftCard
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&card, c.Param("id")).Error; err != nil {
+			return err
+		}
+		if card.Status != services.GiftCardActive && card.Status != services.GiftCardDisabled {
+			return services.ErrGiftCardInactive
+		}
+		from := card.Status
+		if err := tx.Model(&card).Update("status", input.Status).Error; err != nil {
+			return err
+		}
+		return services.RecordAudit(tx, audit, "gift_card.status", "gift_card", card.ID, models.JSONB{
+			"from":   from,
+			"to":     input.Status,
+			"reason": reason,
+		})
+	})
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Gift card not found"})
+		return
+	case errors.Is(err, services.ErrGiftCardInactive):
+		c.JSON(http.StatusConflict, gin.H{"error": "Only issued gift cards can be enabled or disabled"})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update gift card"})
+		return
+	}
+
+	c.JSON(http.StatusOK, card)
+}
+
+// GetUserStoreCredit returns a customer's store credit and ledger
+func GetUserStoreCredit(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	respondStoreCredit(c, uint(id))
+}
+
+// AdjustStoreCredit adds to or takes from a customer's store credit
+func AdjustStoreCredit(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	input, ok := bindAdjustment(c)
+	if !ok {
+		return
+	}
+
+	audit := auditEntry(c)
+	var entry *models.StoreCreditEntry
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		entry, err = services.AddStoreCredit(tx, uint(id), services.LedgerChange{
+			Amount:  input.Amount,
+			Kind:    services.LedgerAdjustment,
+			Reason:  input.Reason,
+			ActorID: audit.ActorID,
+		})
+		if err != nil {
+			return err
+		}
+		return services.RecordAudit(tx, audit, "store_credit.adjust", "user", uint(id), models.JSONB{
+			"amount":        input.Amount,
+			"balance_after": entry.BalanceAfter,
+			"reason":        input.Reason,
+		})
+	})
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	case errors.Is(err, services.ErrInsufficientBalance):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to adjust store credit"})
+		return
+	}
+
+	c.JSON(http.StatusOK, entry)
+}
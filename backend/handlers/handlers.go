@@ -1,24 +1,56 @@
 package handlers
 
 import (
-	"fmt"
+	"encoding/json"
+	"errors"
 	"net/http"
+	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"pashmina-backend/config"
 	"pashmina-backend/models"
+	"pashmina-backend/services"
 	"pashmina-backend/utils"
 
 	"github.com/gin-gonic/gin"
 	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 )
 
+// GetProducts lists the products on the storefront with the promotions running on
+// each, and their sale prices
 func GetProducts(c *gin.Context) {
-	var products []models.Product
+	listProducts(c, services.VisibleProducts(config.DB.Preload("Category"), time.Now()), true)
+}
+
+// GetAdminProducts lists every product whatever its status, optionally only those
+// with ?status=draft, published or archived
+func GetAdminProducts(c *gin.Context) {
 	query := config.DB.Preload("Category")
+	if status := c.Query("status"); status != "" {
+		if err := services.ValidateProductStatus(status, nil, nil); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		query = query.Where("products.status = ?", status)
+	}
+	listProducts(c, query, false)
+}
+
+// listProducts pages through the products of query with the category, featured
+// and sort parameters applied, and the promotions running on them when offers is set
+func listProducts(c *gin.Context, query *gorm.DB, offers bool) {
+	var products []models.Product
 
 	if category := c.Query("category"); category != "" {
-		query = query.Where("category_id = ?", category)
+		categoryID, err := resolveCategoryParam(category)
+		if err != nil {
+			respondCategoryError(c, err)
+			return
+		}
+		query = services.InCategory(query, categoryID)
 	}
 
 	if featured := c.Query("featured"); featured == "true" {
@@ -51,14 +83,26 @@ func GetProducts(c *gin.Context) {
 
 	query.Offset(offset).Limit(limit).Find(&products)
 
+	var listed interface{} = products
+	if offers {
+		withPromotions, err := withOffers(config.DB, products)
+		if err != nil {
+			utils.Error("Failed to load promotions", map[string]interface{}{"error": err.Error()})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load products"})
+			return
+		}
+		listed = withPromotions
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"products": products,
+		"products": listed,
 		"total":    total,
 		"page":     page,
 		"limit":    limit,
 	})
 }
 
+// GetProduct returns a product on the storefront with the promotions running on it
 func GetProduct(c *gin.Context) {
 	idStr := c.Param("id")
 	id, err := strconv.Atoi(idStr)
@@ -68,52 +112,80 @@ func GetProduct(c *gin.Context) {
 	}
 	var product models.Product
 
-	if err := config.DB.Preload("Category").First(&product, id).Error; err != nil {
+	if err := services.VisibleProducts(config.DB, time.Now()).
+		Preload("Category").Preload("Variants", "is_active = ?", true).
+		Preload("Images", func(db *gorm.DB) *gorm.DB { return db.Order("position ASC, id ASC") }).
+		First(&product, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
 		return
 	}
-	c.JSON(http.StatusOK, product)
+	listed, err := withOffers(config.DB, []models.Product{product})
+	if err != nil {
+		utils.Error("Failed to load promotions", map[string]interface{}{"product_id": product.ID, "error": err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load product"})
+		return
+	}
+	c.Header("ETag", utils.VersionTag(product.UpdatedAt))
+	c.JSON(http.StatusOK, listed[0])
 }
 
-func GetFilterOptions(c *gin.Context) {
-	var products []models.Product
-	config.DB.Find(&products)
+// PreviewProduct shows admins a product as the storefront would, whatever its
+// status, with whether and why it is hidden. Inactive variants are included.
+func PreviewProduct(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		return
+	}
+	var product models.Product
 
-	colorSet := make(map[string]bool)
-	for _, p := range products {
-		for _, color := range p.Colors {
-			colorSet[color] = true
-		}
+	if err := config.DB.Preload("Category").Preload("Variants", func(db *gorm.DB) *gorm.DB { return db.Order("id ASC") }).
+		Preload("Images", func(db *gorm.DB) *gorm.DB { return db.Order("position ASC, id ASC") }).
+		First(&product, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
 	}
-	colors := []string{}
-	for color := range colorSet {
-		colors = append(colors, color)
+	visibility, err := services.CheckProductVisibility(config.DB, &product, time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load product"})
+		return
 	}
+	c.Header("ETag", utils.VersionTag(product.UpdatedAt))
+	c.JSON(http.StatusOK, struct {
+		*models.Product
+		Visibility services.ProductVisibility `json:"visibility"`
+	}{&product, visibility})
+}
 
-	sizeSet := make(map[string]bool)
-	for _, p := range products {
-		for _, size := range p.Sizes {
-			sizeSet[size] = true
-		}
+// GetFilterOptions returns the colors, sizes, categories and price ranges of active
+// products with how many products each would show. It takes the same q and filter
+// parameters as SearchProducts, so counts follow the listing being filtered.
+func GetFilterOptions(c *gin.Context) {
+	params, err := searchParamsFromQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
-	sizes := []string{}
-	for size := range sizeSet {
-		sizes = append(sizes, size)
+	params.Query = utils.SanitizeString(c.Query("q"), 200)
+
+	facets, err := services.ProductFacets(config.DB, params)
+	if err != nil {
+		utils.Error("Failed to compute filter options", map[string]interface{}{"error": err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load filters"})
+		return
 	}
 
-	minPrice := 0.0
-	maxPrice := 2000.0
-	if len(products) > 0 {
-		minPrice = products[0].Price
-		maxPrice = products[0].Price
-		for _, p := range products {
-			if p.Price < minPrice {
-				minPrice = p.Price
-			}
-			if p.Price > maxPrice {
-				maxPrice = p.Price
-			}
-		}
+	colors := make([]string, len(facets.Colors))
+	for i, color := range facets.Colors {
+		colors[i] = color.Value
+	}
+	sizes := make([]string, len(facets.Sizes))
+	for i, size := range facets.Sizes {
+		sizes[i] = size.Value
+	}
+	minPrice, maxPrice := facets.MinPrice, facets.MaxPrice
+	if len(facets.PriceBuckets) == 0 {
+		minPrice, maxPrice = 0, 2000
 	}
 
 	c.JSON(http.StatusOK, gin.H{
@@ -121,33 +193,110 @@ func GetFilterOptions(c *gin.Context) {
 		"sizes":     sizes,
 		"min_price": minPrice,
 		"max_price": maxPrice,
+		"facets":    facets,
 	})
 }
 
+// SearchProducts returns active products ranked by how well they match q, with
+// highlighted snippets. Category, price range, color, size and in_stock filters are
+// applied in the same query.
 func SearchProducts(c *gin.Context) {
-	query := c.Query("q")
+	query := utils.SanitizeString(c.Query("q"), 200)
 	if query == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Search query required"})
 		return
 	}
 
-	var products []models.Product
-	config.DB.Where("name ILIKE ? OR description ILIKE ?", "%"+query+"%", "%"+query+"%").Preload("Category").Find(&products)
-	c.JSON(http.StatusOK, products)
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	page, limit = utils.ValidatePagination(page, limit)
+
+	params, err := searchParamsFromQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	params.Query = query
+	params.Page, params.Limit = page, limit
+
+	results, err := services.SearchProducts(config.DB, params)
+	if err != nil {
+		utils.Error("Product search failed", map[string]interface{}{"query": query, "error": err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Search failed"})
+		return
+	}
+
+	// Count each search once, not once per page
+	if page == 1 {
+		go services.LogSearch(config.DB, query, results.Total, results.Fuzzy)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"products": results.Hits,
+		"total":    results.Total,
+		"page":     page,
+		"limit":    limit,
+		"query":    query,
+		"fuzzy":    results.Fuzzy,
+	})
+}
+
+// searchParamsFromQuery reads the category, price, color, size and in_stock filters
+// shared by product search and filter options
+func searchParamsFromQuery(c *gin.Context) (services.SearchParams, error) {
+	params := services.SearchParams{
+		Colors:  queryList(c, "color"),
+		Sizes:   queryList(c, "size"),
+		InStock: c.Query("in_stock") == "true",
+	}
+
+	if category := c.Query("category"); category != "" {
+		id, err := resolveCategoryParam(category)
+		if err != nil {
+			return params, errors.New("Invalid category")
+		}
+		params.CategoryID = id
+	}
+	for key, target := range map[string]**float64{"min_price": &params.MinPrice, "max_price": &params.MaxPrice} {
+		if v := c.Query(key); v != "" {
+			price, err := strconv.ParseFloat(v, 64)
+			if err != nil || price < 0 {
+				return params, errors.New("Invalid " + key)
+			}
+			*target = &price
+		}
+	}
+	return params, nil
+}
+
+// queryList reads a filter given either as repeated parameters or comma-separated
+func queryList(c *gin.Context, key string) []string {
+	var values []string
+	for _, raw := range c.QueryArray(key) {
+		for _, v := range strings.Split(raw, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+	}
+	return values
 }
 
 func CreateProduct(c *gin.Context) {
 	var input struct {
-		Name        string   `json:"name" binding:"required"`
-		Price       float64  `json:"price" binding:"required"`
-		Description string   `json:"description"`
-		Image       string   `json:"image"`
-		CategoryID  uint     `json:"category_id" binding:"required"`
-		Colors      []string `json:"colors"`
-		Sizes       []string `json:"sizes"`
-		Stock       int      `json:"stock"`
-		IsFeatured  bool     `json:"is_featured"`
-		IsActive    bool     `json:"is_active"`
+		SKU         string     `json:"sku"`
+		Name        string     `json:"name" binding:"required"`
+		Price       float64    `json:"price" binding:"required"`
+		Description string     `json:"description"`
+		Image       string     `json:"image"`
+		CategoryID  uint       `json:"category_id" binding:"required"`
+		Colors      []string   `json:"colors"`
+		Sizes       []string   `json:"sizes"`
+		Stock       int        `json:"stock"`
+		IsFeatured  bool       `json:"is_featured"`
+		Status      string     `json:"status"`
+		PublishAt   *time.Time `json:"publish_at"`
+		UnpublishAt *time.Time `json:"unpublish_at"`
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -175,6 +324,29 @@ func CreateProduct(c *gin.Context) {
 		return
 	}
 
+	if input.Status == "" {
+		input.Status = services.ProductPublished
+	}
+	if err := services.ValidateProductStatus(input.Status, input.PublishAt, input.UnpublishAt); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var sku *string
+	if input.SKU = strings.ToUpper(strings.TrimSpace(input.SKU)); input.SKU != "" {
+		if err := utils.ValidateSKU(input.SKU); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		var count int64
+		config.DB.Unscoped().Model(&models.Product{}).Where("sku = ?", input.SKU).Count(&count)
+		if count > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "A product with this SKU already exists"})
+			return
+		}
+		sku = &input.SKU
+	}
+
 	var category models.Category
 	if err := config.DB.First(&category, input.CategoryID).Error; err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Category not found"})
@@ -182,6 +354,7 @@ func CreateProduct(c *gin.Context) {
 	}
 
 	product := models.Product{
+		SKU:         sku,
 		Name:        utils.SanitizeString(input.Name, 255),
 		Price:       input.Price,
 		Description: utils.SanitizeString(input.Description, 2000),
@@ -191,7 +364,9 @@ func CreateProduct(c *gin.Context) {
 		Sizes:       input.Sizes,
 		Stock:       input.Stock,
 		IsFeatured:  input.IsFeatured,
-		IsActive:    input.IsActive,
+		Status:      input.Status,
+		PublishAt:   input.PublishAt,
+		UnpublishAt: input.UnpublishAt,
 	}
 
 	if err := config.DB.Create(&product).Error; err != nil {
@@ -200,10 +375,92 @@ func CreateProduct(c *gin.Context) {
 	}
 
 	utils.Info("Product created", map[string]interface{}{"product_id": product.ID, "name": product.Name})
+	services.Suggestions(config.DB).Refresh()
+	services.InvalidateFacets()
 
 	c.JSON(http.StatusCreated, product)
 }
 
+type productPatch struct {
+	patchVersion
+	SKU         utils.PatchField[string]             `json:"sku"`
+	Name        utils.PatchField[string]             `json:"name"`
+	Price       utils.PatchField[float64]            `json:"price"`
+	Description utils.PatchField[string]             `json:"description"`
+	Image       utils.PatchField[string]             `json:"image"`
+	CategoryID  utils.PatchField[uint]               `json:"category_id"`
+	Colors      utils.PatchField[models.StringArray] `json:"colors"`
+	Sizes       utils.PatchField[models.StringArray] `json:"sizes"`
+	Stock       utils.PatchField[int]                `json:"stock"`
+	IsFeatured  utils.PatchField[bool]               `json:"is_featured"`
+	Status      utils.PatchField[string]             `json:"status"`
+	PublishAt   utils.PatchField[time.Time]          `json:"publish_at"`
+	UnpublishAt utils.PatchField[time.Time]          `json:"unpublish_at"`
+}
+
+// validate checks every field the patch sets the way CreateProduct does. The
+// status and publishing window are checked as they will be after the patch.
+func (p *productPatch) validate(current *models.Product) error {
+	if err := firstError(p.Name.Required("name"), p.Price.Required("price"), p.CategoryID.Required("category_id"),
+		p.Stock.Required("stock"), p.IsFeatured.Required("is_featured"), p.Status.Required("status")); err != nil {
+		return err
+	}
+	if err := services.ValidateProductStatus(patched(p.Status, current.Status),
+		patchedTime(p.PublishAt, current.PublishAt), patchedTime(p.UnpublishAt, current.UnpublishAt)); err != nil {
+		return err
+	}
+	if p.SKU.Present() {
+		// An empty SKU clears it, as it does on create
+		if p.SKU.Value = strings.ToUpper(strings.TrimSpace(p.SKU.Value)); p.SKU.Value == "" {
+			p.SKU.Null = true
+		} else if err := utils.ValidateSKU(p.SKU.Value); err != nil {
+			return err
+		}
+	}
+	if p.Name.Present() {
+		if err := utils.ValidateProductName(p.Name.Value); err != nil {
+			return err
+		}
+		p.Name.Value = utils.SanitizeString(p.Name.Value, 255)
+	}
+	if p.Price.Present() {
+		if err := utils.ValidatePrice(p.Price.Value); err != nil {
+			return err
+		}
+	}
+	if p.Stock.Present() {
+		if err := utils.ValidateStock(p.Stock.Value); err != nil {
+			return err
+		}
+	}
+	if p.Image.Present() {
+		if err := utils.ValidateURL(p.Image.Value); err != nil {
+			return err
+		}
+	}
+	p.Description.Value = utils.SanitizeString(p.Description.Value, 2000)
+	return nil
+}
+
+func (p *productPatch) updates() map[string]interface{} {
+	updates := map[string]interface{}{}
+	setField(updates, "sku", p.SKU, nil)
+	setField(updates, "name", p.Name, nil)
+	setField(updates, "price", p.Price, nil)
+	setField(updates, "description", p.Description, "")
+	setField(updates, "image", p.Image, "")
+	setField(updates, "category_id", p.CategoryID, nil)
+	setField(updates, "colors", p.Colors, models.StringArray{})
+	setField(updates, "sizes", p.Sizes, models.StringArray{})
+	setField(updates, "stock", p.Stock, nil)
+	setField(updates, "is_featured", p.IsFeatured, nil)
+	setField(updates, "status", p.Status, nil)
+	setField(updates, "publish_at", p.PublishAt, nil)
+	setField(updates, "unpublish_at", p.UnpublishAt, nil)
+	return updates
+}
+
+// UpdateProduct applies a merge patch to a product
 func UpdateProduct(c *gin.Context) {
 	idStr := c.Param("id")
 	id, err := strconv.Atoi(idStr)
@@ -213,19 +470,54 @@ func UpdateProduct(c *gin.Context) {
 	}
 	var product models.Product
 
-	if err := config.DB.First(&product, id).Error; err != nil {
+	if err := config.DB.Preload("Category").First(&product, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
 		return
 	}
 
-	var updates map[string]interface{}
-	if err := c.ShouldBindJSON(&updates); err != nil {
+	var patch productPatch
+	if !bindPatch(c, &patch) {
+		return
+	}
+	conditional, ok := checkVersion(c, patch.patchVersion, product.UpdatedAt, product)
+	if !ok {
+		return
+	}
+	if err := patch.validate(&product); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	config.DB.Model(&product).Updates(updates)
+	if patch.SKU.Present() {
+		var count int64
+		config.DB.Unscoped().Model(&models.Product{}).Where("sku = ? AND id <> ?", patch.SKU.Value, product.ID).Count(&count)
+		if count > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "A product with this SKU already exists"})
+			return
+		}
+	}
+	if patch.CategoryID.Present() {
+		var category models.Category
+		if err := config.DB.First(&category, patch.CategoryID.Value).Error; err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Category not found"})
+			return
+		}
+	}
+
+	err = savePatch(config.DB, &product, product.UpdatedAt, conditional, patch.updates())
 	config.DB.Preload("Category").First(&product, id)
+	if errors.Is(err, errRecordModified) {
+		respondModified(c, product.UpdatedAt, product)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update product"})
+		return
+	}
+
+	services.Suggestions(config.DB).Refresh()
+	services.InvalidateFacets()
+	c.Header("ETag", utils.VersionTag(product.UpdatedAt))
 	c.JSON(http.StatusOK, product)
 }
 
@@ -244,26 +536,218 @@ func DeleteProduct(c *gin.Context) {
 	}
 
 	config.DB.Delete(&product)
+	services.Suggestions(config.DB).Refresh()
+	services.InvalidateFacets()
 	c.JSON(http.StatusOK, gin.H{"message": "Product deleted"})
 }
 
 // Categories
+// GetCategories lists the categories on the storefront: active ones whose parents
+// are active too
 func GetCategories(c *gin.Context) {
 	var categories []models.Category
-	config.DB.Find(&categories)
+	services.VisibleCategories(config.DB).Order("sort_order ASC, name ASC").Find(&categories)
+	c.JSON(http.StatusOK, categories)
+}
+
+// GetAdminCategories lists every category, active or not, nested under their
+// parents with ?tree=true
+func GetAdminCategories(c *gin.Context) {
+	if tree, _ := strconv.ParseBool(c.Query("tree")); tree {
+		categories, err := services.CategoryTree(config.DB, false)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load categories"})
+			return
+		}
+		c.JSON(http.StatusOK, categories)
+		return
+	}
+	var categories []models.Category
+	config.DB.Order("sort_order ASC, name ASC").Find(&categories)
 	c.JSON(http.StatusOK, categories)
 }
 
+// GetCategoryTree returns the active categories nested under their parents
+func GetCategoryTree(c *gin.Context) {
+	tree, err := services.CategoryTree(config.DB, true)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load categories"})
+		return
+	}
+	c.JSON(http.StatusOK, tree)
+}
+
+// GetCategory returns an active category by slug with its subcategories and the
+// breadcrumb leading to it
+func GetCategory(c *gin.Context) {
+	category, err := services.CategoryBySlug(config.DB, c.Param("slug"))
+	if err != nil {
+		respondCategoryError(c, err)
+		return
+	}
+	breadcrumb, err := services.CategoryBreadcrumb(config.DB, category.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load category"})
+		return
+	}
+	c.Header("ETag", utils.VersionTag(category.UpdatedAt))
+	c.JSON(http.StatusOK, struct {
+		*models.Category
+		Breadcrumb []services.CategoryCrumb `json:"breadcrumb"`
+	}{category, breadcrumb})
+}
+
+// GetCategoryBreadcrumb returns the path from the top-level category down to the
+// category with the given slug
+func GetCategoryBreadcrumb(c *gin.Context) {
+	category, err := services.CategoryBySlug(config.DB, c.Param("slug"))
+	if err != nil {
+		respondCategoryError(c, err)
+		return
+	}
+	breadcrumb, err := services.CategoryBreadcrumb(config.DB, category.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load category"})
+		return
+	}
+	c.JSON(http.StatusOK, breadcrumb)
+}
+
 func CreateCategory(c *gin.Context) {
-	var category models.Category
-	if err := c.ShouldBindJSON(&category); err != nil {
+	var input struct {
+		Name        string `json:"name" binding:"required"`
+		Slug        string `json:"slug"`
+		Description string `json:"description"`
+		Image       string `json:"image"`
+		ParentID    *uint  `json:"parent_id"`
+		SortOrder   int    `json:"sort_order"`
+		IsActive    *bool  `json:"is_active"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	config.DB.Create(&category)
+
+	input.Name = utils.SanitizeString(input.Name, 100)
+	if err := utils.ValidateName(input.Name); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := utils.ValidateURL(input.Image); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var count int64
+	config.DB.Unscoped().Model(&models.Category{}).Where("name = ?", input.Name).Count(&count)
+	if count > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "A category with this name already exists"})
+		return
+	}
+
+	if input.ParentID != nil {
+		if err := services.ValidateCategoryParent(config.DB, 0, *input.ParentID); err != nil {
+			respondCategoryError(c, err)
+			return
+		}
+	}
+
+	slug := strings.ToLower(strings.TrimSpace(input.Slug))
+	if slug != "" {
+		if err := utils.ValidateSlug(slug); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := services.CheckCategorySlug(config.DB, slug, 0); err != nil {
+			respondCategoryError(c, err)
+			return
+		}
+	} else {
+		var err error
+		if slug, err = services.UniqueCategorySlug(config.DB, input.Name, input.ParentID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create category"})
+			return
+		}
+	}
+
+	category := models.Category{
+		Name:        input.Name,
+		Slug:        slug,
+		Description: utils.SanitizeString(input.Description, 2000),
+		Image:       input.Image,
+		ParentID:    input.ParentID,
+		SortOrder:   input.SortOrder,
+		IsActive:    true,
+	}
+	if err := config.DB.Create(&category).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create category"})
+		return
+	}
+	// Create skips false for columns that default to true
+	if input.IsActive != nil && !*input.IsActive {
+		config.DB.Model(&category).Update("is_active", false)
+	}
+
+	services.Suggestions(config.DB).Refresh()
+	services.InvalidateFacets()
 	c.JSON(http.StatusCreated, category)
 }
 
+type categoryPatch struct {
+	patchVersion
+	Name        utils.PatchField[string] `json:"name"`
+	Slug        utils.PatchField[string] `json:"slug"`
+	Description utils.PatchField[string] `json:"description"`
+	Image       utils.PatchField[string] `json:"image"`
+	ParentID    utils.PatchField[uint]   `json:"parent_id"`
+	SortOrder   utils.PatchField[int]    `json:"sort_order"`
+	IsActive    utils.PatchField[bool]   `json:"is_active"`
+}
+
+// validate checks every field the patch sets the way CreateCategory does
+func (p *categoryPatch) validate() error {
+	if err := firstError(p.Name.Required("name"), p.Slug.Required("slug"),
+		p.SortOrder.Required("sort_order"), p.IsActive.Required("is_active")); err != nil {
+		return err
+	}
+	if p.Name.Present() {
+		p.Name.Value = utils.SanitizeString(p.Name.Value, 100)
+		if err := utils.ValidateName(p.Name.Value); err != nil {
+			return err
+		}
+	}
+	if p.Slug.Present() {
+		p.Slug.Value = strings.ToLower(strings.TrimSpace(p.Slug.Value))
+		if err := utils.ValidateSlug(p.Slug.Value); err != nil {
+			return err
+		}
+	}
+	if p.Image.Present() {
+		if err := utils.ValidateURL(p.Image.Value); err != nil {
+			return err
+		}
+	}
+	if p.ParentID.Present() && p.ParentID.Value == 0 {
+		return errors.New("invalid parent_id")
+	}
+	p.Description.Value = utils.SanitizeString(p.Description.Value, 2000)
+	return nil
+}
+
+func (p *categoryPatch) updates() map[string]interface{} {
+	updates := map[string]interface{}{}
+	setField(updates, "name", p.Name, nil)
+	setField(updates, "slug", p.Slug, nil)
+	setField(updates, "description", p.Description, "")
+	setField(updates, "image", p.Image, "")
+	setField(updates, "parent_id", p.ParentID, nil)
+	setField(updates, "sort_order", p.SortOrder, nil)
+	setField(updates, "is_active", p.IsActive, nil)
+	return updates
+}
+
+// UpdateCategory applies a merge patch to a category. A null parent_id makes it a
+// top-level category.
 func UpdateCategory(c *gin.Context) {
 	idStr := c.Param("id")
 	id, err := strconv.Atoi(idStr)
@@ -278,22 +762,91 @@ func UpdateCategory(c *gin.Context) {
 		return
 	}
 
-	var updates map[string]interface{}
-	if err := c.ShouldBindJSON(&updates); err != nil {
+	var patch categoryPatch
+	if !bindPatch(c, &patch) {
+		return
+	}
+	conditional, ok := checkVersion(c, patch.patchVersion, category.UpdatedAt, category)
+	if !ok {
+		return
+	}
+	if err := patch.validate(); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	if result := config.DB.Model(&category).Updates(updates); result.Error != nil {
+	if patch.Name.Present() {
+		var count int64
+		config.DB.Unscoped().Model(&models.Category{}).Where("name = ? AND id <> ?", patch.Name.Value, category.ID).Count(&count)
+		if count > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "A category with this name already exists"})
+			return
+		}
+	}
+	if patch.Slug.Present() {
+		if err := services.CheckCategorySlug(config.DB, patch.Slug.Value, category.ID); err != nil {
+			respondCategoryError(c, err)
+			return
+		}
+	}
+	if patch.ParentID.Present() {
+		if err := services.ValidateCategoryParent(config.DB, category.ID, patch.ParentID.Value); err != nil {
+			respondCategoryError(c, err)
+			return
+		}
+	}
+
+	err = savePatch(config.DB, &category, category.UpdatedAt, conditional, patch.updates())
+	config.DB.First(&category, id)
+	if errors.Is(err, errRecordModified) {
+		respondModified(c, category.UpdatedAt, category)
+		return
+	}
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update category"})
 		return
 	}
 
-	// Reload the category to return the latest data
-	config.DB.First(&category, id)
+	services.Suggestions(config.DB).Refresh()
+	services.InvalidateFacets()
+	c.Header("ETag", utils.VersionTag(category.UpdatedAt))
 	c.JSON(http.StatusOK, category)
 }
 
+// resolveCategoryParam reads a category filter given as an ID or a slug
+func resolveCategoryParam(value string) (uint, error) {
+	if id, err := strconv.ParseUint(value, 10, 64); err == nil {
+		return uint(id), nil
+	}
+	var category models.Category
+	if err := config.DB.Select("id").Where("slug = ?", strings.ToLower(value)).First(&category).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return 0, services.ErrCategoryNotFound
+		}
+		return 0, err
+	}
+	return category.ID, nil
+}
+
+// respondCategoryError maps category validation errors to responses
+func respondCategoryError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrCategoryNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Category not found"})
+	case errors.Is(err, services.ErrSlugTaken):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrParentNotFound), errors.Is(err, services.ErrCategoryCycle),
+		errors.Is(err, services.ErrCategoryTooDeep):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		utils.Error("Category request failed", map[string]interface{}{"error": err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Something went wrong"})
+	}
+}
+
+// DeleteCategory soft-deletes a category. A category with products needs
+// ?reassign_to=<category id> to say where they go; its subcategories move up to
+// its parent.
 func DeleteCategory(c *gin.Context) {
 	idStr := c.Param("id")
 	id, err := strconv.Atoi(idStr)
@@ -301,47 +854,118 @@ func DeleteCategory(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid category ID"})
 		return
 	}
-	var category models.Category
 
-	if err := config.DB.First(&category, id).Error; err != nil {
+	var reassignTo *uint
+	if target := c.Query("reassign_to"); target != "" {
+		targetID, err := strconv.ParseUint(target, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid reassign_to"})
+			return
+		}
+		reassignTo = new(uint)
+		*reassignTo = uint(targetID)
+	}
+
+	result, err := services.DeleteCategory(config.DB, uint(id), reassignTo, auditEntry(c))
+	switch {
+	case errors.Is(err, services.ErrCategoryNotFound):
 		c.JSON(http.StatusNotFound, gin.H{"error": "Category not found"})
 		return
+	case errors.Is(err, services.ErrCategoryHasProducts):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "products": result.Products})
+		return
+	case errors.Is(err, services.ErrReassignToSelf), errors.Is(err, services.ErrReassignTargetNotFound):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		utils.Error("Failed to delete category", map[string]interface{}{"category_id": id, "error": err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete category"})
+		return
 	}
 
-	fmt.Printf(">>> DeleteCategory: Found category ID=%d, Name=%s\n", category.ID, category.Name)
+	utils.Info("Category deleted", map[string]interface{}{
+		"category_id": id, "products": result.Products, "reassigned_to": reassignTo,
+	})
+	services.Suggestions(config.DB).Refresh()
+	services.InvalidateFacets()
+	c.JSON(http.StatusOK, gin.H{
+		"message":       "Category deleted",
+		"products":      result.Products,
+		"reassigned_to": result.ReassignedTo,
+		"subcategories": result.Subcategories,
+	})
+}
 
-	// Unlink products from this category before deleting
-	config.DB.Model(&models.Product{}).Where("category_id = ?", id).Update("category_id", 0)
+// GetDeletedCategories lists soft-deleted categories that can be restored
+func GetDeletedCategories(c *gin.Context) {
+	var categories []models.Category
+	config.DB.Unscoped().Where("deleted_at IS NOT NULL").Order("deleted_at DESC").Find(&categories)
+	c.JSON(http.StatusOK, categories)
+}
 
-	result := config.DB.Delete(&category)
-	fmt.Printf(">>> DeleteCategory: Rows affected=%d, Error=%v\n", result.RowsAffected, result.Error)
-	if result.Error != nil {
-		fmt.Printf("Error deleting category ID=%d: %v\n", category.ID, result.Error)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete category"})
+// RestoreCategory brings back a soft-deleted category
+func RestoreCategory(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid category ID"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Category deleted"})
+	category, err := services.RestoreCategory(config.DB, uint(id), auditEntry(c))
+	if errors.Is(err, services.ErrCategoryNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deleted category not found"})
+		return
+	}
+	if err != nil {
+		utils.Error("Failed to restore category", map[string]interface{}{"category_id": id, "error": err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore category"})
+		return
+	}
+
+	services.Suggestions(config.DB).Refresh()
+	services.InvalidateFacets()
+	c.JSON(http.StatusOK, category)
 }
 
 // Catalogues
+// GetCatalogues lists the active catalogues with the products in them that are on
+// the storefront
 func GetCatalogues(c *gin.Context) {
-	var catalogues []models.Catalogue
-	query := config.DB.Preload("Products.Category")
+	listCatalogues(c, services.VisibleCatalogues(config.DB), storefrontProducts)
+}
 
+// GetAdminCatalogues lists every catalogue with all of its products, optionally
+// only active or inactive ones with ?status=true or false
+func GetAdminCatalogues(c *gin.Context) {
+	query := config.DB
 	if status := c.Query("status"); status != "" {
-		query = query.Where("status = ?", status == "true")
+		query = query.Where("catalogues.status = ?", status == "true")
 	}
+	listCatalogues(c, query, nil)
+}
 
+func listCatalogues(c *gin.Context, query *gorm.DB, products func(*gorm.DB) *gorm.DB) {
+	var catalogues []models.Catalogue
 	if search := c.Query("search"); search != "" {
 		query = query.Where("name ILIKE ?", "%"+search+"%")
 	}
 
-	query.Order("sort_order ASC, created_at DESC").Find(&catalogues)
+	query.Scopes(preloadCatalogueProducts(products)).Order("sort_order ASC, created_at DESC").Find(&catalogues)
 	c.JSON(http.StatusOK, catalogues)
 }
 
+// GetCatalogue returns an active catalogue with the products in it that are on the
+// storefront
 func GetCatalogue(c *gin.Context) {
+	showCatalogue(c, services.VisibleCatalogues(config.DB), storefrontProducts)
+}
+
+// GetAdminCatalogue previews a catalogue, active or not, with all of its products
+func GetAdminCatalogue(c *gin.Context) {
+	showCatalogue(c, config.DB, nil)
+}
+
+func showCatalogue(c *gin.Context, query *gorm.DB, products func(*gorm.DB) *gorm.DB) {
 	idStr := c.Param("id")
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
@@ -350,13 +974,30 @@ func GetCatalogue(c *gin.Context) {
 	}
 	var catalogue models.Catalogue
 
-	if err := config.DB.Preload("Products.Category").First(&catalogue, id).Error; err != nil {
+	if err := query.Scopes(preloadCatalogueProducts(products)).First(&catalogue, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Catalogue not found"})
 		return
 	}
+	c.Header("ETag", utils.VersionTag(catalogue.UpdatedAt))
 	c.JSON(http.StatusOK, catalogue)
 }
 
+// storefrontProducts limits preloaded products to those on the storefront
+func storefrontProducts(db *gorm.DB) *gorm.DB {
+	return services.VisibleProducts(db, time.Now())
+}
+
+// preloadCatalogueProducts preloads a catalogue's products and their categories,
+// filtered by products when it is set
+func preloadCatalogueProducts(products func(*gorm.DB) *gorm.DB) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if products != nil {
+			db = db.Preload("Products", products)
+		}
+		return db.Preload("Products.Category")
+	}
+}
+
 func CreateCatalogue(c *gin.Context) {
 	var input models.CreateCatalogueInput
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -389,9 +1030,42 @@ func CreateCatalogue(c *gin.Context) {
 		config.DB.Preload("Products.Category").First(&catalogue)
 	}
 
+	services.Suggestions(config.DB).Refresh()
 	c.JSON(http.StatusCreated, catalogue)
 }
 
+type cataloguePatch struct {
+	patchVersion
+	Name        utils.PatchField[string] `json:"name"`
+	Description utils.PatchField[string] `json:"description"`
+	Image       utils.PatchField[string] `json:"image"`
+	Status      utils.PatchField[bool]   `json:"status"`
+	SortOrder   utils.PatchField[int]    `json:"sort_order"`
+}
+
+// validate checks every field the patch sets the way CreateCatalogue does
+func (p *cataloguePatch) validate() error {
+	if err := firstError(p.Name.Required("name"), p.Status.Required("status"), p.SortOrder.Required("sort_order")); err != nil {
+		return err
+	}
+	if p.Name.Present() && strings.TrimSpace(p.Name.Value) == "" {
+		return errors.New("name is required")
+	}
+	return nil
+}
+
+func (p *cataloguePatch) updates() map[string]interface{} {
+	updates := map[string]interface{}{}
+	setField(updates, "name", p.Name, nil)
+	setField(updates, "description", p.Description, "")
+	setField(updates, "image", p.Image, "")
+	setField(updates, "status", p.Status, nil)
+	setField(updates, "sort_order", p.SortOrder, nil)
+	return updates
+}
+
+// UpdateCatalogue applies a merge patch to a catalogue. Its products are changed
+// through AddProductsToCatalogue and RemoveProductsFromCatalogue.
 func UpdateCatalogue(c *gin.Context) {
 	idStr := c.Param("id")
 	id, err := strconv.Atoi(idStr)
@@ -401,19 +1075,37 @@ func UpdateCatalogue(c *gin.Context) {
 	}
 	var catalogue models.Catalogue
 
-	if err := config.DB.First(&catalogue, id).Error; err != nil {
+	if err := config.DB.Preload("Products.Category").First(&catalogue, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Catalogue not found"})
 		return
 	}
 
-	var updates map[string]interface{}
-	if err := c.ShouldBindJSON(&updates); err != nil {
+	var patch cataloguePatch
+	if !bindPatch(c, &patch) {
+		return
+	}
+	conditional, ok := checkVersion(c, patch.patchVersion, catalogue.UpdatedAt, catalogue)
+	if !ok {
+		return
+	}
+	if err := patch.validate(); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	config.DB.Model(&catalogue).Updates(updates)
-	config.DB.Preload("Products.Category").First(&catalogue)
+	err = savePatch(config.DB, &catalogue, catalogue.UpdatedAt, conditional, patch.updates())
+	config.DB.Preload("Products.Category").First(&catalogue, id)
+	if errors.Is(err, errRecordModified) {
+		respondModified(c, catalogue.UpdatedAt, catalogue)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update catalogue"})
+		return
+	}
+
+	services.Suggestions(config.DB).Refresh()
+	c.Header("ETag", utils.VersionTag(catalogue.UpdatedAt))
 	c.JSON(http.StatusOK, catalogue)
 }
 
@@ -433,6 +1125,7 @@ func DeleteCatalogue(c *gin.Context) {
 
 	config.DB.Model(&catalogue).Association("Products").Clear()
 	config.DB.Delete(&catalogue)
+	services.Suggestions(config.DB).Refresh()
 	c.JSON(http.StatusOK, gin.H{"message": "Catalogue deleted"})
 }
 
@@ -532,6 +1225,52 @@ func CreatePageContent(c *gin.Context) {
 	c.JSON(http.StatusCreated, content)
 }
 
+type pageContentPatch struct {
+	patchVersion
+	Page     utils.PatchField[string]          `json:"page"`
+	Section  utils.PatchField[string]          `json:"section"`
+	Title    utils.PatchField[string]          `json:"title"`
+	Content  utils.PatchField[string]          `json:"content"`
+	Image    utils.PatchField[string]          `json:"image"`
+	Metadata utils.PatchField[json.RawMessage] `json:"metadata"`
+}
+
+// validate checks every field the patch sets. page and section place the content
+// on the site, so they can't be emptied.
+func (p *pageContentPatch) validate() error {
+	if err := firstError(p.Page.Required("page"), p.Section.Required("section")); err != nil {
+		return err
+	}
+	if p.Page.Present() && strings.TrimSpace(p.Page.Value) == "" {
+		return errors.New("page is required")
+	}
+	if p.Section.Present() && strings.TrimSpace(p.Section.Value) == "" {
+		return errors.New("section is required")
+	}
+	if p.Image.Present() {
+		if err := utils.ValidateURL(p.Image.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *pageContentPatch) updates() map[string]interface{} {
+	updates := map[string]interface{}{}
+	setField(updates, "page", p.Page, nil)
+	setField(updates, "section", p.Section, nil)
+	setField(updates, "title", p.Title, "")
+	setField(updates, "content", p.Content, "")
+	setField(updates, "image", p.Image, "")
+	if p.Metadata.Present() {
+		updates["metadata"] = string(p.Metadata.Value)
+	} else if p.Metadata.Null {
+		updates["metadata"] = nil
+	}
+	return updates
+}
+
+// UpdatePageContent applies a merge patch to a block of page content
 func UpdatePageContent(c *gin.Context) {
 	idStr := c.Param("id")
 	id, err := strconv.Atoi(idStr)
@@ -546,25 +1285,50 @@ func UpdatePageContent(c *gin.Context) {
 		return
 	}
 
-	var updates map[string]interface{}
-	if err := c.ShouldBindJSON(&updates); err != nil {
+	var patch pageContentPatch
+	if !bindPatch(c, &patch) {
+		return
+	}
+	conditional, ok := checkVersion(c, patch.patchVersion, content.UpdatedAt, content)
+	if !ok {
+		return
+	}
+	if err := patch.validate(); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	config.DB.Model(&content).Updates(updates)
+	err = savePatch(config.DB, &content, content.UpdatedAt, conditional, patch.updates())
+	config.DB.First(&content, id)
+	if errors.Is(err, errRecordModified) {
+		respondModified(c, content.UpdatedAt, content)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update content"})
+		return
+	}
+
+	c.Header("ETag", utils.VersionTag(content.UpdatedAt))
 	c.JSON(http.StatusOK, content)
 }
 
 // Orders
 func CreateOrder(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	uid := userID.(uint)
+
 	var input struct {
-		UserID          uint             `json:"user_id"`
 		Items           []OrderItemInput `json:"items" binding:"required,min=1"`
-		TotalAmount     float64          `json:"total_amount" binding:"required"`
-		DiscountAmount  float64          `json:"discount_amount"`
-		ShippingCost    float64          `json:"shipping_cost"`
-		TaxAmount       float64          `json:"tax_amount"`
+		TotalAmount     *float64         `json:"total_amount"`
+		DiscountAmount  *float64         `json:"discount_amount"`
+		ShippingCost    *float64         `json:"shipping_cost"`
+		TaxAmount       *float64         `json:"tax_amount"`
+		ShippingMethod  string           `json:"shipping_method"`
 		ShippingName    string           `json:"shipping_name" binding:"required"`
 		ShippingAddress string           `json:"shipping_address" binding:"required"`
 		ShippingCity    string           `json:"shipping_city" binding:"required"`
@@ -574,6 +1338,8 @@ func CreateOrder(c *gin.Context) {
 		ShippingPhone   string           `json:"shipping_phone" binding:"required"`
 		ShippingEmail   string           `json:"shipping_email"`
 		CouponCode      string           `json:"coupon_code"`
+		GiftCardCode    string           `json:"gift_card_code"`
+		UseStoreCredit  bool             `json:"use_store_credit"`
 		Notes           string           `json:"notes"`
 	}
 
@@ -592,42 +1358,77 @@ func CreateOrder(c *gin.Context) {
 		return
 	}
 
-	tx := config.DB.Begin()
-
-	for _, item := range input.Items {
-		var product models.Product
-		if err := tx.First(&product, item.ProductID).Error; err != nil {
-			tx.Rollback()
-			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Product %d not found", item.ProductID)})
+	pricingItems := make([]services.PricingItem, len(input.Items))
+	submitted := services.SubmittedTotals{
+		TotalAmount:    input.TotalAmount,
+		DiscountAmount: input.DiscountAmount,
+		ShippingCost:   input.ShippingCost,
+		TaxAmount:      input.TaxAmount,
+		LinePrices:     map[int]float64{},
+	}
+	for i, item := range input.Items {
+		if err := utils.ValidateQuantity(item.Quantity); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
+		pricingItems[i] = services.PricingItem{
+			ProductID: item.ProductID,
+			VariantID: item.VariantID,
+			Quantity:  item.Quantity,
+			Color:     item.Color,
+			Size:      item.Size,
+		}
+		if item.Price != nil {
+			submitted.LinePrices[i] = *item.Price
+		}
+	}
 
-		if product.Stock < item.Quantity {
-			tx.Rollback()
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error":      "Insufficient stock",
-				"product_id": item.ProductID,
-				"product":    product.Name,
-				"available":  product.Stock,
-				"requested":  item.Quantity,
-			})
+	tx := config.DB.Begin()
+
+	pricing, err := services.PriceOrder(tx, services.PricingRequest{
+		Items:           pricingItems,
+		CouponCode:      input.CouponCode,
+		ShippingMethod:  input.ShippingMethod,
+		ShippingCountry: input.ShippingCountry,
+		UserID:          uid,
+	})
+	if err != nil {
+		tx.Rollback()
+		if services.IsPricingError(err) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
+		utils.Error("Failed to price order", map[string]interface{}{"user_id": uid, "error": err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create order"})
+		return
+	}
 
-		if err := tx.Model(&product).Update("stock", product.Stock-item.Quantity).Error; err != nil {
+	if mismatches := pricing.Mismatches(submitted); len(mismatches) > 0 {
+		utils.Warn("Order price mismatch", map[string]interface{}{
+			"user_id":    uid,
+			"mismatches": mismatches,
+		})
+		if os.Getenv("PRICE_MISMATCH_POLICY") != "report" {
 			tx.Rollback()
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update stock"})
+			c.JSON(http.StatusConflict, gin.H{
+				"error":      "Submitted prices do not match current prices",
+				"mismatches": mismatches,
+				"pricing":    pricing,
+			})
 			return
 		}
 	}
 
 	order := models.Order{
-		UserID:          input.UserID,
+		UserID:          uid,
 		Status:          "pending_payment",
-		TotalAmount:     input.TotalAmount,
-		DiscountAmount:  input.DiscountAmount,
-		ShippingCost:    input.ShippingCost,
-		TaxAmount:       input.TaxAmount,
+		Subtotal:        pricing.Subtotal,
+		TotalAmount:     pricing.TotalAmount,
+		DiscountAmount:  pricing.DiscountAmount,
+		ShippingCost:    pricing.ShippingCost,
+		TaxAmount:       pricing.TaxAmount,
+		Currency:        pricing.Currency,
+		ShippingMethod:  pricing.ShippingMethod,
 		ShippingName:    input.ShippingName,
 		ShippingAddress: input.ShippingAddress,
 		ShippingCity:    input.ShippingCity,
@@ -636,9 +1437,13 @@ func CreateOrder(c *gin.Context) {
 		ShippingZip:     input.ShippingZip,
 		ShippingPhone:   input.ShippingPhone,
 		ShippingEmail:   input.ShippingEmail,
-		CouponCode:      input.CouponCode,
+		CouponCode:      pricing.CouponCode,
 		Notes:           input.Notes,
 	}
+	order.PromotionDiscount = pricing.PromotionDiscount
+	for _, promotion := range pricing.Promotions {
+		order.PromotionIDs = append(order.PromotionIDs, promotion.ID)
+	}
 
 	if err := tx.Create(&order).Error; err != nil {
 		tx.Rollback()
@@ -646,14 +1451,19 @@ func CreateOrder(c *gin.Context) {
 		return
 	}
 
-	for _, itemInput := range input.Items {
+	for _, line := range pricing.Lines {
 		orderItem := models.OrderItem{
 			OrderID:   order.ID,
-			ProductID: itemInput.ProductID,
-			Quantity:  itemInput.Quantity,
-			Price:     itemInput.Price,
-			Color:     itemInput.Color,
-			Size:      itemInput.Size,
+			ProductID: line.ProductID,
+			VariantID: line.VariantID,
+			SKU:       line.SKU,
+			Quantity:  line.Quantity,
+			Price:     line.UnitPrice,
+			LineTotal: line.LineTotal,
+			Discount:  line.Discount,
+			Color:     line.Color,
+			Size:      line.Size,
+			IsGift:    line.IsGift,
 		}
 		if err := tx.Create(&orderItem).Error; err != nil {
 			tx.Rollback()
@@ -662,30 +1472,98 @@ func CreateOrder(c *gin.Context) {
 		}
 	}
 
+	if pricing.Coupon != nil {
+		if err := services.RedeemCoupon(tx, pricing.Coupon, &order); err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to redeem coupon"})
+			return
+		}
+	}
+
+	if status, err := payWithCredit(tx, &order, input.GiftCardCode, input.UseStoreCredit); err != nil {
+		tx.Rollback()
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	reservedUntil, err := services.ReserveStock(tx, order.ID, pricing.Lines)
+	if err != nil {
+		tx.Rollback()
+		var stockErr *services.InsufficientStockError
+		if errors.As(err, &stockErr) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":      "Insufficient stock",
+				"product_id": stockErr.ProductID,
+				"variant_id": stockErr.VariantID,
+				"product":    stockErr.Name,
+				"available":  stockErr.Available,
+				"requested":  stockErr.Requested,
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update stock"})
+		return
+	}
+
+	if err := services.RecordOrderStatus(tx, order.ID, "", order.Status, services.StatusChange{
+		Source:  services.StatusSourceCustomer,
+		ActorID: &order.UserID,
+		Reason:  "Order placed",
+	}); err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create order"})
+		return
+	}
+
+	order.ReservedUntil = &reservedUntil
+	if err := tx.Model(&order).Update("reserved_until", reservedUntil).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reserve stock"})
+		return
+	}
+
+	// An order gift card and store credit pay for in full needs no Razorpay payment
+	if (order.GiftCardAmount > 0 || order.StoreCreditAmount > 0) && services.AmountDue(&order) == 0 {
+		if err := payOrderInFull(tx, &order); err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to complete order"})
+			return
+		}
+	}
+
 	if err := tx.Commit().Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to complete order"})
 		return
 	}
 
+	services.NotifyOrderStatus(config.DB, &order)
+	services.NotifyLowStock(config.DB, pricing.Lines)
+
 	utils.Info("Order created", map[string]interface{}{
 		"order_id": order.ID,
-		"user_id":  input.UserID,
-		"total":    input.TotalAmount,
+		"user_id":  uid,
+		"total":    order.TotalAmount,
 	})
 
 	c.JSON(http.StatusCreated, gin.H{
-		"order_id": order.ID,
-		"status":   order.Status,
-		"message":  "Order created successfully",
+		"order_id":            order.ID,
+		"status":              order.Status,
+		"pricing":             pricing,
+		"gift_card_amount":    order.GiftCardAmount,
+		"store_credit_amount": order.StoreCreditAmount,
+		"amount_due":          services.AmountDue(&order),
+		"reserved_until":      order.ReservedUntil,
+		"message":             "Order created successfully",
 	})
 }
 
 type OrderItemInput struct {
-	ProductID uint    `json:"product_id" binding:"required"`
-	Quantity  int     `json:"quantity" binding:"required,min=1"`
-	Price     float64 `json:"price" binding:"required"`
-	Color     string  `json:"color"`
-	Size      string  `json:"size"`
+	ProductID uint     `json:"product_id" binding:"required"`
+	VariantID *uint    `json:"variant_id"`
+	Quantity  int      `json:"quantity" binding:"required,min=1"`
+	Price     *float64 `json:"price"`
+	Color     string   `json:"color"`
+	Size      string   `json:"size"`
 }
 
 // Auth
@@ -711,10 +1589,19 @@ func Login(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"user":  user,
-		"token": "jwt-token-placeholder",
+	response, err := issueSession(c, &user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	utils.Info("User logged in", map[string]interface{}{
+		"user_id":    user.ID,
+		"session_id": response["session_id"],
 	})
+
+	response["user"] = user
+	c.JSON(http.StatusOK, response)
 }
 
 func GetCurrentUser(c *gin.Context) {
@@ -1,18 +1,22 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
-	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"pashmina-backend/config"
 	"pashmina-backend/middleware"
 	"pashmina-backend/models"
+	"pashmina-backend/services"
 	"pashmina-backend/utils"
 
 	"github.com/gin-gonic/gin"
 	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 )
 
 func Register(c *gin.Context) {
@@ -64,7 +68,7 @@ func Register(c *gin.Context) {
 		return
 	}
 
-	token, err := middleware.GenerateToken(user.ID, user.Email, user.Role)
+	response, err := issueSession(c, &user)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
@@ -75,38 +79,239 @@ func Register(c *gin.Context) {
 		"email":   user.Email,
 	})
 
-	c.JSON(http.StatusCreated, gin.H{
-		"user": gin.H{
-			"id":    user.ID,
-			"name":  user.Name,
-			"email": user.Email,
-			"role":  user.Role,
-		},
-		"token": token,
-	})
+	services.NotifyNewCustomer(config.DB, &user)
+	services.NotifyWelcome(config.DB, &user)
+
+	response["user"] = gin.H{
+		"id":    user.ID,
+		"name":  user.Name,
+		"email": user.Email,
+		"role":  user.Role,
+	}
+	c.JSON(http.StatusCreated, response)
+}
+
+// issueSession starts a session for the requesting device and returns its access
+// and refresh tokens
+func issueSession(c *gin.Context, user *models.User) (gin.H, error) {
+	session, refreshToken, err := services.CreateSession(config.DB, user.ID, c.Request.UserAgent(), c.ClientIP())
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := middleware.GenerateToken(user.ID, user.Email, user.Role, session.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return gin.H{
+		"token":         token,
+		"refresh_token": refreshToken,
+		"expires_in":    int(middleware.AccessTokenTTL().Seconds()),
+		"session_id":    session.ID,
+	}, nil
 }
 
+// Logout revokes the session identified by the refresh token, or by the access token
+// when no refresh token is sent. With all_devices every session of the user is revoked.
 func Logout(c *gin.Context) {
+	var input struct {
+		RefreshToken string `json:"refresh_token"`
+		AllDevices   bool   `json:"all_devices"`
+	}
+	// The body is optional
+	_ = c.ShouldBindJSON(&input)
+
+	var userID, sessionID uint
+	if input.RefreshToken != "" {
+		if session, err := services.FindSessionByRefreshToken(config.DB, input.RefreshToken); err == nil {
+			userID, sessionID = session.UserID, session.ID
+		}
+	} else if header := c.GetHeader("Authorization"); header != "" {
+		if claims, err := middleware.ValidateToken(strings.TrimPrefix(header, "Bearer ")); err == nil {
+			userID, sessionID = claims.UserID, claims.SessionID
+		}
+	}
+
+	if userID != 0 {
+		if input.AllDevices {
+			if _, err := services.RevokeUserSessions(config.DB, userID, "logged out of all devices"); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+				return
+			}
+		} else if sessionID != 0 {
+			if err := services.RevokeSession(config.DB, sessionID, userID, "logged out"); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+				return
+			}
+		}
+	}
+
 	c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
 }
 
+// RefreshToken exchanges a refresh token for a new access token and a rotated refresh token
 func RefreshToken(c *gin.Context) {
+	var input struct {
+		RefreshToken string `json:"refresh_token" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	session, refreshToken, err := services.RotateRefreshToken(config.DB, input.RefreshToken, c.Request.UserAgent(), c.ClientIP())
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrRefreshTokenReused):
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token was already used; the session has been revoked"})
+		case errors.Is(err, services.ErrRefreshTokenInvalid), errors.Is(err, services.ErrSessionRevoked):
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh token"})
+		}
+		return
+	}
+
+	var user models.User
+	if err := config.DB.First(&user, session.UserID).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	token, err := middleware.GenerateToken(user.ID, user.Email, user.Role, session.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"token":         token,
+		"refresh_token": refreshToken,
+		"expires_in":    int(middleware.AccessTokenTTL().Seconds()),
+		"session_id":    session.ID,
+	})
+}
+
+// ForgotPassword emails a reset link to the account with the given address. The
+// response is the same whether or not the account exists.
+func ForgotPassword(c *gin.Context) {
+	var input struct {
+		Email string `json:"email" binding:"required,email"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := config.DB.Where("email = ?", strings.TrimSpace(input.Email)).First(&user).Error; err == nil && user.Provider == "email" {
+		token, err := services.CreatePasswordReset(config.DB, user.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start password reset"})
+			return
+		}
+		services.SendPasswordReset(config.DB, &user, token)
+
+		utils.Info("Password reset requested", map[string]interface{}{"user_id": user.ID})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "If an account exists for that email, a reset link has been sent"})
+}
+
+// ResetPassword sets a new password using an emailed reset token and signs the user
+// out of every device
+func ResetPassword(c *gin.Context) {
+	var input struct {
+		Token    string `json:"token" binding:"required"`
+		Password string `json:"password" binding:"required,min=8"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := utils.ValidatePassword(input.Password); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(input.Password), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset password"})
+		return
+	}
+
+	user, err := services.ResetPassword(config.DB, input.Token, string(hashedPassword))
+	if err != nil {
+		if errors.Is(err, services.ErrResetTokenInvalid) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset password"})
+		return
+	}
+
+	utils.Info("Password reset", map[string]interface{}{"user_id": user.ID})
+	services.NotifyPasswordChanged(config.DB, user)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password has been reset. Please log in again."})
+}
+
+// GetSessions lists the current user's active sessions
+func GetSessions(c *gin.Context) {
 	userID, exists := c.Get("user_id")
 	if !exists {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
 		return
 	}
 
-	email, _ := c.Get("user_email")
-	role, _ := c.Get("user_role")
+	sessions, err := services.ActiveSessions(config.DB, userID.(uint))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch sessions"})
+		return
+	}
 
-	token, err := middleware.GenerateToken(userID.(uint), email.(string), role.(string))
+	currentID, _ := c.Get("session_id")
+	result := make([]gin.H, 0, len(sessions))
+	for _, session := range sessions {
+		result = append(result, gin.H{
+			"id":           session.ID,
+			"user_agent":   session.UserAgent,
+			"ip_address":   session.IPAddress,
+			"created_at":   session.CreatedAt,
+			"last_used_at": session.LastUsedAt,
+			"expires_at":   session.ExpiresAt,
+			"current":      currentID == session.ID,
+		})
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// RevokeSession signs one of the current user's devices out
+func RevokeSession(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	sessionID, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session ID"})
+		return
+	}
+
+	if err := services.RevokeSession(config.DB, uint(sessionID), userID.(uint), "revoked by user"); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"token": token})
+	c.JSON(http.StatusOK, gin.H{"message": "Session revoked"})
 }
 
 func UpdateCurrentUser(c *gin.Context) {
@@ -171,6 +376,7 @@ func SubscribeNewsletter(c *gin.Context) {
 		}
 		newsletter.Subscribed = true
 		config.DB.Save(&newsletter)
+		services.SendNewsletterWelcome(config.DB, newsletter.Email)
 		c.JSON(http.StatusOK, gin.H{"message": "Resubscribed successfully"})
 		return
 	}
@@ -186,6 +392,7 @@ func SubscribeNewsletter(c *gin.Context) {
 	}
 
 	utils.Info("Newsletter subscription", map[string]interface{}{"email": input.Email})
+	services.SendNewsletterWelcome(config.DB, input.Email)
 
 	c.JSON(http.StatusCreated, gin.H{"message": "Subscribed successfully"})
 }
@@ -240,6 +447,51 @@ func CreateAddress(c *gin.Context) {
 	c.JSON(http.StatusCreated, input)
 }
 
+type addressPatch struct {
+	patchVersion
+	Type         utils.PatchField[string] `json:"type"`
+	IsDefault    utils.PatchField[bool]   `json:"is_default"`
+	Name         utils.PatchField[string] `json:"name"`
+	Phone        utils.PatchField[string] `json:"phone"`
+	AddressLine1 utils.PatchField[string] `json:"address_line1"`
+	AddressLine2 utils.PatchField[string] `json:"address_line2"`
+	City         utils.PatchField[string] `json:"city"`
+	State        utils.PatchField[string] `json:"state"`
+	PostalCode   utils.PatchField[string] `json:"postal_code"`
+	Country      utils.PatchField[string] `json:"country"`
+	Landmark     utils.PatchField[string] `json:"landmark"`
+}
+
+// validate checks the address as it will be after the patch the way CreateAddress
+// does, so clearing a required line is refused
+func (p *addressPatch) validate(current *models.Address) error {
+	if err := p.IsDefault.Required("is_default"); err != nil {
+		return err
+	}
+	if err := utils.ValidateAddress(patched(p.AddressLine1, current.AddressLine1), patched(p.City, current.City),
+		patched(p.State, current.State), patched(p.Country, current.Country)); err != nil {
+		return err
+	}
+	return utils.ValidatePostalCode(patched(p.PostalCode, current.PostalCode))
+}
+
+func (p *addressPatch) updates() map[string]interface{} {
+	updates := map[string]interface{}{}
+	setField(updates, "type", p.Type, "shipping")
+	setField(updates, "is_default", p.IsDefault, nil)
+	setField(updates, "name", p.Name, "")
+	setField(updates, "phone", p.Phone, "")
+	setField(updates, "address_line1", p.AddressLine1, "")
+	setField(updates, "address_line2", p.AddressLine2, "")
+	setField(updates, "city", p.City, "")
+	setField(updates, "state", p.State, "")
+	setField(updates, "postal_code", p.PostalCode, "")
+	setField(updates, "country", p.Country, "")
+	setField(updates, "landmark", p.Landmark, "")
+	return updates
+}
+
+// UpdateAddress applies a merge patch to one of the user's addresses
 func UpdateAddress(c *gin.Context) {
 	userID, exists := c.Get("user_id")
 	if !exists {
@@ -255,19 +507,39 @@ func UpdateAddress(c *gin.Context) {
 		return
 	}
 
-	var updates map[string]interface{}
-	if err := c.ShouldBindJSON(&updates); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	var patch addressPatch
+	if !bindPatch(c, &patch) {
 		return
 	}
-
-	if isDefault, ok := updates["is_default"].(bool); ok && isDefault {
-		config.DB.Model(&models.Address{}).Where("user_id = ?", userID).Update("is_default", false)
+	conditional, ok := checkVersion(c, patch.patchVersion, address.UpdatedAt, address)
+	if !ok {
+		return
+	}
+	if err := patch.validate(&address); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 
-	config.DB.Model(&address).Updates(updates)
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if patch.IsDefault.Present() && patch.IsDefault.Value {
+			if err := tx.Model(&models.Address{}).Where("user_id = ? AND id <> ?", userID, address.ID).
+				Update("is_default", false).Error; err != nil {
+				return err
+			}
+		}
+		return savePatch(tx, &address, address.UpdatedAt, conditional, patch.updates())
+	})
 	config.DB.First(&address, address.ID)
+	if errors.Is(err, errRecordModified) {
+		respondModified(c, address.UpdatedAt, address)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update address"})
+		return
+	}
 
+	c.Header("ETag", utils.VersionTag(address.UpdatedAt))
 	c.JSON(http.StatusOK, address)
 }
 
@@ -423,6 +695,8 @@ func CreateReview(c *gin.Context) {
 		return
 	}
 
+	services.NotifyNewReview(config.DB, &review)
+
 	c.JSON(http.StatusCreated, review)
 }
 
@@ -526,21 +800,67 @@ func GetNotificationPreferences(c *gin.Context) {
 
 	var prefs models.NotificationPreference
 	if err := config.DB.Where("user_id = ?", userID).First(&prefs).Error; err != nil {
-		prefs = models.NotificationPreference{
-			UserID:         userID.(uint),
-			OrderCreated:   true,
-			OrderShipped:   true,
-			OrderDelivered: true,
-			OrderStatus:    true,
-			EmailEnabled:   true,
-			PushEnabled:    true,
-		}
+		prefs = services.DefaultNotificationPreference(userID.(uint))
 		config.DB.Create(&prefs)
+		config.DB.First(&prefs, prefs.ID)
 	}
 
+	c.Header("ETag", utils.VersionTag(prefs.UpdatedAt))
 	c.JSON(http.StatusOK, prefs)
 }
 
+type notificationPreferencePatch struct {
+	patchVersion
+	OrderCreated   utils.PatchField[bool] `json:"order_created"`
+	OrderShipped   utils.PatchField[bool] `json:"order_shipped"`
+	OrderDelivered utils.PatchField[bool] `json:"order_delivered"`
+	OrderStatus    utils.PatchField[bool] `json:"order_status"`
+	LowStock       utils.PatchField[bool] `json:"low_stock"`
+	ProductUpdates utils.PatchField[bool] `json:"product_updates"`
+	Newsletter     utils.PatchField[bool] `json:"newsletter"`
+	Marketing      utils.PatchField[bool] `json:"marketing"`
+	EmailEnabled   utils.PatchField[bool] `json:"email_enabled"`
+	SMSEnabled     utils.PatchField[bool] `json:"sms_enabled"`
+	PushEnabled    utils.PatchField[bool] `json:"push_enabled"`
+}
+
+// columns pairs each preference with its column
+func (p *notificationPreferencePatch) columns() map[string]utils.PatchField[bool] {
+	return map[string]utils.PatchField[bool]{
+		"order_created":   p.OrderCreated,
+		"order_shipped":   p.OrderShipped,
+		"order_delivered": p.OrderDelivered,
+		"order_status":    p.OrderStatus,
+		"low_stock":       p.LowStock,
+		"product_updates": p.ProductUpdates,
+		"newsletter":      p.Newsletter,
+		"marketing":       p.Marketing,
+		"email_enabled":   p.EmailEnabled,
+		"sms_enabled":     p.SMSEnabled,
+		"push_enabled":    p.PushEnabled,
+	}
+}
+
+// validate refuses null: a preference is either on or off
+func (p *notificationPreferencePatch) validate() error {
+	for column, field := range p.columns() {
+		if err := field.Required(column); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *notificationPreferencePatch) updates() map[string]interface{} {
+	updates := map[string]interface{}{}
+	for column, field := range p.columns() {
+		setField(updates, column, field, nil)
+	}
+	return updates
+}
+
+// UpdateNotificationPreferences applies a merge patch to the user's notification
+// preferences, creating them with the defaults first if needed
 func UpdateNotificationPreferences(c *gin.Context) {
 	userID, exists := c.Get("user_id")
 	if !exists {
@@ -550,25 +870,51 @@ func UpdateNotificationPreferences(c *gin.Context) {
 
 	var prefs models.NotificationPreference
 	if err := config.DB.Where("user_id = ?", userID).First(&prefs).Error; err != nil {
-		prefs.UserID = userID.(uint)
+		prefs = services.DefaultNotificationPreference(userID.(uint))
 		config.DB.Create(&prefs)
+		config.DB.First(&prefs, prefs.ID)
 	}
 
-	var updates map[string]interface{}
-	if err := c.ShouldBindJSON(&updates); err != nil {
+	var patch notificationPreferencePatch
+	if !bindPatch(c, &patch) {
+		return
+	}
+	conditional, ok := checkVersion(c, patch.patchVersion, prefs.UpdatedAt, prefs)
+	if !ok {
+		return
+	}
+	if err := patch.validate(); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	config.DB.Model(&prefs).Updates(updates)
+	err := savePatch(config.DB, &prefs, prefs.UpdatedAt, conditional, patch.updates())
 	config.DB.First(&prefs, prefs.ID)
+	if errors.Is(err, errRecordModified) {
+		respondModified(c, prefs.UpdatedAt, prefs)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update preferences"})
+		return
+	}
 
+	c.Header("ETag", utils.VersionTag(prefs.UpdatedAt))
 	c.JSON(http.StatusOK, prefs)
 }
 
+// GetCoupons lists coupons, newest first. Codes generated for a campaign are only
+// listed when asked for with ?campaign_id, so thousands of them don't bury the rest.
 func GetCoupons(c *gin.Context) {
+	query := config.DB.Order("created_at DESC")
+	if campaignID := c.Query("campaign_id"); campaignID != "" {
+		query = query.Where("campaign_id = ?", campaignID)
+	} else {
+		query = query.Where("campaign_id IS NULL")
+	}
+
 	var coupons []models.Coupon
-	config.DB.Order("created_at DESC").Find(&coupons)
+	query.Find(&coupons)
 
 	c.JSON(http.StatusOK, coupons)
 }
@@ -580,12 +926,16 @@ func CreateCoupon(c *gin.Context) {
 		return
 	}
 
+	input.Code = utils.NormalizeCouponCode(input.Code)
 	if err := utils.ValidateCouponCode(input.Code); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	input.Code = os.Getenv("APP_ENV") + input.Code
+	// Uses are only counted as orders redeem the coupon, and only campaigns
+	// generate campaign codes
+	input.UsedCount = 0
+	input.CampaignID = nil
 
 	var existing models.Coupon
 	if err := config.DB.Where("code = ?", input.Code).First(&existing).Error; err == nil {
@@ -593,8 +943,8 @@ func CreateCoupon(c *gin.Context) {
 		return
 	}
 
-	if input.DiscountType != "percentage" && input.DiscountType != "fixed" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid discount type"})
+	if err := validateCouponTerms(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
@@ -606,6 +956,123 @@ func CreateCoupon(c *gin.Context) {
 	c.JSON(http.StatusCreated, input)
 }
 
+// validateCouponTerms checks a coupon's discount, limits and validity window
+func validateCouponTerms(coupon *models.Coupon) error {
+	switch coupon.DiscountType {
+	case services.DiscountPercentage, services.DiscountFixed, services.DiscountBuyXGetY:
+		if coupon.DiscountValue <= 0 {
+			return errors.New("discount value must be greater than 0")
+		}
+	case services.DiscountFreeShipping:
+		if coupon.DiscountValue != 0 {
+			return errors.New("free shipping coupons take no discount value")
+		}
+	default:
+		return errors.New("Invalid discount type")
+	}
+	if coupon.DiscountType != services.DiscountFixed && coupon.DiscountValue > 100 {
+		return errors.New("percentage discount cannot be more than 100")
+	}
+	if coupon.DiscountType == services.DiscountBuyXGetY && (coupon.BuyQuantity < 1 || coupon.GetQuantity < 1) {
+		return errors.New("buy_x_get_y coupons need a buy_quantity and get_quantity of at least 1")
+	}
+	if coupon.MinQuantity < 0 {
+		return errors.New("minimum quantity cannot be negative")
+	}
+	for _, segment := range coupon.CustomerSegments {
+		if !services.IsCustomerSegment(segment) {
+			return fmt.Errorf("unknown customer segment %q", segment)
+		}
+	}
+	if coupon.MinOrderAmount < 0 || coupon.MaxDiscountAmount < 0 {
+		return errors.New("order and discount amounts cannot be negative")
+	}
+	if coupon.UsageLimit < 0 || coupon.PerUserLimit < 0 {
+		return errors.New("usage limits cannot be negative")
+	}
+	if !coupon.ValidUntil.IsZero() && coupon.ValidUntil.Before(coupon.ValidFrom) {
+		return errors.New("valid_until must be after valid_from")
+	}
+	return nil
+}
+
+// couponPatch leaves out code, which orders refer to, and used_count, which only
+// redemptions change
+type couponPatch struct {
+	patchVersion
+	Description         utils.PatchField[string]             `json:"description"`
+	DiscountType        utils.PatchField[string]             `json:"discount_type"`
+	DiscountValue       utils.PatchField[float64]            `json:"discount_value"`
+	MinOrderAmount      utils.PatchField[float64]            `json:"min_order_amount"`
+	MaxDiscountAmount   utils.PatchField[float64]            `json:"max_discount_amount"`
+	ValidFrom           utils.PatchField[time.Time]          `json:"valid_from"`
+	ValidUntil          utils.PatchField[time.Time]          `json:"valid_until"`
+	UsageLimit          utils.PatchField[int]                `json:"usage_limit"`
+	IsActive            utils.PatchField[bool]               `json:"is_active"`
+	ApplicableCountries utils.PatchField[models.StringArray] `json:"applicable_countries"`
+	PerUserLimit        utils.PatchField[int]                `json:"per_user_limit"`
+	FirstOrderOnly      utils.PatchField[bool]               `json:"first_order_only"`
+	IncludedCategories  utils.PatchField[models.UintArray]   `json:"included_categories"`
+	ExcludedCategories  utils.PatchField[models.UintArray]   `json:"excluded_categories"`
+	IncludedProducts    utils.PatchField[models.UintArray]   `json:"included_products"`
+	ExcludedProducts    utils.PatchField[models.UintArray]   `json:"excluded_products"`
+	AllowedCustomers    utils.PatchField[models.UintArray]   `json:"allowed_customers"`
+	CustomerSegments    utils.PatchField[models.StringArray] `json:"customer_segments"`
+	MinQuantity         utils.PatchField[int]                `json:"min_quantity"`
+	BuyQuantity         utils.PatchField[int]                `json:"buy_quantity"`
+	GetQuantity         utils.PatchField[int]                `json:"get_quantity"`
+}
+
+// validate checks the coupon as it will be after the patch the way CreateCoupon does
+func (p *couponPatch) validate(current models.Coupon) error {
+	if err := firstError(p.DiscountType.Required("discount_type"), p.DiscountValue.Required("discount_value"),
+		p.ValidFrom.Required("valid_from"), p.ValidUntil.Required("valid_until"), p.IsActive.Required("is_active"),
+		p.FirstOrderOnly.Required("first_order_only")); err != nil {
+		return err
+	}
+	merged := current
+	merged.DiscountType = patched(p.DiscountType, current.DiscountType)
+	merged.DiscountValue = patched(p.DiscountValue, current.DiscountValue)
+	merged.MinOrderAmount = patched(p.MinOrderAmount, current.MinOrderAmount)
+	merged.MaxDiscountAmount = patched(p.MaxDiscountAmount, current.MaxDiscountAmount)
+	merged.ValidFrom = patched(p.ValidFrom, current.ValidFrom)
+	merged.ValidUntil = patched(p.ValidUntil, current.ValidUntil)
+	merged.UsageLimit = patched(p.UsageLimit, current.UsageLimit)
+	merged.PerUserLimit = patched(p.PerUserLimit, current.PerUserLimit)
+	merged.CustomerSegments = patched(p.CustomerSegments, current.CustomerSegments)
+	merged.MinQuantity = patched(p.MinQuantity, current.MinQuantity)
+	merged.BuyQuantity = patched(p.BuyQuantity, current.BuyQuantity)
+	merged.GetQuantity = patched(p.GetQuantity, current.GetQuantity)
+	return validateCouponTerms(&merged)
+}
+
+func (p *couponPatch) updates() map[string]interface{} {
+	updates := map[string]interface{}{}
+	setField(updates, "description", p.Description, "")
+	setField(updates, "discount_type", p.DiscountType, nil)
+	setField(updates, "discount_value", p.DiscountValue, nil)
+	setField(updates, "min_order_amount", p.MinOrderAmount, 0)
+	setField(updates, "max_discount_amount", p.MaxDiscountAmount, 0)
+	setField(updates, "valid_from", p.ValidFrom, nil)
+	setField(updates, "valid_until", p.ValidUntil, nil)
+	setField(updates, "usage_limit", p.UsageLimit, 0)
+	setField(updates, "is_active", p.IsActive, nil)
+	setField(updates, "applicable_countries", p.ApplicableCountries, models.StringArray{})
+	setField(updates, "per_user_limit", p.PerUserLimit, 0)
+	setField(updates, "first_order_only", p.FirstOrderOnly, nil)
+	setField(updates, "included_categories", p.IncludedCategories, models.UintArray{})
+	setField(updates, "excluded_categories", p.ExcludedCategories, models.UintArray{})
+	setField(updates, "included_products", p.IncludedProducts, models.UintArray{})
+	setField(updates, "excluded_products", p.ExcludedProducts, models.UintArray{})
+	setField(updates, "allowed_customers", p.AllowedCustomers, models.UintArray{})
+	setField(updates, "customer_segments", p.CustomerSegments, models.StringArray{})
+	setField(updates, "min_quantity", p.MinQuantity, 0)
+	setField(updates, "buy_quantity", p.BuyQuantity, 0)
+	setField(updates, "get_quantity", p.GetQuantity, 0)
+	return updates
+}
+
+// UpdateCoupon applies a merge patch to a coupon
 func UpdateCoupon(c *gin.Context) {
 	couponID := c.Param("id")
 
@@ -615,17 +1082,31 @@ func UpdateCoupon(c *gin.Context) {
 		return
 	}
 
-	var updates map[string]interface{}
-	if err := c.ShouldBindJSON(&updates); err != nil {
+	var patch couponPatch
+	if !bindPatch(c, &patch) {
+		return
+	}
+	conditional, ok := checkVersion(c, patch.patchVersion, coupon.UpdatedAt, coupon)
+	if !ok {
+		return
+	}
+	if err := patch.validate(coupon); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	delete(updates, "code")
-
-	config.DB.Model(&coupon).Updates(updates)
+	err := savePatch(config.DB, &coupon, coupon.UpdatedAt, conditional, patch.updates())
 	config.DB.First(&coupon, coupon.ID)
+	if errors.Is(err, errRecordModified) {
+		respondModified(c, coupon.UpdatedAt, coupon)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update coupon"})
+		return
+	}
 
+	c.Header("ETag", utils.VersionTag(coupon.UpdatedAt))
 	c.JSON(http.StatusOK, coupon)
 }
 
@@ -641,46 +1122,72 @@ func DeleteCoupon(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Coupon deleted"})
 }
 
+// ValidateCoupon checks a coupon for the shopper. Sent the cart's items, it prices
+// the order the way checkout will and shows the discount on each line; with just
+// an amount it can only quote coupons that don't depend on what is in the cart.
 func ValidateCoupon(c *gin.Context) {
-	code := c.Query("code")
-	amount := c.Query("amount")
+	var input struct {
+		Code           string           `form:"code" json:"code"`
+		Amount         float64          `form:"amount" json:"amount"`
+		Country        string           `form:"country" json:"shipping_country"`
+		ShippingMethod string           `form:"shipping_method" json:"shipping_method"`
+		Items          []OrderItemInput `form:"-" json:"items"`
+	}
+	if err := c.ShouldBind(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-	if code == "" {
+	if input.Code == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Coupon code required"})
 		return
 	}
 
-	var coupon models.Coupon
-	if err := config.DB.Where("code = ?", code).First(&coupon).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"valid": false, "error": "Invalid coupon code"})
-		return
+	var uid uint
+	if userID, exists := c.Get("user_id"); exists {
+		uid = userID.(uint)
 	}
 
-	if !coupon.IsValid() {
-		c.JSON(http.StatusOK, gin.H{"valid": false, "error": "Coupon has expired or reached usage limit"})
+	if len(input.Items) > 0 {
+		validateCouponForCart(c, input.Code, input.Country, input.ShippingMethod, input.Items, uid)
 		return
 	}
 
-	var orderAmount float64
-	if amount != "" {
-		fmt.Sscanf(amount, "%f", &orderAmount)
+	var coupon models.Coupon
+	if err := config.DB.Where("code = ?", utils.NormalizeCouponCode(input.Code)).First(&coupon).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"valid": false, "error": "Invalid coupon code"})
+		return
 	}
 
-	if orderAmount > 0 && coupon.MinOrderAmount > 0 && orderAmount < coupon.MinOrderAmount {
+	cart := services.CouponCart{Subtotal: input.Amount, Country: input.Country, UserID: uid}
+	err := services.CheckCouponTerms(config.DB, &coupon, &cart)
+	if err == nil && uid != 0 {
+		err = services.CheckCouponEligibility(config.DB, &coupon, uid)
+	}
+	if errors.Is(err, services.ErrCouponMinimum) {
 		c.JSON(http.StatusOK, gin.H{
 			"valid":            false,
-			"error":            "Order amount does not meet minimum requirement",
+			"error":            err.Error(),
 			"min_order_amount": coupon.MinOrderAmount,
 		})
 		return
 	}
+	if err != nil {
+		if !services.IsCouponError(err) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check coupon"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"valid": false, "error": err.Error()})
+		return
+	}
 
+	// Without an amount we can only quote the fixed value; percentage coupons, and
+	// those that depend on the cart, are priced at checkout
 	discountAmount := coupon.DiscountValue
-	if coupon.DiscountType == "percentage" {
-		discountAmount = orderAmount * (coupon.DiscountValue / 100)
-		if coupon.MaxDiscountAmount > 0 && discountAmount > coupon.MaxDiscountAmount {
-			discountAmount = coupon.MaxDiscountAmount
-		}
+	if input.Amount > 0 {
+		discountAmount, _ = services.CouponDiscount(&coupon, input.Amount)
+	} else if coupon.DiscountType != services.DiscountFixed || services.CouponNeedsCart(&coupon) {
+		discountAmount = 0
 	}
 
 	c.JSON(http.StatusOK, gin.H{
@@ -688,10 +1195,64 @@ func ValidateCoupon(c *gin.Context) {
 		"discount_type":   coupon.DiscountType,
 		"discount_value":  coupon.DiscountValue,
 		"discount_amount": discountAmount,
+		"free_shipping":   coupon.DiscountType == services.DiscountFreeShipping,
 		"code":            coupon.Code,
 	})
 }
 
+// validateCouponForCart prices a cart with a coupon, rolling back so nothing is
+// held or redeemed
+func validateCouponForCart(c *gin.Context, code, country, shippingMethod string, items []OrderItemInput, userID uint) {
+	pricingItems := make([]services.PricingItem, len(items))
+	for i, item := range items {
+		if err := utils.ValidateQuantity(item.Quantity); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		pricingItems[i] = services.PricingItem{
+			ProductID: item.ProductID,
+			VariantID: item.VariantID,
+			Quantity:  item.Quantity,
+			Color:     item.Color,
+			Size:      item.Size,
+		}
+	}
+
+	tx := config.DB.Begin()
+	defer tx.Rollback()
+
+	pricing, err := services.PriceOrder(tx, services.PricingRequest{
+		Items:           pricingItems,
+		CouponCode:      code,
+		ShippingMethod:  shippingMethod,
+		ShippingCountry: country,
+		UserID:          userID,
+		Quote:           true,
+	})
+	if errors.Is(err, services.ErrCouponNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"valid": false, "error": "Invalid coupon code"})
+		return
+	}
+	if err != nil {
+		if services.IsCouponError(err) {
+			c.JSON(http.StatusOK, gin.H{"valid": false, "error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"valid":           true,
+		"discount_type":   pricing.Coupon.DiscountType,
+		"discount_value":  pricing.Coupon.DiscountValue,
+		"discount_amount": pricing.CouponDiscount,
+		"free_shipping":   pricing.FreeShipping,
+		"code":            pricing.CouponCode,
+		"pricing":         pricing,
+	})
+}
+
 func ShiprocketWebhook(c *gin.Context) {
 	var payload map[string]interface{}
 	if err := c.ShouldBindJSON(&payload); err != nil {
@@ -0,0 +1,303 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"pashmina-backend/config"
+	"pashmina-backend/models"
+	"pashmina-backend/services"
+	"pashmina-backend/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	mediaStorage  services.Storage
+	imagePipeline *services.ImagePipeline
+)
+
+var errFileRequired = errors.New("file is required")
+
+// GetMediaStorage returns the configured media storage (lazy initialization)
+func GetMediaStorage() services.Storage {
+	if mediaStorage == nil {
+		mediaStorage = services.NewStorage()
+	}
+	return mediaStorage
+}
+
+// GetImagePipeline returns the image resizing pipeline over media storage (lazy
+// initialization)
+func GetImagePipeline() *services.ImagePipeline {
+	if imagePipeline == nil {
+		imagePipeline = services.NewImagePipeline(GetMediaStorage())
+	}
+	return imagePipeline
+}
+
+// ServeUpload serves a stored file. With ?w= it serves a copy scaled down to that
+// width, rounded up to one of the configured sizes; ?format= picks jpeg or png.
+// Content-addressed files never change, so they are cached for a year.
+func ServeUpload(c *gin.Context) {
+	key := strings.TrimPrefix(c.Param("filepath"), "/")
+
+	width := 0
+	if w := c.Query("w"); w != "" {
+		var err error
+		if width, err = strconv.Atoi(w); err != nil || width <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid width"})
+			return
+		}
+	}
+
+	image, err := GetImagePipeline().Image(c.Request.Context(), key, width, c.Query("format"), c.GetHeader("Accept"))
+	if errors.Is(err, services.ErrObjectNotFound) || errors.Is(err, services.ErrInvalidKey) {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, services.ErrImageTooLarge) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		utils.Error("Failed to serve upload", map[string]interface{}{"key": key, "width": width, "error": err.Error()})
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	c.Header("ETag", image.ETag)
+	c.Header("X-Content-Type-Options", "nosniff")
+	if width > 0 {
+		c.Header("Vary", "Accept")
+	}
+	if image.Immutable {
+		c.Header("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		c.Header("Cache-Control", "public, max-age=3600")
+	}
+	if match := c.GetHeader("If-None-Match"); match != "" && strings.Contains(match, image.ETag) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	c.Data(http.StatusOK, image.ContentType, image.Data)
+}
+
+// readUpload reads the "file" field of a multipart upload, capped at limit bytes,
+// and returns it with the client's file name
+func readUpload(c *gin.Context, limit int64) ([]byte, string, error) {
+	// Leave room for the multipart framing and other form fields
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit+1<<20)
+
+	header, err := c.FormFile("file")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return nil, "", services.ErrMediaTooLarge
+		}
+		return nil, "", errFileRequired
+	}
+	if header.Size > limit {
+		return nil, "", services.ErrMediaTooLarge
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		return nil, "", err
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, limit+1))
+	return data, header.Filename, err
+}
+
+// storeUpload saves the uploaded file as media, writing the error response itself
+func storeUpload(c *gin.Context) (*models.Media, bool) {
+	data, _, err := readUpload(c, services.MaxUploadSize())
+	if err == nil {
+		var uploadedBy *uint
+		if userID, exists := c.Get("user_id"); exists {
+			id := userID.(uint)
+			uploadedBy = &id
+		}
+		var media *models.Media
+		if media, err = services.StoreMedia(c.Request.Context(), config.DB, GetMediaStorage(), data, uploadedBy); err == nil {
+			go GetImagePipeline().Pregenerate(context.Background(), media)
+			return media, true
+		}
+	}
+
+	switch {
+	case errors.Is(err, services.ErrMediaTooLarge):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error(), "max_bytes": services.MaxUploadSize()})
+	case errors.Is(err, services.ErrUnsupportedMedia):
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": err.Error()})
+	case errors.Is(err, errFileRequired):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		utils.Error("Media upload failed", map[string]interface{}{"error": err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store upload"})
+	}
+	return nil, false
+}
+
+// UploadMedia stores an image for use as a category, catalogue or page image and
+// returns its URL
+func UploadMedia(c *gin.Context) {
+	media, ok := storeUpload(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusCreated, media)
+}
+
+// GetProductImages lists a product's gallery in display order
+func GetProductImages(c *gin.Context) {
+	var images []models.ProductImage
+	config.DB.Where("product_id = ?", c.Param("id")).Order("position ASC, id ASC").Find(&images)
+	c.JSON(http.StatusOK, images)
+}
+
+// AddProductImage uploads an image into a product's gallery. Send the file as
+// "file", or the ID of an earlier upload as "media_id".
+func AddProductImage(c *gin.Context) {
+	productID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		return
+	}
+	var product models.Product
+	if err := config.DB.First(&product, productID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+
+	var media *models.Media
+	if mediaID := c.PostForm("media_id"); mediaID != "" {
+		media = &models.Media{}
+		if err := config.DB.First(media, mediaID).Error; err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Media not found"})
+			return
+		}
+	} else {
+		var ok bool
+		if media, ok = storeUpload(c); !ok {
+			return
+		}
+	}
+
+	altText := utils.SanitizeString(c.PostForm("alt_text"), 255)
+	image, err := services.AddProductImage(config.DB, product.ID, media, altText, c.PostForm("is_primary") == "true")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add image"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, image)
+}
+
+// UpdateProductImage changes an image's alt text or makes it the primary image
+func UpdateProductImage(c *gin.Context) {
+	productID, err1 := strconv.Atoi(c.Param("id"))
+	imageID, err2 := strconv.Atoi(c.Param("imageId"))
+	if err1 != nil || err2 != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid image ID"})
+		return
+	}
+
+	var input struct {
+		AltText   *string `json:"alt_text"`
+		IsPrimary bool    `json:"is_primary"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if input.AltText != nil {
+		alt := utils.SanitizeString(*input.AltText, 255)
+		input.AltText = &alt
+	}
+
+	image, err := services.UpdateProductImage(config.DB, uint(productID), uint(imageID), input.AltText, input.IsPrimary)
+	if errors.Is(err, services.ErrImageNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update image"})
+		return
+	}
+
+	c.JSON(http.StatusOK, image)
+}
+
+// ReorderProductImages sets the gallery order from a list of all its image IDs
+func ReorderProductImages(c *gin.Context) {
+	productID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		return
+	}
+
+	var input struct {
+		ImageIDs []uint `json:"image_ids" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := services.ReorderProductImages(config.DB, uint(productID), input.ImageIDs); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	GetProductImages(c)
+}
+
+// DeleteProductImage removes an image from a product's gallery. The file itself is
+// deleted by the orphan sweep once nothing else uses it.
+func DeleteProductImage(c *gin.Context) {
+	productID, err1 := strconv.Atoi(c.Param("id"))
+	imageID, err2 := strconv.Atoi(c.Param("imageId"))
+	if err1 != nil || err2 != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid image ID"})
+		return
+	}
+
+	err := services.RemoveProductImage(config.DB, uint(productID), uint(imageID))
+	if errors.Is(err, services.ErrImageNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete image"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Image deleted"})
+}
+
+// GetOrphanedMedia lists uploads that nothing refers to and the sweep would delete
+func GetOrphanedMedia(c *gin.Context) {
+	orphans, err := services.OrphanedMedia(config.DB, services.MediaOrphanGrace())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to find orphaned media"})
+		return
+	}
+	c.JSON(http.StatusOK, orphans)
+}
+
+// DeleteOrphanedMedia runs the orphan sweep now
+func DeleteOrphanedMedia(c *gin.Context) {
+	deleted, err := services.DeleteOrphanedMedia(c.Request.Context(), config.DB, GetMediaStorage(), GetImagePipeline(), services.MediaOrphanGrace())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete orphaned media", "deleted": deleted})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"deleted": deleted})
+}
@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"pashmina-backend/config"
+	"pashmina-backend/models"
+	"pashmina-backend/services"
+	"pashmina-backend/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// GetUserNotifications lists the current user's inbox, newest first
+func GetUserNotifications(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	page, limit = utils.ValidatePagination(page, limit)
+	offset := (page - 1) * limit
+
+	inbox := config.DB.Model(&models.Notification{}).
+		Where("user_id = ? AND channel = ?", userID, services.ChannelInApp)
+
+	var unreadCount int64
+	inbox.Session(&gorm.Session{}).Where("read_at IS NULL").Count(&unreadCount)
+
+	query := inbox.Session(&gorm.Session{})
+	if c.Query("unread") == "true" {
+		query = query.Where("read_at IS NULL")
+	}
+
+	var total int64
+	query.Count(&total)
+
+	var notifications []models.Notification
+	query.Order("created_at DESC").Offset(offset).Limit(limit).Find(&notifications)
+
+	c.JSON(http.StatusOK, gin.H{
+		"notifications": notifications,
+		"unread_count":  unreadCount,
+		"total":         total,
+		"page":          page,
+		"limit":         limit,
+	})
+}
+
+// MarkNotificationRead marks one of the current user's notifications as read
+func MarkNotificationRead(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var notification models.Notification
+	if err := config.DB.Where("id = ? AND user_id = ? AND channel = ?", c.Param("id"), userID, services.ChannelInApp).First(&notification).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Notification not found"})
+		return
+	}
+
+	if notification.ReadAt == nil {
+		now := time.Now()
+		notification.ReadAt = &now
+		config.DB.Model(&notification).Update("read_at", now)
+	}
+
+	c.JSON(http.StatusOK, notification)
+}
+
+// MarkAllNotificationsRead marks every unread notification in the current user's inbox as read
+func MarkAllNotificationsRead(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	result := config.DB.Model(&models.Notification{}).
+		Where("user_id = ? AND channel = ? AND read_at IS NULL", userID, services.ChannelInApp).
+		Update("read_at", time.Now())
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update notifications"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"updated": result.RowsAffected})
+}
+
+// DeleteNotification removes a notification from the current user's inbox
+func DeleteNotification(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var notification models.Notification
+	if err := config.DB.Where("id = ? AND user_id = ? AND channel = ?", c.Param("id"), userID, services.ChannelInApp).First(&notification).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Notification not found"})
+		return
+	}
+
+	if err := config.DB.Delete(&notification).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete notification"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Notification deleted"})
+}
+
+// GetEmailLog lists sent and failed emails, newest first. It can be filtered by
+// status, type and recipient address.
+func GetEmailLog(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	page, limit = utils.ValidatePagination(page, limit)
+	offset := (page - 1) * limit
+
+	query := config.DB.Model(&models.EmailLog{})
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if emailType := c.Query("type"); emailType != "" {
+		query = query.Where("type = ?", emailType)
+	}
+	if to := c.Query("to"); to != "" {
+		query = query.Where("to_address = ?", to)
+	}
+
+	var total int64
+	query.Count(&total)
+
+	var emails []models.EmailLog
+	query.Order("created_at DESC").Offset(offset).Limit(limit).Find(&emails)
+
+	c.JSON(http.StatusOK, gin.H{
+		"emails": emails,
+		"total":  total,
+		"page":   page,
+		"limit":  limit,
+	})
+}
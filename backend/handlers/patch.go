@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"pashmina-backend/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Update endpoints take a JSON merge patch: fields left out are unchanged, fields
+// sent as null are cleared, and fields the patch struct doesn't list are refused.
+// A client that sends If-Match with the ETag it read, or the updated_at it read,
+// gets 412 instead of overwriting a change made since.
+
+var errRecordModified = errors.New("record was modified")
+
+// patchVersion is embedded in patch structs so the updated_at a client read can be
+// sent back in the body instead of an If-Match header
+type patchVersion struct {
+	UpdatedAt *time.Time `json:"updated_at"`
+}
+
+// bindPatch decodes the request body into a patch struct, answering 400 on failure
+func bindPatch(c *gin.Context, patch interface{}) bool {
+	if err := utils.DecodePatch(c.Request.Body, patch); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+	return true
+}
+
+// checkVersion compares the client's If-Match header and updated_at with the version
+// of the record it is patching, answering 412 with the current record on a mismatch.
+// conditional reports whether the client asked for the check, in which case the
+// write has to be conditional too.
+func checkVersion(c *gin.Context, version patchVersion, updatedAt time.Time, current interface{}) (conditional, ok bool) {
+	if ifMatch := c.GetHeader("If-Match"); ifMatch != "" {
+		if !utils.MatchesVersion(ifMatch, updatedAt) {
+			respondModified(c, updatedAt, current)
+			return false, false
+		}
+		conditional = strings.TrimSpace(ifMatch) != "*"
+	}
+	if version.UpdatedAt != nil {
+		if !version.UpdatedAt.Round(time.Microsecond).Equal(updatedAt.Round(time.Microsecond)) {
+			respondModified(c, updatedAt, current)
+			return false, false
+		}
+		conditional = true
+	}
+	return conditional, true
+}
+
+// respondModified answers 412 with the record as it is now, so the client can
+// merge its change and try again
+func respondModified(c *gin.Context, updatedAt time.Time, current interface{}) {
+	c.Header("ETag", utils.VersionTag(updatedAt))
+	c.JSON(http.StatusPreconditionFailed, gin.H{
+		"error":   "This record has been changed since it was loaded; reload it and try again",
+		"current": current,
+	})
+}
+
+// savePatch writes a patch's column updates to record. A conditional write only goes
+// through while the row still has the updated_at it was read with, so a change that
+// lands between reading and writing returns errRecordModified rather than being lost.
+func savePatch(db *gorm.DB, record interface{}, readAt time.Time, conditional bool, updates map[string]interface{}) error {
+	if len(updates) == 0 {
+		return nil
+	}
+	query := db.Model(record)
+	if conditional {
+		query = query.Where("updated_at = ?", readAt)
+	}
+	result := query.Updates(updates)
+	if result.Error != nil {
+		return result.Error
+	}
+	if conditional && result.RowsAffected == 0 {
+		return errRecordModified
+	}
+	return nil
+}
+
+// setField adds a patched field to updates: its value, or cleared if it was sent as null
+func setField[T any](updates map[string]interface{}, column string, field utils.PatchField[T], cleared interface{}) {
+	switch {
+	case field.Null:
+		updates[column] = cleared
+	case field.Set:
+		updates[column] = field.Value
+	}
+}
+
+// patched returns the value a field will have once the patch is applied
+func patched[T any](field utils.PatchField[T], current T) T {
+	if field.Set {
+		return field.Value
+	}
+	return current
+}
+
+// patchedTime is patched for an optional time, which null clears
+func patchedTime(field utils.PatchField[time.Time], current *time.Time) *time.Time {
+	switch {
+	case field.Null:
+		return nil
+	case field.Set:
+		return &field.Value
+	}
+	return current
+}
+
+// patchedID is patched for an optional ID, which null clears
+func patchedID(field utils.PatchField[uint], current *uint) *uint {
+	switch {
+	case field.Null:
+		return nil
+	case field.Set:
+		return &field.Value
+	}
+	return current
+}
+
+// firstError returns the first non-nil error
+func firstError(errs ...error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -11,9 +12,11 @@ import (
 	"pashmina-backend/config"
 	"pashmina-backend/models"
 	"pashmina-backend/services"
+	"pashmina-backend/utils"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 var (
@@ -37,7 +40,8 @@ func GetRazorpayService() *services.RazorpayService {
 	return razorpayService
 }
 
-// CreatePaymentIntent creates a Razorpay order for payment
+// CreatePaymentIntent creates a Razorpay order for what is due on an existing order,
+// after any gift card and store credit
 func CreatePaymentIntent(c *gin.Context) {
 	if GetRazorpayService() == nil {
 		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Payment service not available"})
@@ -45,10 +49,9 @@ func CreatePaymentIntent(c *gin.Context) {
 	}
 
 	var input struct {
-		Amount   float64                `json:"amount" binding:"required"`
-		Currency string                 `json:"currency" binding:"required"`
-		Receipt  string                 `json:"receipt"`
-		Notes    map[string]interface{} `json:"notes"`
+		OrderID uint                   `json:"order_id" binding:"required"`
+		Receipt string                 `json:"receipt"`
+		Notes   map[string]interface{} `json:"notes"`
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -56,18 +59,44 @@ func CreatePaymentIntent(c *gin.Context) {
 		return
 	}
 
+	// The customer comes from the session, so they can only pay for their own orders
+	var order models.Order
+	if err := ownOrders(c, config.DB).First(&order, input.OrderID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	}
+
+	if order.PaymentStatus == "paid" || order.Status != "pending_payment" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Order is not awaiting payment"})
+		return
+	}
+	amountDue := services.AmountDue(&order)
+	if amountDue <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Order has nothing left to pay"})
+		return
+	}
+
 	// Generate receipt if not provided
 	if input.Receipt == "" {
 		input.Receipt = services.GenerateReceiptID()
 	}
 
-	order, err := razorpayService.CreateOrder(input.Amount, input.Currency, input.Receipt, input.Notes)
+	if input.Notes == nil {
+		input.Notes = map[string]interface{}{}
+	}
+	input.Notes["order_id"] = order.ID
+
+	rzpOrder, err := razorpayService.CreateOrder(amountDue, order.Currency, input.Receipt, input.Notes)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, order)
+	if rzpOrderID, ok := rzpOrder["id"].(string); ok {
+		config.DB.Model(&order).Update("razorpay_order_id", rzpOrderID)
+	}
+
+	c.JSON(http.StatusOK, rzpOrder)
 }
 
 // VerifyPayment verifies Razorpay payment signature
@@ -95,30 +124,106 @@ func VerifyPayment(c *gin.Context) {
 		return
 	}
 
+	// The signature only proves the payment belongs to the Razorpay order, so the
+	// payment itself is checked against the order below
+	payment, err := razorpayService.FetchPayment(input.PaymentID)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to fetch payment"})
+		return
+	}
+
 	// Update order in database
+	tx := config.DB.Begin()
+
 	var order models.Order
-	if err := config.DB.First(&order, input.OrderIDInt).Error; err != nil {
+	if err := ownOrders(c, tx).Clauses(clause.Locking{Strength: "UPDATE"}).First(&order, input.OrderIDInt).Error; err != nil {
+		tx.Rollback()
 		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
 		return
 	}
 
+	// The Razorpay order was created from what is due on this order, so it must be
+	// the one the payment was made against, and for that amount
+	if order.RazorpayOrderID == "" || order.RazorpayOrderID != input.OrderID ||
+		services.CheckPayment(payment, order.RazorpayOrderID, services.AmountDue(&order), order.Currency) != nil {
+		tx.Rollback()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Payment does not belong to this order"})
+		return
+	}
+
+	// The webhook may have confirmed this payment already
+	alreadyPaid := order.PaymentStatus == "paid"
+	var giftCards []models.GiftCard
+	if !alreadyPaid && !services.CanTransition(order.Status, "paid") {
+		tx.Rollback()
+		c.JSON(http.StatusConflict, gin.H{"error": "Order cannot be paid while " + order.Status})
+		return
+	}
+
+	if !alreadyPaid {
+		if err := services.ClaimReservedStock(tx, &order); err != nil {
+			tx.Rollback()
+			var stockErr *services.InsufficientStockError
+			if errors.As(err, &stockErr) {
+				refundUnfulfillableOrder(&order, input.PaymentID, input.OrderID)
+				c.JSON(http.StatusConflict, gin.H{
+					"error":    "Order expired and its items are no longer in stock; the payment has been refunded",
+					"order_id": order.ID,
+					"product":  stockErr.Name,
+				})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to confirm stock"})
+			return
+		}
+
+		// An order paid after it expired gets back the coupon use it released
+		if err := services.ReclaimCouponRedemption(tx, order.ID); err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update order"})
+			return
+		}
+
+		if err := services.TransitionOrder(tx, &order, "paid", statusChange(c, services.StatusSourceCustomer, "Payment verified")); err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update order"})
+			return
+		}
+
+		if giftCards, err = settleOrderCredit(tx, &order); err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update order"})
+			return
+		}
+	}
+
 	// Update order with payment details
 	order.PaymentStatus = "paid"
-	order.Status = "paid"
 	order.RazorpayOrderID = input.OrderID
 	order.RazorpayPaymentID = input.PaymentID
 	order.RazorpaySignature = input.Signature
 
-	if err := config.DB.Save(&order).Error; err != nil {
+	if err := tx.Save(&order).Error; err != nil {
+		tx.Rollback()
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update order"})
 		return
 	}
 
+	if err := tx.Commit().Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update order"})
+		return
+	}
+
+	if !alreadyPaid {
+		services.NotifyOrderStatus(config.DB, &order)
+		sendGiftCards(giftCards, &order)
+	}
+
 	// Create payment transaction record
 	transaction := models.PaymentTransaction{
 		OrderID:       order.ID,
 		Provider:      "razorpay",
-		Amount:        order.TotalAmount,
+		Amount:        services.AmountDue(&order),
 		Currency:      order.Currency,
 		Status:        "success",
 		TransactionID: input.PaymentID,
@@ -175,13 +280,78 @@ func RazorpayWebhook(c *gin.Context) {
 	case "payment.captured":
 		payment := payload["payment"].(map[string]interface{})
 		orderID := payment["order_id"].(string)
+		paymentID, _ := payment["id"].(string)
 
 		// Update order status
+		tx := config.DB.Begin()
 		var order models.Order
-		if err := config.DB.Where("razorpay_order_id = ?", orderID).First(&order).Error; err == nil {
-			order.PaymentStatus = "paid"
-			order.Status = "paid"
-			config.DB.Save(&order)
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("razorpay_order_id = ?", orderID).First(&order).Error; err != nil || order.PaymentStatus == "paid" {
+			tx.Rollback()
+			break
+		}
+
+		if err := services.CheckPayment(payment, order.RazorpayOrderID, services.AmountDue(&order), order.Currency); err != nil {
+			tx.Rollback()
+			utils.Warn("Captured payment does not match the order", map[string]interface{}{
+				"order_id":   order.ID,
+				"payment_id": paymentID,
+				"amount":     payment["amount"],
+			})
+			break
+		}
+
+		if !services.CanTransition(order.Status, "paid") {
+			tx.Rollback()
+			utils.Warn("Captured payment for order that cannot be paid", map[string]interface{}{
+				"order_id":   order.ID,
+				"status":     order.Status,
+				"payment_id": paymentID,
+			})
+			break
+		}
+
+		if err := services.ClaimReservedStock(tx, &order); err != nil {
+			tx.Rollback()
+			var stockErr *services.InsufficientStockError
+			if errors.As(err, &stockErr) {
+				refundUnfulfillableOrder(&order, paymentID, orderID)
+				break
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to confirm stock"})
+			return
+		}
+
+		if err := services.ReclaimCouponRedemption(tx, order.ID); err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update order"})
+			return
+		}
+
+		if err := services.TransitionOrder(tx, &order, "paid", services.StatusChange{
+			Source: services.StatusSourceWebhook,
+			Reason: "payment.captured",
+		}); err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update order"})
+			return
+		}
+
+		giftCards, err := settleOrderCredit(tx, &order)
+		if err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update order"})
+			return
+		}
+
+		order.PaymentStatus = "paid"
+		if paymentID != "" {
+			order.RazorpayPaymentID = paymentID
+		}
+		tx.Save(&order)
+		if err := tx.Commit().Error; err == nil {
+			services.NotifyOrderStatus(config.DB, &order)
+			sendGiftCards(giftCards, &order)
 		}
 
 	case "payment.failed":
@@ -189,17 +359,81 @@ func RazorpayWebhook(c *gin.Context) {
 		orderID := payment["order_id"].(string)
 
 		// Update order status
+		tx := config.DB.Begin()
 		var order models.Order
-		if err := config.DB.Where("razorpay_order_id = ?", orderID).First(&order).Error; err == nil {
-			order.PaymentStatus = "failed"
-			order.Status = "payment_failed"
-			config.DB.Save(&order)
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("razorpay_order_id = ?", orderID).First(&order).Error; err != nil {
+			tx.Rollback()
+			break
+		}
+
+		// A failed attempt after a successful one leaves the order as it is
+		if err := services.TransitionOrder(tx, &order, "payment_failed", services.StatusChange{
+			Source: services.StatusSourceWebhook,
+			Reason: "payment.failed",
+		}); err != nil {
+			tx.Rollback()
+			break
+		}
+
+		order.PaymentStatus = "failed"
+		tx.Save(&order)
+		if err := tx.Commit().Error; err == nil {
+			services.NotifyOrderStatus(config.DB, &order)
 		}
 	}
 
 	c.JSON(http.StatusOK, gin.H{"status": "received"})
 }
 
+// statusChange describes a status change made by the authenticated user
+func statusChange(c *gin.Context, source, reason string) services.StatusChange {
+	change := services.StatusChange{Source: source, Reason: reason}
+	if userID, ok := c.Get("user_id"); ok {
+		if id, ok := userID.(uint); ok {
+			change.ActorID = &id
+		}
+	}
+	return change
+}
+
+// refundUnfulfillableOrder refunds a payment that arrived after the order expired
+// and its items sold out in the meantime
+func refundUnfulfillableOrder(order *models.Order, paymentID, razorpayOrderID string) {
+	reason := "Order expired before payment and items are out of stock"
+	notes := map[string]interface{}{
+		"order_id": order.ID,
+		"reason":   reason,
+	}
+
+	amount := services.AmountDue(order)
+	if _, err := razorpayService.RefundPayment(paymentID, &amount, notes); err != nil {
+		utils.Error("Failed to refund payment for expired order", map[string]interface{}{
+			"order_id":   order.ID,
+			"payment_id": paymentID,
+			"error":      err.Error(),
+		})
+		return
+	}
+
+	config.DB.Model(order).Updates(map[string]interface{}{
+		"payment_status":      "refunded",
+		"razorpay_payment_id": paymentID,
+	})
+
+	transaction := models.PaymentTransaction{
+		OrderID:       order.ID,
+		Provider:      "razorpay",
+		Amount:        amount,
+		Currency:      order.Currency,
+		Status:        "refunded",
+		TransactionID: paymentID,
+		OrderIDExt:    razorpayOrderID,
+		Metadata:      models.JSONB{"reason": reason},
+	}
+	config.DB.Create(&transaction)
+}
+
 // GetPaymentStatus gets the status of a payment
 func GetPaymentStatus(c *gin.Context) {
 	if razorpayService == nil {
@@ -222,7 +456,7 @@ func GetPaymentStatus(c *gin.Context) {
 	c.JSON(http.StatusOK, payment)
 }
 
-// ProcessRefund processes a refund for a payment
+// ProcessRefund processes a refund for a payment, capped at the order's stored total
 func ProcessRefund(c *gin.Context) {
 	if razorpayService == nil {
 		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Payment service not available"})
@@ -240,36 +474,85 @@ func ProcessRefund(c *gin.Context) {
 		return
 	}
 
+	var order models.Order
+	if err := config.DB.Where("razorpay_payment_id = ?", input.PaymentID).First(&order).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found for payment"})
+		return
+	}
+
+	refundable := refundableAmount(&order)
+	amount := refundable
+	if input.Amount != nil {
+		amount = *input.Amount
+	}
+
+	if amount <= 0 || amount > refundable {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":      "Refund amount exceeds refundable balance",
+			"refundable": refundable,
+		})
+		return
+	}
+
 	notes := map[string]interface{}{
-		"reason": input.Reason,
+		"order_id": order.ID,
+		"reason":   input.Reason,
 	}
 
-	refund, err := razorpayService.RefundPayment(input.PaymentID, input.Amount, notes)
+	refund, err := razorpayService.RefundPayment(input.PaymentID, &amount, notes)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	transaction := models.PaymentTransaction{
+		OrderID:       order.ID,
+		Provider:      "razorpay",
+		Amount:        amount,
+		Currency:      order.Currency,
+		Status:        "refunded",
+		TransactionID: input.PaymentID,
+		OrderIDExt:    order.RazorpayOrderID,
+		Metadata:      models.JSONB{"reason": input.Reason},
+	}
+	config.DB.Create(&transaction)
+
+	if amount >= refundable {
+		config.DB.Model(&order).Update("payment_status", "refunded")
+	}
+
 	c.JSON(http.StatusOK, refund)
 }
 
+// refundableAmount returns the part of the order's stored total paid through
+// Razorpay and not yet refunded
+func refundableAmount(order *models.Order) float64 {
+	var refunded float64
+	config.DB.Model(&models.PaymentTransaction{}).
+		Where("order_id = ? AND status = ?", order.ID, "refunded").
+		Select("COALESCE(SUM(amount), 0)").Scan(&refunded)
+
+	return services.RoundMoney(services.AmountDue(order) - refunded)
+}
+
 // CalculateShippingRates gets shipping rates for an address
 func CalculateShippingRates(c *gin.Context) {
 	shiprocket := GetShiprocketService()
 	if shiprocket == nil {
-		// Return default rates if Shiprocket not configured
+		// Return the server rate table if Shiprocket not configured
+		rates := services.ShippingRates()
 		c.JSON(http.StatusOK, gin.H{
 			"rates": []gin.H{
 				{
 					"courier_name":   "Standard Shipping",
-					"rate":           150,
+					"rate":           rates["standard"],
 					"currency":       "INR",
 					"estimated_days": 5,
 					"service_type":   "standard",
 				},
 				{
 					"courier_name":   "Express Shipping",
-					"rate":           300,
+					"rate":           rates["express"],
 					"currency":       "INR",
 					"estimated_days": 2,
 					"service_type":   "express",
@@ -364,7 +647,7 @@ func GenerateShippingLabel(c *gin.Context) {
 
 	// Get order from database
 	var order models.Order
-	if err := config.DB.Preload("Items.Product").First(&order, orderID).Error; err != nil {
+	if err := config.DB.Preload("Items.Product").Preload("Items.Variant").First(&order, orderID).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
 		return
 	}
@@ -375,6 +658,11 @@ func GenerateShippingLabel(c *gin.Context) {
 		return
 	}
 
+	if order.Status != "processing" && !services.CanTransition(order.Status, "processing") {
+		c.JSON(http.StatusConflict, gin.H{"error": "Cannot ship an order that is " + order.Status})
+		return
+	}
+
 	// Create shipping order in Shiprocket
 	shippingOrder := map[string]interface{}{
 		"order_id":              fmt.Sprintf("ORD%d", order.ID),
@@ -399,7 +687,7 @@ func GenerateShippingLabel(c *gin.Context) {
 		"giftwrap_charges":      0,
 		"transaction_charges":   0,
 		"total_discount":        order.DiscountAmount,
-		"sub_total":             order.TotalAmount - order.ShippingCost + order.DiscountAmount,
+		"sub_total":             order.Subtotal,
 		"length":                10,
 		"breadth":               10,
 		"height":                10,
@@ -408,10 +696,18 @@ func GenerateShippingLabel(c *gin.Context) {
 
 	// Add order items
 	items := make([]map[string]interface{}, len(order.Items))
+	weight := 0.0
 	for i, item := range order.Items {
+		sku := item.SKU
+		if sku == "" {
+			sku = fmt.Sprintf("SKU%d", item.ProductID)
+		}
+		if item.Variant != nil {
+			weight += item.Variant.Weight * float64(item.Quantity)
+		}
 		items[i] = map[string]interface{}{
 			"name":          item.Product.Name,
-			"sku":           fmt.Sprintf("SKU%d", item.ProductID),
+			"sku":           sku,
 			"units":         item.Quantity,
 			"selling_price": item.Price,
 			"discount":      0,
@@ -419,6 +715,9 @@ func GenerateShippingLabel(c *gin.Context) {
 		}
 	}
 	shippingOrder["order_items"] = items
+	if weight > 0 {
+		shippingOrder["weight"] = weight
+	}
 
 	// Create order in Shiprocket
 	shiprocketOrder, err := shiprocketService.CreateOrder(shippingOrder)
@@ -460,17 +759,38 @@ func GenerateShippingLabel(c *gin.Context) {
 	// Update order with tracking info
 	order.TrackingNumber = awbNumber
 	order.ShippingProvider = "shiprocket"
-	order.Status = "processing"
 
 	// Set estimated delivery (5-7 days from now)
 	estimatedDelivery := time.Now().AddDate(0, 0, 7)
 	order.EstimatedDelivery = &estimatedDelivery
 
-	if err := config.DB.Save(&order).Error; err != nil {
+	tx := config.DB.Begin()
+
+	shippedNow := order.Status != "processing"
+	if shippedNow {
+		change := statusChange(c, services.StatusSourceAdmin, "Shipping label generated, AWB "+awbNumber)
+		if err := services.TransitionOrder(tx, &order, "processing", change); err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	if err := tx.Save(&order).Error; err != nil {
+		tx.Rollback()
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update order"})
 		return
 	}
 
+	if err := tx.Commit().Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update order"})
+		return
+	}
+
+	if shippedNow {
+		services.NotifyOrderStatus(config.DB, &order)
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"awb_number":   awbNumber,
 		"shipment_id":  shipmentID,
@@ -511,7 +831,7 @@ func GetOrderTracking(c *gin.Context) {
 	}
 
 	var order models.Order
-	if err := config.DB.Preload("Items.Product").First(&order, orderID).Error; err != nil {
+	if err := ownOrders(c, config.DB).Preload("Items.Product").First(&order, orderID).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
 		return
 	}
@@ -526,6 +846,10 @@ func GetOrderTracking(c *gin.Context) {
 		"delivered_at":       order.DeliveredAt,
 	}
 
+	if timeline, err := services.OrderTimeline(config.DB, order.ID); err == nil {
+		trackingInfo["timeline"] = timeline
+	}
+
 	// If we have an AWB and Shiprocket is configured, fetch live tracking
 	if order.TrackingNumber != "" && shiprocketService != nil {
 		tracking, err := shiprocketService.TrackShipment(order.TrackingNumber)
@@ -547,7 +871,9 @@ func UpdateOrderStatus(c *gin.Context) {
 	}
 
 	var input struct {
-		Status string `json:"status" binding:"required"`
+		Status       string `json:"status" binding:"required"`
+		Reason       string `json:"reason"`
+		RefundMethod string `json:"refund_method"`
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -555,31 +881,101 @@ func UpdateOrderStatus(c *gin.Context) {
 		return
 	}
 
+	if err := utils.ValidateOrderStatus(input.Status); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !validRefundMethod(&input.RefundMethod) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "refund_method must be original or store_credit"})
+		return
+	}
+
+	tx := config.DB.Begin()
+
 	var order models.Order
-	if err := config.DB.First(&order, orderID).Error; err != nil {
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Items").First(&order, orderID).Error; err != nil {
+		tx.Rollback()
 		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
 		return
 	}
 
-	order.Status = input.Status
+	// Orders are marked paid when their payment is verified, never by hand
+	from := order.Status
+	if !services.AdminCanTransition(from, input.Status) {
+		tx.Rollback()
+		c.JSON(http.StatusConflict, gin.H{
+			"error":   (&services.InvalidTransitionError{From: from, To: input.Status}).Error(),
+			"allowed": services.AdminNextStatuses(from),
+		})
+		return
+	}
+
+	reason := utils.SanitizeString(input.Reason, 500)
+	if err := services.TransitionOrder(tx, &order, input.Status, statusChange(c, services.StatusSourceAdmin, reason)); err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update order"})
+		return
+	}
+
+	// An order that is abandoned or refunded gives back its stock, coupon use, gift
+	// card balance and store credit, and what was paid is refunded. Delivered items
+	// are with the customer, so they are not restocked. An order moved to paid
+	// takes its stock and credit again.
+	var giftCards []models.GiftCard
+	var storeCredit float64
+	switch order.Status {
+	case "cancelled", "expired", "refunded":
+		change := statusChange(c, services.StatusSourceAdmin, "Order "+order.Status)
+		storeCredit, err = unwindOrder(tx, &order, from != "delivered", input.RefundMethod, change)
+	case "paid":
+		err = services.ClaimReservedStock(tx, &order)
+		if err == nil {
+			err = services.ReclaimCouponRedemption(tx, order.ID)
+		}
+		if err == nil {
+			giftCards, err = settleOrderCredit(tx, &order)
+		}
+	}
+	var stockErr *services.InsufficientStockError
+	if errors.As(err, &stockErr) {
+		tx.Rollback()
+		c.JSON(http.StatusConflict, gin.H{"error": "Not enough stock left for " + stockErr.Name})
+		return
+	}
+	if errors.Is(err, services.ErrGiftCardUsed) {
+		tx.Rollback()
+		c.JSON(http.StatusConflict, gin.H{"error": "A gift card bought with this order has already been used"})
+		return
+	}
+	if err != nil {
+		tx.Rollback()
+		utils.Error("Failed to update order status", map[string]interface{}{"order_id": order.ID, "status": order.Status, "error": err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update order"})
+		return
+	}
 
-	// Update timestamps based on status
-	now := time.Now()
-	switch input.Status {
-	case "shipped":
-		order.ShippedAt = &now
-	case "delivered":
-		order.DeliveredAt = &now
+	if err := tx.Save(&order).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update order"})
+		return
 	}
 
-	if err := config.DB.Save(&order).Error; err != nil {
+	if err := tx.Commit().Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update order"})
 		return
 	}
 
+	if order.Status == "cancelled" && order.TrackingNumber != "" && shiprocketService != nil {
+		GetShiprocketService().CancelOrder([]int{int(order.ID)}, "order_ids")
+	}
+
+	services.NotifyOrderStatus(config.DB, &order)
+	sendGiftCards(giftCards, &order)
+
 	c.JSON(http.StatusOK, gin.H{
-		"order_id": order.ID,
-		"status":   order.Status,
+		"order_id":           order.ID,
+		"status":             order.Status,
+		"refunded_as_credit": storeCredit,
 	})
 }
 
@@ -593,7 +989,10 @@ func GetOrderDetails(c *gin.Context) {
 	}
 
 	var order models.Order
-	if err := config.DB.Preload("Items.Product").Preload("User").First(&order, orderID).Error; err != nil {
+	if err := ownOrders(c, config.DB).Preload("Items.Product").Preload("User").
+		Preload("StatusHistory", func(db *gorm.DB) *gorm.DB {
+			return db.Order("created_at ASC, id ASC")
+		}).First(&order, orderID).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
 		return
 	}
@@ -601,7 +1000,78 @@ func GetOrderDetails(c *gin.Context) {
 	c.JSON(http.StatusOK, order)
 }
 
-// CancelOrder cancels an order
+// ownOrders limits a query to the signed-in customer's orders, unless they are an admin
+func ownOrders(c *gin.Context, db *gorm.DB) *gorm.DB {
+	if role, _ := c.Get("user_role"); role == "admin" {
+		return db
+	}
+	userID, _ := c.Get("user_id")
+	return db.Where("user_id = ?", userID)
+}
+
+// GetOrderInvoice returns the invoice for an order built from its stored price breakdown
+func GetOrderInvoice(c *gin.Context) {
+	orderIDStr := c.Param("id")
+	orderID, err := strconv.Atoi(orderIDStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+
+	var order models.Order
+	if err := ownOrders(c, config.DB).Preload("Items.Product").First(&order, orderID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	}
+
+	lines := make([]gin.H, len(order.Items))
+	for i, item := range order.Items {
+		lines[i] = gin.H{
+			"product_id": item.ProductID,
+			"sku":        item.SKU,
+			"name":       item.Product.Name,
+			"color":      item.Color,
+			"size":       item.Size,
+			"quantity":   item.Quantity,
+			"unit_price": item.Price,
+			"line_total": item.LineTotal,
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"invoice_number": fmt.Sprintf("INV%06d", order.ID),
+		"order_id":       order.ID,
+		"issued_at":      order.CreatedAt,
+		"currency":       order.Currency,
+		"bill_to": gin.H{
+			"name":  order.ShippingName,
+			"email": order.ShippingEmail,
+			"phone": order.ShippingPhone,
+		},
+		"ship_to": gin.H{
+			"address": order.ShippingAddress,
+			"city":    order.ShippingCity,
+			"state":   order.ShippingState,
+			"country": order.ShippingCountry,
+			"zip":     order.ShippingZip,
+		},
+		"lines":               lines,
+		"subtotal":            order.Subtotal,
+		"discount_amount":     order.DiscountAmount,
+		"coupon_code":         order.CouponCode,
+		"shipping_cost":       order.ShippingCost,
+		"tax_amount":          order.TaxAmount,
+		"total_amount":        order.TotalAmount,
+		"gift_card_amount":    order.GiftCardAmount,
+		"store_credit_amount": order.StoreCreditAmount,
+		"amount_due":          services.AmountDue(&order),
+		"payment_status":      order.PaymentStatus,
+	})
+}
+
+// CancelOrder cancels an order. A paid order is refunded to the card it was paid
+// with, or as store credit when refund_method is store_credit. Gift card balance
+// and store credit the order used are always given back.
 func CancelOrder(c *gin.Context) {
 	orderIDStr := c.Param("id")
 	orderID, err := strconv.Atoi(orderIDStr)
@@ -610,30 +1080,125 @@ func CancelOrder(c *gin.Context) {
 		return
 	}
 
+	var input struct {
+		RefundMethod string `json:"refund_method"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !validRefundMethod(&input.RefundMethod) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "refund_method must be original or store_credit"})
+		return
+	}
+
+	// Customers can only cancel their own orders. The order stays locked until the
+	// cancellation commits, so it can't be paid or cancelled twice meanwhile.
+	tx := config.DB.Begin()
+
 	var order models.Order
-	if err := config.DB.Preload("Items").First(&order, orderID).Error; err != nil {
+	if err := ownOrders(c, tx).Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Items").First(&order, orderID).Error; err != nil {
+		tx.Rollback()
 		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
 		return
 	}
 
 	// Check if order can be cancelled
-	if order.Status == "shipped" || order.Status == "delivered" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot cancel shipped or delivered order"})
+	if !services.CanTransition(order.Status, "cancelled") {
+		tx.Rollback()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot cancel an order that is " + order.Status})
 		return
 	}
 
+	source := services.StatusSourceCustomer
+	if role, _ := c.Get("user_role"); role == "admin" {
+		source = services.StatusSourceAdmin
+	}
+	if err := services.TransitionOrder(tx, &order, "cancelled", statusChange(c, source, "Order cancelled")); err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Gift cards bought with the order can't be taken back once spent from. They stay
+	// locked from here, so none can be spent from between this check and the refund.
+	storeCredit, err := unwindOrder(tx, &order, true, input.RefundMethod, statusChange(c, source, "Order cancelled"))
+	if err != nil {
+		tx.Rollback()
+		if errors.Is(err, services.ErrGiftCardUsed) {
+			c.JSON(http.StatusConflict, gin.H{"error": "A gift card bought with this order has already been used"})
+			return
+		}
+		utils.Error("Failed to cancel order", map[string]interface{}{"order_id": order.ID, "error": err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel order"})
+		return
+	}
+
+	if err := tx.Save(&order).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel order"})
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel order"})
+		return
+	}
+
+	// Cancel shipping order if it exists
+	if order.TrackingNumber != "" && shiprocketService != nil {
+		// Extract shipment ID from order or tracking number
+		// This is simplified - in production you'd store the shipment ID
+		GetShiprocketService().CancelOrder([]int{int(order.ID)}, "order_ids")
+	}
+
+	services.NotifyOrderStatus(config.DB, &order)
+
+	c.JSON(http.StatusOK, gin.H{
+		"order_id":           order.ID,
+		"status":             "cancelled",
+		"refunded_as_credit": storeCredit,
+		"message":            "Order cancelled successfully",
+	})
+}
+
+// unwindOrder gives back what a cancelled, expired or refunded order holds: its
+// stock when restock is set, its coupon use, the gift card balance and store credit
+// it used, and the gift cards bought with it. What was paid through Razorpay is
+// refunded to the card, or as store credit when refundMethod is store_credit. It
+// returns the amount refunded as store credit.
+func unwindOrder(tx *gorm.DB, order *models.Order, restock bool, refundMethod string, change services.StatusChange) (float64, error) {
+	// Release held stock, or restock the items if the holds were already committed
+	if restock {
+		if err := services.RestoreOrderStock(tx, order); err != nil {
+			return 0, fmt.Errorf("restore stock: %w", err)
+		}
+	}
+
+	if err := services.ReleaseCouponRedemption(tx, order.ID); err != nil {
+		return 0, fmt.Errorf("release coupon: %w", err)
+	}
+
+	if err := services.ReleaseOrderCredit(tx, order); err != nil {
+		return 0, fmt.Errorf("return gift card and store credit: %w", err)
+	}
+
+	if err := services.CancelOrderGiftCards(tx, order, services.LedgerChange{Reason: change.Reason, ActorID: change.ActorID}); err != nil {
+		return 0, err
+	}
+
 	// If order is paid, process refund
-	if order.PaymentStatus == "paid" && order.RazorpayPaymentID != "" && razorpayService != nil {
-		refundReason := "Order cancelled by customer"
+	refundReason := "Refund for " + order.Status + " order"
+	refundToCredit := order.PaymentStatus == "paid" && refundMethod == "store_credit"
+	if order.PaymentStatus == "paid" && order.RazorpayPaymentID != "" && razorpayService != nil && !refundToCredit {
 		notes := map[string]interface{}{
 			"order_id": order.ID,
 			"reason":   refundReason,
 		}
 
-		_, err := razorpayService.RefundPayment(order.RazorpayPaymentID, &order.TotalAmount, notes)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process refund: " + err.Error()})
-			return
+		refundAmount := refundableAmount(order)
+		if _, err := razorpayService.RefundPayment(order.RazorpayPaymentID, &refundAmount, notes); err != nil {
+			return 0, fmt.Errorf("process refund: %w", err)
 		}
 
 		order.PaymentStatus = "refunded"
@@ -642,41 +1207,61 @@ func CancelOrder(c *gin.Context) {
 		transaction := models.PaymentTransaction{
 			OrderID:       order.ID,
 			Provider:      "razorpay",
-			Amount:        order.TotalAmount,
+			Amount:        refundAmount,
 			Currency:      order.Currency,
 			Status:        "refunded",
 			TransactionID: order.RazorpayPaymentID,
 			OrderIDExt:    order.RazorpayOrderID,
 			Metadata:      models.JSONB{"reason": refundReason},
 		}
-		config.DB.Create(&transaction)
-	}
-
-	// Cancel shipping order if it exists
-	if order.TrackingNumber != "" && shiprocketService != nil {
-		// Extract shipment ID from order or tracking number
-		// This is simplified - in production you'd store the shipment ID
-		GetShiprocketService().CancelOrder([]int{int(order.ID)}, "order_ids")
+		tx.Create(&transaction)
+	}
+
+	// Refund what was paid through Razorpay as store credit instead of to the card
+	var storeCredit float64
+	if refundToCredit {
+		if storeCredit = refundableAmount(order); storeCredit > 0 {
+			if _, err := services.AddStoreCredit(tx, order.UserID, services.LedgerChange{
+				Amount:  storeCredit,
+				Kind:    services.LedgerRefund,
+				OrderID: &order.ID,
+				Reason:  refundReason,
+				ActorID: change.ActorID,
+			}); err != nil {
+				return 0, fmt.Errorf("issue store credit: %w", err)
+			}
+			if err := tx.Create(&models.PaymentTransaction{
+				OrderID:       order.ID,
+				Provider:      "store_credit",
+				Amount:        storeCredit,
+				Currency:      order.Currency,
+				Status:        "refunded",
+				TransactionID: order.RazorpayPaymentID,
+				OrderIDExt:    order.RazorpayOrderID,
+				Metadata:      models.JSONB{"reason": refundReason},
+			}).Error; err != nil {
+				return 0, fmt.Errorf("issue store credit: %w", err)
+			}
+		}
 	}
-
-	order.Status = "cancelled"
-
-	// Restore stock for items
-	for _, item := range order.Items {
-		config.DB.Model(&models.Product{}).Where("id = ?", item.ProductID).
-			UpdateColumn("stock", gorm.Expr("stock + ?", item.Quantity))
+	if order.PaymentStatus == "paid" && (refundToCredit || services.AmountDue(order) == 0) {
+		order.PaymentStatus = "refunded"
 	}
 
-	if err := config.DB.Save(&order).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel order"})
-		return
-	}
+	return storeCredit, nil
+}
 
-	c.JSON(http.StatusOK, gin.H{
-		"order_id": order.ID,
-		"status":   "cancelled",
-		"message":  "Order cancelled successfully",
-	})
+// validRefundMethod defaults an empty refund method to the original payment and
+// reports whether the method is known
+func validRefundMethod(method *string) bool {
+	switch *method {
+	case "":
+		*method = "original"
+	case "original", "store_credit":
+	default:
+		return false
+	}
+	return true
 }
 
 // GetAllOrders gets all orders (for admin)
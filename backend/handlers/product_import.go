@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"pashmina-backend/config"
+	"pashmina-backend/models"
+	"pashmina-backend/services"
+	"pashmina-backend/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ImportProducts takes a CSV or XLSX file of products and upserts them by SKU in the
+// background. With dry_run=true it only returns the validation report. A file with
+// problems is rejected with the report and nothing is written.
+func ImportProducts(c *gin.Context) {
+	data, filename, err := readUpload(c, services.MaxImportSize())
+	switch {
+	case errors.Is(err, services.ErrMediaTooLarge):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error(), "max_bytes": services.MaxImportSize()})
+		return
+	case errors.Is(err, errFileRequired):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read file"})
+		return
+	}
+
+	format := services.DetectSheetFormat(data)
+	plan, err := services.PlanProductImport(config.DB, data, format)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Could not read the file as " + strings.ToUpper(format) + ": " + err.Error()})
+		return
+	}
+
+	dryRun, _ := strconv.ParseBool(c.DefaultQuery("dry_run", c.PostForm("dry_run")))
+	var createdBy *uint
+	if userID, exists := c.Get("user_id"); exists {
+		id := userID.(uint)
+		createdBy = &id
+	}
+
+	job, err := services.NewImportJob(config.DB, plan, filepath.Base(filename), format, dryRun, createdBy)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create import job"})
+		return
+	}
+
+	switch {
+	case dryRun:
+		c.JSON(http.StatusOK, job)
+	case job.Status == services.ImportInvalid:
+		c.JSON(http.StatusUnprocessableEntity, job)
+	default:
+		go services.RunProductImport(context.Background(), config.DB, GetMediaStorage(), GetImagePipeline(), job, plan)
+		c.JSON(http.StatusAccepted, job)
+	}
+}
+
+// GetImportJob reports the progress of a product import
+func GetImportJob(c *gin.Context) {
+	var job models.ImportJob
+	if err := config.DB.First(&job, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Import job not found"})
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+// ExportProducts downloads the catalog in the import layout, as CSV or with
+// ?format=xlsx as a workbook
+func ExportProducts(c *gin.Context) {
+	format := strings.ToLower(c.DefaultQuery("format", services.SheetCSV))
+	contentType := "text/csv; charset=utf-8"
+	switch format {
+	case services.SheetCSV:
+	case services.SheetXLSX:
+		contentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be csv or xlsx"})
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := services.ExportProducts(config.DB, &buf, format); err != nil {
+		utils.Error("Failed to export products", map[string]interface{}{"error": err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export products"})
+		return
+	}
+
+	filename := fmt.Sprintf("products-%s.%s", time.Now().Format("2006-01-02"), format)
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+	c.Data(http.StatusOK, contentType, buf.Bytes())
+}
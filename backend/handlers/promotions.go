@@ -0,0 +1,313 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"pashmina-backend/config"
+	"pashmina-backend/models"
+	"pashmina-backend/services"
+	"pashmina-backend/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// storefrontProduct is a product with what the storefront shows of the promotions
+// running on it
+type storefrontProduct struct {
+	models.Product
+	services.ProductOffer
+}
+
+// withOffers pairs products with the promotions running on them
+func withOffers(db *gorm.DB, products []models.Product) ([]storefrontProduct, error) {
+	promotions, err := services.ActivePromotions(db, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	offers, err := services.ProductOffers(db, promotions, products)
+	if err != nil {
+		return nil, err
+	}
+
+	listed := make([]storefrontProduct, len(products))
+	for i, product := range products {
+		listed[i] = storefrontProduct{Product: product, ProductOffer: offers[product.ID]}
+	}
+	return listed, nil
+}
+
+// GetPromotions lists promotions, highest priority first
+func GetPromotions(c *gin.Context) {
+	var promotions []models.Promotion
+	if err := config.DB.Order("priority DESC, id ASC").Find(&promotions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load promotions"})
+		return
+	}
+	c.JSON(http.StatusOK, promotions)
+}
+
+// CreatePromotion adds an automatic promotion. It is active unless is_active is
+// sent as false.
+func CreatePromotion(c *gin.Context) {
+	var input struct {
+		models.Promotion
+		IsActive *bool `json:"is_active"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	promotion := input.Promotion
+	promotion.ID = 0
+	promotion.IsActive = input.IsActive == nil || *input.IsActive
+	if promotion.Type == services.PromotionFreeGift && promotion.GiftQuantity == 0 {
+		promotion.GiftQuantity = 1
+	}
+	if err := validatePromotion(config.DB, &promotion); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&promotion).Error; err != nil {
+			return err
+		}
+		// is_active defaults to true, so a false value isn't written on create
+		if !promotion.IsActive {
+			return tx.Model(&promotion).Update("is_active", false).Error
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create promotion"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, promotion)
+}
+
+// validatePromotion checks a promotion's discount, gift and schedule
+func validatePromotion(db *gorm.DB, promotion *models.Promotion) error {
+	promotion.Name = utils.SanitizeString(promotion.Name, 200)
+	promotion.Description = utils.SanitizeString(promotion.Description, 1000)
+	if promotion.Name == "" {
+		return errors.New("name is required")
+	}
+
+	switch promotion.Type {
+	case services.DiscountPercentage, services.DiscountFixed:
+		if promotion.DiscountValue <= 0 {
+			return errors.New("discount value must be greater than 0")
+		}
+		if promotion.Type == services.DiscountPercentage && promotion.DiscountValue > 100 {
+			return errors.New("percentage discount cannot be more than 100")
+		}
+	case services.PromotionTieredSpend:
+		if err := validatePromotionTiers(promotion.Tiers); err != nil {
+			return err
+		}
+	case services.PromotionFreeGift:
+		if err := validatePromotionGift(db, promotion); err != nil {
+			return err
+		}
+	default:
+		return errors.New("Invalid promotion type")
+	}
+
+	if promotion.MinSpend < 0 {
+		return errors.New("minimum spend cannot be negative")
+	}
+	if promotion.StartsAt != nil && promotion.EndsAt != nil && !promotion.EndsAt.After(*promotion.StartsAt) {
+		return errors.New("ends_at must be after starts_at")
+	}
+	return nil
+}
+
+func validatePromotionTiers(tiers models.PromotionTiers) error {
+	if len(tiers) == 0 {
+		return errors.New("tiered_spend promotions need at least one tier")
+	}
+	seen := map[float64]bool{}
+	for _, tier := range tiers {
+		if tier.MinSpend < 0 {
+			return errors.New("tier minimum spend cannot be negative")
+		}
+		if seen[tier.MinSpend] {
+			return fmt.Errorf("more than one tier starts at %.2f", tier.MinSpend)
+		}
+		seen[tier.MinSpend] = true
+		switch tier.DiscountType {
+		case services.DiscountPercentage, services.DiscountFixed:
+		default:
+			return errors.New("tier discount type must be percentage or fixed")
+		}
+		if tier.DiscountValue <= 0 {
+			return errors.New("tier discount value must be greater than 0")
+		}
+		if tier.DiscountType == services.DiscountPercentage && tier.DiscountValue > 100 {
+			return errors.New("percentage discount cannot be more than 100")
+		}
+	}
+	return nil
+}
+
+// validatePromotionGift checks the gift exists, naming a variant when the product
+// has them
+func validatePromotionGift(db *gorm.DB, promotion *models.Promotion) error {
+	if promotion.GiftProductID == nil {
+		return errors.New("free_gift promotions need a gift_product_id")
+	}
+	if promotion.GiftQuantity < 1 {
+		return errors.New("gift quantity must be at least 1")
+	}
+
+	var product models.Product
+	if err := db.First(&product, *promotion.GiftProductID).Error; err != nil {
+		return errors.New("gift product not found")
+	}
+	if promotion.GiftVariantID != nil {
+		var count int64
+		db.Model(&models.ProductVariant{}).Where("id = ? AND product_id = ?", *promotion.GiftVariantID, product.ID).Count(&count)
+		if count == 0 {
+			return errors.New("gift variant not found for the gift product")
+		}
+		return nil
+	}
+	var variants int64
+	db.Model(&models.ProductVariant{}).Where("product_id = ?", product.ID).Count(&variants)
+	if variants > 0 {
+		return errors.New("gift product has variants, so a gift_variant_id is required")
+	}
+	return nil
+}
+
+type promotionPatch struct {
+	patchVersion
+	Name                utils.PatchField[string]                `json:"name"`
+	Description         utils.PatchField[string]                `json:"description"`
+	Type                utils.PatchField[string]                `json:"type"`
+	DiscountValue       utils.PatchField[float64]               `json:"discount_value"`
+	Tiers               utils.PatchField[models.PromotionTiers] `json:"tiers"`
+	MinSpend            utils.PatchField[float64]               `json:"min_spend"`
+	GiftProductID       utils.PatchField[uint]                  `json:"gift_product_id"`
+	GiftVariantID       utils.PatchField[uint]                  `json:"gift_variant_id"`
+	GiftQuantity        utils.PatchField[int]                   `json:"gift_quantity"`
+	IncludedCategories  utils.PatchField[models.UintArray]      `json:"included_categories"`
+	ExcludedCategories  utils.PatchField[models.UintArray]      `json:"excluded_categories"`
+	IncludedProducts    utils.PatchField[models.UintArray]      `json:"included_products"`
+	ExcludedProducts    utils.PatchField[models.UintArray]      `json:"excluded_products"`
+	StartsAt            utils.PatchField[time.Time]             `json:"starts_at"`
+	EndsAt              utils.PatchField[time.Time]             `json:"ends_at"`
+	Priority            utils.PatchField[int]                   `json:"priority"`
+	Stackable           utils.PatchField[bool]                  `json:"stackable"`
+	CombinesWithCoupons utils.PatchField[bool]                  `json:"combines_with_coupons"`
+	IsActive            utils.PatchField[bool]                  `json:"is_active"`
+}
+
+// validate checks the promotion as it will be after the patch the way
+// CreatePromotion does
+func (p *promotionPatch) validate(db *gorm.DB, current models.Promotion) error {
+	if err := firstError(p.Name.Required("name"), p.Type.Required("type"), p.Priority.Required("priority"),
+		p.Stackable.Required("stackable"), p.CombinesWithCoupons.Required("combines_with_coupons"),
+		p.IsActive.Required("is_active")); err != nil {
+		return err
+	}
+	merged := current
+	merged.Name = patched(p.Name, current.Name)
+	merged.Description = patched(p.Description, current.Description)
+	merged.Type = patched(p.Type, current.Type)
+	merged.DiscountValue = patched(p.DiscountValue, current.DiscountValue)
+	merged.Tiers = patched(p.Tiers, current.Tiers)
+	merged.MinSpend = patched(p.MinSpend, current.MinSpend)
+	merged.GiftProductID = patchedID(p.GiftProductID, current.GiftProductID)
+	merged.GiftVariantID = patchedID(p.GiftVariantID, current.GiftVariantID)
+	merged.GiftQuantity = patched(p.GiftQuantity, current.GiftQuantity)
+	merged.StartsAt = patchedTime(p.StartsAt, current.StartsAt)
+	merged.EndsAt = patchedTime(p.EndsAt, current.EndsAt)
+	if err := validatePromotion(db, &merged); err != nil {
+		return err
+	}
+	// Keep the cleaned-up text validatePromotion settled on
+	if p.Name.Present() {
+		p.Name.Value = merged.Name
+	}
+	if p.Description.Present() {
+		p.Description.Value = merged.Description
+	}
+	return nil
+}
+
+func (p *promotionPatch) updates() map[string]interface{} {
+	updates := map[string]interface{}{}
+	setField(updates, "name", p.Name, nil)
+	setField(updates, "description", p.Description, "")
+	setField(updates, "type", p.Type, nil)
+	setField(updates, "discount_value", p.DiscountValue, 0)
+	setField(updates, "tiers", p.Tiers, models.PromotionTiers{})
+	setField(updates, "min_spend", p.MinSpend, 0)
+	setField(updates, "gift_product_id", p.GiftProductID, nil)
+	setField(updates, "gift_variant_id", p.GiftVariantID, nil)
+	setField(updates, "gift_quantity", p.GiftQuantity, 0)
+	setField(updates, "included_categories", p.IncludedCategories, models.UintArray{})
+	setField(updates, "excluded_categories", p.ExcludedCategories, models.UintArray{})
+	setField(updates, "included_products", p.IncludedProducts, models.UintArray{})
+	setField(updates, "excluded_products", p.ExcludedProducts, models.UintArray{})
+	setField(updates, "starts_at", p.StartsAt, nil)
+	setField(updates, "ends_at", p.EndsAt, nil)
+	setField(updates, "priority", p.Priority, nil)
+	setField(updates, "stackable", p.Stackable, nil)
+	setField(updates, "combines_with_coupons", p.CombinesWithCoupons, nil)
+	setField(updates, "is_active", p.IsActive, nil)
+	return updates
+}
+
+// UpdatePromotion applies a merge patch to a promotion
+func UpdatePromotion(c *gin.Context) {
+	var promotion models.Promotion
+	if err := config.DB.First(&promotion, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Promotion not found"})
+		return
+	}
+
+	var patch promotionPatch
+	if !bindPatch(c, &patch) {
+		return
+	}
+	conditional, ok := checkVersion(c, patch.patchVersion, promotion.UpdatedAt, promotion)
+	if !ok {
+		return
+	}
+	if err := patch.validate(config.DB, promotion); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := savePatch(config.DB, &promotion, promotion.UpdatedAt, conditional, patch.updates())
+	config.DB.First(&promotion, promotion.ID)
+	if errors.Is(err, errRecordModified) {
+		respondModified(c, promotion.UpdatedAt, promotion)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update promotion"})
+		return
+	}
+
+	c.Header("ETag", utils.VersionTag(promotion.UpdatedAt))
+	c.JSON(http.StatusOK, promotion)
+}
+
+func DeletePromotion(c *gin.Context) {
+	result := config.DB.Delete(&models.Promotion{}, c.Param("id"))
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Promotion not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Promotion deleted"})
+}
@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"pashmina-backend/config"
+	"pashmina-backend/models"
+	"pashmina-backend/services"
+	"pashmina-backend/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+var pushService *services.PushService
+
+// GetPushService returns the web push service (lazy initialization). It is nil when
+// VAPID keys are not configured.
+func GetPushService() *services.PushService {
+	if pushService == nil {
+		pushService = services.NewPushService(config.DB)
+	}
+	return pushService
+}
+
+// GetVAPIDPublicKey returns the key browsers need to create a push subscription
+func GetVAPIDPublicKey(c *gin.Context) {
+	if GetPushService() == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Push notifications not configured"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"public_key": pushService.PublicKey()})
+}
+
+// SubscribePush stores the current user's browser push subscription
+func SubscribePush(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var input struct {
+		Endpoint       string `json:"endpoint" binding:"required"`
+		P256DH         string `json:"p256dh" binding:"required"`
+		Auth           string `json:"auth" binding:"required"`
+		ExpirationTime *int64 `json:"expiration_time"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if endpoint, err := url.Parse(input.Endpoint); err != nil || endpoint.Scheme != "https" || endpoint.Host == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "endpoint must be an https URL"})
+		return
+	}
+
+	if err := services.ValidatePushKeys(input.P256DH, input.Auth); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// An endpoint identifies one browser, so re-subscribing replaces its keys and owner
+	var sub models.PushSubscription
+	config.DB.Where("endpoint = ?", input.Endpoint).First(&sub)
+
+	sub.UserID = userID.(uint)
+	sub.Endpoint = input.Endpoint
+	sub.P256DH = input.P256DH
+	sub.Auth = input.Auth
+	sub.Expiries = nil
+	if input.ExpirationTime != nil {
+		expires := time.UnixMilli(*input.ExpirationTime)
+		sub.Expiries = &expires
+	}
+
+	if err := config.DB.Save(&sub).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save subscription"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, sub)
+}
+
+// UnsubscribePush removes one of the current user's push subscriptions
+func UnsubscribePush(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var input struct {
+		Endpoint string `json:"endpoint" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result := config.DB.Where("user_id = ? AND endpoint = ?", userID, input.Endpoint).Delete(&models.PushSubscription{})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove subscription"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Subscription not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Unsubscribed from push notifications"})
+}
+
+// BroadcastPush sends a push notification to every subscribed user in a segment who
+// has push enabled. Delivery happens in the background.
+func BroadcastPush(c *gin.Context) {
+	if GetPushService() == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Push notifications not configured"})
+		return
+	}
+
+	var input struct {
+		Segment string `json:"segment"`
+		UserIDs []uint `json:"user_ids"`
+		Title   string `json:"title" binding:"required"`
+		Body    string `json:"body" binding:"required"`
+		URL     string `json:"url"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if input.Segment == "" {
+		input.Segment = "all"
+	}
+	if input.URL != "" && !strings.HasPrefix(input.URL, "/") && !strings.HasPrefix(input.URL, "https://") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "url must be a site path or an https URL"})
+		return
+	}
+
+	query := config.DB.Model(&models.PushSubscription{})
+	switch input.Segment {
+	case "all":
+	case "customers":
+		query = query.Where("user_id IN (?)",
+			config.DB.Model(&models.Order{}).Select("user_id").Where("payment_status = ?", "paid"))
+	case "admins":
+		query = query.Where("user_id IN (?)",
+			config.DB.Model(&models.User{}).Select("id").Where("role = ?", "admin"))
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "segment must be one of all, customers, admins"})
+		return
+	}
+	if len(input.UserIDs) > 0 {
+		query = query.Where("user_id IN ?", input.UserIDs)
+	}
+
+	// Respect users who turned push off
+	query = query.Where("user_id NOT IN (?)",
+		config.DB.Model(&models.NotificationPreference{}).Select("user_id").Where("push_enabled = ?", false))
+
+	var userIDs []uint
+	if err := query.Distinct().Pluck("user_id", &userIDs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve segment"})
+		return
+	}
+
+	msg := services.Message{
+		Type:  "broadcast",
+		Title: utils.SanitizeString(input.Title, 100),
+		Text:  utils.SanitizeString(input.Body, 500),
+		Link:  input.URL,
+	}
+
+	go broadcastPush(config.DB, userIDs, msg)
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"segment":    input.Segment,
+		"recipients": len(userIDs),
+	})
+}
+
+func broadcastPush(db *gorm.DB, userIDs []uint, msg services.Message) {
+	dispatcher := services.Notifications(db)
+	sent := 0
+	for i := range userIDs {
+		userID := userIDs[i]
+		if dispatcher.Send(services.ChannelPush, services.Recipient{UserID: &userID}, msg) {
+			sent++
+		}
+	}
+
+	utils.Info("Push broadcast finished", map[string]interface{}{
+		"recipients": len(userIDs),
+		"sent":       sent,
+	})
+}
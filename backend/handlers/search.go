@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"pashmina-backend/config"
+	"pashmina-backend/services"
+	"pashmina-backend/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SuggestProducts returns type-ahead suggestions: product, category and catalogue
+// names and popular searches with a word starting with q
+func SuggestProducts(c *gin.Context) {
+	query := utils.SanitizeString(c.Query("q"), 100)
+	if query == "" {
+		c.JSON(http.StatusOK, gin.H{"query": query, "suggestions": []services.Suggestion{}})
+		return
+	}
+
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "8"))
+	if limit < 1 || limit > 20 {
+		limit = 8
+	}
+
+	suggestions := services.Suggestions(config.DB).Suggest(query, limit)
+	if suggestions == nil {
+		suggestions = []services.Suggestion{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"query": query, "suggestions": suggestions})
+}
+
+// GetTopSearches lists the most used search terms that found results
+func GetTopSearches(c *gin.Context) {
+	window, limit := searchReportParams(c)
+	c.JSON(http.StatusOK, gin.H{
+		"days":    int(window.Hours() / 24),
+		"queries": services.PopularSearches(config.DB, window, limit),
+	})
+}
+
+// GetZeroResultSearches lists the most used search terms that found nothing
+func GetZeroResultSearches(c *gin.Context) {
+	window, limit := searchReportParams(c)
+	c.JSON(http.StatusOK, gin.H{
+		"days":    int(window.Hours() / 24),
+		"queries": services.ZeroResultSearches(config.DB, window, limit),
+	})
+}
+
+func searchReportParams(c *gin.Context) (time.Duration, int) {
+	days, _ := strconv.Atoi(c.DefaultQuery("days", "30"))
+	if days < 1 || days > 365 {
+		days = 30
+	}
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	_, limit = utils.ValidatePagination(1, limit)
+	return time.Duration(days) * 24 * time.Hour, limit
+}
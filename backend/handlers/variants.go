@@ -0,0 +1,247 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"pashmina-backend/config"
+	"pashmina-backend/models"
+	"pashmina-backend/services"
+	"pashmina-backend/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type variantInput struct {
+	SKU      *string  `json:"sku"`
+	Color    *string  `json:"color"`
+	Size     *string  `json:"size"`
+	Price    *float64 `json:"price"`
+	Stock    *int     `json:"stock"`
+	Weight   *float64 `json:"weight"`
+	Barcode  *string  `json:"barcode"`
+	IsActive *bool    `json:"is_active"`
+}
+
+// validate checks every field that was supplied
+func (in *variantInput) validate() error {
+	if in.SKU != nil {
+		*in.SKU = strings.ToUpper(strings.TrimSpace(*in.SKU))
+		if err := utils.ValidateSKU(*in.SKU); err != nil {
+			return err
+		}
+	}
+	if in.Price != nil {
+		if err := utils.ValidatePrice(*in.Price); err != nil {
+			return err
+		}
+	}
+	if in.Stock != nil {
+		if err := utils.ValidateStock(*in.Stock); err != nil {
+			return err
+		}
+	}
+	if in.Weight != nil {
+		if err := utils.ValidateWeight(*in.Weight); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// apply copies supplied fields onto the variant
+func (in *variantInput) apply(v *models.ProductVariant) {
+	if in.SKU != nil {
+		v.SKU = *in.SKU
+	}
+	if in.Color != nil {
+		v.Color = utils.SanitizeString(*in.Color, 100)
+	}
+	if in.Size != nil {
+		v.Size = utils.SanitizeString(*in.Size, 100)
+	}
+	if in.Price != nil {
+		v.Price = in.Price
+	}
+	if in.Stock != nil {
+		v.Stock = *in.Stock
+	}
+	if in.Weight != nil {
+		v.Weight = *in.Weight
+	}
+	if in.Barcode != nil {
+		v.Barcode = strings.TrimSpace(*in.Barcode)
+	}
+	if in.IsActive != nil {
+		v.IsActive = *in.IsActive
+	}
+}
+
+// GetProductVariants lists the active variants of a product on the storefront
+func GetProductVariants(c *gin.Context) {
+	productID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		return
+	}
+
+	var count int64
+	services.VisibleProducts(config.DB.Model(&models.Product{}), time.Now()).Where("products.id = ?", productID).Count(&count)
+	if count == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+
+	var variants []models.ProductVariant
+	config.DB.Where("product_id = ? AND is_active = ?", productID, true).Order("id ASC").Find(&variants)
+
+	c.JSON(http.StatusOK, variants)
+}
+
+// CreateProductVariant adds a variant to a product
+func CreateProductVariant(c *gin.Context) {
+	productID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		return
+	}
+
+	var product models.Product
+	if err := config.DB.First(&product, productID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+
+	var input variantInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if input.SKU == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "sku is required"})
+		return
+	}
+
+	if err := input.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var existing models.ProductVariant
+	if err := config.DB.Unscoped().Where("sku = ?", *input.SKU).First(&existing).Error; err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "SKU already exists"})
+		return
+	}
+
+	variant := models.ProductVariant{ProductID: product.ID, IsActive: true}
+	input.apply(&variant)
+
+	tx := config.DB.Begin()
+
+	if err := tx.Create(&variant).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create variant"})
+		return
+	}
+
+	if err := services.SyncProductFromVariants(tx, product.ID); err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update product stock"})
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create variant"})
+		return
+	}
+
+	utils.Info("Product variant created", map[string]interface{}{"product_id": product.ID, "sku": variant.SKU})
+	services.InvalidateFacets()
+
+	c.JSON(http.StatusCreated, variant)
+}
+
+// UpdateProductVariant updates a variant's SKU, attributes, price or stock
+func UpdateProductVariant(c *gin.Context) {
+	var variant models.ProductVariant
+	if err := config.DB.Where("id = ? AND product_id = ?", c.Param("variantId"), c.Param("id")).
+		First(&variant).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Variant not found"})
+		return
+	}
+
+	var input variantInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := input.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if input.SKU != nil && *input.SKU != variant.SKU {
+		var existing models.ProductVariant
+		if err := config.DB.Unscoped().Where("sku = ?", *input.SKU).First(&existing).Error; err == nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "SKU already exists"})
+			return
+		}
+	}
+
+	input.apply(&variant)
+
+	tx := config.DB.Begin()
+
+	if err := tx.Save(&variant).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update variant"})
+		return
+	}
+
+	if err := services.SyncProductFromVariants(tx, variant.ProductID); err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update product stock"})
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update variant"})
+		return
+	}
+	services.InvalidateFacets()
+
+	c.JSON(http.StatusOK, variant)
+}
+
+// DeleteProductVariant removes a variant from a product
+func DeleteProductVariant(c *gin.Context) {
+	var variant models.ProductVariant
+	if err := config.DB.Where("id = ? AND product_id = ?", c.Param("variantId"), c.Param("id")).
+		First(&variant).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Variant not found"})
+		return
+	}
+
+	tx := config.DB.Begin()
+
+	if err := tx.Delete(&variant).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete variant"})
+		return
+	}
+
+	if err := services.SyncProductFromVariants(tx, variant.ProductID); err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update product stock"})
+		return
+	}
+
+	tx.Commit()
+	services.InvalidateFacets()
+
+	c.JSON(http.StatusOK, gin.H{"message": "Variant deleted"})
+}
@@ -10,9 +10,12 @@ import (
 	"time"
 
 	"pashmina-backend/config"
+	"pashmina-backend/handlers"
 	"pashmina-backend/middleware"
+	"pashmina-backend/migrations"
 	"pashmina-backend/models"
 	"pashmina-backend/routes"
+	"pashmina-backend/services"
 	"pashmina-backend/websocket"
 
 	"github.com/gin-gonic/gin"
@@ -23,30 +26,23 @@ func main() {
 	config.ConnectDB()
 	defer config.CloseDB()
 
-	config.DB.AutoMigrate(
-		&models.User{},
-		&models.Product{},
-		&models.Category{},
-		&models.Order{},
-		&models.OrderItem{},
-		&models.PaymentTransaction{},
-		&models.Newsletter{},
-		&models.PageContent{},
-		&models.Notification{},
-		&models.NotificationPreference{},
-		&models.AdminNotificationSetting{},
-		&models.NotificationTemplate{},
-		&models.Catalogue{},
-		&models.Coupon{},
-		&models.Review{},
-		&models.Wishlist{},
-		&models.Address{},
-		&models.PushSubscription{},
-	)
+	if os.Getenv("MIGRATE_ON_START") != "false" {
+		runMigrations()
+	}
 
 	seedData()
+	services.FailInterruptedImports(config.DB)
 
 	go websocket.GlobalHub.Run()
+	go services.StartReservationSweeper(config.DB, time.Minute)
+	go services.StartPublishingSweeper(config.DB, time.Minute)
+	go services.StartMediaSweeper(config.DB, handlers.GetMediaStorage(), handlers.GetImagePipeline(), time.Hour)
+	services.Suggestions(config.DB)
+
+	services.Notifications(config.DB).Register(services.ChannelEmail, services.NewMailer(config.DB, services.NewEmailTransport()))
+	if push := handlers.GetPushService(); push != nil {
+		services.Notifications(config.DB).Register(services.ChannelPush, push)
+	}
 
 	if os.Getenv("ENVIRONMENT") == "production" {
 		gin.SetMode(gin.ReleaseMode)
@@ -60,7 +56,8 @@ func main() {
 
 	routes.SetupRoutes(r)
 
-	r.Static("/uploads", "./uploads")
+	r.GET("/uploads/*filepath", handlers.ServeUpload)
+	r.HEAD("/uploads/*filepath", handlers.ServeUpload)
 
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -98,6 +95,22 @@ func main() {
 	log.Println("Server exited properly")
 }
 
+// runMigrations brings the schema up to date before the server starts. Set
+// MIGRATE_ON_START=false to run go run ./cmd/migrate up as a separate deploy step.
+func runMigrations() {
+	sqlDB, err := config.DB.DB()
+	if err != nil {
+		log.Fatalf("Failed to get database connection: %v", err)
+	}
+	migrator, err := migrations.New(sqlDB)
+	if err != nil {
+		log.Fatalf("Failed to load migrations: %v", err)
+	}
+	if _, err := migrator.Up(context.Background()); err != nil {
+		log.Fatalf("Failed to migrate database: %v", err)
+	}
+}
+
 func seedData() {
 	var count int64
 	config.DB.Model(&models.Product{}).Count(&count)
@@ -0,0 +1,26 @@
+DROP TABLE IF EXISTS push_subscriptions;
+DROP TABLE IF EXISTS addresses;
+DROP TABLE IF EXISTS wishlists;
+DROP TABLE IF EXISTS reviews;
+DROP TABLE IF EXISTS coupons;
+DROP TABLE IF EXISTS catalogue_products;
+DROP TABLE IF EXISTS catalogues;
+DROP TABLE IF EXISTS email_logs;
+DROP TABLE IF EXISTS notification_templates;
+DROP TABLE IF EXISTS admin_notification_settings;
+DROP TABLE IF EXISTS notification_preferences;
+DROP TABLE IF EXISTS notifications;
+DROP TABLE IF EXISTS page_contents;
+DROP TABLE IF EXISTS newsletters;
+DROP TABLE IF EXISTS payment_transactions;
+DROP TABLE IF EXISTS order_status_histories;
+DROP TABLE IF EXISTS stock_reservations;
+DROP TABLE IF EXISTS order_items;
+DROP TABLE IF EXISTS orders;
+DROP TABLE IF EXISTS product_variants;
+DROP TABLE IF EXISTS products;
+DROP TABLE IF EXISTS categories;
+DROP TABLE IF EXISTS password_reset_tokens;
+DROP TABLE IF EXISTS refresh_tokens;
+DROP TABLE IF EXISTS user_sessions;
+DROP TABLE IF EXISTS users;
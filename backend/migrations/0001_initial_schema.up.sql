@@ -0,0 +1,450 @@
+-- Baseline schema, matching what AutoMigrate created before versioned migrations.
+-- IF NOT EXISTS lets it run over a database AutoMigrate already set up.
+
+CREATE TABLE IF NOT EXISTS users (
+    id bigserial,
+    created_at timestamptz,
+    updated_at timestamptz,
+    deleted_at timestamptz,
+    email text,
+    password text NOT NULL,
+    name text,
+    phone text,
+    role text DEFAULT 'user',
+    firebase_uid text,
+    provider text DEFAULT 'email',
+    PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS idx_users_firebase_uid ON users (firebase_uid);
+CREATE INDEX IF NOT EXISTS idx_users_phone ON users (phone);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email ON users (email);
+CREATE INDEX IF NOT EXISTS idx_users_deleted_at ON users (deleted_at);
+
+CREATE TABLE IF NOT EXISTS user_sessions (
+    id bigserial,
+    created_at timestamptz,
+    updated_at timestamptz,
+    user_id bigint NOT NULL,
+    user_agent text,
+    ip_address text,
+    last_used_at timestamptz,
+    expires_at timestamptz,
+    revoked_at timestamptz,
+    revoked_reason text,
+    PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS idx_user_sessions_expires_at ON user_sessions (expires_at);
+CREATE INDEX IF NOT EXISTS idx_user_sessions_user_id ON user_sessions (user_id);
+
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    id bigserial,
+    created_at timestamptz,
+    session_id bigint NOT NULL,
+    user_id bigint NOT NULL,
+    token_hash text NOT NULL,
+    expires_at timestamptz,
+    used_at timestamptz,
+    PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_refresh_tokens_token_hash ON refresh_tokens (token_hash);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_user_id ON refresh_tokens (user_id);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_session_id ON refresh_tokens (session_id);
+
+CREATE TABLE IF NOT EXISTS password_reset_tokens (
+    id bigserial,
+    created_at timestamptz,
+    user_id bigint NOT NULL,
+    token_hash text NOT NULL,
+    expires_at timestamptz,
+    used_at timestamptz,
+    PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_password_reset_tokens_token_hash ON password_reset_tokens (token_hash);
+CREATE INDEX IF NOT EXISTS idx_password_reset_tokens_user_id ON password_reset_tokens (user_id);
+
+CREATE TABLE IF NOT EXISTS categories (
+    id bigserial,
+    created_at timestamptz,
+    updated_at timestamptz,
+    deleted_at timestamptz,
+    name text NOT NULL,
+    slug text,
+    description text,
+    image text,
+    is_active boolean DEFAULT true,
+    PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_categories_slug ON categories (slug);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_categories_name ON categories (name);
+CREATE INDEX IF NOT EXISTS idx_categories_deleted_at ON categories (deleted_at);
+
+CREATE TABLE IF NOT EXISTS products (
+    id bigserial,
+    created_at timestamptz,
+    updated_at timestamptz,
+    deleted_at timestamptz,
+    name text NOT NULL,
+    price decimal NOT NULL,
+    description text,
+    image text,
+    category_id bigint,
+    colors jsonb,
+    sizes jsonb,
+    stock bigint DEFAULT 0,
+    is_featured boolean DEFAULT false,
+    is_active boolean DEFAULT true,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_categories_products FOREIGN KEY (category_id) REFERENCES categories (id)
+);
+CREATE INDEX IF NOT EXISTS idx_products_deleted_at ON products (deleted_at);
+
+CREATE TABLE IF NOT EXISTS product_variants (
+    id bigserial,
+    created_at timestamptz,
+    updated_at timestamptz,
+    deleted_at timestamptz,
+    product_id bigint NOT NULL,
+    sku text NOT NULL,
+    color text,
+    size text,
+    price decimal,
+    stock bigint DEFAULT 0,
+    weight decimal,
+    barcode text,
+    is_active boolean DEFAULT true,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_products_variants FOREIGN KEY (product_id) REFERENCES products (id)
+);
+CREATE INDEX IF NOT EXISTS idx_product_variants_barcode ON product_variants (barcode);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_product_variants_sku ON product_variants (sku);
+CREATE INDEX IF NOT EXISTS idx_product_variants_product_id ON product_variants (product_id);
+CREATE INDEX IF NOT EXISTS idx_product_variants_deleted_at ON product_variants (deleted_at);
+
+CREATE TABLE IF NOT EXISTS orders (
+    id bigserial,
+    created_at timestamptz,
+    updated_at timestamptz,
+    user_id bigint,
+    status text DEFAULT 'pending_payment',
+    subtotal decimal,
+    total_amount decimal,
+    discount_amount decimal,
+    shipping_cost decimal,
+    tax_amount decimal,
+    currency text DEFAULT 'INR',
+    shipping_name text,
+    shipping_email text,
+    shipping_address text,
+    shipping_city text,
+    shipping_state text,
+    shipping_country text,
+    shipping_zip text,
+    shipping_phone text,
+    coupon_code text,
+    shipping_method text,
+    reserved_until timestamptz,
+    notes text,
+    payment_method text,
+    payment_intent_id text,
+    payment_status text DEFAULT 'pending',
+    shipping_provider text,
+    shipping_label_url text,
+    tracking_number text,
+    estimated_delivery timestamptz,
+    shipped_at timestamptz,
+    delivered_at timestamptz,
+    razorpay_order_id text,
+    razorpay_payment_id text,
+    razorpay_signature text,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_orders_user FOREIGN KEY (user_id) REFERENCES users (id)
+);
+
+CREATE TABLE IF NOT EXISTS order_items (
+    id bigserial,
+    order_id bigint,
+    product_id bigint,
+    variant_id bigint,
+    sku text,
+    quantity bigint,
+    price decimal,
+    line_total decimal,
+    color text,
+    size text,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_order_items_product FOREIGN KEY (product_id) REFERENCES products (id),
+    CONSTRAINT fk_order_items_variant FOREIGN KEY (variant_id) REFERENCES product_variants (id),
+    CONSTRAINT fk_orders_items FOREIGN KEY (order_id) REFERENCES orders (id)
+);
+CREATE INDEX IF NOT EXISTS idx_order_items_variant_id ON order_items (variant_id);
+
+CREATE TABLE IF NOT EXISTS stock_reservations (
+    id bigserial,
+    created_at timestamptz,
+    updated_at timestamptz,
+    order_id bigint NOT NULL,
+    product_id bigint NOT NULL,
+    variant_id bigint,
+    quantity bigint NOT NULL,
+    status text NOT NULL DEFAULT 'held',
+    expires_at timestamptz,
+    PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS idx_stock_reservations_expires_at ON stock_reservations (expires_at);
+CREATE INDEX IF NOT EXISTS idx_stock_reservations_status ON stock_reservations (status);
+CREATE INDEX IF NOT EXISTS idx_stock_reservations_product_id ON stock_reservations (product_id);
+CREATE INDEX IF NOT EXISTS idx_stock_reservations_order_id ON stock_reservations (order_id);
+
+CREATE TABLE IF NOT EXISTS order_status_histories (
+    id bigserial,
+    created_at timestamptz,
+    order_id bigint NOT NULL,
+    from_status text,
+    to_status text NOT NULL,
+    source text NOT NULL,
+    actor_id bigint,
+    reason text,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_orders_status_history FOREIGN KEY (order_id) REFERENCES orders (id)
+);
+CREATE INDEX IF NOT EXISTS idx_order_status_histories_order_id ON order_status_histories (order_id);
+
+CREATE TABLE IF NOT EXISTS payment_transactions (
+    id bigserial,
+    created_at timestamptz,
+    updated_at timestamptz,
+    order_id bigint,
+    provider text,
+    amount decimal,
+    currency text,
+    status text,
+    transaction_id text,
+    order_id_ext text,
+    signature text,
+    failure_reason text,
+    metadata jsonb,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_payment_transactions_order FOREIGN KEY (order_id) REFERENCES orders (id)
+);
+
+CREATE TABLE IF NOT EXISTS newsletters (
+    id bigserial,
+    created_at timestamptz,
+    email text NOT NULL,
+    subscribed boolean DEFAULT true,
+    PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_newsletters_email ON newsletters (email);
+
+CREATE TABLE IF NOT EXISTS page_contents (
+    id bigserial,
+    created_at timestamptz,
+    updated_at timestamptz,
+    page text NOT NULL,
+    section text NOT NULL,
+    title text,
+    content text,
+    image text,
+    metadata jsonb,
+    PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS idx_page_contents_page ON page_contents (page);
+
+CREATE TABLE IF NOT EXISTS notifications (
+    id bigserial,
+    created_at timestamptz,
+    updated_at timestamptz,
+    deleted_at timestamptz,
+    user_id bigint,
+    type text NOT NULL,
+    channel text NOT NULL,
+    title text NOT NULL,
+    message text,
+    data jsonb,
+    status text DEFAULT 'pending',
+    sent_at timestamptz,
+    read_at timestamptz,
+    failure_reason text,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_notifications_user FOREIGN KEY (user_id) REFERENCES users (id)
+);
+CREATE INDEX IF NOT EXISTS idx_notifications_type ON notifications (type);
+CREATE INDEX IF NOT EXISTS idx_notifications_deleted_at ON notifications (deleted_at);
+
+CREATE TABLE IF NOT EXISTS notification_preferences (
+    id bigserial,
+    created_at timestamptz,
+    updated_at timestamptz,
+    user_id bigint,
+    order_created boolean DEFAULT true,
+    order_shipped boolean DEFAULT true,
+    order_delivered boolean DEFAULT true,
+    order_status boolean DEFAULT true,
+    low_stock boolean DEFAULT true,
+    product_updates boolean DEFAULT false,
+    newsletter boolean DEFAULT true,
+    marketing boolean DEFAULT false,
+    email_enabled boolean DEFAULT true,
+    sms_enabled boolean DEFAULT false,
+    push_enabled boolean DEFAULT true,
+    PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_notification_preferences_user_id ON notification_preferences (user_id);
+
+CREATE TABLE IF NOT EXISTS admin_notification_settings (
+    id bigserial,
+    created_at timestamptz,
+    updated_at timestamptz,
+    admin_email text NOT NULL,
+    admin_phone text,
+    notify_on_order boolean DEFAULT true,
+    notify_on_low_stock boolean DEFAULT true,
+    notify_on_new_customer boolean DEFAULT true,
+    notify_on_review boolean DEFAULT false,
+    email_enabled boolean DEFAULT true,
+    sms_enabled boolean DEFAULT false,
+    PRIMARY KEY (id)
+);
+
+CREATE TABLE IF NOT EXISTS notification_templates (
+    id bigserial,
+    created_at timestamptz,
+    updated_at timestamptz,
+    type text NOT NULL,
+    channel text NOT NULL,
+    subject text,
+    title text,
+    body text,
+    is_active boolean DEFAULT true,
+    PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_notification_templates_type ON notification_templates (type);
+
+CREATE TABLE IF NOT EXISTS email_logs (
+    id bigserial,
+    created_at timestamptz,
+    notification_id bigint,
+    type text,
+    to_address text NOT NULL,
+    subject text,
+    message_id text,
+    status text NOT NULL,
+    attempts bigint,
+    last_error text,
+    sent_at timestamptz,
+    PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS idx_email_logs_status ON email_logs (status);
+CREATE INDEX IF NOT EXISTS idx_email_logs_to_address ON email_logs (to_address);
+CREATE INDEX IF NOT EXISTS idx_email_logs_type ON email_logs (type);
+CREATE INDEX IF NOT EXISTS idx_email_logs_notification_id ON email_logs (notification_id);
+
+CREATE TABLE IF NOT EXISTS catalogues (
+    id bigserial,
+    created_at timestamptz,
+    updated_at timestamptz,
+    deleted_at timestamptz,
+    name text NOT NULL,
+    description text,
+    image text,
+    status boolean DEFAULT true,
+    sort_order bigint DEFAULT 0,
+    PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS idx_catalogues_deleted_at ON catalogues (deleted_at);
+
+CREATE TABLE IF NOT EXISTS catalogue_products (
+    catalogue_id bigint,
+    product_id bigint,
+    PRIMARY KEY (catalogue_id,product_id),
+    CONSTRAINT fk_catalogue_products_catalogue FOREIGN KEY (catalogue_id) REFERENCES catalogues (id),
+    CONSTRAINT fk_catalogue_products_product FOREIGN KEY (product_id) REFERENCES products (id)
+);
+
+CREATE TABLE IF NOT EXISTS coupons (
+    id bigserial,
+    created_at timestamptz,
+    updated_at timestamptz,
+    code text NOT NULL,
+    description text,
+    discount_type text NOT NULL,
+    discount_value decimal NOT NULL,
+    min_order_amount decimal,
+    max_discount_amount decimal,
+    valid_from timestamptz,
+    valid_until timestamptz,
+    usage_limit bigint,
+    used_count bigint DEFAULT 0,
+    is_active boolean DEFAULT true,
+    applicable_countries jsonb,
+    PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_coupons_code ON coupons (code);
+
+CREATE TABLE IF NOT EXISTS reviews (
+    id bigserial,
+    created_at timestamptz,
+    updated_at timestamptz,
+    deleted_at timestamptz,
+    product_id bigint NOT NULL,
+    user_id bigint NOT NULL,
+    rating bigint NOT NULL,
+    title text,
+    comment text,
+    is_verified boolean DEFAULT false,
+    is_approved boolean DEFAULT true,
+    helpful_count bigint DEFAULT 0,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_reviews_product FOREIGN KEY (product_id) REFERENCES products (id),
+    CONSTRAINT fk_reviews_user FOREIGN KEY (user_id) REFERENCES users (id)
+);
+CREATE INDEX IF NOT EXISTS idx_reviews_product_id ON reviews (product_id);
+CREATE INDEX IF NOT EXISTS idx_reviews_deleted_at ON reviews (deleted_at);
+
+CREATE TABLE IF NOT EXISTS wishlists (
+    id bigserial,
+    created_at timestamptz,
+    user_id bigint NOT NULL,
+    product_id bigint NOT NULL,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_wishlists_user FOREIGN KEY (user_id) REFERENCES users (id),
+    CONSTRAINT fk_wishlists_product FOREIGN KEY (product_id) REFERENCES products (id)
+);
+CREATE INDEX IF NOT EXISTS idx_wishlists_product_id ON wishlists (product_id);
+CREATE INDEX IF NOT EXISTS idx_wishlists_user_id ON wishlists (user_id);
+
+CREATE TABLE IF NOT EXISTS addresses (
+    id bigserial,
+    created_at timestamptz,
+    updated_at timestamptz,
+    deleted_at timestamptz,
+    user_id bigint NOT NULL,
+    type text DEFAULT 'shipping',
+    is_default boolean DEFAULT false,
+    name text,
+    phone text,
+    address_line1 text,
+    address_line2 text,
+    city text,
+    state text,
+    postal_code text,
+    country text NOT NULL,
+    landmark text,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_addresses_user FOREIGN KEY (user_id) REFERENCES users (id)
+);
+CREATE INDEX IF NOT EXISTS idx_addresses_user_id ON addresses (user_id);
+CREATE INDEX IF NOT EXISTS idx_addresses_deleted_at ON addresses (deleted_at);
+
+CREATE TABLE IF NOT EXISTS push_subscriptions (
+    id bigserial,
+    created_at timestamptz,
+    updated_at timestamptz,
+    user_id bigint NOT NULL,
+    endpoint text NOT NULL,
+    p256_dh text NOT NULL,
+    auth text NOT NULL,
+    expiries timestamptz,
+    PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS idx_push_subscriptions_user_id ON push_subscriptions (user_id);
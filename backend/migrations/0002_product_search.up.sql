@@ -0,0 +1,48 @@
+-- Full-text search over products. The vector weights the name highest, then the
+-- category name, colors and description. It is kept up to date by triggers because
+-- the category name lives in another table.
+
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+ALTER TABLE products ADD COLUMN IF NOT EXISTS search_vector tsvector;
+
+CREATE OR REPLACE FUNCTION product_search_vector(p products) RETURNS tsvector AS $$
+    SELECT
+        setweight(to_tsvector('english', coalesce(p.name, '')), 'A') ||
+        setweight(to_tsvector('english', coalesce((SELECT c.name FROM categories c WHERE c.id = p.category_id), '')), 'B') ||
+        setweight(to_tsvector('english', coalesce((
+            SELECT string_agg(color, ' ')
+            FROM jsonb_array_elements_text(CASE WHEN jsonb_typeof(p.colors) = 'array' THEN p.colors ELSE '[]'::jsonb END) AS color
+        ), '')), 'C') ||
+        setweight(to_tsvector('english', coalesce(p.description, '')), 'D')
+$$ LANGUAGE sql STABLE;
+
+CREATE OR REPLACE FUNCTION products_search_vector_trigger() RETURNS trigger AS $$
+BEGIN
+    NEW.search_vector := product_search_vector(NEW);
+    RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS products_search_vector_update ON products;
+CREATE TRIGGER products_search_vector_update
+    BEFORE INSERT OR UPDATE OF name, description, colors, category_id ON products
+    FOR EACH ROW EXECUTE FUNCTION products_search_vector_trigger();
+
+CREATE OR REPLACE FUNCTION categories_search_vector_trigger() RETURNS trigger AS $$
+BEGIN
+    UPDATE products SET search_vector = product_search_vector(products) WHERE category_id = NEW.id;
+    RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS categories_search_vector_update ON categories;
+CREATE TRIGGER categories_search_vector_update
+    AFTER UPDATE OF name ON categories
+    FOR EACH ROW WHEN (OLD.name IS DISTINCT FROM NEW.name)
+    EXECUTE FUNCTION categories_search_vector_trigger();
+
+UPDATE products SET search_vector = product_search_vector(products);
+
+CREATE INDEX IF NOT EXISTS idx_products_search_vector ON products USING gin (search_vector);
+CREATE INDEX IF NOT EXISTS idx_products_name_trgm ON products USING gin (name gin_trgm_ops);
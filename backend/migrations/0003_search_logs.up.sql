@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS search_logs (
+    id bigserial,
+    created_at timestamptz,
+    query text NOT NULL,
+    result_count bigint,
+    fuzzy boolean,
+    PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS idx_search_logs_created_at ON search_logs (created_at);
+CREATE INDEX IF NOT EXISTS idx_search_logs_query ON search_logs (query);
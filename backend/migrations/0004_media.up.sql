@@ -0,0 +1,33 @@
+CREATE TABLE IF NOT EXISTS media (
+    id bigserial,
+    created_at timestamptz,
+    key text NOT NULL,
+    url text NOT NULL,
+    content_type text NOT NULL,
+    size bigint,
+    checksum text NOT NULL,
+    width bigint,
+    height bigint,
+    uploaded_by bigint,
+    PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_media_key ON media (key);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_media_checksum ON media (checksum);
+CREATE INDEX IF NOT EXISTS idx_media_uploaded_by ON media (uploaded_by);
+
+CREATE TABLE IF NOT EXISTS product_images (
+    id bigserial,
+    created_at timestamptz,
+    updated_at timestamptz,
+    product_id bigint NOT NULL,
+    media_id bigint NOT NULL,
+    url text NOT NULL,
+    alt_text text,
+    position bigint DEFAULT 0,
+    is_primary boolean DEFAULT false,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_products_images FOREIGN KEY (product_id) REFERENCES products (id),
+    CONSTRAINT fk_product_images_media FOREIGN KEY (media_id) REFERENCES media (id)
+);
+CREATE INDEX IF NOT EXISTS idx_product_images_product_id ON product_images (product_id);
+CREATE INDEX IF NOT EXISTS idx_product_images_media_id ON product_images (media_id);
@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS import_jobs;
+
+DROP INDEX IF EXISTS idx_media_source_url;
+ALTER TABLE media DROP COLUMN IF EXISTS source_url;
+
+DROP INDEX IF EXISTS idx_products_sku;
+ALTER TABLE products DROP COLUMN IF EXISTS sku;
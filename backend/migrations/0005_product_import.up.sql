@@ -0,0 +1,27 @@
+ALTER TABLE products ADD COLUMN IF NOT EXISTS sku text;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_products_sku ON products (sku);
+
+ALTER TABLE media ADD COLUMN IF NOT EXISTS source_url text;
+CREATE INDEX IF NOT EXISTS idx_media_source_url ON media (source_url);
+
+CREATE TABLE IF NOT EXISTS import_jobs (
+    id bigserial,
+    created_at timestamptz,
+    updated_at timestamptz,
+    filename text,
+    format text,
+    dry_run boolean,
+    status text DEFAULT 'pending',
+    total_rows bigint,
+    total_products bigint,
+    processed bigint,
+    created bigint,
+    updated bigint,
+    failed bigint,
+    issues jsonb,
+    created_by bigint,
+    finished_at timestamptz,
+    PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS idx_import_jobs_status ON import_jobs (status);
+CREATE INDEX IF NOT EXISTS idx_import_jobs_created_by ON import_jobs (created_by);
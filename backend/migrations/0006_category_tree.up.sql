@@ -0,0 +1,14 @@
+ALTER TABLE categories ADD COLUMN IF NOT EXISTS parent_id bigint CONSTRAINT fk_categories_children REFERENCES categories (id);
+ALTER TABLE categories ADD COLUMN IF NOT EXISTS sort_order bigint DEFAULT 0;
+CREATE INDEX IF NOT EXISTS idx_categories_parent_id ON categories (parent_id);
+
+-- Categories created without a slug get one from their name, suffixed with the ID
+-- where two names make the same slug
+UPDATE categories SET slug = trim(BOTH '-' FROM regexp_replace(lower(name), '[^a-z0-9]+', '-', 'g'))
+WHERE (slug IS NULL OR slug = '')
+  AND NOT EXISTS (
+      SELECT 1 FROM categories other
+      WHERE other.slug = trim(BOTH '-' FROM regexp_replace(lower(categories.name), '[^a-z0-9]+', '-', 'g'))
+  );
+UPDATE categories SET slug = trim(BOTH '-' FROM regexp_replace(lower(name), '[^a-z0-9]+', '-', 'g')) || '-' || id
+WHERE slug IS NULL OR slug = '';
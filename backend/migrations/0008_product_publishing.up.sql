@@ -0,0 +1,8 @@
+ALTER TABLE products ADD COLUMN IF NOT EXISTS status text NOT NULL DEFAULT 'published';
+ALTER TABLE products ADD COLUMN IF NOT EXISTS publish_at timestamptz;
+ALTER TABLE products ADD COLUMN IF NOT EXISTS unpublish_at timestamptz;
+CREATE INDEX IF NOT EXISTS idx_products_status ON products (status);
+
+-- Inactive products were already kept out of search, so they become drafts
+UPDATE products SET status = 'draft' WHERE is_active = false;
+ALTER TABLE products DROP COLUMN IF EXISTS is_active;
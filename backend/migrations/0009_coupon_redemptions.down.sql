@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS coupon_redemptions;
+ALTER TABLE coupons DROP COLUMN IF EXISTS first_order_only;
+ALTER TABLE coupons DROP COLUMN IF EXISTS per_user_limit;
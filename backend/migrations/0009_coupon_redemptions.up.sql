@@ -0,0 +1,27 @@
+ALTER TABLE coupons ADD COLUMN IF NOT EXISTS per_user_limit bigint NOT NULL DEFAULT 0;
+ALTER TABLE coupons ADD COLUMN IF NOT EXISTS first_order_only boolean NOT NULL DEFAULT false;
+
+CREATE TABLE IF NOT EXISTS coupon_redemptions (
+    id bigserial,
+    created_at timestamptz,
+    updated_at timestamptz,
+    coupon_id bigint NOT NULL,
+    user_id bigint NOT NULL,
+    order_id bigint NOT NULL,
+    discount_amount decimal NOT NULL,
+    status text NOT NULL DEFAULT 'redeemed',
+    released_at timestamptz,
+    PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS idx_coupon_redemptions_coupon_user ON coupon_redemptions (coupon_id, user_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_coupon_redemptions_order_id ON coupon_redemptions (order_id);
+CREATE INDEX IF NOT EXISTS idx_coupon_redemptions_status ON coupon_redemptions (status);
+
+-- Coupons used on earlier orders were never counted, so record those orders now
+INSERT INTO coupon_redemptions (created_at, updated_at, coupon_id, user_id, order_id, discount_amount, status)
+SELECT o.created_at, o.created_at, c.id, o.user_id, o.id, o.discount_amount, 'redeemed'
+FROM orders o JOIN coupons c ON c.code = o.coupon_code
+WHERE o.status NOT IN ('cancelled', 'expired')
+ON CONFLICT DO NOTHING;
+UPDATE coupons SET used_count = GREATEST(COALESCE(used_count, 0),
+    (SELECT count(*) FROM coupon_redemptions r WHERE r.coupon_id = coupons.id AND r.status = 'redeemed'));
@@ -0,0 +1,11 @@
+ALTER TABLE order_items DROP COLUMN IF EXISTS discount;
+
+ALTER TABLE coupons DROP COLUMN IF EXISTS get_quantity;
+ALTER TABLE coupons DROP COLUMN IF EXISTS buy_quantity;
+ALTER TABLE coupons DROP COLUMN IF EXISTS min_quantity;
+ALTER TABLE coupons DROP COLUMN IF EXISTS customer_segments;
+ALTER TABLE coupons DROP COLUMN IF EXISTS allowed_customers;
+ALTER TABLE coupons DROP COLUMN IF EXISTS excluded_products;
+ALTER TABLE coupons DROP COLUMN IF EXISTS included_products;
+ALTER TABLE coupons DROP COLUMN IF EXISTS excluded_categories;
+ALTER TABLE coupons DROP COLUMN IF EXISTS included_categories;
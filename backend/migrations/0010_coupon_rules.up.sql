@@ -0,0 +1,11 @@
+ALTER TABLE coupons ADD COLUMN IF NOT EXISTS included_categories jsonb;
+ALTER TABLE coupons ADD COLUMN IF NOT EXISTS excluded_categories jsonb;
+ALTER TABLE coupons ADD COLUMN IF NOT EXISTS included_products jsonb;
+ALTER TABLE coupons ADD COLUMN IF NOT EXISTS excluded_products jsonb;
+ALTER TABLE coupons ADD COLUMN IF NOT EXISTS allowed_customers jsonb;
+ALTER TABLE coupons ADD COLUMN IF NOT EXISTS customer_segments jsonb;
+ALTER TABLE coupons ADD COLUMN IF NOT EXISTS min_quantity bigint NOT NULL DEFAULT 0;
+ALTER TABLE coupons ADD COLUMN IF NOT EXISTS buy_quantity bigint NOT NULL DEFAULT 0;
+ALTER TABLE coupons ADD COLUMN IF NOT EXISTS get_quantity bigint NOT NULL DEFAULT 0;
+
+ALTER TABLE order_items ADD COLUMN IF NOT EXISTS discount decimal NOT NULL DEFAULT 0;
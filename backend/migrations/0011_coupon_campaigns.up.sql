@@ -0,0 +1,36 @@
+CREATE TABLE IF NOT EXISTS coupon_campaigns (
+    id bigserial,
+    created_at timestamptz,
+    updated_at timestamptz,
+    name text NOT NULL,
+    description text,
+    template text NOT NULL,
+    code_count bigint NOT NULL DEFAULT 0,
+    created_by bigint,
+    PRIMARY KEY (id)
+);
+
+ALTER TABLE coupons ADD COLUMN IF NOT EXISTS campaign_id bigint;
+CREATE INDEX IF NOT EXISTS idx_coupons_campaign_id ON coupons (campaign_id);
+
+-- Codes used to be stored with APP_ENV in front, e.g. productionDIWALI20, and
+-- valid codes are uppercase. The known environment prefixes are dropped, in any
+-- case, and the rest uppercased wherever that doesn't collide with another code.
+-- Orders keep pointing at the coupon they used.
+CREATE TEMP TABLE coupon_code_fixes ON COMMIT DROP AS
+SELECT id, code AS old_code, upper(
+    CASE WHEN trim(code) ~* '^(production|staging|development)[a-z0-9]{3,}$'
+         THEN regexp_replace(trim(code), '^(production|staging|development)', '', 'i')
+         ELSE trim(code)
+    END) AS new_code
+FROM coupons;
+
+DELETE FROM coupon_code_fixes WHERE new_code = old_code;
+
+DELETE FROM coupon_code_fixes f
+WHERE EXISTS (SELECT 1 FROM coupons o WHERE o.code = f.new_code)
+   OR f.id <> (SELECT min(d.id) FROM coupon_code_fixes d WHERE d.new_code = f.new_code);
+
+UPDATE coupons c SET code = f.new_code FROM coupon_code_fixes f WHERE c.id = f.id;
+
+UPDATE orders o SET coupon_code = f.new_code FROM coupon_code_fixes f WHERE o.coupon_code = f.old_code;
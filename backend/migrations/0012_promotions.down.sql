@@ -0,0 +1,6 @@
+ALTER TABLE order_items DROP COLUMN IF EXISTS is_gift;
+
+ALTER TABLE orders DROP COLUMN IF EXISTS promotion_ids;
+ALTER TABLE orders DROP COLUMN IF EXISTS promotion_discount;
+
+DROP TABLE IF EXISTS promotions;
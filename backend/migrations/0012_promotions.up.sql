@@ -0,0 +1,32 @@
+CREATE TABLE IF NOT EXISTS promotions (
+    id bigserial,
+    created_at timestamptz,
+    updated_at timestamptz,
+    name text NOT NULL,
+    description text,
+    type text NOT NULL,
+    discount_value decimal NOT NULL DEFAULT 0,
+    tiers jsonb,
+    min_spend decimal NOT NULL DEFAULT 0,
+    gift_product_id bigint,
+    gift_variant_id bigint,
+    gift_quantity bigint NOT NULL DEFAULT 0,
+    included_categories jsonb,
+    excluded_categories jsonb,
+    included_products jsonb,
+    excluded_products jsonb,
+    starts_at timestamptz,
+    ends_at timestamptz,
+    priority bigint NOT NULL DEFAULT 0,
+    stackable boolean NOT NULL DEFAULT false,
+    combines_with_coupons boolean NOT NULL DEFAULT false,
+    is_active boolean DEFAULT true,
+    PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS idx_promotions_priority ON promotions (priority);
+CREATE INDEX IF NOT EXISTS idx_promotions_is_active ON promotions (is_active);
+
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS promotion_discount decimal NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS promotion_ids jsonb;
+
+ALTER TABLE order_items ADD COLUMN IF NOT EXISTS is_gift boolean NOT NULL DEFAULT false;
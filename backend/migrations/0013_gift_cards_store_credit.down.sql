@@ -0,0 +1,7 @@
+ALTER TABLE orders DROP COLUMN IF EXISTS store_credit_amount;
+ALTER TABLE orders DROP COLUMN IF EXISTS gift_card_amount;
+ALTER TABLE orders DROP COLUMN IF EXISTS gift_card_id;
+
+DROP TABLE IF EXISTS store_credit_entries;
+DROP TABLE IF EXISTS gift_card_entries;
+DROP TABLE IF EXISTS gift_cards;
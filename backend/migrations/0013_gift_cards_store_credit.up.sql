@@ -0,0 +1,56 @@
+CREATE TABLE IF NOT EXISTS gift_cards (
+    id bigserial,
+    created_at timestamptz,
+    updated_at timestamptz,
+    code text NOT NULL,
+    initial_value decimal NOT NULL,
+    balance decimal NOT NULL DEFAULT 0,
+    currency text DEFAULT 'INR',
+    status text NOT NULL DEFAULT 'pending',
+    expires_at timestamptz,
+    purchaser_id bigint,
+    order_id bigint,
+    issued_by bigint,
+    recipient_name text,
+    recipient_email text,
+    message text,
+    PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_gift_cards_code ON gift_cards (code);
+CREATE INDEX IF NOT EXISTS idx_gift_cards_status ON gift_cards (status);
+CREATE INDEX IF NOT EXISTS idx_gift_cards_purchaser_id ON gift_cards (purchaser_id);
+CREATE INDEX IF NOT EXISTS idx_gift_cards_order_id ON gift_cards (order_id);
+
+CREATE TABLE IF NOT EXISTS gift_card_entries (
+    id bigserial,
+    created_at timestamptz,
+    gift_card_id bigint NOT NULL,
+    amount decimal NOT NULL,
+    balance_after decimal NOT NULL,
+    kind text NOT NULL,
+    order_id bigint,
+    reason text,
+    actor_id bigint,
+    PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS idx_gift_card_entries_gift_card_id ON gift_card_entries (gift_card_id);
+CREATE INDEX IF NOT EXISTS idx_gift_card_entries_order_id ON gift_card_entries (order_id);
+
+CREATE TABLE IF NOT EXISTS store_credit_entries (
+    id bigserial,
+    created_at timestamptz,
+    user_id bigint NOT NULL,
+    amount decimal NOT NULL,
+    balance_after decimal NOT NULL,
+    kind text NOT NULL,
+    order_id bigint,
+    reason text,
+    actor_id bigint,
+    PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS idx_store_credit_entries_user_id ON store_credit_entries (user_id);
+CREATE INDEX IF NOT EXISTS idx_store_credit_entries_order_id ON store_credit_entries (order_id);
+
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS gift_card_id bigint;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS gift_card_amount decimal NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS store_credit_amount decimal NOT NULL DEFAULT 0;
@@ -0,0 +1,102 @@
+package migrations
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    fstest.MapFS
+		versions []int64
+		wantErr  string
+	}{
+		{
+			name: "sorted by version",
+			files: fstest.MapFS{
+				"0010_add_index.up.sql":   {Data: []byte("CREATE INDEX a ON b (c);")},
+				"0010_add_index.down.sql": {Data: []byte("DROP INDEX a;")},
+				"0002_init.up.sql":        {Data: []byte("CREATE TABLE b (c int);")},
+				"migrations.go":           {Data: []byte("package migrations")},
+			},
+			versions: []int64{2, 10},
+		},
+		{
+			name:    "down without up",
+			files:   fstest.MapFS{"0001_init.down.sql": {Data: []byte("DROP TABLE b;")}},
+			wantErr: "has no up file",
+		},
+		{
+			name: "mismatched names",
+			files: fstest.MapFS{
+				"0001_init.up.sql":    {Data: []byte("CREATE TABLE b (c int);")},
+				"0001_other.down.sql": {Data: []byte("DROP TABLE b;")},
+			},
+			wantErr: "different names",
+		},
+		{
+			name:    "badly named file",
+			files:   fstest.MapFS{"init.sql": {Data: []byte("CREATE TABLE b (c int);")}},
+			wantErr: "is not named",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			migrations, err := load(tt.files)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("load error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("load error = %v", err)
+			}
+			if len(migrations) != len(tt.versions) {
+				t.Fatalf("loaded %d migrations, want %d", len(migrations), len(tt.versions))
+			}
+			for i, v := range tt.versions {
+				if migrations[i].Version != v {
+					t.Errorf("migrations[%d].Version = %d, want %d", i, migrations[i].Version, v)
+				}
+			}
+		})
+	}
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	migrations, err := Load()
+	if err != nil {
+		t.Fatalf("Load error = %v", err)
+	}
+	for _, m := range migrations {
+		if strings.TrimSpace(m.Down) == "" {
+			t.Errorf("migration %04d_%s has no down file", m.Version, m.Name)
+		}
+	}
+}
+
+func TestCreate(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "0003_init.up.sql"), []byte("CREATE TABLE b (c int);"), 0o644)
+
+	upPath, downPath, err := Create(dir, "Add Product Search!")
+	if err != nil {
+		t.Fatalf("Create error = %v", err)
+	}
+	if filepath.Base(upPath) != "0004_add_product_search.up.sql" {
+		t.Errorf("up file = %s", filepath.Base(upPath))
+	}
+	if filepath.Base(downPath) != "0004_add_product_search.down.sql" {
+		t.Errorf("down file = %s", filepath.Base(downPath))
+	}
+
+	if _, _, err := Create(dir, "  !! "); err == nil {
+		t.Error("Create accepted an empty name")
+	}
+}
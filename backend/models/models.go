@@ -35,6 +35,34 @@ func (a *StringArray) Scan(value interface{}) error {
 	return json.Unmarshal(bytes, a)
 }
 
+// UintArray is a list of IDs stored as a JSON array
+type UintArray []uint
+
+func (a UintArray) Value() (driver.Value, error) {
+	if a == nil {
+		return nil, nil
+	}
+	return json.Marshal(a)
+}
+
+func (a *UintArray) Scan(value interface{}) error {
+	if value == nil {
+		*a = nil
+		return nil
+	}
+
+	bytes, ok := value.([]byte)
+	if !ok {
+		str, ok := value.(string)
+		if !ok {
+			return nil
+		}
+		bytes = []byte(str)
+	}
+
+	return json.Unmarshal(bytes, a)
+}
+
 type JSONB map[string]interface{}
 
 func (j JSONB) Value() (driver.Value, error) {
@@ -76,6 +104,38 @@ type User struct {
 	Provider    string         `gorm:"default:email" json:"provider"`
 }
 
+type UserSession struct {
+	ID            uint       `gorm:"primarykey" json:"id"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+	UserID        uint       `gorm:"not null;index" json:"user_id"`
+	UserAgent     string     `json:"user_agent"`
+	IPAddress     string     `json:"ip_address"`
+	LastUsedAt    time.Time  `json:"last_used_at"`
+	ExpiresAt     time.Time  `gorm:"index" json:"expires_at"`
+	RevokedAt     *time.Time `json:"revoked_at,omitempty"`
+	RevokedReason string     `json:"revoked_reason,omitempty"`
+}
+
+type RefreshToken struct {
+	ID        uint       `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time  `json:"created_at"`
+	SessionID uint       `gorm:"not null;index" json:"session_id"`
+	UserID    uint       `gorm:"not null;index" json:"user_id"`
+	TokenHash string     `gorm:"uniqueIndex;not null" json:"-"` // SHA-256 of the opaque token
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"` // set when rotated; presenting it again is reuse
+}
+
+type PasswordResetToken struct {
+	ID        uint       `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time  `json:"created_at"`
+	UserID    uint       `gorm:"not null;index" json:"user_id"`
+	TokenHash string     `gorm:"uniqueIndex;not null" json:"-"` // SHA-256 of the emailed token
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+}
+
 type Category struct {
 	ID          uint           `gorm:"primarykey" json:"id"`
 	CreatedAt   time.Time      `json:"created_at"`
@@ -86,6 +146,9 @@ type Category struct {
 	Description string         `json:"description"`
 	Image       string         `json:"image"`
 	IsActive    bool           `gorm:"default:true" json:"is_active"`
+	ParentID    *uint          `gorm:"index" json:"parent_id"`
+	SortOrder   int            `gorm:"default:0" json:"sort_order"`
+	Children    []Category     `gorm:"foreignKey:ParentID" json:"children,omitempty"`
 	Products    []Product      `gorm:"foreignKey:CategoryID" json:"products,omitempty"`
 }
 
@@ -94,6 +157,7 @@ type Product struct {
 	CreatedAt   time.Time      `json:"created_at"`
 	UpdatedAt   time.Time      `json:"updated_at"`
 	DeletedAt   gorm.DeletedAt `gorm:"index" json:"deleted_at,omitempty"`
+	SKU         *string        `gorm:"uniqueIndex" json:"sku,omitempty"`
 	Name        string         `gorm:"not null" json:"name"`
 	Price       float64        `gorm:"not null" json:"price"`
 	Description string         `json:"description"`
@@ -104,32 +168,107 @@ type Product struct {
 	Sizes       StringArray    `gorm:"type:jsonb" json:"sizes"`
 	Stock       int            `gorm:"default:0" json:"stock"`
 	IsFeatured  bool           `gorm:"default:false" json:"is_featured"`
-	IsActive    bool           `gorm:"default:true" json:"is_active"`
+	// Status is draft, published or archived. A published product is on the
+	// storefront between PublishAt and UnpublishAt when they are set.
+	Status      string           `gorm:"not null;default:published;index" json:"status"`
+	PublishAt   *time.Time       `json:"publish_at"`
+	UnpublishAt *time.Time       `json:"unpublish_at"`
+	Variants    []ProductVariant `gorm:"foreignKey:ProductID" json:"variants,omitempty"`
+	Images      []ProductImage   `gorm:"foreignKey:ProductID" json:"images,omitempty"`
+}
+
+// Media is an uploaded file in media storage. Files are stored once per content
+// hash, so uploading the same image twice returns the same record.
+type Media struct {
+	ID          uint      `gorm:"primarykey" json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	Key         string    `gorm:"uniqueIndex;not null" json:"key"`
+	URL         string    `gorm:"not null" json:"url"`
+	ContentType string    `gorm:"not null" json:"content_type"`
+	Size        int64     `json:"size"`
+	Checksum    string    `gorm:"uniqueIndex;not null" json:"checksum"` // hex SHA-256 of the content
+	Width       int       `json:"width"`
+	Height      int       `json:"height"`
+	UploadedBy  *uint     `gorm:"index" json:"uploaded_by,omitempty"`
+	SourceURL   string    `gorm:"index" json:"source_url,omitempty"` // where an imported image was fetched from
+}
+
+// ProductImage places a media file in a product's gallery. The primary image's URL
+// is copied to Product.Image.
+type ProductImage struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	ProductID uint      `gorm:"not null;index" json:"product_id"`
+	MediaID   uint      `gorm:"not null;index" json:"media_id"`
+	Media     Media     `gorm:"foreignKey:MediaID" json:"-"`
+	URL       string    `gorm:"not null" json:"url"`
+	AltText   string    `json:"alt_text"`
+	Position  int       `gorm:"default:0" json:"position"`
+	IsPrimary bool      `gorm:"default:false" json:"is_primary"`
+}
+
+type ProductVariant struct {
+	ID        uint           `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"deleted_at,omitempty"`
+	ProductID uint           `gorm:"not null;index" json:"product_id"`
+	SKU       string         `gorm:"uniqueIndex;not null" json:"sku"`
+	Color     string         `json:"color"`
+	Size      string         `json:"size"`
+	Price     *float64       `json:"price,omitempty"` // overrides Product.Price when set
+	Stock     int            `gorm:"default:0" json:"stock"`
+	Weight    float64        `json:"weight"` // kg
+	Barcode   string         `gorm:"index" json:"barcode"`
+	IsActive  bool           `gorm:"default:true" json:"is_active"`
+}
+
+// EffectivePrice returns the variant's price override or the product's base price
+func (v *ProductVariant) EffectivePrice(product *Product) float64 {
+	if v.Price != nil {
+		return *v.Price
+	}
+	return product.Price
 }
 
 type Order struct {
-	ID              uint        `gorm:"primarykey" json:"id"`
-	CreatedAt       time.Time   `json:"created_at"`
-	UpdatedAt       time.Time   `json:"updated_at"`
-	UserID          uint        `json:"user_id"`
-	User            User        `gorm:"foreignKey:UserID" json:"user,omitempty"`
-	Status          string      `gorm:"default:pending_payment" json:"status"`
-	TotalAmount     float64     `json:"total_amount"`
-	DiscountAmount  float64     `json:"discount_amount"`
-	ShippingCost    float64     `json:"shipping_cost"`
-	TaxAmount       float64     `json:"tax_amount"`
-	Currency        string      `gorm:"default:INR" json:"currency"`
-	Items           []OrderItem `gorm:"foreignKey:OrderID" json:"items"`
-	ShippingName    string      `json:"shipping_name"`
-	ShippingEmail   string      `json:"shipping_email"`
-	ShippingAddress string      `json:"shipping_address"`
-	ShippingCity    string      `json:"shipping_city"`
-	ShippingState   string      `json:"shipping_state"`
-	ShippingCountry string      `json:"shipping_country"`
-	ShippingZip     string      `json:"shipping_zip"`
-	ShippingPhone   string      `json:"shipping_phone"`
-	CouponCode      string      `json:"coupon_code"`
-	Notes           string      `gorm:"type:text" json:"notes"`
+	ID              uint                 `gorm:"primarykey" json:"id"`
+	CreatedAt       time.Time            `json:"created_at"`
+	UpdatedAt       time.Time            `json:"updated_at"`
+	UserID          uint                 `json:"user_id"`
+	User            User                 `gorm:"foreignKey:UserID" json:"user,omitempty"`
+	Status          string               `gorm:"default:pending_payment" json:"status"`
+	Subtotal        float64              `json:"subtotal"`
+	TotalAmount     float64              `json:"total_amount"`
+	DiscountAmount  float64              `json:"discount_amount"`
+	ShippingCost    float64              `json:"shipping_cost"`
+	TaxAmount       float64              `json:"tax_amount"`
+	Currency        string               `gorm:"default:INR" json:"currency"`
+	Items           []OrderItem          `gorm:"foreignKey:OrderID" json:"items"`
+	StatusHistory   []OrderStatusHistory `gorm:"foreignKey:OrderID" json:"status_history,omitempty"`
+	ShippingName    string               `json:"shipping_name"`
+	ShippingEmail   string               `json:"shipping_email"`
+	ShippingAddress string               `json:"shipping_address"`
+	ShippingCity    string               `json:"shipping_city"`
+	ShippingState   string               `json:"shipping_state"`
+	ShippingCountry string               `json:"shipping_country"`
+	ShippingZip     string               `json:"shipping_zip"`
+	ShippingPhone   string               `json:"shipping_phone"`
+	CouponCode      string               `json:"coupon_code"`
+	ShippingMethod  string               `json:"shipping_method"`
+	ReservedUntil   *time.Time           `json:"reserved_until,omitempty"`
+	Notes           string               `gorm:"type:text" json:"notes"`
+	// PromotionDiscount is the part of DiscountAmount taken off by the promotions
+	// in PromotionIDs
+	PromotionDiscount float64   `gorm:"not null;default:0" json:"promotion_discount"`
+	PromotionIDs      UintArray `gorm:"type:jsonb" json:"promotion_ids"`
+	// GiftCardAmount and StoreCreditAmount are the parts of TotalAmount paid with
+	// the gift card GiftCardID and the customer's store credit. Razorpay is only
+	// charged the rest.
+	GiftCardID        *uint   `json:"gift_card_id,omitempty"`
+	GiftCardAmount    float64 `gorm:"not null;default:0" json:"gift_card_amount"`
+	StoreCreditAmount float64 `gorm:"not null;default:0" json:"store_credit_amount"`
 	// Payment Fields
 	PaymentMethod   string `json:"payment_method"`
 	PaymentIntentID string `json:"payment_intent_id"`
@@ -148,15 +287,46 @@ type Order struct {
 }
 
 type OrderItem struct {
-	ID        uint    `gorm:"primarykey" json:"id"`
-	OrderID   uint    `json:"order_id"`
-	Order     Order   `gorm:"foreignKey:OrderID" json:"order,omitempty"`
-	ProductID uint    `json:"product_id"`
-	Product   Product `gorm:"foreignKey:ProductID" json:"product,omitempty"`
-	Quantity  int     `json:"quantity"`
-	Price     float64 `json:"price"`
-	Color     string  `json:"color"`
-	Size      string  `json:"size"`
+	ID        uint            `gorm:"primarykey" json:"id"`
+	OrderID   uint            `json:"order_id"`
+	Order     Order           `gorm:"foreignKey:OrderID" json:"order,omitempty"`
+	ProductID uint            `json:"product_id"`
+	Product   Product         `gorm:"foreignKey:ProductID" json:"product,omitempty"`
+	VariantID *uint           `gorm:"index" json:"variant_id,omitempty"`
+	Variant   *ProductVariant `gorm:"foreignKey:VariantID" json:"variant,omitempty"`
+	SKU       string          `json:"sku"`
+	Quantity  int             `json:"quantity"`
+	Price     float64         `json:"price"`
+	LineTotal float64         `json:"line_total"`
+	// Discount is what promotions and the order's coupon take off this line
+	Discount float64 `gorm:"not null;default:0" json:"discount"`
+	Color    string  `json:"color"`
+	Size     string  `json:"size"`
+	// IsGift marks an item added free by a promotion
+	IsGift bool `gorm:"not null;default:false" json:"is_gift"`
+}
+
+type OrderStatusHistory struct {
+	ID         uint      `gorm:"primarykey" json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	OrderID    uint      `gorm:"not null;index" json:"order_id"`
+	FromStatus string    `json:"from_status"`
+	ToStatus   string    `gorm:"not null" json:"to_status"`
+	Source     string    `gorm:"not null" json:"source"` // "admin", "customer", "webhook", "system"
+	ActorID    *uint     `json:"actor_id,omitempty"`
+	Reason     string    `gorm:"type:text" json:"reason,omitempty"`
+}
+
+type StockReservation struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	OrderID   uint      `gorm:"not null;index" json:"order_id"`
+	ProductID uint      `gorm:"not null;index" json:"product_id"`
+	VariantID *uint     `json:"variant_id,omitempty"`
+	Quantity  int       `gorm:"not null" json:"quantity"`
+	Status    string    `gorm:"not null;index;default:held" json:"status"` // "held", "committed", "released"
+	ExpiresAt time.Time `gorm:"index" json:"expires_at"`
 }
 
 type PaymentTransaction struct {
@@ -205,6 +375,68 @@ type ShippingZone struct {
 	IsActive      bool        `gorm:"default:true" json:"is_active"`
 }
 
+type SearchLog struct {
+	ID          uint      `gorm:"primarykey" json:"id"`
+	CreatedAt   time.Time `gorm:"index" json:"created_at"`
+	Query       string    `gorm:"not null;index" json:"query"` // normalized search term
+	ResultCount int       `json:"result_count"`
+	Fuzzy       bool      `json:"fuzzy"`
+}
+
+// ImportIssue is a problem found in one row of an import file
+type ImportIssue struct {
+	Row     int    `json:"row"`
+	Column  string `json:"column,omitempty"`
+	Message string `json:"message"`
+}
+
+type ImportIssues []ImportIssue
+
+func (a ImportIssues) Value() (driver.Value, error) {
+	if a == nil {
+		return nil, nil
+	}
+	return json.Marshal(a)
+}
+
+func (a *ImportIssues) Scan(value interface{}) error {
+	if value == nil {
+		*a = nil
+		return nil
+	}
+
+	bytes, ok := value.([]byte)
+	if !ok {
+		str, ok := value.(string)
+		if !ok {
+			return nil
+		}
+		bytes = []byte(str)
+	}
+
+	return json.Unmarshal(bytes, a)
+}
+
+// ImportJob tracks a product import from validation to completion
+type ImportJob struct {
+	ID            uint         `gorm:"primarykey" json:"id"`
+	CreatedAt     time.Time    `json:"created_at"`
+	UpdatedAt     time.Time    `json:"updated_at"`
+	Filename      string       `json:"filename"`
+	Format        string       `json:"format"` // csv or xlsx
+	DryRun        bool         `json:"dry_run"`
+	Status        string       `gorm:"default:pending;index" json:"status"` // see services.Import* statuses
+	TotalRows     int          `json:"total_rows"`
+	TotalProducts int          `json:"total_products"`
+	Processed     int          `json:"processed"` // products handled so far
+	Created       int          `json:"created"`
+	Updated       int          `json:"updated"`
+	Failed        int          `json:"failed"`
+	Issues        ImportIssues `gorm:"type:jsonb" json:"issues"`
+	CreatedBy     *uint        `gorm:"index" json:"created_by,omitempty"`
+	FinishedAt    *time.Time   `json:"finished_at,omitempty"`
+}
+
 type Newsletter struct {
 	ID         uint      `gorm:"primarykey" json:"id"`
 	CreatedAt  time.Time `json:"created_at"`
@@ -286,6 +518,33 @@ type NotificationTemplate struct {
 	IsActive  bool      `gorm:"default:true" json:"is_active"`
 }
 
+type EmailLog struct {
+	ID             uint       `gorm:"primarykey" json:"id"`
+	CreatedAt      time.Time  `json:"created_at"`
+	NotificationID *uint      `gorm:"index" json:"notification_id,omitempty"`
+	Type           string     `gorm:"index" json:"type"`
+	ToAddress      string     `gorm:"index;not null" json:"to_address"`
+	Subject        string     `json:"subject"`
+	MessageID      string     `json:"message_id"`
+	Status         string     `gorm:"index;not null" json:"status"`
+	Attempts       int        `json:"attempts"`
+	LastError      string     `gorm:"type:text" json:"last_error,omitempty"`
+	SentAt         *time.Time `json:"sent_at,omitempty"`
+}
+
+// AuditLog records an admin action: who did it, to what, and what changed
+type AuditLog struct {
+	ID         uint      `gorm:"primarykey" json:"id"`
+	CreatedAt  time.Time `gorm:"index" json:"created_at"`
+	ActorID    *uint     `gorm:"index" json:"actor_id,omitempty"`
+	Action     string    `gorm:"not null;index" json:"action"` // e.g. category.delete
+	EntityType string    `gorm:"not null;index:idx_audit_logs_entity" json:"entity_type"`
+	EntityID   uint      `gorm:"index:idx_audit_logs_entity" json:"entity_id"`
+	Details    JSONB     `gorm:"type:jsonb" json:"details"`
+	IPAddress  string    `json:"ip_address"`
+	RequestID  string    `json:"request_id"`
+}
+
 type PushSubscription struct {
 	ID        uint       `gorm:"primarykey" json:"id"`
 	CreatedAt time.Time  `json:"created_at"`
@@ -313,6 +572,52 @@ type Coupon struct {
 	UsedCount           int         `gorm:"default:0" json:"used_count"`
 	IsActive            bool        `gorm:"default:true" json:"is_active"`
 	ApplicableCountries StringArray `gorm:"type:jsonb" json:"applicable_countries"`
+	PerUserLimit        int         `gorm:"not null;default:0" json:"per_user_limit"`
+	FirstOrderOnly      bool        `gorm:"not null;default:false" json:"first_order_only"`
+	// Product and category rules pick the lines a coupon discounts. Categories cover
+	// their subcategories, and exclusions win over inclusions.
+	IncludedCategories UintArray `gorm:"type:jsonb" json:"included_categories"`
+	ExcludedCategories UintArray `gorm:"type:jsonb" json:"excluded_categories"`
+	IncludedProducts   UintArray `gorm:"type:jsonb" json:"included_products"`
+	ExcludedProducts   UintArray `gorm:"type:jsonb" json:"excluded_products"`
+	// A coupon with an allow-list or segments is only for the customers they name
+	AllowedCustomers UintArray   `gorm:"type:jsonb" json:"allowed_customers"`
+	CustomerSegments StringArray `gorm:"type:jsonb" json:"customer_segments"`
+	// MinQuantity is how many qualifying items the order needs
+	MinQuantity int `gorm:"not null;default:0" json:"min_quantity"`
+	// BuyQuantity and GetQuantity shape a buy_x_get_y coupon, which takes
+	// DiscountValue percent off the cheapest GetQuantity of every
+	// BuyQuantity+GetQuantity qualifying items
+	BuyQuantity int `gorm:"not null;default:0" json:"buy_quantity"`
+	GetQuantity int `gorm:"not null;default:0" json:"get_quantity"`
+	// CampaignID is set on codes generated in bulk for a campaign
+	CampaignID *uint `gorm:"index" json:"campaign_id,omitempty"`
+}
+
+// CouponCampaign groups coupon codes generated in bulk from one template
+type CouponCampaign struct {
+	ID          uint      `gorm:"primarykey" json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	Name        string    `gorm:"not null" json:"name"`
+	Description string    `json:"description"`
+	Template    string    `gorm:"not null" json:"template"`
+	CodeCount   int       `gorm:"not null;default:0" json:"code_count"`
+	CreatedBy   *uint     `json:"created_by,omitempty"`
+}
+
+// CouponRedemption records a coupon used on an order. It is released, and stops
+// counting towards the coupon's limits, when the order is cancelled or expires.
+type CouponRedemption struct {
+	ID             uint       `gorm:"primarykey" json:"id"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+	CouponID       uint       `gorm:"not null;index:idx_coupon_redemptions_coupon_user" json:"coupon_id"`
+	UserID         uint       `gorm:"not null;index:idx_coupon_redemptions_coupon_user" json:"user_id"`
+	OrderID        uint       `gorm:"not null;uniqueIndex" json:"order_id"`
+	DiscountAmount float64    `gorm:"not null" json:"discount_amount"`
+	Status         string     `gorm:"not null;index;default:redeemed" json:"status"` // "redeemed", "released"
+	ReleasedAt     *time.Time `json:"released_at,omitempty"`
 }
 
 func (c *Coupon) IsValid() bool {
@@ -332,6 +637,126 @@ func (c *Coupon) IsValid() bool {
 	return true
 }
 
+// Promotion is a discount applied automatically, without a code, to orders that
+// meet its rules while it is running
+type Promotion struct {
+	ID          uint      `gorm:"primarykey" json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	Name        string    `gorm:"not null" json:"name"`
+	Description string    `json:"description"`
+	// Type is percentage or fixed for a discount on each covered item, tiered_spend
+	// or free_gift
+	Type          string  `gorm:"not null" json:"type"`
+	DiscountValue float64 `gorm:"not null;default:0" json:"discount_value"`
+	// Tiers are the discounts of a tiered_spend promotion by spend on covered items
+	Tiers PromotionTiers `gorm:"type:jsonb" json:"tiers"`
+	// The promotion only applies to orders that spend at least MinSpend on covered
+	// items. A free_gift promotion adds GiftQuantity of the gift product to them.
+	MinSpend      float64 `gorm:"not null;default:0" json:"min_spend"`
+	GiftProductID *uint   `json:"gift_product_id,omitempty"`
+	GiftVariantID *uint   `json:"gift_variant_id,omitempty"`
+	GiftQuantity  int     `gorm:"not null;default:0" json:"gift_quantity"`
+	// Product and category rules pick the items a promotion covers, as for coupons
+	IncludedCategories UintArray  `gorm:"type:jsonb" json:"included_categories"`
+	ExcludedCategories UintArray  `gorm:"type:jsonb" json:"excluded_categories"`
+	IncludedProducts   UintArray  `gorm:"type:jsonb" json:"included_products"`
+	ExcludedProducts   UintArray  `gorm:"type:jsonb" json:"excluded_products"`
+	StartsAt           *time.Time `json:"starts_at"`
+	EndsAt             *time.Time `json:"ends_at"`
+	// Promotions are tried from the highest Priority down. A promotion that isn't
+	// Stackable only applies on its own.
+	Priority  int  `gorm:"not null;default:0;index" json:"priority"`
+	Stackable bool `gorm:"not null;default:false" json:"stackable"`
+	// CombinesWithCoupons lets a coupon be used on orders the promotion applies to
+	CombinesWithCoupons bool `gorm:"not null;default:false" json:"combines_with_coupons"`
+	IsActive            bool `gorm:"default:true;index" json:"is_active"`
+}
+
+// PromotionTier is a step of a tiered_spend promotion
+type PromotionTier struct {
+	MinSpend      float64 `json:"min_spend"`
+	DiscountType  string  `json:"discount_type"` // "percentage", "fixed"
+	DiscountValue float64 `json:"discount_value"`
+}
+
+// PromotionTiers is a list of tiers stored as a JSON array
+type PromotionTiers []PromotionTier
+
+func (t PromotionTiers) Value() (driver.Value, error) {
+	if t == nil {
+		return nil, nil
+	}
+	return json.Marshal(t)
+}
+
+func (t *PromotionTiers) Scan(value interface{}) error {
+	if value == nil {
+		*t = nil
+		return nil
+	}
+
+	bytes, ok := value.([]byte)
+	if !ok {
+		str, ok := value.(string)
+		if !ok {
+			return nil
+		}
+		bytes = []byte(str)
+	}
+
+	return json.Unmarshal(bytes, t)
+}
+
+// GiftCard is a prepaid card spent by its code at checkout. Balance is what is left
+// of InitialValue, and every change to it is recorded as a GiftCardEntry.
+type GiftCard struct {
+	ID           uint       `gorm:"primarykey" json:"id"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+	Code         string     `gorm:"not null;uniqueIndex" json:"code"`
+	InitialValue float64    `gorm:"not null" json:"initial_value"`
+	Balance      float64    `gorm:"not null;default:0" json:"balance"`
+	Currency     string     `gorm:"default:INR" json:"currency"`
+	Status       string     `gorm:"not null;default:pending;index" json:"status"` // "pending", "active", "disabled", "cancelled"
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+	// A card bought by a customer is paid for by OrderID and stays pending until
+	// that order is paid. Cards issued by an admin have IssuedBy instead.
+	PurchaserID    *uint  `gorm:"index" json:"purchaser_id,omitempty"`
+	OrderID        *uint  `gorm:"index" json:"order_id,omitempty"`
+	IssuedBy       *uint  `json:"issued_by,omitempty"`
+	RecipientName  string `json:"recipient_name"`
+	RecipientEmail string `json:"recipient_email"`
+	Message        string `gorm:"type:text" json:"message"`
+}
+
+// GiftCardEntry is a change to a gift card's balance. Entries are only ever added.
+type GiftCardEntry struct {
+	ID           uint      `gorm:"primarykey" json:"id"`
+	CreatedAt    time.Time `json:"created_at"`
+	GiftCardID   uint      `gorm:"not null;index" json:"gift_card_id"`
+	Amount       float64   `gorm:"not null" json:"amount"`
+	BalanceAfter float64   `gorm:"not null" json:"balance_after"`
+	Kind         string    `gorm:"not null" json:"kind"` // "issue", "order_payment", "order_release", "adjustment", "void"
+	OrderID      *uint     `gorm:"index" json:"order_id,omitempty"`
+	Reason       string    `json:"reason,omitempty"`
+	ActorID      *uint     `json:"actor_id,omitempty"`
+}
+
+// StoreCreditEntry is a change to a customer's store credit. Entries are only ever
+// added, and the customer's balance is the BalanceAfter of their latest.
+type StoreCreditEntry struct {
+	ID           uint      `gorm:"primarykey" json:"id"`
+	CreatedAt    time.Time `json:"created_at"`
+	UserID       uint      `gorm:"not null;index" json:"user_id"`
+	Amount       float64   `gorm:"not null" json:"amount"`
+	BalanceAfter float64   `gorm:"not null" json:"balance_after"`
+	Kind         string    `gorm:"not null" json:"kind"` // "refund", "order_payment", "order_release", "adjustment"
+	OrderID      *uint     `gorm:"index" json:"order_id,omitempty"`
+	Reason       string    `json:"reason,omitempty"`
+	ActorID      *uint     `json:"actor_id,omitempty"`
+}
+
 type Review struct {
 	ID           uint           `gorm:"primarykey" json:"id"`
 	CreatedAt    time.Time      `json:"created_at"`
@@ -425,5 +850,5 @@ type ProductInput struct {
 	Sizes       []string `json:"sizes"`
 	Stock       int      `json:"stock"`
 	IsFeatured  bool     `json:"is_featured"`
-	IsActive    bool     `json:"is_active"`
+	Status      string   `json:"status"`
 }
@@ -20,10 +20,15 @@ func SetupRoutes(r *gin.Engine) {
 
 		api.GET("/products", handlers.GetProducts)
 		api.GET("/products/search", handlers.SearchProducts)
+		api.GET("/products/suggest", handlers.SuggestProducts)
 		api.GET("/products/:id", handlers.GetProduct)
+		api.GET("/products/:id/variants", handlers.GetProductVariants)
 		api.GET("/filters", handlers.GetFilterOptions)
 
 		api.GET("/categories", handlers.GetCategories)
+		api.GET("/categories/tree", handlers.GetCategoryTree)
+		api.GET("/categories/:slug", handlers.GetCategory)
+		api.GET("/categories/:slug/breadcrumb", handlers.GetCategoryBreadcrumb)
 
 		api.GET("/catalogues", handlers.GetCatalogues)
 		api.GET("/catalogues/:id", handlers.GetCatalogue)
@@ -32,6 +37,8 @@ func SetupRoutes(r *gin.Engine) {
 
 		api.POST("/newsletter/subscribe", handlers.SubscribeNewsletter)
 
+		api.GET("/notifications/push/vapid-public-key", handlers.GetVAPIDPublicKey)
+
 		api.GET("/shipping/calculate-rates", handlers.CalculateShippingRates)
 		api.GET("/shipping/track/:awb", handlers.TrackShipment)
 
@@ -42,6 +49,8 @@ func SetupRoutes(r *gin.Engine) {
 			auth.POST("/login", handlers.Login)
 			auth.POST("/logout", handlers.Logout)
 			auth.POST("/refresh", handlers.RefreshToken)
+			auth.POST("/forgot-password", handlers.ForgotPassword)
+			auth.POST("/reset-password", handlers.ResetPassword)
 		}
 
 		protected := api.Group("")
@@ -49,10 +58,13 @@ func SetupRoutes(r *gin.Engine) {
 		{
 			protected.GET("/user/me", handlers.GetCurrentUser)
 			protected.PUT("/user/me", handlers.UpdateCurrentUser)
+			protected.GET("/user/sessions", handlers.GetSessions)
+			protected.DELETE("/user/sessions/:id", handlers.RevokeSession)
 
 			protected.GET("/addresses", handlers.GetAddresses)
 			protected.POST("/addresses", handlers.CreateAddress)
 			protected.PUT("/addresses/:id", handlers.UpdateAddress)
+			protected.PATCH("/addresses/:id", handlers.UpdateAddress)
 			protected.DELETE("/addresses/:id", handlers.DeleteAddress)
 
 			protected.GET("/orders", handlers.GetUserOrders)
@@ -60,6 +72,12 @@ func SetupRoutes(r *gin.Engine) {
 			protected.GET("/orders/:id", handlers.GetOrderDetails)
 			protected.POST("/orders/:id/cancel", handlers.CancelOrder)
 			protected.GET("/orders/:id/tracking", handlers.GetOrderTracking)
+			protected.GET("/orders/:id/invoice", handlers.GetOrderInvoice)
+
+			protected.GET("/user/store-credit", handlers.GetStoreCredit)
+			protected.GET("/gift-cards", handlers.GetMyGiftCards)
+			protected.POST("/gift-cards", handlers.PurchaseGiftCard)
+			protected.POST("/gift-cards/check", middleware.AuthRateLimit(), handlers.CheckGiftCard)
 
 			protected.GET("/wishlist", handlers.GetWishlist)
 			protected.POST("/wishlist", handlers.AddToWishlist)
@@ -72,6 +90,13 @@ func SetupRoutes(r *gin.Engine) {
 
 			protected.GET("/notifications/preferences", handlers.GetNotificationPreferences)
 			protected.PUT("/notifications/preferences", handlers.UpdateNotificationPreferences)
+			protected.PATCH("/notifications/preferences", handlers.UpdateNotificationPreferences)
+			protected.POST("/notifications/push/subscribe", handlers.SubscribePush)
+			protected.POST("/notifications/push/unsubscribe", handlers.UnsubscribePush)
+			protected.GET("/notifications/user", handlers.GetUserNotifications)
+			protected.PUT("/notifications/read-all", handlers.MarkAllNotificationsRead)
+			protected.PUT("/notifications/:id/read", handlers.MarkNotificationRead)
+			protected.DELETE("/notifications/:id", handlers.DeleteNotification)
 
 			protected.POST("/payments/create-intent", handlers.CreatePaymentIntent)
 			protected.POST("/payments/verify", handlers.VerifyPayment)
@@ -81,22 +106,48 @@ func SetupRoutes(r *gin.Engine) {
 		admin := api.Group("/admin")
 		admin.Use(middleware.AdminAuth())
 		{
+			admin.GET("/products", handlers.GetAdminProducts)
 			admin.POST("/products", handlers.CreateProduct)
+			admin.POST("/products/import", handlers.ImportProducts)
+			admin.GET("/products/import/:id", handlers.GetImportJob)
+			admin.GET("/products/export", handlers.ExportProducts)
+			admin.GET("/products/:id/preview", handlers.PreviewProduct)
 			admin.PUT("/products/:id", handlers.UpdateProduct)
+			admin.PATCH("/products/:id", handlers.UpdateProduct)
 			admin.DELETE("/products/:id", handlers.DeleteProduct)
-
+			admin.POST("/products/:id/variants", handlers.CreateProductVariant)
+			admin.PUT("/products/:id/variants/:variantId", handlers.UpdateProductVariant)
+			admin.DELETE("/products/:id/variants/:variantId", handlers.DeleteProductVariant)
+			admin.GET("/products/:id/images", handlers.GetProductImages)
+			admin.POST("/products/:id/images", handlers.AddProductImage)
+			admin.PUT("/products/:id/images", handlers.ReorderProductImages)
+			admin.PUT("/products/:id/images/:imageId", handlers.UpdateProductImage)
+			admin.DELETE("/products/:id/images/:imageId", handlers.DeleteProductImage)
+
+			admin.POST("/media", handlers.UploadMedia)
+			admin.GET("/media/orphans", handlers.GetOrphanedMedia)
+			admin.DELETE("/media/orphans", handlers.DeleteOrphanedMedia)
+
+			admin.GET("/categories", handlers.GetAdminCategories)
 			admin.POST("/categories", handlers.CreateCategory)
+			admin.GET("/categories/deleted", handlers.GetDeletedCategories)
 			admin.PUT("/categories/:id", handlers.UpdateCategory)
+			admin.PATCH("/categories/:id", handlers.UpdateCategory)
 			admin.DELETE("/categories/:id", handlers.DeleteCategory)
+			admin.POST("/categories/:id/restore", handlers.RestoreCategory)
 
+			admin.GET("/catalogues", handlers.GetAdminCatalogues)
+			admin.GET("/catalogues/:id", handlers.GetAdminCatalogue)
 			admin.POST("/catalogues", handlers.CreateCatalogue)
 			admin.PUT("/catalogues/:id", handlers.UpdateCatalogue)
+			admin.PATCH("/catalogues/:id", handlers.UpdateCatalogue)
 			admin.DELETE("/catalogues/:id", handlers.DeleteCatalogue)
 			admin.POST("/catalogues/:id/products", handlers.AddProductsToCatalogue)
 			admin.DELETE("/catalogues/:id/products", handlers.RemoveProductsFromCatalogue)
 
 			admin.POST("/content", handlers.CreatePageContent)
 			admin.PUT("/content/:id", handlers.UpdatePageContent)
+			admin.PATCH("/content/:id", handlers.UpdatePageContent)
 
 			admin.GET("/orders", handlers.GetAllOrders)
 			admin.PATCH("/orders/:id/status", handlers.UpdateOrderStatus)
@@ -107,13 +158,41 @@ func SetupRoutes(r *gin.Engine) {
 			admin.POST("/coupons", handlers.CreateCoupon)
 			admin.GET("/coupons", handlers.GetCoupons)
 			admin.PUT("/coupons/:id", handlers.UpdateCoupon)
+			admin.PATCH("/coupons/:id", handlers.UpdateCoupon)
 			admin.DELETE("/coupons/:id", handlers.DeleteCoupon)
+			admin.GET("/coupon-campaigns", handlers.GetCouponCampaigns)
+			admin.POST("/coupon-campaigns", handlers.CreateCouponCampaign)
+			admin.GET("/coupon-campaigns/:id", handlers.GetCouponCampaign)
+			admin.GET("/coupon-campaigns/:id/export", handlers.ExportCouponCampaign)
+
+			admin.GET("/promotions", handlers.GetPromotions)
+			admin.POST("/promotions", handlers.CreatePromotion)
+			admin.PUT("/promotions/:id", handlers.UpdatePromotion)
+			admin.PATCH("/promotions/:id", handlers.UpdatePromotion)
+			admin.DELETE("/promotions/:id", handlers.DeletePromotion)
+
+			admin.GET("/gift-cards", handlers.GetGiftCards)
+			admin.POST("/gift-cards", handlers.IssueGiftCard)
+			admin.GET("/gift-cards/:id", handlers.GetGiftCard)
+			admin.POST("/gift-cards/:id/adjustments", handlers.AdjustGiftCard)
+			admin.PATCH("/gift-cards/:id/status", handlers.UpdateGiftCardStatus)
+			admin.GET("/users/:id/store-credit", handlers.GetUserStoreCredit)
+			admin.POST("/users/:id/store-credit/adjustments", handlers.AdjustStoreCredit)
+
+			admin.GET("/search/top-queries", handlers.GetTopSearches)
+			admin.GET("/search/zero-results", handlers.GetZeroResultSearches)
 
 			admin.GET("/reviews", handlers.GetAllReviews)
 			admin.PATCH("/reviews/:id/approve", handlers.ApproveReview)
+
+			admin.POST("/notifications/push/broadcast", handlers.BroadcastPush)
+			admin.GET("/notifications/emails", handlers.GetEmailLog)
+
+			admin.GET("/audit-log", handlers.GetAuditLog)
 		}
 
-		api.GET("/coupons/validate", handlers.ValidateCoupon)
+		api.GET("/coupons/validate", middleware.AuthRateLimit(), middleware.OptionalAuth(), handlers.ValidateCoupon)
+		api.POST("/coupons/validate", middleware.AuthRateLimit(), middleware.OptionalAuth(), handlers.ValidateCoupon)
 
 		api.GET("/products/:id/reviews", handlers.GetProductReviews)
 	}
@@ -0,0 +1,20 @@
+package services
+
+import (
+	"pashmina-backend/models"
+
+	"gorm.io/gorm"
+)
+
+// RecordAudit completes an audit entry, which carries the actor and request it came
+// from, and writes it. Pass the transaction making the change so the entry is kept
+// exactly when the change is.
+func RecordAudit(tx *gorm.DB, entry *models.AuditLog, action, entityType string, entityID uint, details models.JSONB) error {
+	record := *entry
+	record.ID = 0
+	record.Action = action
+	record.EntityType = entityType
+	record.EntityID = entityID
+	record.Details = details
+	return tx.Create(&record).Error
+}
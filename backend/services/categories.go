@@ -0,0 +1,372 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"pashmina-backend/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrCategoryNotFound is returned when a category does not exist
+	ErrCategoryNotFound = errors.New("category not found")
+	// ErrParentNotFound is returned when a category is placed under one that does not exist
+	ErrParentNotFound = errors.New("parent category not found")
+	// ErrCategoryCycle is returned when a category would be moved under itself
+	ErrCategoryCycle = errors.New("a category cannot be placed under itself or one of its subcategories")
+	// ErrCategoryTooDeep is returned when a move would nest categories deeper than maxCategoryDepth
+	ErrCategoryTooDeep = fmt.Errorf("categories can be nested at most %d levels deep", maxCategoryDepth)
+	// ErrSlugTaken is returned for a slug another category already uses
+	ErrSlugTaken = errors.New("slug is already in use")
+	// ErrCategoryHasProducts is returned when deleting a category that has products
+	// without saying where they should go
+	ErrCategoryHasProducts = errors.New("category has products; choose a category to move them to")
+	// ErrReassignToSelf is returned when products would be moved to the category being deleted
+	ErrReassignToSelf = errors.New("products cannot be moved to the category being deleted")
+	// ErrReassignTargetNotFound is returned when the category products would move to does not exist
+	ErrReassignTargetNotFound = errors.New("category to move products to not found")
+
+	slugSeparators = regexp.MustCompile(`[^a-z0-9]+`)
+)
+
+const maxCategoryDepth = 5
+
+// reservedCategorySlugs would be shadowed by fixed routes under /api/categories
+var reservedCategorySlugs = map[string]bool{"tree": true}
+
+// categorySubtreeSQL selects a category's ID and those of all its descendants.
+// UNION rather than UNION ALL stops at rows already seen, so a cycle can't loop.
+const categorySubtreeSQL = `WITH RECURSIVE subtree AS (
+		SELECT id FROM categories WHERE id = ?
+		UNION
+		SELECT c.id FROM categories c JOIN subtree s ON c.parent_id = s.id WHERE c.deleted_at IS NULL
+	) SELECT id FROM subtree`
+
+// CategoryCrumb is one step of a category's breadcrumb
+type CategoryCrumb struct {
+	ID   uint   `json:"id"`
+	Name string `json:"name"`
+	Slug string `json:"slug"`
+}
+
+// InCategory limits a product query to a category and all of its descendants
+func InCategory(query *gorm.DB, categoryID uint) *gorm.DB {
+	return query.Where("products.category_id IN ("+categorySubtreeSQL+")", categoryID)
+}
+
+// Slugify turns a name into a lowercase, hyphen-separated slug
+func Slugify(name string) string {
+	slug := strings.Trim(slugSeparators.ReplaceAllString(strings.ToLower(name), "-"), "-")
+	if len(slug) > 100 {
+		slug = strings.TrimRight(slug[:100], "-")
+	}
+	return slug
+}
+
+// UniqueCategorySlug makes a slug for a new category from its name. When another
+// category has it, the parent's slug is put in front, then a number after.
+func UniqueCategorySlug(db *gorm.DB, name string, parentID *uint) (string, error) {
+	base := Slugify(name)
+	if base == "" {
+		base = "category"
+	}
+	candidates := []string{base}
+	if parentID != nil {
+		var parent models.Category
+		if err := db.Select("slug").First(&parent, *parentID).Error; err == nil && parent.Slug != "" {
+			candidates = append(candidates, Slugify(parent.Slug+"-"+base))
+		}
+	}
+	for _, slug := range candidates {
+		taken, err := categorySlugTaken(db, slug, 0)
+		if err != nil || !taken {
+			return slug, err
+		}
+	}
+	for n := 2; ; n++ {
+		slug := fmt.Sprintf("%s-%d", base, n)
+		taken, err := categorySlugTaken(db, slug, 0)
+		if err != nil || !taken {
+			return slug, err
+		}
+	}
+}
+
+// CheckCategorySlug returns ErrSlugTaken if a slug chosen by hand is in use.
+// excludeID is the category being updated, whose own slug doesn't count.
+func CheckCategorySlug(db *gorm.DB, slug string, excludeID uint) error {
+	taken, err := categorySlugTaken(db, slug, excludeID)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return ErrSlugTaken
+	}
+	return nil
+}
+
+func categorySlugTaken(db *gorm.DB, slug string, excludeID uint) (bool, error) {
+	if reservedCategorySlugs[slug] {
+		return true, nil
+	}
+	// Deleted categories keep their slug in the unique index
+	var count int64
+	err := db.Unscoped().Model(&models.Category{}).Where("slug = ? AND id <> ?", slug, excludeID).Count(&count).Error
+	return count > 0, err
+}
+
+// ValidateCategoryParent checks that categoryID (0 for a new category) can be placed
+// under parentID without making a cycle or nesting too deep
+func ValidateCategoryParent(db *gorm.DB, categoryID, parentID uint) error {
+	var parent models.Category
+	if err := db.Select("id").First(&parent, parentID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrParentNotFound
+		}
+		return err
+	}
+
+	height := 1
+	if categoryID != 0 {
+		var subtree []uint
+		if err := db.Raw(categorySubtreeSQL, categoryID).Scan(&subtree).Error; err != nil {
+			return err
+		}
+		for _, id := range subtree {
+			if id == parentID {
+				return ErrCategoryCycle
+			}
+		}
+		if err := db.Raw(`WITH RECURSIVE subtree AS (
+				SELECT id, 1 AS depth FROM categories WHERE id = ?
+				UNION ALL
+				SELECT c.id, s.depth + 1 FROM categories c JOIN subtree s ON c.parent_id = s.id
+				WHERE c.deleted_at IS NULL AND s.depth < ?
+			) SELECT COALESCE(MAX(depth), 1) FROM subtree`, categoryID, maxCategoryDepth+1).Scan(&height).Error; err != nil {
+			return err
+		}
+	}
+
+	ancestors, err := CategoryBreadcrumb(db, parentID)
+	if err != nil {
+		return err
+	}
+	if len(ancestors)+height > maxCategoryDepth {
+		return ErrCategoryTooDeep
+	}
+	return nil
+}
+
+// CategoryBreadcrumb returns the path from the top-level category down to the
+// category itself
+func CategoryBreadcrumb(db *gorm.DB, categoryID uint) ([]CategoryCrumb, error) {
+	crumbs := []CategoryCrumb{}
+	err := db.Raw(`WITH RECURSIVE ancestors AS (
+			SELECT id, name, slug, parent_id, 0 AS depth FROM categories WHERE id = ? AND deleted_at IS NULL
+			UNION ALL
+			SELECT c.id, c.name, c.slug, c.parent_id, a.depth + 1 FROM categories c
+			JOIN ancestors a ON c.id = a.parent_id
+			WHERE c.deleted_at IS NULL AND a.depth < 32
+		) SELECT id, name, slug FROM ancestors ORDER BY depth DESC`, categoryID).Scan(&crumbs).Error
+	return crumbs, err
+}
+
+// CategoryTree returns the top-level categories with their subcategories nested
+// under them, each level in sort order. With activeOnly, inactive categories and
+// everything under them are left out.
+func CategoryTree(db *gorm.DB, activeOnly bool) ([]models.Category, error) {
+	query := db.Order("sort_order ASC, name ASC")
+	if activeOnly {
+		query = query.Where("is_active = ?", true)
+	}
+	var categories []models.Category
+	if err := query.Find(&categories).Error; err != nil {
+		return nil, err
+	}
+	return buildCategoryTree(categories), nil
+}
+
+// buildCategoryTree nests categories under their parents. Categories whose parent
+// isn't in the list are unreachable and left out.
+func buildCategoryTree(categories []models.Category) []models.Category {
+	byParent := map[uint][]models.Category{}
+	for _, c := range categories {
+		parent := uint(0)
+		if c.ParentID != nil {
+			parent = *c.ParentID
+		}
+		byParent[parent] = append(byParent[parent], c)
+	}
+
+	var build func(parent uint, depth int) []models.Category
+	build = func(parent uint, depth int) []models.Category {
+		nodes := make([]models.Category, 0, len(byParent[parent]))
+		if depth > maxCategoryDepth {
+			return nodes
+		}
+		for _, node := range byParent[parent] {
+			node.Children = build(node.ID, depth+1)
+			nodes = append(nodes, node)
+		}
+		return nodes
+	}
+	return build(0, 0)
+}
+
+// CategoryBySlug returns a category the storefront shows with its active
+// subcategories. Categories under an inactive parent are not found.
+func CategoryBySlug(db *gorm.DB, slug string) (*models.Category, error) {
+	var category models.Category
+	err := VisibleCategories(db.Preload("Children", func(db *gorm.DB) *gorm.DB {
+		return db.Where("is_active = ?", true).Order("sort_order ASC, name ASC")
+	})).Where("slug = ?", slug).First(&category).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrCategoryNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &category, nil
+}
+
+// CategoryDeletion is the outcome of deleting a category
+type CategoryDeletion struct {
+	// Products counts the category's products, deleted ones included: moved when a
+	// target was given, otherwise the ones that stopped the deletion
+	Products     int64 `json:"products"`
+	ReassignedTo *uint `json:"reassigned_to,omitempty"`
+	// Subcategories counts the children moved up to the deleted category's parent
+	Subcategories int64 `json:"subcategories"`
+}
+
+// DeleteCategory soft-deletes a category in one transaction. Its products, deleted
+// ones included, are moved to reassignTo; without a target, a category that still
+// has any is refused with ErrCategoryHasProducts. Subcategories move up to the
+// deleted category's parent. audit carries the actor of the audit entry written
+// with the change.
+func DeleteCategory(db *gorm.DB, id uint, reassignTo *uint, audit *models.AuditLog) (*CategoryDeletion, error) {
+	result := &CategoryDeletion{ReassignedTo: reassignTo}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var category models.Category
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&category, id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrCategoryNotFound
+			}
+			return err
+		}
+
+		if err := categoryProducts(tx, id).Count(&result.Products).Error; err != nil {
+			return err
+		}
+		if err := checkCategoryDeletion(id, result.Products, reassignTo); err != nil {
+			return err
+		}
+
+		if reassignTo != nil {
+			var target models.Category
+			if err := tx.Clauses(clause.Locking{Strength: "SHARE"}).Select("id").First(&target, *reassignTo).Error; err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					return ErrReassignTargetNotFound
+				}
+				return err
+			}
+			if err := categoryProducts(tx, id).Update("category_id", *reassignTo).Error; err != nil {
+				return err
+			}
+		}
+
+		moved := tx.Unscoped().Model(&models.Category{}).Where("parent_id = ?", id).Update("parent_id", category.ParentID)
+		if moved.Error != nil {
+			return moved.Error
+		}
+		result.Subcategories = moved.RowsAffected
+
+		if err := tx.Delete(&category).Error; err != nil {
+			return err
+		}
+		details := models.JSONB{
+			"name":          category.Name,
+			"slug":          category.Slug,
+			"products":      result.Products,
+			"subcategories": result.Subcategories,
+		}
+		if reassignTo != nil {
+			details["reassigned_to"] = *reassignTo
+		}
+		return RecordAudit(tx, audit, "category.delete", "category", id, details)
+	})
+	return result, err
+}
+
+// categoryProducts scopes a query to a category's products, deleted ones included,
+// so restoring a product never brings back a reference to a deleted category
+func categoryProducts(tx *gorm.DB, id uint) *gorm.DB {
+	return tx.Unscoped().Model(&models.Product{}).Where("category_id = ?", id)
+}
+
+// checkCategoryDeletion reports why a category with this many products can't be
+// deleted, if it can't
+func checkCategoryDeletion(id uint, products int64, reassignTo *uint) error {
+	if reassignTo == nil {
+		if products > 0 {
+			return ErrCategoryHasProducts
+		}
+		return nil
+	}
+	if *reassignTo == id {
+		return ErrReassignToSelf
+	}
+	return nil
+}
+
+// RestoreCategory brings back a soft-deleted category. Products and subcategories
+// moved away when it was deleted stay where they are. If its parent is gone too, it
+// comes back as a top-level category.
+func RestoreCategory(db *gorm.DB, id uint, audit *models.AuditLog) (*models.Category, error) {
+	var category models.Category
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("deleted_at IS NOT NULL").First(&category, id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrCategoryNotFound
+			}
+			return err
+		}
+
+		var parents int64
+		if category.ParentID != nil {
+			if err := tx.Model(&models.Category{}).Where("id = ?", *category.ParentID).Count(&parents).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Unscoped().Model(&category).Updates(restoreUpdates(&category, parents > 0)).Error; err != nil {
+			return err
+		}
+		if err := tx.First(&category, id).Error; err != nil {
+			return err
+		}
+		return RecordAudit(tx, audit, "category.restore", "category", id, models.JSONB{
+			"name": category.Name,
+			"slug": category.Slug,
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &category, nil
+}
+
+// restoreUpdates returns the changes that bring back a deleted category, making it
+// top-level when its parent is gone
+func restoreUpdates(category *models.Category, parentExists bool) map[string]interface{} {
+	updates := map[string]interface{}{"deleted_at": nil}
+	if category.ParentID != nil && !parentExists {
+		updates["parent_id"] = nil
+	}
+	return updates
+}
@@ -0,0 +1,116 @@
+package services
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"pashmina-backend/models"
+
+	"gorm.io/gorm"
+)
+
+func TestSlugify(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"Embroidered", "embroidered"},
+		{"Sozni & Tilla", "sozni-tilla"},
+		{"  Kani -- Weave! ", "kani-weave"},
+		{"Shawls 2024", "shawls-2024"},
+		{"***", ""},
+		{strings.Repeat("abc ", 30), strings.TrimRight(strings.Repeat("abc-", 25), "-")},
+	}
+	for _, tt := range tests {
+		if got := Slugify(tt.in); got != tt.want {
+			t.Errorf("Slugify(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestBuildCategoryTree(t *testing.T) {
+	id := func(n uint) *uint { return &n }
+	categories := []models.Category{
+		{ID: 1, Name: "Embroidered"},
+		{ID: 2, Name: "Sozni", ParentID: id(1)},
+		{ID: 3, Name: "Tilla", ParentID: id(2)},
+		{ID: 4, Name: "Classic"},
+		{ID: 5, Name: "Aari", ParentID: id(1)},
+		{ID: 6, Name: "Orphan", ParentID: id(99)},
+	}
+
+	tree := buildCategoryTree(categories)
+	if len(tree) != 2 || tree[0].Name != "Embroidered" || tree[1].Name != "Classic" {
+		t.Fatalf("roots = %+v, want Embroidered and Classic in order", tree)
+	}
+	embroidered := tree[0]
+	if len(embroidered.Children) != 2 || embroidered.Children[0].Name != "Sozni" || embroidered.Children[1].Name != "Aari" {
+		t.Fatalf("Embroidered children = %+v", embroidered.Children)
+	}
+	if sozni := embroidered.Children[0]; len(sozni.Children) != 1 || sozni.Children[0].Name != "Tilla" {
+		t.Errorf("Sozni children = %+v, want Tilla", sozni.Children)
+	}
+	if len(tree[1].Children) != 0 {
+		t.Errorf("Classic children = %+v, want none", tree[1].Children)
+	}
+}
+
+func TestCheckCategoryDeletion(t *testing.T) {
+	id := func(n uint) *uint { return &n }
+	tests := []struct {
+		name       string
+		products   int64
+		reassignTo *uint
+		want       error
+	}{
+		{"empty category", 0, nil, nil},
+		{"products and no target", 3, nil, ErrCategoryHasProducts},
+		{"products moved to another category", 3, id(8), nil},
+		{"empty category with a target", 0, id(8), nil},
+		{"moved to itself", 3, id(5), ErrReassignToSelf},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkCategoryDeletion(5, tt.products, tt.reassignTo); err != tt.want {
+				t.Errorf("checkCategoryDeletion() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCategoryProductsIncludesDeleted(t *testing.T) {
+	db := dryRunDB(t)
+
+	count := db.ToSQL(func(tx *gorm.DB) *gorm.DB {
+		var n int64
+		return categoryProducts(tx, 5).Count(&n)
+	})
+	move := db.ToSQL(func(tx *gorm.DB) *gorm.DB {
+		return categoryProducts(tx, 5).Update("category_id", 8)
+	})
+	for _, sql := range []string{count, move} {
+		if !strings.Contains(sql, "category_id = 5") || strings.Contains(sql, "deleted_at") {
+			t.Errorf("%s: want every product of category 5, deleted ones included", sql)
+		}
+	}
+}
+
+func TestRestoreUpdates(t *testing.T) {
+	id := func(n uint) *uint { return &n }
+	tests := []struct {
+		name         string
+		parentID     *uint
+		parentExists bool
+		want         map[string]interface{}
+	}{
+		{"top-level category", nil, false, map[string]interface{}{"deleted_at": nil}},
+		{"parent still there", id(1), true, map[string]interface{}{"deleted_at": nil}},
+		{"parent deleted too", id(1), false, map[string]interface{}{"deleted_at": nil, "parent_id": nil}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := restoreUpdates(&models.Category{ID: 2, ParentID: tt.parentID}, tt.parentExists)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("restoreUpdates() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,286 @@
+package services
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"pashmina-backend/models"
+	"pashmina-backend/utils"
+
+	"gorm.io/gorm"
+)
+
+// MaxCampaignCodes is the most codes a single campaign can generate
+const MaxCampaignCodes = 10000
+
+const (
+	// codePlaceholder marks a random character in a code template
+	codePlaceholder = "#"
+	// defaultCodeSuffix is added to a template that has no placeholders
+	defaultCodeSuffix = "########"
+	// codeAlphabet leaves out characters that are easily misread, like 0/O and 1/I/L
+	codeAlphabet = "23456789ABCDEFGHJKMNPQRSTUVWXYZ"
+	// codeSpaceFactor is how many times more codes a template must be able to make
+	// than a campaign asks for, so random codes rarely collide
+	codeSpaceFactor = 100
+	// maxCodeRounds bounds how often codes that collided are generated again
+	maxCodeRounds = 10
+)
+
+var (
+	ErrInvalidCodeTemplate  = errors.New("template may only contain letters, numbers and # for each random character")
+	ErrCampaignCodeCount    = fmt.Errorf("a campaign can have between 1 and %d codes", MaxCampaignCodes)
+	ErrCodeTemplateTooSmall = errors.New("template doesn't have enough # characters for that many different codes")
+
+	codeTemplatePattern = regexp.MustCompile(`^[A-Z0-9#]+$`)
+)
+
+// CouponCampaignColumns are the columns of a campaign's code export
+var CouponCampaignColumns = []string{"code", "status", "used_count", "usage_limit", "valid_from", "valid_until"}
+
+// CampaignStats sums up how a campaign's codes have been used
+type CampaignStats struct {
+	Codes         int64   `json:"codes"`
+	UsedCodes     int64   `json:"used_codes"`
+	Redemptions   int64   `json:"redemptions"`
+	DiscountTotal float64 `json:"discount_total"`
+}
+
+// NormalizeCodeTemplate puts a code template in stored form and checks it makes
+// valid codes. A template without placeholders gets random characters appended.
+func NormalizeCodeTemplate(template string) (string, error) {
+	template = utils.NormalizeCouponCode(template)
+	if !codeTemplatePattern.MatchString(template) {
+		return "", ErrInvalidCodeTemplate
+	}
+	if !strings.Contains(template, codePlaceholder) {
+		template += defaultCodeSuffix
+	}
+	if err := utils.ValidateCouponCode(strings.ReplaceAll(template, codePlaceholder, "A")); err != nil {
+		return "", err
+	}
+	return template, nil
+}
+
+// GenerateCouponCodes makes count different codes from a template, filling each #
+// with a random character. taken reports which of a batch of codes already exist,
+// and those are generated again.
+func GenerateCouponCodes(template string, count int, taken func(codes []string) (map[string]bool, error)) ([]string, error) {
+	if count < 1 || count > MaxCampaignCodes {
+		return nil, ErrCampaignCodeCount
+	}
+	space := math.Pow(float64(len(codeAlphabet)), float64(strings.Count(template, codePlaceholder)))
+	if space < float64(count)*codeSpaceFactor {
+		return nil, ErrCodeTemplateTooSmall
+	}
+
+	codes := make([]string, 0, count)
+	seen := map[string]bool{}
+	for round := 0; len(codes) < count; round++ {
+		if round == maxCodeRounds {
+			return nil, errors.New("could not generate enough unused codes")
+		}
+		batch := make([]string, 0, count-len(codes))
+		for len(batch) < cap(batch) {
+			code, err := fillCodeTemplate(template)
+			if err != nil {
+				return nil, err
+			}
+			if !seen[code] {
+				seen[code] = true
+				batch = append(batch, code)
+			}
+		}
+		existing, err := taken(batch)
+		if err != nil {
+			return nil, err
+		}
+		for _, code := range batch {
+			if !existing[code] {
+				codes = append(codes, code)
+			}
+		}
+	}
+	return codes, nil
+}
+
+func fillCodeTemplate(template string) (string, error) {
+	var b strings.Builder
+	size := big.NewInt(int64(len(codeAlphabet)))
+	for _, r := range template {
+		if string(r) != codePlaceholder {
+			b.WriteRune(r)
+			continue
+		}
+		n, err := rand.Int(rand.Reader, size)
+		if err != nil {
+			return "", err
+		}
+		b.WriteByte(codeAlphabet[n.Int64()])
+	}
+	return b.String(), nil
+}
+
+// existingCouponCodes returns which of codes are already used by a coupon
+func existingCouponCodes(db *gorm.DB, codes []string) (map[string]bool, error) {
+	existing := map[string]bool{}
+	for start := 0; start < len(codes); start += 1000 {
+		end := start + 1000
+		if end > len(codes) {
+			end = len(codes)
+		}
+		var found []string
+		if err := db.Model(&models.Coupon{}).Where("code IN ?", codes[start:end]).Pluck("code", &found).Error; err != nil {
+			return nil, err
+		}
+		for _, code := range found {
+			existing[code] = true
+		}
+	}
+	return existing, nil
+}
+
+// CreateCouponCampaign generates count single-use codes from the campaign's
+// template, each with the discount and rules of terms, and saves them with the
+// campaign in one transaction
+func CreateCouponCampaign(db *gorm.DB, campaign *models.CouponCampaign, terms models.Coupon, count int, audit *models.AuditLog) error {
+	template, err := NormalizeCodeTemplate(campaign.Template)
+	if err != nil {
+		return err
+	}
+	campaign.Template = template
+
+	codes, err := GenerateCouponCodes(template, count, func(batch []string) (map[string]bool, error) {
+		return existingCouponCodes(db, batch)
+	})
+	if err != nil {
+		return err
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		campaign.CodeCount = len(codes)
+		if err := tx.Create(campaign).Error; err != nil {
+			return err
+		}
+
+		coupons := make([]models.Coupon, len(codes))
+		for i, code := range codes {
+			coupon := terms
+			coupon.ID = 0
+			coupon.Code = code
+			coupon.CampaignID = &campaign.ID
+			coupon.UsageLimit = 1
+			coupon.UsedCount = 0
+			coupons[i] = coupon
+		}
+		if err := tx.CreateInBatches(coupons, 500).Error; err != nil {
+			return err
+		}
+		// is_active defaults to true, so a false value isn't written on create
+		if !terms.IsActive {
+			if err := tx.Model(&models.Coupon{}).Where("campaign_id = ?", campaign.ID).Update("is_active", false).Error; err != nil {
+				return err
+			}
+		}
+
+		return RecordAudit(tx, audit, "coupon_campaign.create", "coupon_campaign", campaign.ID, models.JSONB{
+			"name":     campaign.Name,
+			"template": campaign.Template,
+			"codes":    len(codes),
+		})
+	})
+}
+
+// CampaignStatistics sums up the usage of each campaign's codes
+func CampaignStatistics(db *gorm.DB, campaignIDs []uint) (map[uint]CampaignStats, error) {
+	stats := make(map[uint]CampaignStats, len(campaignIDs))
+	if len(campaignIDs) == 0 {
+		return stats, nil
+	}
+
+	var codes []struct {
+		CampaignID uint
+		Codes      int64
+		UsedCodes  int64
+	}
+	if err := db.Model(&models.Coupon{}).
+		Select("campaign_id, COUNT(*) AS codes, COUNT(*) FILTER (WHERE used_count > 0) AS used_codes").
+		Where("campaign_id IN ?", campaignIDs).
+		Group("campaign_id").Scan(&codes).Error; err != nil {
+		return nil, err
+	}
+
+	var redemptions []struct {
+		CampaignID    uint
+		Redemptions   int64
+		DiscountTotal float64
+	}
+	if err := db.Model(&models.CouponRedemption{}).
+		Select("coupons.campaign_id, COUNT(*) AS redemptions, COALESCE(SUM(coupon_redemptions.discount_amount), 0) AS discount_total").
+		Joins("JOIN coupons ON coupons.id = coupon_redemptions.coupon_id").
+		Where("coupons.campaign_id IN ? AND coupon_redemptions.status = ?", campaignIDs, RedemptionRedeemed).
+		Group("coupons.campaign_id").Scan(&redemptions).Error; err != nil {
+		return nil, err
+	}
+
+	for _, row := range codes {
+		s := stats[row.CampaignID]
+		s.Codes, s.UsedCodes = row.Codes, row.UsedCodes
+		stats[row.CampaignID] = s
+	}
+	for _, row := range redemptions {
+		s := stats[row.CampaignID]
+		s.Redemptions, s.DiscountTotal = row.Redemptions, RoundMoney(row.DiscountTotal)
+		stats[row.CampaignID] = s
+	}
+	return stats, nil
+}
+
+// ExportCampaignCodes writes a campaign's codes as CSV, one row per code
+func ExportCampaignCodes(db *gorm.DB, w io.Writer, campaignID uint) error {
+	var coupons []models.Coupon
+	if err := db.Where("campaign_id = ?", campaignID).Order("id ASC").Find(&coupons).Error; err != nil {
+		return err
+	}
+
+	now := time.Now()
+	rows := make([][]string, 0, len(coupons)+1)
+	rows = append(rows, CouponCampaignColumns)
+	for _, coupon := range coupons {
+		rows = append(rows, []string{
+			coupon.Code, couponStatus(&coupon, now), strconv.Itoa(coupon.UsedCount), strconv.Itoa(coupon.UsageLimit),
+			formatCellTime(coupon.ValidFrom), formatCellTime(coupon.ValidUntil),
+		})
+	}
+	return WriteSheet(w, SheetCSV, rows)
+}
+
+// couponStatus says whether a coupon can still be used, and if not, why
+func couponStatus(coupon *models.Coupon, now time.Time) string {
+	switch {
+	case coupon.UsageLimit > 0 && coupon.UsedCount >= coupon.UsageLimit:
+		return "used"
+	case !coupon.IsActive:
+		return "inactive"
+	case coupon.ValidUntil.Before(now):
+		return "expired"
+	case coupon.ValidFrom.After(now):
+		return "scheduled"
+	}
+	return "available"
+}
+
+func formatCellTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,156 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"pashmina-backend/models"
+)
+
+func TestNormalizeCodeTemplate(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		want     string
+		wantErr  bool
+	}{
+		{"placeholders kept", "DIWALI####", "DIWALI####", false},
+		{"punctuation", "DIWALI-####", "", true},
+		{"uppercased and trimmed", " diwali#### ", "DIWALI####", false},
+		{"suffix added", "SUMMER", "SUMMER########", false},
+		{"only placeholders", "######", "######", false},
+		{"invalid characters", "SALE_##", "", true},
+		{"empty", "  ", "", true},
+		{"too long", strings.Repeat("A", 45) + "######", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NormalizeCodeTemplate(tt.template)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NormalizeCodeTemplate(%q) error = %v, wantErr %v", tt.template, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("NormalizeCodeTemplate(%q) = %q, want %q", tt.template, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenerateCouponCodes(t *testing.T) {
+	none := func([]string) (map[string]bool, error) { return nil, nil }
+
+	t.Run("unique codes from template", func(t *testing.T) {
+		codes, err := GenerateCouponCodes("GIFT####", 200, none)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(codes) != 200 {
+			t.Fatalf("got %d codes, want 200", len(codes))
+		}
+		seen := map[string]bool{}
+		for _, code := range codes {
+			if seen[code] {
+				t.Errorf("duplicate code %q", code)
+			}
+			seen[code] = true
+			if !strings.HasPrefix(code, "GIFT") || len(code) != 8 {
+				t.Errorf("code %q doesn't match template", code)
+			}
+			for _, r := range code[4:] {
+				if !strings.ContainsRune(codeAlphabet, r) {
+					t.Errorf("code %q has character %q outside the alphabet", code, r)
+				}
+			}
+		}
+	})
+
+	t.Run("taken codes replaced", func(t *testing.T) {
+		var rounds int
+		taken := map[string]bool{}
+		codes, err := GenerateCouponCodes("X######", 50, func(batch []string) (map[string]bool, error) {
+			rounds++
+			existing := map[string]bool{}
+			if rounds == 1 {
+				// Pretend the first half of the first batch already exists
+				for _, code := range batch[:len(batch)/2] {
+					existing[code], taken[code] = true, true
+				}
+			}
+			return existing, nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(codes) != 50 || rounds != 2 {
+			t.Fatalf("got %d codes in %d rounds, want 50 in 2", len(codes), rounds)
+		}
+		for _, code := range codes {
+			if taken[code] {
+				t.Errorf("taken code %q was returned", code)
+			}
+		}
+	})
+
+	t.Run("lookup error", func(t *testing.T) {
+		lookup := errors.New("connection refused")
+		_, err := GenerateCouponCodes("X######", 5, func([]string) (map[string]bool, error) { return nil, lookup })
+		if !errors.Is(err, lookup) {
+			t.Errorf("error = %v, want %v", err, lookup)
+		}
+	})
+
+	limits := []struct {
+		name     string
+		template string
+		count    int
+		want     error
+	}{
+		{"no codes", "X######", 0, ErrCampaignCodeCount},
+		{"too many codes", "X######", MaxCampaignCodes + 1, ErrCampaignCodeCount},
+		{"template too small", "X##", 100, ErrCodeTemplateTooSmall},
+	}
+	for _, tt := range limits {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := GenerateCouponCodes(tt.template, tt.count, none); err != tt.want {
+				t.Errorf("error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCouponStatus(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	coupon := func(change func(*models.Coupon)) *models.Coupon {
+		c := &models.Coupon{
+			IsActive:   true,
+			UsageLimit: 1,
+			ValidFrom:  now.Add(-time.Hour),
+			ValidUntil: now.Add(time.Hour),
+		}
+		change(c)
+		return c
+	}
+
+	tests := []struct {
+		name   string
+		coupon *models.Coupon
+		want   string
+	}{
+		{"available", coupon(func(c *models.Coupon) {}), "available"},
+		{"used", coupon(func(c *models.Coupon) { c.UsedCount = 1 }), "used"},
+		{"inactive", coupon(func(c *models.Coupon) { c.IsActive = false }), "inactive"},
+		{"expired", coupon(func(c *models.Coupon) { c.ValidUntil = now.Add(-time.Minute) }), "expired"},
+		{"no end date", coupon(func(c *models.Coupon) { c.ValidUntil = time.Time{} }), "expired"},
+		{"scheduled", coupon(func(c *models.Coupon) { c.ValidFrom = now.Add(time.Minute) }), "scheduled"},
+		{"used beats inactive", coupon(func(c *models.Coupon) { c.UsedCount, c.IsActive = 1, false }), "used"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := couponStatus(tt.coupon, now); got != tt.want {
+				t.Errorf("couponStatus = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,374 @@
+package services
+
+import (
+	"errors"
+	"sort"
+	"strings"
+
+	"pashmina-backend/models"
+
+	"gorm.io/gorm"
+)
+
+// Coupon discount types
+const (
+	DiscountPercentage   = "percentage"
+	DiscountFixed        = "fixed"
+	DiscountBuyXGetY     = "buy_x_get_y"
+	DiscountFreeShipping = "free_shipping"
+)
+
+// Customer segments a coupon can be limited to
+const (
+	SegmentNewCustomer       = "new_customer"
+	SegmentReturningCustomer = "returning_customer"
+	SegmentVIP               = "vip"
+)
+
+var customerSegmentNames = map[string]bool{
+	SegmentNewCustomer:       true,
+	SegmentReturningCustomer: true,
+	SegmentVIP:               true,
+}
+
+var (
+	ErrCouponCountry  = errors.New("coupon is not valid for this shipping country")
+	ErrCouponCustomer = errors.New("coupon is not available to this customer")
+	ErrCouponNoItems  = errors.New("coupon does not apply to any items in this order")
+	ErrCouponQuantity = errors.New("order does not have enough qualifying items for this coupon")
+)
+
+// couponErrors are the reasons a coupon can't be used, as opposed to failures
+var couponErrors = []error{
+	ErrCouponNotFound, ErrCouponExpired, ErrCouponMinimum, ErrCouponUserLimit, ErrCouponFirstOrder,
+	ErrCouponCountry, ErrCouponCustomer, ErrCouponNoItems, ErrCouponQuantity, ErrCouponPromotion,
+}
+
+// IsCouponError reports whether err says a coupon can't be used on an order
+func IsCouponError(err error) bool {
+	for _, couponErr := range couponErrors {
+		if errors.Is(err, couponErr) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsCustomerSegment reports whether a coupon can be limited to the named segment
+func IsCustomerSegment(name string) bool {
+	return customerSegmentNames[name]
+}
+
+// CouponCart is what a coupon's rules are checked against. A zero Subtotal or an
+// empty Country means it isn't known yet, and rules about it are skipped. Customer
+// rules are never met without a UserID.
+type CouponCart struct {
+	Lines    []PricedLine
+	Subtotal float64
+	Country  string
+	UserID   uint
+	// Segments are the customer's segments, worked out when a coupon needs them
+	Segments []string
+}
+
+// AppliedCoupon is what a coupon takes off an order
+type AppliedCoupon struct {
+	Discount float64
+	// LineDiscounts is each cart line's share of Discount, in cart order
+	LineDiscounts []float64
+	FreeShipping  bool
+}
+
+// CouponNeedsCart reports whether a coupon's discount depends on what is in the
+// cart, so it can't be quoted from an order amount alone
+func CouponNeedsCart(coupon *models.Coupon) bool {
+	return coupon.DiscountType == DiscountBuyXGetY || coupon.MinQuantity > 0 ||
+		len(coupon.IncludedCategories) > 0 || len(coupon.ExcludedCategories) > 0 ||
+		len(coupon.IncludedProducts) > 0 || len(coupon.ExcludedProducts) > 0
+}
+
+// CheckCouponTerms checks the rules that don't depend on the cart's lines: the
+// coupon's validity, minimum order amount, shipping country and customers
+func CheckCouponTerms(db *gorm.DB, coupon *models.Coupon, cart *CouponCart) error {
+	if len(coupon.CustomerSegments) > 0 && cart.UserID != 0 && cart.Segments == nil {
+		segments, err := CustomerSegments(db, cart.UserID)
+		if err != nil {
+			return err
+		}
+		cart.Segments = segments
+	}
+	return checkCouponTerms(coupon, *cart)
+}
+
+// ApplyCoupon checks all of a coupon's rules against a cart and works out its
+// discount, spread across the lines it covers
+func ApplyCoupon(db *gorm.DB, coupon *models.Coupon, cart CouponCart) (*AppliedCoupon, error) {
+	if err := CheckCouponTerms(db, coupon, &cart); err != nil {
+		return nil, err
+	}
+	scope, err := loadCouponScope(db, coupon)
+	if err != nil {
+		return nil, err
+	}
+	return discountLines(coupon, cart.Lines, scope)
+}
+
+func checkCouponTerms(coupon *models.Coupon, cart CouponCart) error {
+	if !coupon.IsValid() {
+		return ErrCouponExpired
+	}
+	if coupon.MinOrderAmount > 0 && cart.Subtotal > 0 && cart.Subtotal < coupon.MinOrderAmount {
+		return ErrCouponMinimum
+	}
+	if !countryAllowed(coupon.ApplicableCountries, cart.Country) {
+		return ErrCouponCountry
+	}
+	if !customerAllowed(coupon, cart.UserID, cart.Segments) {
+		return ErrCouponCustomer
+	}
+	return nil
+}
+
+func countryAllowed(countries []string, country string) bool {
+	country = strings.TrimSpace(country)
+	if len(countries) == 0 || country == "" {
+		return true
+	}
+	for _, allowed := range countries {
+		if strings.EqualFold(strings.TrimSpace(allowed), country) {
+			return true
+		}
+	}
+	return false
+}
+
+// customerAllowed lets a customer in if either the allow-list or a segment names them
+func customerAllowed(coupon *models.Coupon, userID uint, segments []string) bool {
+	if len(coupon.AllowedCustomers) == 0 && len(coupon.CustomerSegments) == 0 {
+		return true
+	}
+	if userID == 0 {
+		return false
+	}
+	for _, id := range coupon.AllowedCustomers {
+		if id == userID {
+			return true
+		}
+	}
+	for _, want := range coupon.CustomerSegments {
+		for _, segment := range segments {
+			if want == segment {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// productScope is a coupon's or promotion's product and category rules, with each
+// category expanded to take in its subcategories
+type productScope struct {
+	includeProducts, excludeProducts     map[uint]bool
+	includeCategories, excludeCategories map[uint]bool
+}
+
+func loadCouponScope(db *gorm.DB, coupon *models.Coupon) (productScope, error) {
+	return loadProductScope(db, coupon.IncludedCategories, coupon.ExcludedCategories,
+		coupon.IncludedProducts, coupon.ExcludedProducts)
+}
+
+func loadProductScope(db *gorm.DB, includedCategories, excludedCategories, includedProducts, excludedProducts []uint) (productScope, error) {
+	scope := productScope{
+		includeProducts: idSet(includedProducts),
+		excludeProducts: idSet(excludedProducts),
+	}
+	var err error
+	if scope.includeCategories, err = categorySubtrees(db, includedCategories); err != nil {
+		return scope, err
+	}
+	if scope.excludeCategories, err = categorySubtrees(db, excludedCategories); err != nil {
+		return scope, err
+	}
+	return scope, nil
+}
+
+// covers reports whether the rules take in a line. Exclusions win, and rules that
+// include nothing in particular cover everything else.
+func (s productScope) covers(line PricedLine) bool {
+	var categoryID uint
+	if line.Product != nil {
+		categoryID = line.Product.CategoryID
+	}
+	if s.excludeProducts[line.ProductID] || s.excludeCategories[categoryID] {
+		return false
+	}
+	if len(s.includeProducts) == 0 && len(s.includeCategories) == 0 {
+		return true
+	}
+	return s.includeProducts[line.ProductID] || s.includeCategories[categoryID]
+}
+
+func idSet(ids []uint) map[uint]bool {
+	set := make(map[uint]bool, len(ids))
+	for _, id := range ids {
+		set[id] = true
+	}
+	return set
+}
+
+// categorySubtrees returns the given categories and all of their descendants
+func categorySubtrees(db *gorm.DB, categoryIDs []uint) (map[uint]bool, error) {
+	set := map[uint]bool{}
+	for _, categoryID := range categoryIDs {
+		var ids []uint
+		if err := db.Raw(categorySubtreeSQL, categoryID).Scan(&ids).Error; err != nil {
+			return nil, err
+		}
+		for _, id := range ids {
+			set[id] = true
+		}
+	}
+	return set, nil
+}
+
+// discountLines works out a coupon's discount on the lines it covers
+func discountLines(coupon *models.Coupon, lines []PricedLine, scope productScope) (*AppliedCoupon, error) {
+	covered := make([]bool, len(lines))
+	weights := make([]float64, len(lines))
+	quantity, eligible := 0, 0.0
+	for i, line := range lines {
+		if scope.covers(line) {
+			covered[i] = true
+			weights[i] = line.LineTotal
+			quantity += line.Quantity
+			eligible += line.LineTotal
+		}
+	}
+	if quantity == 0 {
+		return nil, ErrCouponNoItems
+	}
+	if quantity < coupon.MinQuantity {
+		return nil, ErrCouponQuantity
+	}
+
+	applied := &AppliedCoupon{LineDiscounts: make([]float64, len(lines))}
+	var discount float64
+	switch coupon.DiscountType {
+	case DiscountFreeShipping:
+		applied.FreeShipping = true
+		return applied, nil
+	case DiscountFixed:
+		discount = coupon.DiscountValue
+	case DiscountBuyXGetY:
+		weights = buyXGetYDiscounts(coupon, lines, covered)
+		for _, w := range weights {
+			discount += w
+		}
+		if discount == 0 {
+			return nil, ErrCouponQuantity
+		}
+	default:
+		discount = eligible * coupon.DiscountValue / 100
+	}
+
+	if coupon.DiscountType != DiscountFixed && coupon.MaxDiscountAmount > 0 && discount > coupon.MaxDiscountAmount {
+		discount = coupon.MaxDiscountAmount
+	}
+	if discount > eligible {
+		discount = eligible
+	}
+	applied.Discount = RoundMoney(discount)
+	applied.LineDiscounts = allocateDiscount(applied.Discount, weights)
+	return applied, nil
+}
+
+// buyXGetYDiscounts takes DiscountValue percent off the cheapest GetQuantity items
+// of every BuyQuantity+GetQuantity covered items, and returns the discount per line
+func buyXGetYDiscounts(coupon *models.Coupon, lines []PricedLine, covered []bool) []float64 {
+	type unit struct {
+		line  int
+		price float64
+	}
+	var units []unit
+	for i, line := range lines {
+		if !covered[i] {
+			continue
+		}
+		for n := 0; n < line.Quantity; n++ {
+			units = append(units, unit{i, line.UnitPrice})
+		}
+	}
+
+	discounts := make([]float64, len(lines))
+	group := coupon.BuyQuantity + coupon.GetQuantity
+	if coupon.BuyQuantity <= 0 || coupon.GetQuantity <= 0 {
+		return discounts
+	}
+	free := len(units) / group * coupon.GetQuantity
+	sort.SliceStable(units, func(a, b int) bool { return units[a].price < units[b].price })
+	for _, u := range units[:free] {
+		discounts[u.line] += u.price * coupon.DiscountValue / 100
+	}
+	return discounts
+}
+
+// allocateDiscount spreads a discount over lines in proportion to weights. Shares
+// are rounded to the paisa, with the rounding left on the last weighted line so
+// they add up to exactly the discount.
+func allocateDiscount(discount float64, weights []float64) []float64 {
+	shares := make([]float64, len(weights))
+	var total float64
+	last := -1
+	for i, w := range weights {
+		if w > 0 {
+			total += w
+			last = i
+		}
+	}
+	if total == 0 || discount == 0 {
+		return shares
+	}
+
+	var allocated float64
+	for i, w := range weights {
+		if w > 0 && i != last {
+			shares[i] = RoundMoney(discount * w / total)
+			allocated += shares[i]
+		}
+	}
+	shares[last] = RoundMoney(discount - allocated)
+	return shares
+}
+
+// VIPSpendThreshold is how much a customer must have paid for across their orders
+// to be in the vip segment
+func VIPSpendThreshold() float64 {
+	return envFloat("VIP_SPEND_THRESHOLD", 50000)
+}
+
+// CustomerSegments works out which segments a customer is in from their orders
+func CustomerSegments(db *gorm.DB, userID uint) ([]string, error) {
+	var history struct {
+		Orders int64
+		Spent  float64
+	}
+	err := db.Model(&models.Order{}).
+		Select("COUNT(*) AS orders, COALESCE(SUM(total_amount) FILTER (WHERE payment_status = 'paid'), 0) AS spent").
+		Where("user_id = ? AND status NOT IN ?", userID, abandonedOrderStatuses).
+		Scan(&history).Error
+	if err != nil {
+		return nil, err
+	}
+	return customerSegments(history.Orders, history.Spent, VIPSpendThreshold()), nil
+}
+
+func customerSegments(orders int64, spent, vipThreshold float64) []string {
+	segments := []string{SegmentNewCustomer}
+	if orders > 0 {
+		segments = []string{SegmentReturningCustomer}
+	}
+	if vipThreshold > 0 && spent >= vipThreshold {
+		segments = append(segments, SegmentVIP)
+	}
+	return segments
+}
@@ -0,0 +1,183 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+
+	"pashmina-backend/models"
+)
+
+func cartLine(productID, categoryID uint, unitPrice float64, quantity int) PricedLine {
+	return PricedLine{
+		ProductID: productID,
+		Quantity:  quantity,
+		UnitPrice: unitPrice,
+		LineTotal: RoundMoney(unitPrice * float64(quantity)),
+		Product:   &models.Product{ID: productID, CategoryID: categoryID},
+	}
+}
+
+func TestCheckCouponTerms(t *testing.T) {
+	indiaOnly := activeCoupon(DiscountFixed, 50)
+	indiaOnly.ApplicableCountries = models.StringArray{"IN", "India"}
+	minimum := activeCoupon(DiscountFixed, 50)
+	minimum.MinOrderAmount = 500
+	listed := activeCoupon(DiscountFixed, 50)
+	listed.AllowedCustomers = models.UintArray{7}
+	listed.CustomerSegments = models.StringArray{SegmentVIP}
+
+	tests := []struct {
+		name   string
+		coupon *models.Coupon
+		cart   CouponCart
+		want   error
+	}{
+		{"no rules", activeCoupon(DiscountFixed, 50), CouponCart{Subtotal: 100}, nil},
+		{"country matches", indiaOnly, CouponCart{Country: "india"}, nil},
+		{"country code matches", indiaOnly, CouponCart{Country: " IN "}, nil},
+		{"other country", indiaOnly, CouponCart{Country: "Nepal"}, ErrCouponCountry},
+		{"country not known yet", indiaOnly, CouponCart{}, nil},
+		{"below minimum", minimum, CouponCart{Subtotal: 450}, ErrCouponMinimum},
+		{"amount not known yet", minimum, CouponCart{}, nil},
+		{"allow-listed customer", listed, CouponCart{UserID: 7}, nil},
+		{"customer in segment", listed, CouponCart{UserID: 8, Segments: []string{SegmentReturningCustomer, SegmentVIP}}, nil},
+		{"customer not listed", listed, CouponCart{UserID: 8, Segments: []string{SegmentReturningCustomer}}, ErrCouponCustomer},
+		{"guest", listed, CouponCart{}, ErrCouponCustomer},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := checkCouponTerms(tt.coupon, tt.cart); got != tt.want {
+				t.Errorf("checkCouponTerms = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCouponScopeCovers(t *testing.T) {
+	scope := productScope{
+		includeProducts:   idSet([]uint{1}),
+		excludeProducts:   idSet([]uint{2}),
+		includeCategories: idSet([]uint{10, 11}),
+		excludeCategories: idSet([]uint{11}),
+	}
+	tests := []struct {
+		name string
+		line PricedLine
+		want bool
+	}{
+		{"included product", cartLine(1, 20, 100, 1), true},
+		{"excluded product in an included category", cartLine(2, 10, 100, 1), false},
+		{"included category", cartLine(3, 10, 100, 1), true},
+		{"category both included and excluded", cartLine(4, 11, 100, 1), false},
+		{"neither", cartLine(5, 20, 100, 1), false},
+	}
+	for _, tt := range tests {
+		if got := scope.covers(tt.line); got != tt.want {
+			t.Errorf("%s: covers = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	if !(productScope{}).covers(cartLine(5, 20, 100, 1)) {
+		t.Error("a coupon without product rules should cover every line")
+	}
+}
+
+func TestDiscountLines(t *testing.T) {
+	shawls := productScope{includeCategories: idSet([]uint{10})}
+	lines := []PricedLine{
+		cartLine(1, 10, 300, 2), // 600
+		cartLine(2, 10, 100, 1), // 100
+		cartLine(3, 20, 500, 1), // not a shawl
+	}
+
+	percentage := activeCoupon(DiscountPercentage, 10)
+	capped := activeCoupon(DiscountPercentage, 50)
+	capped.MaxDiscountAmount = 70
+	fixed := activeCoupon(DiscountFixed, 100)
+	bigFixed := activeCoupon(DiscountFixed, 5000)
+	bogo := activeCoupon(DiscountBuyXGetY, 100)
+	bogo.BuyQuantity, bogo.GetQuantity = 1, 1
+	buyTwoHalfOff := activeCoupon(DiscountBuyXGetY, 50)
+	buyTwoHalfOff.BuyQuantity, buyTwoHalfOff.GetQuantity = 2, 1
+	buyFive := activeCoupon(DiscountBuyXGetY, 100)
+	buyFive.BuyQuantity, buyFive.GetQuantity = 5, 1
+	bulk := activeCoupon(DiscountPercentage, 10)
+	bulk.MinQuantity = 4
+
+	tests := []struct {
+		name         string
+		coupon       *models.Coupon
+		scope        productScope
+		wantDiscount float64
+		wantLines    []float64
+		wantErr      error
+	}{
+		{"percentage on covered lines", percentage, shawls, 70, []float64{60, 10, 0}, nil},
+		{"percentage on everything", percentage, productScope{}, 120, []float64{60, 10, 50}, nil},
+		{"capped percentage spread by line total", capped, shawls, 70, []float64{60, 10, 0}, nil},
+		{"fixed spread by line total", fixed, shawls, 100, []float64{85.71, 14.29, 0}, nil},
+		{"fixed larger than covered lines", bigFixed, shawls, 700, []float64{600, 100, 0}, nil},
+		{"buy one get one takes the cheapest", bogo, shawls, 100, []float64{0, 100, 0}, nil},
+		{"buy two get one half off", buyTwoHalfOff, shawls, 50, []float64{0, 50, 0}, nil},
+		{"buy x get y across all lines", bogo, productScope{}, 400, []float64{300, 100, 0}, nil},
+		{"not enough for a free item", buyFive, shawls, 0, nil, ErrCouponQuantity},
+		{"below minimum quantity", bulk, shawls, 0, nil, ErrCouponQuantity},
+		{"nothing covered", percentage, productScope{includeProducts: idSet([]uint{99})}, 0, nil, ErrCouponNoItems},
+		{"free shipping", activeCoupon(DiscountFreeShipping, 0), shawls, 0, []float64{0, 0, 0}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := discountLines(tt.coupon, lines, tt.scope)
+			if err != tt.wantErr {
+				t.Fatalf("discountLines error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got.Discount != tt.wantDiscount || !reflect.DeepEqual(got.LineDiscounts, tt.wantLines) {
+				t.Errorf("discountLines = %v %v, want %v %v", got.Discount, got.LineDiscounts, tt.wantDiscount, tt.wantLines)
+			}
+			if got.FreeShipping != (tt.coupon.DiscountType == DiscountFreeShipping) {
+				t.Errorf("FreeShipping = %v", got.FreeShipping)
+			}
+		})
+	}
+}
+
+func TestAllocateDiscount(t *testing.T) {
+	tests := []struct {
+		discount float64
+		weights  []float64
+		want     []float64
+	}{
+		{10, []float64{1, 1, 1}, []float64{3.33, 3.33, 3.34}},
+		{10, []float64{1, 0, 1, 0}, []float64{5, 0, 5, 0}},
+		{0, []float64{1, 1}, []float64{0, 0}},
+		{10, []float64{0, 0}, []float64{0, 0}},
+	}
+	for _, tt := range tests {
+		if got := allocateDiscount(tt.discount, tt.weights); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("allocateDiscount(%v, %v) = %v, want %v", tt.discount, tt.weights, got, tt.want)
+		}
+	}
+}
+
+func TestCustomerSegments(t *testing.T) {
+	tests := []struct {
+		orders int64
+		spent  float64
+		want   []string
+	}{
+		{0, 0, []string{SegmentNewCustomer}},
+		{2, 1000, []string{SegmentReturningCustomer}},
+		{5, 60000, []string{SegmentReturningCustomer, SegmentVIP}},
+	}
+	for _, tt := range tests {
+		if got := customerSegments(tt.orders, tt.spent, 50000); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("customerSegments(%d, %v) = %v, want %v", tt.orders, tt.spent, got, tt.want)
+		}
+	}
+	if got := customerSegments(5, 60000, 0); !reflect.DeepEqual(got, []string{SegmentReturningCustomer}) {
+		t.Errorf("vip segment without a threshold: %v", got)
+	}
+}
@@ -0,0 +1,128 @@
+package services
+
+import (
+	"errors"
+	"time"
+
+	"pashmina-backend/models"
+
+	"gorm.io/gorm"
+)
+
+const (
+	RedemptionRedeemed = "redeemed"
+	RedemptionReleased = "released"
+)
+
+var (
+	ErrCouponUserLimit  = errors.New("you have already used this coupon the maximum number of times")
+	ErrCouponFirstOrder = errors.New("coupon is only valid on your first order")
+)
+
+// Orders in these statuses never went through, so they don't count against
+// first-order coupons
+var abandonedOrderStatuses = []string{"cancelled", "expired"}
+
+// CheckCouponEligibility checks the limits a coupon places on a single customer:
+// how often they may use it and whether it is only for their first order
+func CheckCouponEligibility(db *gorm.DB, coupon *models.Coupon, userID uint) error {
+	var used, orders int64
+	if coupon.PerUserLimit > 0 {
+		if err := db.Model(&models.CouponRedemption{}).
+			Where("coupon_id = ? AND user_id = ? AND status = ?", coupon.ID, userID, RedemptionRedeemed).
+			Count(&used).Error; err != nil {
+			return err
+		}
+	}
+	if coupon.FirstOrderOnly {
+		if err := db.Model(&models.Order{}).
+			Where("user_id = ? AND status NOT IN ?", userID, abandonedOrderStatuses).
+			Count(&orders).Error; err != nil {
+			return err
+		}
+	}
+	return couponEligibility(coupon, used, orders)
+}
+
+func couponEligibility(coupon *models.Coupon, used, orders int64) error {
+	if coupon.PerUserLimit > 0 && used >= int64(coupon.PerUserLimit) {
+		return ErrCouponUserLimit
+	}
+	if coupon.FirstOrderOnly && orders > 0 {
+		return ErrCouponFirstOrder
+	}
+	return nil
+}
+
+// RedeemCoupon records coupon as used on order and counts it towards the coupon's
+// usage limit. The coupon must have been loaded for update in tx, as PriceOrder
+// does, so concurrent orders can't both take its last use.
+func RedeemCoupon(tx *gorm.DB, coupon *models.Coupon, order *models.Order) error {
+	redemption := models.CouponRedemption{
+		CouponID:       coupon.ID,
+		UserID:         order.UserID,
+		OrderID:        order.ID,
+		DiscountAmount: RoundMoney(order.DiscountAmount - order.PromotionDiscount),
+		Status:         RedemptionRedeemed,
+	}
+	if err := tx.Create(&redemption).Error; err != nil {
+		return err
+	}
+
+	// UpdateColumn leaves updated_at alone, so an admin editing the coupon isn't
+	// told it changed under them every time it is used
+	if err := tx.Model(coupon).UpdateColumn("used_count", gorm.Expr("used_count + 1")).Error; err != nil {
+		return err
+	}
+	coupon.UsedCount++
+	return nil
+}
+
+// ReleaseCouponRedemption gives back the coupon use of a cancelled or expired
+// order. Orders without a coupon are left alone.
+func ReleaseCouponRedemption(tx *gorm.DB, orderID uint) error {
+	var redemption models.CouponRedemption
+	err := tx.Where("order_id = ? AND status = ?", orderID, RedemptionRedeemed).First(&redemption).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Model(&redemption).Updates(map[string]interface{}{
+		"status":      RedemptionReleased,
+		"released_at": time.Now(),
+	}).Error; err != nil {
+		return err
+	}
+
+	return tx.Model(&models.Coupon{}).
+		Where("id = ? AND used_count > 0", redemption.CouponID).
+		UpdateColumn("used_count", gorm.Expr("used_count - 1")).Error
+}
+
+// ReclaimCouponRedemption counts a released coupon use again when an expired order
+// is paid after all. Limits aren't checked: the customer has already paid the
+// discounted price.
+func ReclaimCouponRedemption(tx *gorm.DB, orderID uint) error {
+	var redemption models.CouponRedemption
+	err := tx.Where("order_id = ? AND status = ?", orderID, RedemptionReleased).First(&redemption).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Model(&redemption).Updates(map[string]interface{}{
+		"status":      RedemptionRedeemed,
+		"released_at": nil,
+	}).Error; err != nil {
+		return err
+	}
+
+	return tx.Model(&models.Coupon{}).
+		Where("id = ?", redemption.CouponID).
+		UpdateColumn("used_count", gorm.Expr("used_count + 1")).Error
+}
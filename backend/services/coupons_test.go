@@ -0,0 +1,30 @@
+package services
+
+import (
+	"testing"
+
+	"pashmina-backend/models"
+)
+
+func TestCouponEligibility(t *testing.T) {
+	tests := []struct {
+		name         string
+		coupon       models.Coupon
+		used, orders int64
+		want         error
+	}{
+		{"no limits", models.Coupon{}, 5, 5, nil},
+		{"under the per-user limit", models.Coupon{PerUserLimit: 2}, 1, 3, nil},
+		{"at the per-user limit", models.Coupon{PerUserLimit: 2}, 2, 3, ErrCouponUserLimit},
+		{"first order", models.Coupon{FirstOrderOnly: true}, 0, 0, nil},
+		{"not the first order", models.Coupon{FirstOrderOnly: true}, 0, 1, ErrCouponFirstOrder},
+		{"limit checked before first order", models.Coupon{PerUserLimit: 1, FirstOrderOnly: true}, 1, 1, ErrCouponUserLimit},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := couponEligibility(&tt.coupon, tt.used, tt.orders); got != tt.want {
+				t.Errorf("couponEligibility = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,481 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"pashmina-backend/models"
+	"pashmina-backend/utils"
+	"pashmina-backend/websocket"
+
+	"gorm.io/gorm"
+)
+
+// Notification channels
+const (
+	ChannelInApp = "in_app"
+	ChannelEmail = "email"
+	ChannelPush  = "push"
+	ChannelSMS   = "sms"
+)
+
+// Notification attempt statuses
+const (
+	NotificationPending = "pending"
+	NotificationSent    = "sent"
+	NotificationFailed  = "failed"
+)
+
+// Event is something that happened which users or admins may be told about
+type Event struct {
+	Type string
+	// UserID is the customer the event concerns; zero for admin-only events
+	UserID uint
+	// Data is passed to the templates alongside the recipient as .User
+	Data map[string]interface{}
+	Link string
+	// Private events carry secrets such as reset links; their text and link are sent
+	// but not kept on the notification record
+	Private bool
+}
+
+// Recipient is who a notification is delivered to
+type Recipient struct {
+	UserID *uint
+	Name   string
+	Email  string
+	Phone  string
+}
+
+// Message is a rendered notification ready for a channel
+type Message struct {
+	NotificationID uint
+	Type           string
+	Subject        string
+	Title          string
+	Text           string
+	HTML           string
+	Link           string
+	Private        bool
+	CreatedAt      time.Time
+}
+
+// Sender delivers messages on one channel
+type Sender interface {
+	Send(to Recipient, msg Message) error
+}
+
+// Dispatcher renders notification templates for events and fans them out to the
+// channels each recipient has enabled, recording every attempt
+type Dispatcher struct {
+	db      *gorm.DB
+	mu      sync.RWMutex
+	senders map[string]Sender
+}
+
+var (
+	dispatcher     *Dispatcher
+	dispatcherOnce sync.Once
+)
+
+// Notifications returns the shared dispatcher, creating it on first use
+func Notifications(db *gorm.DB) *Dispatcher {
+	dispatcherOnce.Do(func() {
+		dispatcher = NewDispatcher(db)
+	})
+	return dispatcher
+}
+
+// NewDispatcher creates a dispatcher that delivers in-app notifications. Other
+// channels are added with Register.
+func NewDispatcher(db *gorm.DB) *Dispatcher {
+	d := &Dispatcher{db: db, senders: make(map[string]Sender)}
+	d.Register(ChannelInApp, InAppSender{})
+	return d
+}
+
+// Register sets the sender used for a channel
+func (d *Dispatcher) Register(channel string, sender Sender) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.senders[channel] = sender
+}
+
+func (d *Dispatcher) sender(channel string) Sender {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.senders[channel]
+}
+
+// DispatchAsync dispatches an event in the background, logging any failure
+func (d *Dispatcher) DispatchAsync(event Event) {
+	go func() {
+		if err := d.Dispatch(event); err != nil {
+			utils.Error("Notification dispatch failed", map[string]interface{}{
+				"type":  event.Type,
+				"error": err.Error(),
+			})
+		}
+	}()
+}
+
+// Dispatch renders the event's template and delivers it to every recipient on the
+// channels they have enabled. Delivery failures are recorded on the notification
+// rather than returned.
+func (d *Dispatcher) Dispatch(event Event) error {
+	tmpl, ok := d.template(event.Type)
+	if !ok {
+		return fmt.Errorf("no notification template for %s", event.Type)
+	}
+	channels := templateChannels(tmpl)
+
+	if isAdminEvent(event.Type) {
+		return d.dispatchToAdmins(event, tmpl, channels)
+	}
+
+	var user models.User
+	if err := d.db.First(&user, event.UserID).Error; err != nil {
+		return err
+	}
+
+	prefs := DefaultNotificationPreference(user.ID)
+	d.db.Where("user_id = ?", user.ID).First(&prefs)
+	if !preferenceAllows(&prefs, event.Type) {
+		return nil
+	}
+
+	to := Recipient{UserID: &user.ID, Name: user.Name, Email: user.Email, Phone: user.Phone}
+	for _, channel := range channels {
+		if channelEnabled(&prefs, channel) {
+			d.deliver(event, tmpl, channel, to)
+		}
+	}
+	return nil
+}
+
+// DispatchTo renders the event's template for a recipient given by the caller, such
+// as a newsletter subscriber or a user resetting their password. Preferences are not
+// consulted, so it is only for messages the recipient asked for; the template's
+// channels the recipient cannot be reached on are skipped.
+func (d *Dispatcher) DispatchTo(event Event, to Recipient) error {
+	tmpl, ok := d.template(event.Type)
+	if !ok {
+		return fmt.Errorf("no notification template for %s", event.Type)
+	}
+
+	for _, channel := range templateChannels(tmpl) {
+		switch {
+		case channel == ChannelEmail && to.Email == "",
+			channel == ChannelSMS && to.Phone == "",
+			(channel == ChannelInApp || channel == ChannelPush) && to.UserID == nil:
+			continue
+		}
+		d.deliver(event, tmpl, channel, to)
+	}
+	return nil
+}
+
+// DispatchToAsync runs DispatchTo in the background, logging any failure
+func (d *Dispatcher) DispatchToAsync(event Event, to Recipient) {
+	go func() {
+		if err := d.DispatchTo(event, to); err != nil {
+			utils.Error("Notification dispatch failed", map[string]interface{}{
+				"type":  event.Type,
+				"error": err.Error(),
+			})
+		}
+	}()
+}
+
+// dispatchToAdmins delivers an admin event in-app to every admin user and by email
+// and SMS to each admin notification setting that asks for it
+func (d *Dispatcher) dispatchToAdmins(event Event, tmpl models.NotificationTemplate, channels []string) error {
+	var settings []models.AdminNotificationSetting
+	if err := d.db.Find(&settings).Error; err != nil {
+		return err
+	}
+
+	for _, channel := range channels {
+		switch channel {
+		case ChannelInApp:
+			var admins []models.User
+			d.db.Where("role = ?", "admin").Find(&admins)
+			for i := range admins {
+				admin := admins[i]
+				d.deliver(event, tmpl, channel, Recipient{UserID: &admin.ID, Name: admin.Name, Email: admin.Email})
+			}
+		case ChannelEmail, ChannelSMS:
+			for _, setting := range settings {
+				if !adminSettingAllows(&setting, event.Type) {
+					continue
+				}
+				if channel == ChannelEmail && setting.EmailEnabled && setting.AdminEmail != "" {
+					d.deliver(event, tmpl, channel, Recipient{Email: setting.AdminEmail})
+				}
+				if channel == ChannelSMS && setting.SMSEnabled && setting.AdminPhone != "" {
+					d.deliver(event, tmpl, channel, Recipient{Phone: setting.AdminPhone})
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// deliver renders the template for one channel and recipient and sends it
+func (d *Dispatcher) deliver(event Event, tmpl models.NotificationTemplate, channel string, to Recipient) {
+	data := map[string]interface{}{"User": to, "Link": event.Link}
+	for k, v := range event.Data {
+		data[k] = v
+	}
+
+	msg, err := renderMessage(tmpl, channel, data)
+	msg.Type = event.Type
+	msg.Link = event.Link
+	msg.Private = event.Private
+	d.send(channel, to, msg, err)
+}
+
+// Send records a message that was composed without a template and delivers it on
+// one channel. It reports whether delivery succeeded.
+func (d *Dispatcher) Send(channel string, to Recipient, msg Message) bool {
+	return d.send(channel, to, msg, nil)
+}
+
+// send records the attempt, hands the message to the channel's sender unless
+// rendering already failed, and stores the outcome
+func (d *Dispatcher) send(channel string, to Recipient, msg Message, renderErr error) bool {
+	notification := models.Notification{
+		UserID:  to.UserID,
+		Type:    msg.Type,
+		Channel: channel,
+		Title:   msg.Title,
+		Message: msg.Text,
+		Status:  NotificationPending,
+	}
+	if msg.Private {
+		notification.Message = ""
+	} else if msg.Link != "" {
+		notification.Data = models.StringArray{msg.Link}
+	}
+	if notification.Title == "" {
+		notification.Title = msg.Type
+	}
+	if err := d.db.Create(&notification).Error; err != nil {
+		utils.Error("Failed to record notification", map[string]interface{}{
+			"type":    msg.Type,
+			"channel": channel,
+			"error":   err.Error(),
+		})
+		return false
+	}
+	msg.NotificationID = notification.ID
+	msg.CreatedAt = notification.CreatedAt
+
+	err := renderErr
+	if err == nil {
+		if sender := d.sender(channel); sender != nil {
+			err = sender.Send(to, msg)
+		} else {
+			err = fmt.Errorf("no sender configured for %s", channel)
+		}
+	}
+
+	updates := map[string]interface{}{"status": NotificationSent, "sent_at": time.Now()}
+	if err != nil {
+		updates = map[string]interface{}{"status": NotificationFailed, "failure_reason": err.Error()}
+	}
+	d.db.Model(&notification).Updates(updates)
+	return err == nil
+}
+
+// template returns the active template for an event type, falling back to the
+// built-in default
+func (d *Dispatcher) template(eventType string) (models.NotificationTemplate, bool) {
+	var tmpl models.NotificationTemplate
+	if err := d.db.Where("type = ? AND is_active = ?", eventType, true).First(&tmpl).Error; err == nil {
+		return tmpl, true
+	}
+	tmpl, ok := defaultTemplates[eventType]
+	return tmpl, ok
+}
+
+// templateChannels reads a template's comma-separated channel list. "all" means the
+// channels that have a sender by default; SMS has none, so it must be listed.
+func templateChannels(tmpl models.NotificationTemplate) []string {
+	if strings.TrimSpace(tmpl.Channel) == "all" {
+		return []string{ChannelInApp, ChannelEmail, ChannelPush}
+	}
+
+	var channels []string
+	for _, channel := range strings.Split(tmpl.Channel, ",") {
+		if channel = strings.TrimSpace(channel); channel != "" {
+			channels = append(channels, channel)
+		}
+	}
+	return channels
+}
+
+// renderMessage renders a template's subject, title and body for a channel. Email
+// bodies are rendered as HTML with the data escaped.
+func renderMessage(tmpl models.NotificationTemplate, channel string, data map[string]interface{}) (Message, error) {
+	var msg Message
+	var errs []error
+
+	var err error
+	if msg.Title, err = renderText(tmpl.Type+".title", tmpl.Title, data); err != nil {
+		errs = append(errs, err)
+	}
+	if msg.Subject, err = renderText(tmpl.Type+".subject", tmpl.Subject, data); err != nil {
+		errs = append(errs, err)
+	}
+	if msg.Subject == "" {
+		msg.Subject = msg.Title
+	}
+	if msg.Text, err = renderText(tmpl.Type+".body", tmpl.Body, data); err != nil {
+		errs = append(errs, err)
+	}
+	if channel == ChannelEmail {
+		if msg.HTML, err = renderHTML(tmpl.Type+".html", tmpl.Body, data); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return msg, errors.Join(errs...)
+}
+
+func renderText(name, text string, data interface{}) (string, error) {
+	t, err := template.New(name).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+func renderHTML(name, text string, data interface{}) (string, error) {
+	t, err := htmltemplate.New(name).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// DefaultNotificationPreference returns the preferences of a user who has not
+// changed any
+func DefaultNotificationPreference(userID uint) models.NotificationPreference {
+	return models.NotificationPreference{
+		UserID:         userID,
+		OrderCreated:   true,
+		OrderShipped:   true,
+		OrderDelivered: true,
+		OrderStatus:    true,
+		LowStock:       true,
+		Newsletter:     true,
+		EmailEnabled:   true,
+		PushEnabled:    true,
+	}
+}
+
+// preferenceAllows reports whether the user wants to hear about this kind of event
+func preferenceAllows(prefs *models.NotificationPreference, eventType string) bool {
+	switch eventType {
+	case "order_created":
+		return prefs.OrderCreated
+	case "order_shipped":
+		return prefs.OrderShipped
+	case "order_delivered":
+		return prefs.OrderDelivered
+	case "product_update":
+		return prefs.ProductUpdates
+	case "newsletter":
+		return prefs.Newsletter
+	case "marketing":
+		return prefs.Marketing
+	}
+	if strings.HasPrefix(eventType, "order_") || strings.HasPrefix(eventType, "payment_") {
+		return prefs.OrderStatus
+	}
+	return true
+}
+
+// channelEnabled reports whether the user accepts notifications on a channel. The
+// in-app inbox is always on.
+func channelEnabled(prefs *models.NotificationPreference, channel string) bool {
+	switch channel {
+	case ChannelInApp:
+		return true
+	case ChannelEmail:
+		return prefs.EmailEnabled
+	case ChannelPush:
+		return prefs.PushEnabled
+	case ChannelSMS:
+		return prefs.SMSEnabled
+	}
+	return false
+}
+
+func isAdminEvent(eventType string) bool {
+	return strings.HasPrefix(eventType, "admin_") || eventType == "low_stock"
+}
+
+// adminSettingAllows reports whether an admin setting subscribes to an admin event
+func adminSettingAllows(setting *models.AdminNotificationSetting, eventType string) bool {
+	switch eventType {
+	case "admin_new_order":
+		return setting.NotifyOnOrder
+	case "low_stock":
+		return setting.NotifyOnLowStock
+	case "admin_new_customer":
+		return setting.NotifyOnNewCustomer
+	case "admin_new_review":
+		return setting.NotifyOnReview
+	}
+	return true
+}
+
+// InAppSender pushes inbox notifications to the recipient's open connections. The
+// notification itself is the dispatcher's record, so nothing else is stored.
+type InAppSender struct{}
+
+// NotificationMessage is the payload pushed to connected clients over the websocket
+type NotificationMessage struct {
+	Type      string    `json:"type"`
+	ID        uint      `json:"id"`
+	Title     string    `json:"title"`
+	Message   string    `json:"message"`
+	Channel   string    `json:"channel"`
+	NotifType string    `json:"notif_type"`
+	Link      string    `json:"link,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (InAppSender) Send(to Recipient, msg Message) error {
+	if to.UserID == nil {
+		return errors.New("in-app notifications need a user")
+	}
+
+	websocket.GlobalHub.SendToUser(*to.UserID, NotificationMessage{
+		Type:      "notification",
+		ID:        msg.NotificationID,
+		Title:     msg.Title,
+		Message:   msg.Text,
+		Channel:   ChannelInApp,
+		NotifType: msg.Type,
+		Link:      msg.Link,
+		CreatedAt: msg.CreatedAt,
+	})
+	return nil
+}
@@ -0,0 +1,453 @@
+package services
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"pashmina-backend/models"
+	"pashmina-backend/utils"
+
+	"gorm.io/gorm"
+)
+
+// Email log statuses
+const (
+	EmailSent   = "sent"
+	EmailFailed = "failed"
+)
+
+// Email is a message ready for a transport
+type Email struct {
+	From      string
+	To        string
+	Subject   string
+	Text      string
+	HTML      string
+	MessageID string
+	Headers   map[string]string
+}
+
+// EmailTransport hands a raw MIME message to a mail system
+type EmailTransport interface {
+	Deliver(from string, to []string, raw []byte) error
+}
+
+// SMTPTransport delivers through an SMTP relay. Port 465 uses implicit TLS; other
+// ports upgrade with STARTTLS when the server offers it.
+type SMTPTransport struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	Timeout  time.Duration
+}
+
+func (t *SMTPTransport) Deliver(from string, to []string, raw []byte) error {
+	addr := net.JoinHostPort(t.Host, t.Port)
+	timeout := t.Timeout
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+	tlsConfig := &tls.Config{ServerName: t.Host}
+
+	var conn net.Conn
+	var err error
+	dialer := &net.Dialer{Timeout: timeout}
+	if t.Port == "465" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	client, err := smtp.NewClient(conn, t.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok && t.Port != "465" {
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+	if t.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", t.Username, t.Password, t.Host)); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Mail(from); err != nil {
+		return err
+	}
+	for _, rcpt := range to {
+		if err := client.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(raw); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// FileTransport writes each message to Dir as an .eml file, for local development
+type FileTransport struct {
+	Dir string
+}
+
+func (t *FileTransport) Deliver(from string, to []string, raw []byte) error {
+	if err := os.MkdirAll(t.Dir, 0o755); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%s.eml", time.Now().Format("20060102-150405.000"), randomToken(4))
+	return os.WriteFile(filepath.Join(t.Dir, name), raw, 0o644)
+}
+
+// CapturedEmail is a message kept by MemoryTransport
+type CapturedEmail struct {
+	From string
+	To   []string
+	Raw  []byte
+}
+
+// MemoryTransport keeps messages in memory so tests can inspect them
+type MemoryTransport struct {
+	mu       sync.Mutex
+	messages []CapturedEmail
+}
+
+func (t *MemoryTransport) Deliver(from string, to []string, raw []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.messages = append(t.messages, CapturedEmail{From: from, To: to, Raw: raw})
+	return nil
+}
+
+// Messages returns the messages captured so far
+func (t *MemoryTransport) Messages() []CapturedEmail {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]CapturedEmail(nil), t.messages...)
+}
+
+// NewEmailTransport picks the transport named by EMAIL_TRANSPORT (smtp, file or
+// memory). Without it, SMTP is used when SMTP_HOST is set and captured files otherwise.
+func NewEmailTransport() EmailTransport {
+	kind := strings.ToLower(strings.TrimSpace(os.Getenv("EMAIL_TRANSPORT")))
+	if kind == "" {
+		kind = "file"
+		if os.Getenv("SMTP_HOST") != "" {
+			kind = "smtp"
+		}
+	}
+
+	switch kind {
+	case "smtp":
+		port := os.Getenv("SMTP_PORT")
+		if port == "" {
+			port = "587"
+		}
+		return &SMTPTransport{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     port,
+			Username: os.Getenv("SMTP_USER"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+		}
+	case "memory":
+		return &MemoryTransport{}
+	default:
+		dir := os.Getenv("EMAIL_CAPTURE_DIR")
+		if dir == "" {
+			dir = "tmp/mail"
+		}
+		return &FileTransport{Dir: dir}
+	}
+}
+
+// Mailer renders notification messages as multipart email, delivers them with
+// retries and logs every message it handles
+type Mailer struct {
+	db          *gorm.DB
+	transport   EmailTransport
+	from        string
+	siteURL     string
+	maxAttempts int
+	backoff     time.Duration
+	sleep       func(time.Duration)
+}
+
+// NewMailer returns a mailer using the configured transport and sender address
+func NewMailer(db *gorm.DB, transport EmailTransport) *Mailer {
+	from := os.Getenv("EMAIL_FROM")
+	if from == "" {
+		from = "noreply@pashmiya.com"
+	}
+	return &Mailer{
+		db:          db,
+		transport:   transport,
+		from:        from,
+		siteURL:     strings.TrimRight(os.Getenv("FRONTEND_URL"), "/"),
+		maxAttempts: int(envFloat("EMAIL_MAX_ATTEMPTS", 3)),
+		backoff:     2 * time.Second,
+		sleep:       time.Sleep,
+	}
+}
+
+// Send implements Sender for the email channel
+func (m *Mailer) Send(to Recipient, msg Message) error {
+	if to.Email == "" {
+		return errors.New("recipient has no email address")
+	}
+
+	link := m.absoluteURL(msg.Link)
+	text := msg.Text
+	if link != "" {
+		text += "\n\n" + link
+	}
+	html, err := renderEmailLayout(msg, link)
+	if err != nil {
+		return err
+	}
+
+	recipient := to.Email
+	if to.Name != "" {
+		recipient = (&mail.Address{Name: to.Name, Address: to.Email}).String()
+	}
+
+	var notificationID *uint
+	if msg.NotificationID != 0 {
+		notificationID = &msg.NotificationID
+	}
+	return m.SendEmail(&Email{
+		To:      recipient,
+		Subject: msg.Subject,
+		Text:    text,
+		HTML:    html,
+	}, msg.Type, notificationID)
+}
+
+// SendEmail delivers an email, retrying transient failures with exponential backoff,
+// and records the outcome in the email log
+func (m *Mailer) SendEmail(email *Email, emailType string, notificationID *uint) error {
+	if email.From == "" {
+		email.From = m.from
+	}
+	if email.MessageID == "" {
+		email.MessageID = fmt.Sprintf("<%s@%s>", randomToken(12), emailDomain(email.From))
+	}
+
+	raw, err := buildMIME(email)
+	if err != nil {
+		return err
+	}
+
+	from, err := mail.ParseAddress(email.From)
+	if err != nil {
+		return fmt.Errorf("invalid sender address: %w", err)
+	}
+	to, err := mail.ParseAddress(email.To)
+	if err != nil {
+		return fmt.Errorf("invalid recipient address: %w", err)
+	}
+
+	attempts := 0
+	delay := m.backoff
+	for {
+		attempts++
+		err = m.transport.Deliver(from.Address, []string{to.Address}, raw)
+		if err == nil || attempts >= m.maxAttempts || isPermanentEmailError(err) {
+			break
+		}
+		utils.Warn("Email delivery failed, retrying", map[string]interface{}{
+			"to":      to.Address,
+			"type":    emailType,
+			"attempt": attempts,
+			"error":   err.Error(),
+		})
+		m.sleep(delay)
+		delay *= 2
+	}
+
+	m.log(email, to.Address, emailType, notificationID, attempts, err)
+	return err
+}
+
+func (m *Mailer) log(email *Email, to, emailType string, notificationID *uint, attempts int, err error) {
+	if m.db == nil {
+		return
+	}
+
+	entry := models.EmailLog{
+		NotificationID: notificationID,
+		Type:           emailType,
+		ToAddress:      to,
+		Subject:        email.Subject,
+		MessageID:      email.MessageID,
+		Attempts:       attempts,
+		Status:         EmailSent,
+	}
+	if err != nil {
+		entry.Status = EmailFailed
+		entry.LastError = err.Error()
+	} else {
+		now := time.Now()
+		entry.SentAt = &now
+	}
+
+	if err := m.db.Create(&entry).Error; err != nil {
+		utils.Error("Failed to record email log", map[string]interface{}{
+			"to":    to,
+			"error": err.Error(),
+		})
+	}
+}
+
+// absoluteURL turns a site path into a link that works from an inbox
+func (m *Mailer) absoluteURL(link string) string {
+	if link == "" || !strings.HasPrefix(link, "/") || m.siteURL == "" {
+		return link
+	}
+	return m.siteURL + link
+}
+
+// isPermanentEmailError reports whether retrying cannot help, such as an SMTP 5xx
+// rejection of the recipient or message
+func isPermanentEmailError(err error) bool {
+	var smtpErr *textproto.Error
+	return errors.As(err, &smtpErr) && smtpErr.Code >= 500
+}
+
+var emailLayout = htmltemplate.Must(htmltemplate.New("email").Parse(`<!DOCTYPE html>
+<html>
+<body style="margin:0;padding:24px;background:#f6f3ee;font-family:Georgia,serif;color:#2b2622;">
+<table role="presentation" width="100%" cellpadding="0" cellspacing="0" style="max-width:560px;margin:0 auto;background:#ffffff;">
+<tr><td style="padding:24px 32px;border-bottom:1px solid #e8e1d6;font-size:20px;letter-spacing:2px;">PASHMIYA</td></tr>
+<tr><td style="padding:32px;">
+<h1 style="margin:0 0 16px;font-size:22px;font-weight:normal;">{{.Title}}</h1>
+<p style="margin:0 0 24px;font-size:15px;line-height:1.6;">{{.Body}}</p>
+{{if .Link}}<a href="{{.Link}}" style="display:inline-block;padding:12px 24px;background:#2b2622;color:#ffffff;text-decoration:none;font-size:14px;">View details</a>{{end}}
+</td></tr>
+<tr><td style="padding:16px 32px;border-top:1px solid #e8e1d6;font-size:12px;color:#8a8178;">You are receiving this email because of your activity at Pashmiya.</td></tr>
+</table>
+</body>
+</html>
+`))
+
+// renderEmailLayout places a message's HTML body, already escaped by renderMessage,
+// inside the store's email layout
+func renderEmailLayout(msg Message, link string) (string, error) {
+	body := msg.HTML
+	if body == "" {
+		body = htmltemplate.HTMLEscapeString(msg.Text)
+	}
+	body = strings.ReplaceAll(strings.TrimSpace(body), "\n", "<br>")
+
+	var buf bytes.Buffer
+	err := emailLayout.Execute(&buf, map[string]interface{}{
+		"Title": msg.Title,
+		"Body":  htmltemplate.HTML(body),
+		"Link":  link,
+	})
+	return buf.String(), err
+}
+
+// buildMIME encodes an email as multipart/alternative with quoted-printable text and
+// HTML parts
+func buildMIME(email *Email) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+
+	headers := []struct{ key, value string }{
+		{"From", email.From},
+		{"To", email.To},
+		{"Subject", mime.QEncoding.Encode("utf-8", email.Subject)},
+		{"Date", time.Now().Format(time.RFC1123Z)},
+		{"Message-ID", email.MessageID},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", fmt.Sprintf("multipart/alternative; boundary=%q", writer.Boundary())},
+	}
+	for key, value := range email.Headers {
+		headers = append(headers, struct{ key, value string }{key, value})
+	}
+	for _, h := range headers {
+		if strings.ContainsAny(h.value, "\r\n") {
+			return nil, fmt.Errorf("invalid %s header", h.key)
+		}
+		fmt.Fprintf(&buf, "%s: %s\r\n", h.key, h.value)
+	}
+	buf.WriteString("\r\n")
+
+	parts := []struct{ contentType, body string }{
+		{"text/plain; charset=utf-8", email.Text},
+		{"text/html; charset=utf-8", email.HTML},
+	}
+	for _, p := range parts {
+		if p.body == "" {
+			continue
+		}
+		w, err := writer.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {p.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(w)
+		if _, err := qp.Write([]byte(p.body)); err != nil {
+			return nil, err
+		}
+		if err := qp.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func emailDomain(address string) string {
+	if parsed, err := mail.ParseAddress(address); err == nil {
+		address = parsed.Address
+	}
+	if at := strings.LastIndex(address, "@"); at >= 0 {
+		return address[at+1:]
+	}
+	return "localhost"
+}
+
+func randomToken(n int) string {
+	buf := make([]byte, n)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
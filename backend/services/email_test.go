@@ -0,0 +1,173 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+)
+
+// flakyTransport fails with the queued errors before delivering
+type flakyTransport struct {
+	errs  []error
+	calls int
+	MemoryTransport
+}
+
+func (t *flakyTransport) Deliver(from string, to []string, raw []byte) error {
+	t.calls++
+	if len(t.errs) > 0 {
+		err := t.errs[0]
+		t.errs = t.errs[1:]
+		return err
+	}
+	return t.MemoryTransport.Deliver(from, to, raw)
+}
+
+func testMailer(transport EmailTransport) (*Mailer, *[]time.Duration) {
+	var slept []time.Duration
+	return &Mailer{
+		transport:   transport,
+		from:        "Pashmiya <noreply@pashmiya.com>",
+		siteURL:     "https://pashmiya.com",
+		maxAttempts: 3,
+		backoff:     time.Second,
+		sleep:       func(d time.Duration) { slept = append(slept, d) },
+	}, &slept
+}
+
+// readParts parses a captured message into its headers and decoded parts by type
+func readParts(t *testing.T, raw []byte) (mail.Header, map[string]string) {
+	t.Helper()
+
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("message does not parse: %v", err)
+	}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("Content-Type = %q, want multipart/alternative", msg.Header.Get("Content-Type"))
+	}
+
+	parts := make(map[string]string)
+	reader := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		// NextPart decodes quoted-printable bodies
+		body, _ := io.ReadAll(part)
+		contentType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		parts[contentType] = string(body)
+	}
+	return msg.Header, parts
+}
+
+func TestMailerSendBuildsMultipart(t *testing.T) {
+	transport := &MemoryTransport{}
+	mailer, _ := testMailer(transport)
+
+	err := mailer.Send(Recipient{Name: "Asha", Email: "asha@example.com"}, Message{
+		Type:    "order_shipped",
+		Subject: "Order #7 — shipped",
+		Title:   "Order shipped",
+		Text:    "Your order #7 is on its way.",
+		HTML:    "Your order #7 &lt;b&gt; is on its way.",
+		Link:    "/orders/7",
+	})
+	if err != nil {
+		t.Fatalf("Send error = %v", err)
+	}
+
+	sent := transport.Messages()
+	if len(sent) != 1 {
+		t.Fatalf("captured %d messages, want 1", len(sent))
+	}
+	if sent[0].From != "noreply@pashmiya.com" || len(sent[0].To) != 1 || sent[0].To[0] != "asha@example.com" {
+		t.Errorf("envelope = %s -> %v", sent[0].From, sent[0].To)
+	}
+
+	header, parts := readParts(t, sent[0].Raw)
+	if subject, _ := new(mime.WordDecoder).DecodeHeader(header.Get("Subject")); subject != "Order #7 — shipped" {
+		t.Errorf("Subject = %q", subject)
+	}
+	if !strings.Contains(parts["text/plain"], "https://pashmiya.com/orders/7") {
+		t.Errorf("text part has no absolute link: %q", parts["text/plain"])
+	}
+	html := parts["text/html"]
+	if !strings.Contains(html, `href="https://pashmiya.com/orders/7"`) {
+		t.Errorf("html part has no link button: %q", html)
+	}
+	if strings.Contains(html, "<b>") || !strings.Contains(html, "&lt;b&gt;") {
+		t.Errorf("html part lost the escaping of the rendered body")
+	}
+}
+
+func TestMailerRetries(t *testing.T) {
+	tests := []struct {
+		name      string
+		errs      []error
+		wantCalls int
+		wantSleep []time.Duration
+		wantErr   bool
+	}{
+		{"first try", nil, 1, nil, false},
+		{"recovers", []error{errors.New("connection reset")}, 2, []time.Duration{time.Second}, false},
+		{
+			"gives up",
+			[]error{errors.New("timeout"), errors.New("timeout"), errors.New("timeout")},
+			3, []time.Duration{time.Second, 2 * time.Second}, true,
+		},
+		{"permanent", []error{&textproto.Error{Code: 550, Msg: "mailbox unavailable"}}, 1, nil, true},
+		{
+			"transient smtp",
+			[]error{&textproto.Error{Code: 451, Msg: "try again later"}},
+			2, []time.Duration{time.Second}, false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transport := &flakyTransport{errs: tt.errs}
+			mailer, slept := testMailer(transport)
+
+			err := mailer.SendEmail(&Email{To: "asha@example.com", Subject: "Hi", Text: "Hello"}, "test", nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SendEmail error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if transport.calls != tt.wantCalls {
+				t.Errorf("delivery attempts = %d, want %d", transport.calls, tt.wantCalls)
+			}
+			if len(*slept) != len(tt.wantSleep) {
+				t.Fatalf("backoff = %v, want %v", *slept, tt.wantSleep)
+			}
+			for i := range tt.wantSleep {
+				if (*slept)[i] != tt.wantSleep[i] {
+					t.Errorf("backoff = %v, want %v", *slept, tt.wantSleep)
+				}
+			}
+		})
+	}
+}
+
+func TestBuildMIMERejectsHeaderInjection(t *testing.T) {
+	_, err := buildMIME(&Email{
+		From:    "noreply@pashmiya.com",
+		To:      "asha@example.com\r\nBcc: victim@example.com",
+		Subject: "Hi",
+		Text:    "Hello",
+	})
+	if err == nil {
+		t.Error("buildMIME accepted a recipient containing a line break")
+	}
+}
@@ -0,0 +1,238 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"pashmina-backend/models"
+
+	"gorm.io/gorm"
+)
+
+// priceBucketCount is roughly how many price ranges the price facet is split into
+const priceBucketCount = 5
+
+// FacetValue is a filter value and how many products have it
+type FacetValue struct {
+	Value string `json:"value"`
+	Count int64  `json:"count"`
+}
+
+// CategoryFacet is a category and how many matching products are in it
+type CategoryFacet struct {
+	ID    uint   `json:"id"`
+	Name  string `json:"name"`
+	Slug  string `json:"slug"`
+	Count int64  `json:"count"`
+}
+
+// PriceBucket is a price range, inclusive of Min and exclusive of Max
+type PriceBucket struct {
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+	Count int64   `json:"count"`
+}
+
+// Facets are the filter values available for a product listing with their counts.
+// Each facet is counted with every active filter except its own, so picking a color
+// still shows how many products the other colors would give.
+type Facets struct {
+	Total        int64           `json:"total"`
+	Colors       []FacetValue    `json:"colors"`
+	Sizes        []FacetValue    `json:"sizes"`
+	Categories   []CategoryFacet `json:"categories"`
+	PriceBuckets []PriceBucket   `json:"price_buckets"`
+	MinPrice     float64         `json:"min_price"`
+	MaxPrice     float64         `json:"max_price"`
+}
+
+type facetCacheEntry struct {
+	facets  *Facets
+	expires time.Time
+}
+
+// facetCache holds computed facets by filter set. Product writes clear it; the TTL
+// covers changes that don't go through the admin handlers, such as stock sold.
+type facetCache struct {
+	mu         sync.Mutex
+	entries    map[string]facetCacheEntry
+	generation uint64
+}
+
+// maxFacetCacheEntries bounds the cache; it is cleared when full
+const maxFacetCacheEntries = 500
+
+var facetResults = &facetCache{entries: make(map[string]facetCacheEntry)}
+
+// InvalidateFacets drops every cached facet result. Call it after products,
+// variants or categories change.
+func InvalidateFacets() {
+	facetResults.mu.Lock()
+	defer facetResults.mu.Unlock()
+	facetResults.entries = make(map[string]facetCacheEntry)
+	facetResults.generation++
+}
+
+// ProductFacets returns the facets for active products matching the params, from the
+// cache when possible. Page and Limit are ignored.
+func ProductFacets(db *gorm.DB, params SearchParams) (*Facets, error) {
+	key := facetCacheKey(params)
+
+	facetResults.mu.Lock()
+	entry, ok := facetResults.entries[key]
+	generation := facetResults.generation
+	facetResults.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.facets, nil
+	}
+
+	facets, err := computeFacets(db, params)
+	if err != nil {
+		return nil, err
+	}
+
+	facetResults.mu.Lock()
+	// A write while computing makes the result stale, so it is not kept
+	if facetResults.generation == generation {
+		if len(facetResults.entries) >= maxFacetCacheEntries {
+			facetResults.entries = make(map[string]facetCacheEntry)
+		}
+		ttl := time.Duration(envFloat("FACET_CACHE_TTL_SECONDS", 300)) * time.Second
+		facetResults.entries[key] = facetCacheEntry{facets: facets, expires: time.Now().Add(ttl)}
+	}
+	facetResults.mu.Unlock()
+	return facets, nil
+}
+
+func computeFacets(db *gorm.DB, params SearchParams) (*Facets, error) {
+	// scoped returns the matching products with one of the filters left out
+	scoped := func(omit func(*SearchParams)) *gorm.DB {
+		p := params
+		if omit != nil {
+			omit(&p)
+		}
+		query := applySearchFilters(VisibleProducts(db.Model(&models.Product{}), time.Now()), p)
+		if p.Query != "" {
+			query = query.Where("products.search_vector @@ websearch_to_tsquery('english', ?)", p.Query)
+		}
+		return query
+	}
+
+	facets := &Facets{}
+	if err := scoped(nil).Count(&facets.Total).Error; err != nil {
+		return nil, err
+	}
+
+	var err error
+	if facets.Colors, err = listFacet(scoped(func(p *SearchParams) { p.Colors = nil }), "colors"); err != nil {
+		return nil, err
+	}
+	if facets.Sizes, err = listFacet(scoped(func(p *SearchParams) { p.Sizes = nil }), "sizes"); err != nil {
+		return nil, err
+	}
+
+	facets.Categories = []CategoryFacet{}
+	if err := scoped(func(p *SearchParams) { p.CategoryID = 0 }).
+		Joins("JOIN categories ON categories.id = products.category_id").
+		Select("categories.id, categories.name, categories.slug, COUNT(*) AS count").
+		Group("categories.id, categories.name, categories.slug").
+		Order("count DESC, categories.name").
+		Scan(&facets.Categories).Error; err != nil {
+		return nil, err
+	}
+
+	priced := scoped(func(p *SearchParams) { p.MinPrice, p.MaxPrice = nil, nil })
+	var bounds struct {
+		Min   float64
+		Max   float64
+		Count int64
+	}
+	if err := priced.Session(&gorm.Session{}).
+		Select("COALESCE(MIN(products.price), 0) AS min, COALESCE(MAX(products.price), 0) AS max, COUNT(*) AS count").
+		Scan(&bounds).Error; err != nil {
+		return nil, err
+	}
+	facets.MinPrice, facets.MaxPrice = bounds.Min, bounds.Max
+	facets.PriceBuckets = []PriceBucket{}
+	if bounds.Count == 0 {
+		return facets, nil
+	}
+
+	step := priceBucketStep(bounds.Min, bounds.Max, priceBucketCount)
+	var buckets []struct {
+		Bucket float64
+		Count  int64
+	}
+	if err := priced.Session(&gorm.Session{}).
+		Select("floor(products.price / ?) AS bucket, COUNT(*) AS count", step).
+		Group("bucket").
+		Order("bucket").
+		Scan(&buckets).Error; err != nil {
+		return nil, err
+	}
+	for _, b := range buckets {
+		facets.PriceBuckets = append(facets.PriceBuckets, PriceBucket{
+			Min:   b.Bucket * step,
+			Max:   (b.Bucket + 1) * step,
+			Count: b.Count,
+		})
+	}
+	return facets, nil
+}
+
+// listFacet counts products per value of a JSON list column. Values differing only
+// in case or surrounding space are counted together.
+func listFacet(query *gorm.DB, column string) ([]FacetValue, error) {
+	values := []FacetValue{}
+	err := query.
+		Joins(`CROSS JOIN LATERAL jsonb_array_elements_text(
+			CASE WHEN jsonb_typeof(products.` + column + `) = 'array' THEN products.` + column + ` ELSE '[]'::jsonb END) AS facet(value)`).
+		Select("MIN(btrim(facet.value)) AS value, COUNT(DISTINCT products.id) AS count").
+		Where("btrim(facet.value) <> ''").
+		Group("lower(btrim(facet.value))").
+		Order("count DESC, value").
+		Scan(&values).Error
+	return values, err
+}
+
+// priceBucketStep picks a round bucket width (1, 2, 2.5 or 5 times a power of ten)
+// that splits the price range into at most about n buckets
+func priceBucketStep(min, max float64, n int) float64 {
+	span := max - min
+	if span <= 0 || n <= 0 {
+		if max <= 0 {
+			return 1
+		}
+		span, n = max, 1
+	}
+	raw := span / float64(n)
+	magnitude := math.Pow(10, math.Floor(math.Log10(raw)))
+	for _, m := range []float64{1, 2, 2.5, 5} {
+		if m*magnitude >= raw {
+			return m * magnitude
+		}
+	}
+	return 10 * magnitude
+}
+
+func facetCacheKey(params SearchParams) string {
+	list := func(values []string) string {
+		values = lowerAll(values)
+		sort.Strings(values)
+		return strings.Join(values, ",")
+	}
+	price := func(v *float64) string {
+		if v == nil {
+			return ""
+		}
+		return fmt.Sprint(*v)
+	}
+	return fmt.Sprintf("%s|%d|%s|%s|%s|%s|%t",
+		strings.TrimSpace(params.Query), params.CategoryID,
+		price(params.MinPrice), price(params.MaxPrice),
+		list(params.Colors), list(params.Sizes), params.InStock)
+}
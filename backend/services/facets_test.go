@@ -0,0 +1,39 @@
+package services
+
+import "testing"
+
+func TestPriceBucketStep(t *testing.T) {
+	tests := []struct {
+		name     string
+		min, max float64
+		want     float64
+	}{
+		{"round range", 0, 500, 100},
+		{"uneven range", 45, 380, 100},
+		{"two and a half", 100, 1300, 250},
+		{"small prices", 2.5, 9.99, 2},
+		{"single price", 120, 120, 200},
+		{"no prices", 0, 0, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := priceBucketStep(tt.min, tt.max, priceBucketCount); got != tt.want {
+				t.Errorf("priceBucketStep(%v, %v) = %v, want %v", tt.min, tt.max, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFacetCacheKeyIgnoresListOrderAndCase(t *testing.T) {
+	a := facetCacheKey(SearchParams{Query: "shawl", Colors: []string{"Navy", "camel"}, Page: 1})
+	b := facetCacheKey(SearchParams{Query: " shawl ", Colors: []string{"CAMEL", "navy"}, Page: 3})
+	if a != b {
+		t.Errorf("keys differ: %q and %q", a, b)
+	}
+
+	price := 50.0
+	if c := facetCacheKey(SearchParams{Query: "shawl", Colors: []string{"navy", "camel"}, MinPrice: &price}); c == a {
+		t.Errorf("price filter did not change the key %q", c)
+	}
+}
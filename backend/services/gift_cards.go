@@ -0,0 +1,269 @@
+package services
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"time"
+
+	"pashmina-backend/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	GiftCardPending   = "pending"
+	GiftCardActive    = "active"
+	GiftCardDisabled  = "disabled"
+	GiftCardCancelled = "cancelled"
+)
+
+// giftCardCodeTemplate makes 16 random characters, printed in groups of four
+const giftCardCodeTemplate = "################"
+
+var (
+	ErrGiftCardNotFound = errors.New("gift card not found")
+	ErrGiftCardInactive = errors.New("gift card is not active")
+	ErrGiftCardExpired  = errors.New("gift card has expired")
+	ErrGiftCardEmpty    = errors.New("gift card has no balance left")
+	ErrGiftCardUsed     = errors.New("gift card has already been used")
+)
+
+// GiftCardMinAmount and GiftCardMaxAmount bound the value of a gift card a
+// customer buys
+func GiftCardMinAmount() float64 {
+	return envFloat("GIFT_CARD_MIN_AMOUNT", 500)
+}
+
+func GiftCardMaxAmount() float64 {
+	return envFloat("GIFT_CARD_MAX_AMOUNT", 100000)
+}
+
+// GiftCardValidity returns how long a gift card can be used for once issued
+func GiftCardValidity() time.Duration {
+	days := envFloat("GIFT_CARD_VALIDITY_DAYS", 365)
+	return time.Duration(days * 24 * float64(time.Hour))
+}
+
+// NormalizeGiftCardCode puts a code in stored form, dropping the spaces and dashes
+// it is printed with
+func NormalizeGiftCardCode(code string) string {
+	return strings.ToUpper(strings.NewReplacer(" ", "", "-", "").Replace(strings.TrimSpace(code)))
+}
+
+// FormatGiftCardCode prints a code in groups of four characters
+func FormatGiftCardCode(code string) string {
+	var groups []string
+	for len(code) > 4 {
+		groups = append(groups, code[:4])
+		code = code[4:]
+	}
+	return strings.Join(append(groups, code), "-")
+}
+
+// NewGiftCardCode makes a code no other gift card has
+func NewGiftCardCode(db *gorm.DB) (string, error) {
+	codes, err := GenerateCouponCodes(giftCardCodeTemplate, 1, func(batch []string) (map[string]bool, error) {
+		var existing []string
+		if err := db.Model(&models.GiftCard{}).Where("code IN ?", batch).Pluck("code", &existing).Error; err != nil {
+			return nil, err
+		}
+		taken := make(map[string]bool, len(existing))
+		for _, code := range existing {
+			taken[code] = true
+		}
+		return taken, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return codes[0], nil
+}
+
+// giftCardUsable reports why a gift card can't be spent at now, if it can't
+func giftCardUsable(card *models.GiftCard, now time.Time) error {
+	if card.Status != GiftCardActive {
+		return ErrGiftCardInactive
+	}
+	if card.ExpiresAt != nil && !now.Before(*card.ExpiresAt) {
+		return ErrGiftCardExpired
+	}
+	if card.Balance <= 0 {
+		return ErrGiftCardEmpty
+	}
+	return nil
+}
+
+// FindGiftCard looks a gift card up by its code, as printed or stored
+func FindGiftCard(db *gorm.DB, code string) (*models.GiftCard, error) {
+	var card models.GiftCard
+	err := db.Where("code = ?", NormalizeGiftCardCode(code)).First(&card).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrGiftCardNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &card, nil
+}
+
+// CheckGiftCard returns a gift card that can be spent now
+func CheckGiftCard(db *gorm.DB, code string) (*models.GiftCard, error) {
+	card, err := FindGiftCard(db, code)
+	if err != nil {
+		return nil, err
+	}
+	if err := giftCardUsable(card, time.Now()); err != nil {
+		return card, err
+	}
+	return card, nil
+}
+
+// GiftCardLedger returns a gift card's entries, newest first
+func GiftCardLedger(db *gorm.DB, cardID uint) ([]models.GiftCardEntry, error) {
+	var entries []models.GiftCardEntry
+	err := db.Where("gift_card_id = ?", cardID).Order("id DESC").Find(&entries).Error
+	return entries, err
+}
+
+// AdjustGiftCard adds an entry to a gift card's ledger and moves its balance with
+// it, locking the card while it does
+func AdjustGiftCard(tx *gorm.DB, cardID uint, change LedgerChange) (*models.GiftCardEntry, error) {
+	var card models.GiftCard
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&card, cardID).Error; err != nil {
+		return nil, err
+	}
+	return adjustLockedGiftCard(tx, &card, change)
+}
+
+func adjustLockedGiftCard(tx *gorm.DB, card *models.GiftCard, change LedgerChange) (*models.GiftCardEntry, error) {
+	after, err := nextBalance(card.Balance, change.Amount, change.Overdraw)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Model(card).Update("balance", after).Error; err != nil {
+		return nil, err
+	}
+
+	entry := models.GiftCardEntry{
+		GiftCardID:   card.ID,
+		Amount:       RoundMoney(change.Amount),
+		BalanceAfter: after,
+		Kind:         change.Kind,
+		OrderID:      change.OrderID,
+		Reason:       change.Reason,
+		ActorID:      change.ActorID,
+	}
+	if err := tx.Create(&entry).Error; err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// IssueGiftCard activates a gift card for its initial value, giving it a code if
+// it has none and an expiry GiftCardValidity away if it has none
+func IssueGiftCard(tx *gorm.DB, card *models.GiftCard, change LedgerChange) error {
+	if card.Code == "" {
+		code, err := NewGiftCardCode(tx)
+		if err != nil {
+			return err
+		}
+		card.Code = code
+	}
+	if card.ExpiresAt == nil {
+		expiresAt := time.Now().Add(GiftCardValidity())
+		card.ExpiresAt = &expiresAt
+	}
+	card.Status = GiftCardActive
+	card.Balance = 0
+	if card.ID == 0 {
+		if err := tx.Create(card).Error; err != nil {
+			return err
+		}
+	} else if err := tx.Model(card).Updates(map[string]interface{}{
+		"status":     card.Status,
+		"expires_at": card.ExpiresAt,
+	}).Error; err != nil {
+		return err
+	}
+
+	change.Amount = card.InitialValue
+	change.Kind = LedgerIssue
+	_, err := adjustLockedGiftCard(tx, card, change)
+	return err
+}
+
+// ApplyGiftCard pays as much of what is due on a new order as a gift card covers
+func ApplyGiftCard(tx *gorm.DB, order *models.Order, code string) error {
+	var card models.GiftCard
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("code = ?", NormalizeGiftCardCode(code)).First(&card).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrGiftCardNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if err := giftCardUsable(&card, time.Now()); err != nil {
+		return err
+	}
+
+	amount := RoundMoney(math.Min(card.Balance, AmountDue(order)))
+	if amount <= 0 {
+		return nil
+	}
+	if _, err := adjustLockedGiftCard(tx, &card, LedgerChange{
+		Amount:  -amount,
+		Kind:    LedgerOrderPayment,
+		OrderID: &order.ID,
+	}); err != nil {
+		return err
+	}
+	order.GiftCardID = &card.ID
+	order.GiftCardAmount = amount
+	return nil
+}
+
+// ActivateOrderGiftCards issues the gift cards bought with an order once it is
+// paid, returning those it issued
+func ActivateOrderGiftCards(tx *gorm.DB, order *models.Order) ([]models.GiftCard, error) {
+	var cards []models.GiftCard
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("order_id = ? AND status = ?", order.ID, GiftCardPending).Find(&cards).Error; err != nil {
+		return nil, err
+	}
+	for i := range cards {
+		if err := IssueGiftCard(tx, &cards[i], LedgerChange{OrderID: &order.ID, Reason: "Bought with the order"}); err != nil {
+			return nil, err
+		}
+	}
+	return cards, nil
+}
+
+// CancelOrderGiftCards cancels the gift cards bought with an order. It returns
+// ErrGiftCardUsed when any has been spent from, since those can't be taken back.
+func CancelOrderGiftCards(tx *gorm.DB, order *models.Order, change LedgerChange) error {
+	var cards []models.GiftCard
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("order_id = ? AND status <> ?", order.ID, GiftCardCancelled).Find(&cards).Error; err != nil {
+		return err
+	}
+	for i := range cards {
+		card := &cards[i]
+		if card.Status != GiftCardPending {
+			if card.Balance < card.InitialValue {
+				return ErrGiftCardUsed
+			}
+			change.Amount = -card.Balance
+			change.Kind = LedgerVoid
+			if _, err := adjustLockedGiftCard(tx, card, change); err != nil {
+				return err
+			}
+		}
+		if err := tx.Model(card).Update("status", GiftCardCancelled).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"pashmina-backend/models"
+)
+
+func TestGiftCardCodes(t *testing.T) {
+	tests := []struct {
+		code string
+		want string
+	}{
+		{"ABCD-EFGH-JKMN-PQRS", "ABCDEFGHJKMNPQRS"},
+		{" abcd efgh jkmn pqrs ", "ABCDEFGHJKMNPQRS"},
+		{"ABCDEFGHJKMNPQRS", "ABCDEFGHJKMNPQRS"},
+	}
+	for _, tt := range tests {
+		if got := NormalizeGiftCardCode(tt.code); got != tt.want {
+			t.Errorf("NormalizeGiftCardCode(%q) = %q, want %q", tt.code, got, tt.want)
+		}
+	}
+
+	if got := FormatGiftCardCode("ABCDEFGHJKMNPQRS"); got != "ABCD-EFGH-JKMN-PQRS" {
+		t.Errorf("FormatGiftCardCode = %q, want ABCD-EFGH-JKMN-PQRS", got)
+	}
+	if got := FormatGiftCardCode("ABCDEF"); got != "ABCD-EF" {
+		t.Errorf("FormatGiftCardCode = %q, want ABCD-EF", got)
+	}
+}
+
+func TestGiftCardUsable(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	later, earlier := now.Add(time.Hour), now.Add(-time.Hour)
+	card := func(change func(*models.GiftCard)) *models.GiftCard {
+		g := &models.GiftCard{Status: GiftCardActive, Balance: 500, ExpiresAt: &later}
+		change(g)
+		return g
+	}
+
+	tests := []struct {
+		name string
+		card *models.GiftCard
+		want error
+	}{
+		{"usable", card(func(g *models.GiftCard) {}), nil},
+		{"no expiry", card(func(g *models.GiftCard) { g.ExpiresAt = nil }), nil},
+		{"pending", card(func(g *models.GiftCard) { g.Status = GiftCardPending }), ErrGiftCardInactive},
+		{"disabled", card(func(g *models.GiftCard) { g.Status = GiftCardDisabled }), ErrGiftCardInactive},
+		{"expired", card(func(g *models.GiftCard) { g.ExpiresAt = &earlier }), ErrGiftCardExpired},
+		{"expires now", card(func(g *models.GiftCard) { g.ExpiresAt = &now }), ErrGiftCardExpired},
+		{"spent", card(func(g *models.GiftCard) { g.Balance = 0 }), ErrGiftCardEmpty},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := giftCardUsable(tt.card, now); got != tt.want {
+				t.Errorf("giftCardUsable = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
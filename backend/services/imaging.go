@@ -0,0 +1,351 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"pashmina-backend/models"
+	"pashmina-backend/utils"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+	"golang.org/x/sync/singleflight"
+)
+
+var (
+	// ErrImageTooLarge is returned for source images over IMAGE_MAX_PIXELS, which are
+	// not decoded to protect memory
+	ErrImageTooLarge = errors.New("image is too large to resize")
+
+	contentHashName = regexp.MustCompile(`^[0-9a-f]{64}$`)
+)
+
+// imageEncoder writes one output format
+type imageEncoder struct {
+	contentType string
+	ext         string
+	encode      func(buf *bytes.Buffer, img image.Image) error
+}
+
+// imageEncoders are the formats variants can be written in. WebP is added when
+// cwebp is installed, since there is no pure-Go WebP encoder.
+var imageEncoders = map[string]imageEncoder{
+	"jpeg": {"image/jpeg", ".jpg", func(buf *bytes.Buffer, img image.Image) error {
+		return jpeg.Encode(buf, img, &jpeg.Options{Quality: int(envFloat("IMAGE_JPEG_QUALITY", 82))})
+	}},
+	"png": {"image/png", ".png", func(buf *bytes.Buffer, img image.Image) error {
+		return (&png.Encoder{CompressionLevel: png.BestCompression}).Encode(buf, img)
+	}},
+}
+
+func init() {
+	if cwebp := cwebpPath(); cwebp != "" {
+		imageEncoders["webp"] = imageEncoder{"image/webp", ".webp", func(buf *bytes.Buffer, img image.Image) error {
+			return encodeWebP(cwebp, buf, img)
+		}}
+	}
+}
+
+// cwebpPath returns libwebp's cwebp encoder from IMAGE_CWEBP_PATH or the PATH, or
+// "" when it isn't installed
+func cwebpPath() string {
+	if path := os.Getenv("IMAGE_CWEBP_PATH"); path != "" {
+		return path
+	}
+	path, _ := exec.LookPath("cwebp")
+	return path
+}
+
+// encodeWebP hands the image to cwebp as PNG, which loses nothing, and reads the
+// WebP back from its output
+func encodeWebP(cwebp string, buf *bytes.Buffer, img image.Image) error {
+	var input bytes.Buffer
+	if err := png.Encode(&input, img); err != nil {
+		return err
+	}
+
+	quality := strconv.Itoa(int(envFloat("IMAGE_WEBP_QUALITY", 80)))
+	var stderr bytes.Buffer
+	cmd := exec.Command(cwebp, "-quiet", "-q", quality, "-o", "-", "--", "-")
+	cmd.Stdin = &input
+	cmd.Stdout = buf
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("cwebp: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// ImageVariant is an image ready to serve
+type ImageVariant struct {
+	Data        []byte
+	ContentType string
+	ETag        string
+	// Immutable is set when the URL names the content by its hash, so the response
+	// can be cached indefinitely
+	Immutable bool
+}
+
+// ImagePipeline serves uploaded images and width-bounded variants of them. Variants
+// are generated on first request and kept in a disk cache named by the source's
+// content hash, width and format.
+type ImagePipeline struct {
+	source Storage
+	cache  *LocalStorage
+	widths []int
+	group  singleflight.Group
+}
+
+// NewImagePipeline returns a pipeline over the media storage. Widths requested are
+// rounded up to one of IMAGE_WIDTHS so the cache stays bounded.
+func NewImagePipeline(source Storage) *ImagePipeline {
+	dir := os.Getenv("IMAGE_CACHE_DIR")
+	if dir == "" {
+		dir = "tmp/image-cache"
+	}
+	return &ImagePipeline{
+		source: source,
+		cache:  &LocalStorage{Dir: dir},
+		widths: envWidths("IMAGE_WIDTHS", []int{200, 400, 800, 1200, 1600}),
+	}
+}
+
+// Image returns the stored file for key, or a variant of it at most width pixels wide
+// when width is positive. format asks for "jpeg", "png" or "webp"; otherwise the
+// Accept header and the source type decide. Sources that cannot be decoded are
+// returned as they are.
+func (p *ImagePipeline) Image(ctx context.Context, key string, width int, format, accept string) (*ImageVariant, error) {
+	hash := hashFromKey(key)
+	var original []byte
+	if hash == "" {
+		data, err := p.source.Get(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		original, hash = data, sha256Hex(data)
+	}
+
+	if width <= 0 {
+		return p.original(ctx, key, hash, original)
+	}
+	width = snapWidth(p.widths, width)
+
+	sourceType := contentTypeForExt(path.Ext(key))
+	if sourceType == "" {
+		if original == nil {
+			data, err := p.source.Get(ctx, key)
+			if err != nil {
+				return nil, err
+			}
+			original = data
+		}
+		sourceType = http.DetectContentType(original)
+	}
+	name := chooseImageFormat(sourceType, format, accept)
+	encoder := imageEncoders[name]
+
+	cacheKey := fmt.Sprintf("%s/%s-w%d%s", hash[:2], hash, width, encoder.ext)
+	variant := &ImageVariant{
+		ContentType: encoder.contentType,
+		ETag:        `"` + hash[:16] + "-w" + strconv.Itoa(width) + encoder.ext + `"`,
+		Immutable:   hashFromKey(key) != "",
+	}
+	if data, err := p.cache.Get(ctx, cacheKey); err == nil {
+		variant.Data = data
+		return variant, nil
+	}
+
+	// Concurrent requests for the same variant share one generation, which must not
+	// fail because the first requester went away
+	genCtx := context.WithoutCancel(ctx)
+	data, err, _ := p.group.Do(cacheKey, func() (interface{}, error) {
+		source := original
+		if source == nil {
+			var err error
+			if source, err = p.source.Get(genCtx, key); err != nil {
+				return nil, err
+			}
+		}
+		data, err := resizeImage(source, width, encoder)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.cache.Put(genCtx, cacheKey, encoder.contentType, data); err != nil {
+			utils.Warn("Failed to cache image variant", map[string]interface{}{"key": cacheKey, "error": err.Error()})
+		}
+		return data, nil
+	})
+	if errors.Is(err, image.ErrFormat) {
+		return p.original(ctx, key, hash, original)
+	}
+	if err != nil {
+		return nil, err
+	}
+	variant.Data = data.([]byte)
+	return variant, nil
+}
+
+func (p *ImagePipeline) original(ctx context.Context, key, hash string, data []byte) (*ImageVariant, error) {
+	if data == nil {
+		var err error
+		if data, err = p.source.Get(ctx, key); err != nil {
+			return nil, err
+		}
+	}
+	return &ImageVariant{
+		Data:        data,
+		ContentType: http.DetectContentType(data),
+		ETag:        `"` + hash[:16] + `"`,
+		Immutable:   hashFromKey(key) != "",
+	}, nil
+}
+
+// Pregenerate builds the IMAGE_PREGENERATE_WIDTHS variants of a new upload so the
+// first visitors don't wait for them
+func (p *ImagePipeline) Pregenerate(ctx context.Context, media *models.Media) {
+	for _, width := range envWidths("IMAGE_PREGENERATE_WIDTHS", []int{400, 800}) {
+		if media.Width > 0 && width >= media.Width {
+			continue
+		}
+		if _, err := p.Image(ctx, media.Key, width, "", ""); err != nil {
+			utils.Warn("Failed to pregenerate image variant", map[string]interface{}{
+				"key": media.Key, "width": width, "error": err.Error(),
+			})
+			return
+		}
+	}
+}
+
+// Purge deletes the cached variants of a stored file
+func (p *ImagePipeline) Purge(key string) {
+	hash := hashFromKey(key)
+	if hash == "" {
+		return
+	}
+	files, _ := filepath.Glob(filepath.Join(p.cache.Dir, hash[:2], hash+"-*"))
+	for _, file := range files {
+		os.Remove(file)
+	}
+}
+
+// resizeImage decodes an image, scales it down to width if it is wider and encodes it
+func resizeImage(data []byte, width int, encoder imageEncoder) ([]byte, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if float64(config.Width)*float64(config.Height) > envFloat("IMAGE_MAX_PIXELS", 40e6) {
+		return nil, ErrImageTooLarge
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := src.Bounds()
+	if width > bounds.Dx() {
+		width = bounds.Dx()
+	}
+	height := (bounds.Dy()*width + bounds.Dx()/2) / bounds.Dx()
+	if height < 1 {
+		height = 1
+	}
+	rect := image.Rect(0, 0, width, height)
+
+	var dst draw.Image
+	if encoder.contentType != "image/jpeg" {
+		dst = image.NewNRGBA(rect)
+	} else {
+		// JPEG has no transparency; flatten onto white rather than black
+		rgba := image.NewRGBA(rect)
+		draw.Draw(rgba, rect, image.NewUniform(color.White), image.Point{}, draw.Src)
+		dst = rgba
+	}
+	draw.CatmullRom.Scale(dst, rect, src, bounds, draw.Over, nil)
+
+	var buf bytes.Buffer
+	if err := encoder.encode(&buf, dst); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// chooseImageFormat picks the output format: the one asked for if it can be written,
+// then WebP if the client accepts it, then PNG for sources that may be transparent
+// and JPEG for the rest
+func chooseImageFormat(sourceType, format, accept string) string {
+	if _, ok := imageEncoders[format]; ok {
+		return format
+	}
+	if _, ok := imageEncoders["webp"]; ok && strings.Contains(accept, "image/webp") {
+		return "webp"
+	}
+	if sourceType == "image/png" || sourceType == "image/gif" {
+		return "png"
+	}
+	return "jpeg"
+}
+
+// snapWidth rounds a requested width up to the nearest allowed one, capped at the
+// largest
+func snapWidth(widths []int, width int) int {
+	for _, w := range widths {
+		if w >= width {
+			return w
+		}
+	}
+	return widths[len(widths)-1]
+}
+
+// hashFromKey returns the content hash a media key is named by, or "" for keys that
+// are not content-addressed
+func hashFromKey(key string) string {
+	name := strings.TrimSuffix(path.Base(key), path.Ext(key))
+	if contentHashName.MatchString(name) {
+		return name
+	}
+	return ""
+}
+
+func contentTypeForExt(ext string) string {
+	ext = strings.ToLower(ext)
+	if ext == ".jpeg" {
+		ext = ".jpg"
+	}
+	for contentType, e := range mediaExtensions {
+		if e == ext {
+			return contentType
+		}
+	}
+	return ""
+}
+
+// envWidths reads a comma-separated list of pixel widths, sorted ascending
+func envWidths(key string, fallback []int) []int {
+	var widths []int
+	for _, field := range strings.Split(os.Getenv(key), ",") {
+		if w, err := strconv.Atoi(strings.TrimSpace(field)); err == nil && w > 0 {
+			widths = append(widths, w)
+		}
+	}
+	if len(widths) == 0 {
+		return fallback
+	}
+	sort.Ints(widths)
+	return widths
+}
@@ -0,0 +1,158 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSnapWidth(t *testing.T) {
+	widths := []int{200, 400, 800}
+	tests := []struct{ in, want int }{
+		{1, 200},
+		{200, 200},
+		{201, 400},
+		{750, 800},
+		{5000, 800},
+	}
+	for _, tt := range tests {
+		if got := snapWidth(widths, tt.in); got != tt.want {
+			t.Errorf("snapWidth(%d) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestChooseImageFormat(t *testing.T) {
+	tests := []struct {
+		name                       string
+		sourceType, format, accept string
+		want                       string
+	}{
+		{"jpeg source", "image/jpeg", "", "", "jpeg"},
+		{"png keeps transparency", "image/png", "", "", "png"},
+		{"gif becomes png", "image/gif", "", "", "png"},
+		{"explicit format", "image/png", "jpeg", "", "jpeg"},
+		{"webp falls back without an encoder", "image/jpeg", "webp", "image/webp,*/*", "jpeg"},
+		{"unknown format ignored", "image/jpeg", "tiff", "", "jpeg"},
+	}
+
+	// Whether WebP can be written depends on cwebp being installed
+	webp, hasWebP := imageEncoders["webp"]
+	delete(imageEncoders, "webp")
+	defer func() {
+		if hasWebP {
+			imageEncoders["webp"] = webp
+		}
+	}()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := chooseImageFormat(tt.sourceType, tt.format, tt.accept); got != tt.want {
+				t.Errorf("chooseImageFormat = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	imageEncoders["webp"] = imageEncoder{contentType: "image/webp", ext: ".webp"}
+	if got := chooseImageFormat("image/png", "", "image/avif,image/webp,*/*"); got != "webp" {
+		t.Errorf("chooseImageFormat with a WebP encoder = %q, want webp", got)
+	}
+	delete(imageEncoders, "webp")
+}
+
+func TestEncodeWebP(t *testing.T) {
+	cwebp := cwebpPath()
+	if cwebp == "" {
+		t.Skip("cwebp is not installed")
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, 30, 20))
+	for x := 0; x < 30; x++ {
+		img.Set(x, 5, color.NRGBA{R: 200, G: 40, B: 40, A: 128})
+	}
+	var buf bytes.Buffer
+	if err := encodeWebP(cwebp, &buf, img); err != nil {
+		t.Fatalf("encodeWebP: %v", err)
+	}
+	config, format, err := image.DecodeConfig(bytes.NewReader(buf.Bytes()))
+	if err != nil || format != "webp" || config.Width != 30 || config.Height != 20 {
+		t.Fatalf("encoded image is %s %dx%d (%v), want webp 30x20", format, config.Width, config.Height, err)
+	}
+
+	if err := encodeWebP(filepath.Join(t.TempDir(), "missing-cwebp"), &buf, img); err == nil {
+		t.Error("encodeWebP succeeded without an encoder to run")
+	}
+}
+
+func TestHashFromKey(t *testing.T) {
+	hash := strings.Repeat("ab", 32)
+	if got := hashFromKey("media/ab/" + hash + ".jpg"); got != hash {
+		t.Errorf("hashFromKey(content-addressed) = %q", got)
+	}
+	if got := hashFromKey("legacy/photo.jpg"); got != "" {
+		t.Errorf("hashFromKey(legacy) = %q, want empty", got)
+	}
+}
+
+func TestImagePipelineResizesAndCaches(t *testing.T) {
+	ctx := context.Background()
+	source := &LocalStorage{Dir: t.TempDir()}
+	cache := &LocalStorage{Dir: t.TempDir()}
+	pipeline := &ImagePipeline{source: source, cache: cache, widths: []int{40, 200}}
+
+	img := image.NewNRGBA(image.Rect(0, 0, 100, 50))
+	for x := 0; x < 100; x++ {
+		for y := 0; y < 50; y++ {
+			img.Set(x, y, color.NRGBA{R: uint8(x * 2), G: 100, B: 50, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	png.Encode(&buf, img)
+	hash := sha256Hex(buf.Bytes())
+	key := "media/" + hash[:2] + "/" + hash + ".png"
+	if err := source.Put(ctx, key, "image/png", buf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+
+	variant, err := pipeline.Image(ctx, key, 30, "jpeg", "")
+	if err != nil {
+		t.Fatalf("Image: %v", err)
+	}
+	if variant.ContentType != "image/jpeg" || !variant.Immutable {
+		t.Errorf("variant = %s, immutable %v", variant.ContentType, variant.Immutable)
+	}
+	config, format, err := image.DecodeConfig(bytes.NewReader(variant.Data))
+	if err != nil || format != "jpeg" || config.Width != 40 || config.Height != 20 {
+		t.Fatalf("variant is %s %dx%d (%v), want jpeg 40x20", format, config.Width, config.Height, err)
+	}
+
+	cached := filepath.Join(cache.Dir, hash[:2], hash+"-w40.jpg")
+	if _, err := os.Stat(cached); err != nil {
+		t.Fatalf("variant not cached: %v", err)
+	}
+
+	// Wider than the source is served at the source's size
+	wide, err := pipeline.Image(ctx, key, 150, "", "")
+	if err != nil {
+		t.Fatalf("Image: %v", err)
+	}
+	if config, _, _ := image.DecodeConfig(bytes.NewReader(wide.Data)); config.Width != 100 {
+		t.Errorf("upscaled to %d, want the source width 100", config.Width)
+	}
+
+	original, err := pipeline.Image(ctx, key, 0, "", "")
+	if err != nil || !bytes.Equal(original.Data, buf.Bytes()) || original.ContentType != "image/png" {
+		t.Errorf("original = %s, %v", original.ContentType, err)
+	}
+
+	pipeline.Purge(key)
+	if _, err := os.Stat(cached); !os.IsNotExist(err) {
+		t.Errorf("variant still cached after Purge: %v", err)
+	}
+}
@@ -0,0 +1,159 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+
+	"pashmina-backend/models"
+
+	"gorm.io/gorm"
+)
+
+var ErrVariantNotFound = errors.New("variant not found")
+
+// InsufficientStockError is returned when a line asks for more units than are on hand
+type InsufficientStockError struct {
+	ProductID uint
+	VariantID *uint
+	Name      string
+	Available int
+	Requested int
+}
+
+func (e *InsufficientStockError) Error() string {
+	return fmt.Sprintf("insufficient stock for %s: %d available, %d requested", e.Name, e.Available, e.Requested)
+}
+
+// FindVariant loads the variant for a cart line, locking it for update when lock is
+// set. An explicit variant ID wins; otherwise the variant is matched by color and
+// size. It returns nil without error when the product has no active variants.
+func FindVariant(tx *gorm.DB, lock bool, productID uint, variantID *uint, color, size string) (*models.ProductVariant, error) {
+	locked := forUpdate(tx, lock)
+
+	var variant models.ProductVariant
+	if variantID != nil {
+		if err := locked.Where("id = ? AND product_id = ? AND is_active = ?", *variantID, productID, true).
+			First(&variant).Error; err != nil {
+			return nil, ErrVariantNotFound
+		}
+		return &variant, nil
+	}
+
+	// Inactive variants can't be bought, so a product whose variants are all
+	// inactive is sold as a product without variants
+	count, err := activeVariantCount(tx, productID)
+	if err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return nil, nil
+	}
+
+	if err := locked.Where("product_id = ? AND color = ? AND size = ? AND is_active = ?", productID, color, size, true).
+		First(&variant).Error; err != nil {
+		return nil, ErrVariantNotFound
+	}
+	return &variant, nil
+}
+
+func activeVariantCount(tx *gorm.DB, productID uint) (int64, error) {
+	var count int64
+	err := tx.Model(&models.ProductVariant{}).Where("product_id = ? AND is_active = ?", productID, true).Count(&count).Error
+	return count, err
+}
+
+// DecrementStock removes a priced line's quantity from its variant (when present)
+// and from the product's aggregate stock
+func DecrementStock(tx *gorm.DB, line PricedLine) error {
+	available := line.Product.Stock
+	if line.Variant != nil {
+		available = line.Variant.Stock
+	}
+
+	if available < line.Quantity {
+		return &InsufficientStockError{
+			ProductID: line.ProductID,
+			VariantID: line.VariantID,
+			Name:      line.Name,
+			Available: available,
+			Requested: line.Quantity,
+		}
+	}
+
+	if line.Variant != nil {
+		if err := tx.Model(line.Variant).
+			UpdateColumn("stock", gorm.Expr("stock - ?", line.Quantity)).Error; err != nil {
+			return err
+		}
+		line.Variant.Stock -= line.Quantity
+	}
+
+	if err := tx.Model(line.Product).
+		UpdateColumn("stock", gorm.Expr("stock - ?", line.Quantity)).Error; err != nil {
+		return err
+	}
+	line.Product.Stock -= line.Quantity
+	return nil
+}
+
+// RestockItem puts an order item's quantity back on its variant and product
+func RestockItem(tx *gorm.DB, item models.OrderItem) error {
+	if item.VariantID != nil {
+		if err := tx.Model(&models.ProductVariant{}).Where("id = ?", *item.VariantID).
+			UpdateColumn("stock", gorm.Expr("stock + ?", item.Quantity)).Error; err != nil {
+			return err
+		}
+	}
+
+	return tx.Model(&models.Product{}).Where("id = ?", item.ProductID).
+		UpdateColumn("stock", gorm.Expr("stock + ?", item.Quantity)).Error
+}
+
+// SyncProductFromVariants recomputes a product's aggregate stock, colors and sizes
+// from its active variants. A product whose variants have all been deactivated or
+// deleted is left with no stock, colors or sizes, since those described variants it
+// no longer sells. Products that never had variants are left untouched.
+func SyncProductFromVariants(tx *gorm.DB, productID uint) error {
+	var variants []models.ProductVariant
+	if err := tx.Where("product_id = ? AND is_active = ?", productID, true).Find(&variants).Error; err != nil {
+		return err
+	}
+	if len(variants) == 0 {
+		var count int64
+		if err := tx.Unscoped().Model(&models.ProductVariant{}).Where("product_id = ?", productID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			return nil
+		}
+	}
+
+	stock, colors, sizes := variantTotals(variants)
+	return tx.Model(&models.Product{}).Where("id = ?", productID).Updates(map[string]interface{}{
+		"stock":  stock,
+		"colors": colors,
+		"sizes":  sizes,
+	}).Error
+}
+
+// variantTotals adds up the stock of variants and lists their colors and sizes in
+// the order they first appear
+func variantTotals(variants []models.ProductVariant) (int, models.StringArray, models.StringArray) {
+	stock := 0
+	colors := models.StringArray{}
+	sizes := models.StringArray{}
+	seenColor := map[string]bool{}
+	seenSize := map[string]bool{}
+	for _, v := range variants {
+		stock += v.Stock
+		if v.Color != "" && !seenColor[v.Color] {
+			seenColor[v.Color] = true
+			colors = append(colors, v.Color)
+		}
+		if v.Size != "" && !seenSize[v.Size] {
+			seenSize[v.Size] = true
+			sizes = append(sizes, v.Size)
+		}
+	}
+	return stock, colors, sizes
+}
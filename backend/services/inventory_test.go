@@ -0,0 +1,48 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+
+	"pashmina-backend/models"
+)
+
+func TestVariantTotals(t *testing.T) {
+	tests := []struct {
+		name      string
+		variants  []models.ProductVariant
+		wantStock int
+		wantColor models.StringArray
+		wantSize  models.StringArray
+	}{
+		{"no variants left", nil, 0, models.StringArray{}, models.StringArray{}},
+		{
+			"colors and sizes in first-seen order",
+			[]models.ProductVariant{
+				{Color: "Camel", Size: "M", Stock: 4},
+				{Color: "Ivory", Size: "S", Stock: 2},
+				{Color: "Camel", Size: "S", Stock: 1},
+			},
+			7, models.StringArray{"Camel", "Ivory"}, models.StringArray{"M", "S"},
+		},
+		{
+			"blank color or size",
+			[]models.ProductVariant{{Size: "Free", Stock: 3}, {Color: "Rust", Stock: 0}},
+			3, models.StringArray{"Rust"}, models.StringArray{"Free"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stock, colors, sizes := variantTotals(tt.variants)
+			if stock != tt.wantStock {
+				t.Errorf("stock = %d, want %d", stock, tt.wantStock)
+			}
+			if !reflect.DeepEqual(colors, tt.wantColor) {
+				t.Errorf("colors = %v, want %v", colors, tt.wantColor)
+			}
+			if !reflect.DeepEqual(sizes, tt.wantSize) {
+				t.Errorf("sizes = %v, want %v", sizes, tt.wantSize)
+			}
+		})
+	}
+}
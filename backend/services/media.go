@@ -0,0 +1,300 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"net/http"
+	"time"
+
+	"pashmina-backend/models"
+	"pashmina-backend/utils"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrUnsupportedMedia is returned for uploads that are not a supported image type
+	ErrUnsupportedMedia = errors.New("unsupported file type; upload a JPEG, PNG, GIF or WebP image")
+	// ErrMediaTooLarge is returned for uploads over MaxUploadSize
+	ErrMediaTooLarge = errors.New("file is too large")
+	// ErrImageNotFound is returned when a gallery image does not belong to the product
+	ErrImageNotFound = errors.New("image not found")
+)
+
+// mediaExtensions are the accepted upload types, as sniffed from the content, and the
+// extension they are stored with
+var mediaExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// MaxUploadSize returns the largest accepted upload in bytes
+func MaxUploadSize() int64 {
+	return int64(envFloat("MEDIA_MAX_UPLOAD_MB", 10) * 1024 * 1024)
+}
+
+// SniffImageType returns the content type of an upload judged from its bytes, never
+// from the name or the type the client claimed
+func SniffImageType(data []byte) (string, error) {
+	contentType := http.DetectContentType(data)
+	if _, ok := mediaExtensions[contentType]; !ok {
+		return "", ErrUnsupportedMedia
+	}
+	return contentType, nil
+}
+
+// StoreMedia checks an uploaded image, writes it to storage and records it. An image
+// already uploaded returns the existing record.
+func StoreMedia(ctx context.Context, db *gorm.DB, storage Storage, data []byte, uploadedBy *uint) (*models.Media, error) {
+	if int64(len(data)) > MaxUploadSize() {
+		return nil, ErrMediaTooLarge
+	}
+	contentType, err := SniffImageType(data)
+	if err != nil {
+		return nil, err
+	}
+
+	media := models.Media{
+		ContentType: contentType,
+		Size:        int64(len(data)),
+		Checksum:    sha256Hex(data),
+		UploadedBy:  uploadedBy,
+	}
+
+	var existing models.Media
+	if err := db.Where("checksum = ?", media.Checksum).First(&existing).Error; err == nil {
+		return &existing, nil
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupportedMedia
+	}
+	media.Width, media.Height = config.Width, config.Height
+
+	media.Key = "media/" + media.Checksum[:2] + "/" + media.Checksum + mediaExtensions[contentType]
+	media.URL = storage.URL(media.Key)
+	if err := storage.Put(ctx, media.Key, contentType, data); err != nil {
+		return nil, err
+	}
+
+	// A concurrent upload of the same file may have been recorded meanwhile
+	if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&media).Error; err != nil {
+		return nil, err
+	}
+	if media.ID == 0 {
+		if err := db.Where("checksum = ?", media.Checksum).First(&media).Error; err != nil {
+			return nil, err
+		}
+	}
+	return &media, nil
+}
+
+// AddProductImage appends a media file to a product's gallery. The first image, or
+// one added as primary, becomes the product's main image.
+func AddProductImage(db *gorm.DB, productID uint, media *models.Media, altText string, primary bool) (*models.ProductImage, error) {
+	image := models.ProductImage{
+		ProductID: productID,
+		MediaID:   media.ID,
+		URL:       media.URL,
+		AltText:   altText,
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// Lock the product so concurrent uploads get distinct positions
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&models.Product{}, productID).Error; err != nil {
+			return err
+		}
+
+		var count int64
+		tx.Model(&models.ProductImage{}).Where("product_id = ?", productID).Count(&count)
+		var last struct{ Position int }
+		tx.Model(&models.ProductImage{}).Select("COALESCE(MAX(position), -1) AS position").
+			Where("product_id = ?", productID).Scan(&last)
+		image.Position = last.Position + 1
+		image.IsPrimary = primary || count == 0
+
+		if image.IsPrimary {
+			if err := tx.Model(&models.ProductImage{}).Where("product_id = ?", productID).
+				Update("is_primary", false).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Create(&image).Error; err != nil {
+			return err
+		}
+		return syncPrimaryImage(tx, productID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &image, nil
+}
+
+// UpdateProductImage changes a gallery image's alt text and, when primary is set,
+// makes it the product's main image
+func UpdateProductImage(db *gorm.DB, productID, imageID uint, altText *string, primary bool) (*models.ProductImage, error) {
+	var image models.ProductImage
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("id = ? AND product_id = ?", imageID, productID).First(&image).Error; err != nil {
+			return ErrImageNotFound
+		}
+		if altText != nil {
+			image.AltText = *altText
+		}
+		if primary && !image.IsPrimary {
+			if err := tx.Model(&models.ProductImage{}).Where("product_id = ?", productID).
+				Update("is_primary", false).Error; err != nil {
+				return err
+			}
+			image.IsPrimary = true
+		}
+		if err := tx.Save(&image).Error; err != nil {
+			return err
+		}
+		return syncPrimaryImage(tx, productID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &image, nil
+}
+
+// ReorderProductImages sets gallery positions from a list of image IDs. Every image
+// of the product must be listed exactly once.
+func ReorderProductImages(db *gorm.DB, productID uint, imageIDs []uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var images []models.ProductImage
+		if err := tx.Where("product_id = ?", productID).Find(&images).Error; err != nil {
+			return err
+		}
+		if len(images) != len(imageIDs) {
+			return errors.New("image_ids must list every image of the product once")
+		}
+		position := make(map[uint]int, len(imageIDs))
+		for i, id := range imageIDs {
+			position[id] = i
+		}
+		for _, image := range images {
+			p, ok := position[image.ID]
+			if !ok {
+				return errors.New("image_ids must list every image of the product once")
+			}
+			if err := tx.Model(&image).Update("position", p).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// RemoveProductImage takes an image out of a product's gallery. The file stays in
+// storage until the orphan sweep finds nothing else uses it.
+func RemoveProductImage(db *gorm.DB, productID, imageID uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var image models.ProductImage
+		if err := tx.Where("id = ? AND product_id = ?", imageID, productID).First(&image).Error; err != nil {
+			return ErrImageNotFound
+		}
+		if err := tx.Delete(&image).Error; err != nil {
+			return err
+		}
+
+		// Clear the main image if it was this one; syncPrimaryImage fills it from the
+		// next image, so it stays empty only when the gallery is
+		if err := tx.Model(&models.Product{}).Where("id = ? AND image = ?", productID, image.URL).
+			Update("image", "").Error; err != nil {
+			return err
+		}
+		return syncPrimaryImage(tx, productID)
+	})
+}
+
+// syncPrimaryImage makes sure a product with images has exactly one primary, the
+// first by position if none is marked, and copies its URL to Product.Image. A product
+// without images keeps whatever Image it has.
+func syncPrimaryImage(tx *gorm.DB, productID uint) error {
+	var images []models.ProductImage
+	if err := tx.Where("product_id = ?", productID).Order("is_primary DESC, position ASC, id ASC").
+		Find(&images).Error; err != nil {
+		return err
+	}
+	if len(images) == 0 {
+		return nil
+	}
+
+	primary := images[0]
+	if !primary.IsPrimary {
+		if err := tx.Model(&primary).Update("is_primary", true).Error; err != nil {
+			return err
+		}
+	}
+	return tx.Model(&models.Product{}).Where("id = ?", productID).Update("image", primary.URL).Error
+}
+
+// OrphanedMedia returns media older than the grace period that no gallery image and
+// no product, category, catalogue or page image refers to. The grace period keeps
+// files uploaded for a form that has not been saved yet.
+func OrphanedMedia(db *gorm.DB, grace time.Duration) ([]models.Media, error) {
+	var orphans []models.Media
+	err := db.Where("media.created_at < ?", time.Now().Add(-grace)).
+		Where("NOT EXISTS (SELECT 1 FROM product_images pi WHERE pi.media_id = media.id)").
+		Where("NOT EXISTS (SELECT 1 FROM products p WHERE p.image = media.url)").
+		Where("NOT EXISTS (SELECT 1 FROM categories c WHERE c.image = media.url)").
+		Where("NOT EXISTS (SELECT 1 FROM catalogues c WHERE c.image = media.url)").
+		Where("NOT EXISTS (SELECT 1 FROM page_contents pc WHERE pc.image = media.url)").
+		Find(&orphans).Error
+	return orphans, err
+}
+
+// MediaOrphanGrace returns how long an unreferenced upload is kept
+func MediaOrphanGrace() time.Duration {
+	return time.Duration(envFloat("MEDIA_ORPHAN_GRACE_HOURS", 24) * float64(time.Hour))
+}
+
+// DeleteOrphanedMedia removes orphaned media files and their cached variants from
+// storage and their records, and returns how many it removed
+func DeleteOrphanedMedia(ctx context.Context, db *gorm.DB, storage Storage, images *ImagePipeline, grace time.Duration) (int, error) {
+	orphans, err := OrphanedMedia(db, grace)
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, media := range orphans {
+		// The record goes first: if a gallery image took the file since the query,
+		// its foreign key fails the delete and the file is kept
+		if err := db.Delete(&media).Error; err != nil {
+			utils.Warn("Skipped orphaned media", map[string]interface{}{"key": media.Key, "error": err.Error()})
+			continue
+		}
+		if err := storage.Delete(ctx, media.Key); err != nil {
+			utils.Warn("Failed to delete orphaned media file", map[string]interface{}{"key": media.Key, "error": err.Error()})
+			continue
+		}
+		if images != nil {
+			images.Purge(media.Key)
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// StartMediaSweeper deletes orphaned media every interval
+func StartMediaSweeper(db *gorm.DB, storage Storage, images *ImagePipeline, interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		if n, err := DeleteOrphanedMedia(context.Background(), db, storage, images, MediaOrphanGrace()); err != nil {
+			utils.Error("Media sweep failed", map[string]interface{}{"error": err.Error()})
+		} else if n > 0 {
+			utils.Info("Deleted orphaned media", map[string]interface{}{"count": n})
+		}
+	}
+}
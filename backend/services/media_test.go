@@ -0,0 +1,69 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/png"
+	"testing"
+)
+
+func TestSniffImageType(t *testing.T) {
+	var pngData bytes.Buffer
+	png.Encode(&pngData, image.NewRGBA(image.Rect(0, 0, 2, 2)))
+
+	tests := []struct {
+		name    string
+		data    []byte
+		want    string
+		wantErr bool
+	}{
+		{"png", pngData.Bytes(), "image/png", false},
+		{"jpeg", []byte("\xff\xd8\xff\xe0\x00\x10JFIF\x00"), "image/jpeg", false},
+		{"webp", []byte("RIFF\x00\x00\x00\x00WEBPVP8 "), "image/webp", false},
+		{"html named .jpg", []byte("<html><script>alert(1)</script></html>"), "", true},
+		{"svg", []byte(`<svg xmlns="http://www.w3.org/2000/svg"></svg>`), "", true},
+		{"pdf", []byte("%PDF-1.7"), "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SniffImageType(tt.data)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("SniffImageType = %q, %v; want %q, error %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLocalStorage(t *testing.T) {
+	storage := &LocalStorage{Dir: t.TempDir(), BaseURL: "http://localhost:8080/uploads/"}
+	ctx := context.Background()
+
+	if err := storage.Put(ctx, "media/ab/file.png", "image/png", []byte("data")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if data, err := storage.Get(ctx, "media/ab/file.png"); err != nil || string(data) != "data" {
+		t.Fatalf("Get = %q, %v", data, err)
+	}
+	if got := storage.URL("media/ab/file.png"); got != "http://localhost:8080/uploads/media/ab/file.png" {
+		t.Errorf("URL = %q", got)
+	}
+
+	if err := storage.Delete(ctx, "media/ab/file.png"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if err := storage.Delete(ctx, "media/ab/file.png"); err != nil {
+		t.Errorf("deleting a missing key = %v, want nil", err)
+	}
+	if _, err := storage.Get(ctx, "media/ab/file.png"); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("Get after delete = %v, want ErrObjectNotFound", err)
+	}
+
+	for _, key := range []string{"../escape.png", "media/../../escape.png", "/abs.png", ""} {
+		if err := storage.Put(ctx, key, "image/png", []byte("x")); err == nil {
+			t.Errorf("Put(%q) succeeded, want an invalid key error", key)
+		}
+	}
+}
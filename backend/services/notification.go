@@ -0,0 +1,283 @@
+package services
+
+import (
+	"fmt"
+	"net/url"
+
+	"pashmina-backend/models"
+
+	"gorm.io/gorm"
+)
+
+// defaultTemplates are used for events that have no active NotificationTemplate.
+// Channel lists the channels the event goes out on, comma-separated.
+var defaultTemplates = map[string]models.NotificationTemplate{
+	"order_created": {
+		Type:    "order_created",
+		Channel: "in_app,email",
+		Subject: "Your Pashmiya order #{{.Order.ID}}",
+		Title:   "Order placed",
+		Body:    "Your order #{{.Order.ID}} has been placed. Complete payment to confirm it.",
+	},
+	"order_confirmed": {
+		Type:    "order_confirmed",
+		Channel: "in_app,email,push",
+		Subject: "Order #{{.Order.ID}} confirmed",
+		Title:   "Order confirmed",
+		Body:    "Payment received. Your order #{{.Order.ID}} is confirmed.",
+	},
+	"payment_failed": {
+		Type:    "payment_failed",
+		Channel: "in_app,email",
+		Subject: "Payment for order #{{.Order.ID}} failed",
+		Title:   "Payment failed",
+		Body:    "Payment for order #{{.Order.ID}} did not go through. You can try again.",
+	},
+	"order_processing": {
+		Type:    "order_processing",
+		Channel: "in_app",
+		Title:   "Order being prepared",
+		Body:    "Your order #{{.Order.ID}} is being packed for shipping.",
+	},
+	"order_shipped": {
+		Type:    "order_shipped",
+		Channel: "in_app,email,push",
+		Subject: "Order #{{.Order.ID}} has shipped",
+		Title:   "Order shipped",
+		Body:    "Your order #{{.Order.ID}} is on its way.{{if .Order.TrackingNumber}} Tracking number: {{.Order.TrackingNumber}}.{{end}}",
+	},
+	"order_delivered": {
+		Type:    "order_delivered",
+		Channel: "in_app,email,push",
+		Subject: "Order #{{.Order.ID}} delivered",
+		Title:   "Order delivered",
+		Body:    "Your order #{{.Order.ID}} has been delivered.",
+	},
+	"order_cancelled": {
+		Type:    "order_cancelled",
+		Channel: "in_app,email",
+		Subject: "Order #{{.Order.ID}} cancelled",
+		Title:   "Order cancelled",
+		Body:    "Your order #{{.Order.ID}} has been cancelled.",
+	},
+	"order_refunded": {
+		Type:    "order_refunded",
+		Channel: "in_app,email",
+		Subject: "Refund for order #{{.Order.ID}}",
+		Title:   "Refund issued",
+		Body:    "A refund for order #{{.Order.ID}} has been issued.",
+	},
+	"order_expired": {
+		Type:    "order_expired",
+		Channel: "in_app,email",
+		Subject: "Order #{{.Order.ID}} expired",
+		Title:   "Order expired",
+		Body:    "Order #{{.Order.ID}} expired because payment was not received in time.",
+	},
+	"admin_new_order": {
+		Type:    "admin_new_order",
+		Channel: "in_app,email",
+		Subject: "New order #{{.Order.ID}}",
+		Title:   "New order",
+		Body:    "Order #{{.Order.ID}} for {{.Order.Currency}} {{printf \"%.2f\" .Order.TotalAmount}} has been paid.",
+	},
+	"low_stock": {
+		Type:    "low_stock",
+		Channel: "in_app,email",
+		Subject: "Low stock: {{.Name}}",
+		Title:   "Low stock",
+		Body:    "{{.Name}}{{if .SKU}} ({{.SKU}}){{end}} has {{.Stock}} left in stock.",
+	},
+	"admin_new_customer": {
+		Type:    "admin_new_customer",
+		Channel: "in_app,email",
+		Subject: "New customer: {{.Customer.Name}}",
+		Title:   "New customer",
+		Body:    "{{.Customer.Name}} ({{.Customer.Email}}) has registered.",
+	},
+	"admin_new_review": {
+		Type:    "admin_new_review",
+		Channel: "in_app,email",
+		Subject: "New review awaiting approval",
+		Title:   "New review",
+		Body:    "A {{.Review.Rating}}-star review was posted for product #{{.Review.ProductID}} and is awaiting approval.",
+	},
+	"welcome": {
+		Type:    "welcome",
+		Channel: "email",
+		Subject: "Welcome to Pashmiya",
+		Title:   "Welcome, {{.User.Name}}",
+		Body:    "Thank you for creating a Pashmiya account. You can now track your orders, save addresses and keep a wishlist.",
+	},
+	"password_reset": {
+		Type:    "password_reset",
+		Channel: "email",
+		Subject: "Reset your Pashmiya password",
+		Title:   "Reset your password",
+		Body:    "We received a request to reset the password for {{.User.Email}}. Use the link below within {{.ExpiresIn}} minutes to choose a new one. If you did not ask for this, you can ignore this email.",
+	},
+	"password_changed": {
+		Type:    "password_changed",
+		Channel: "in_app,email",
+		Subject: "Your Pashmiya password was changed",
+		Title:   "Password changed",
+		Body:    "The password for {{.User.Email}} was changed and you have been signed out of all devices. If this was not you, contact us right away.",
+	},
+	"newsletter_welcome": {
+		Type:    "newsletter_welcome",
+		Channel: "email",
+		Subject: "You're on the Pashmiya list",
+		Title:   "Thanks for subscribing",
+		Body:    "You will now hear about new collections, artisan stories and private offers from Pashmiya.",
+	},
+	"gift_card": {
+		Type:    "gift_card",
+		Channel: "email",
+		Subject: "{{.From}} sent you a Pashmiya gift card",
+		Title:   "A gift card for you",
+		Body:    "{{.From}} sent you a Pashmiya gift card worth {{.Card.Currency}} {{printf \"%.2f\" .Card.InitialValue}}.{{if .Card.Message}} \"{{.Card.Message}}\"{{end}} Use code {{.Code}} at checkout{{if .Card.ExpiresAt}} before {{.Card.ExpiresAt.Format \"2 January 2006\"}}{{end}}.",
+	},
+}
+
+// orderEventType returns the notification event for an order's current status.
+// ok is false for statuses the customer is not told about.
+func orderEventType(status string) (string, bool) {
+	switch status {
+	case "pending_payment":
+		return "order_created", true
+	case "paid", "confirmed":
+		return "order_confirmed", true
+	case "payment_failed":
+		return "payment_failed", true
+	case "processing", "shipped", "delivered", "cancelled", "refunded", "expired":
+		return "order_" + status, true
+	}
+	return "", false
+}
+
+// NotifyOrderStatus tells the customer about their order's current status, and the
+// admins when it has just been paid. Delivery happens in the background.
+func NotifyOrderStatus(db *gorm.DB, order *models.Order) {
+	eventType, ok := orderEventType(order.Status)
+	if !ok || order.UserID == 0 {
+		return
+	}
+
+	// Copy the order so later changes by the caller do not race with rendering
+	snapshot := *order
+	event := Event{
+		Type:   eventType,
+		UserID: order.UserID,
+		Data:   map[string]interface{}{"Order": &snapshot},
+		Link:   fmt.Sprintf("/orders/%d", order.ID),
+	}
+	Notifications(db).DispatchAsync(event)
+
+	if order.Status == "paid" {
+		Notifications(db).DispatchAsync(Event{
+			Type: "admin_new_order",
+			Data: event.Data,
+			Link: event.Link,
+		})
+	}
+}
+
+// LowStockThreshold returns the stock level at or below which admins are alerted
+func LowStockThreshold() int {
+	return int(envFloat("LOW_STOCK_THRESHOLD", 5))
+}
+
+// NotifyLowStock alerts admins about priced lines whose stock has just fallen to the
+// low stock threshold. It expects the stock already decremented in memory.
+func NotifyLowStock(db *gorm.DB, lines []PricedLine) {
+	threshold := LowStockThreshold()
+	for _, line := range lines {
+		name, sku, stock := line.Name, line.SKU, 0
+		switch {
+		case line.Variant != nil:
+			stock = line.Variant.Stock
+		case line.Product != nil:
+			stock = line.Product.Stock
+		default:
+			continue
+		}
+
+		// Only alert when this line took the stock across the threshold
+		if stock > threshold || stock+line.Quantity <= threshold {
+			continue
+		}
+
+		Notifications(db).DispatchAsync(Event{
+			Type: "low_stock",
+			Data: map[string]interface{}{
+				"Name":      name,
+				"SKU":       sku,
+				"Stock":     stock,
+				"ProductID": line.ProductID,
+			},
+			Link: fmt.Sprintf("/products/%d", line.ProductID),
+		})
+	}
+}
+
+// NotifyNewCustomer tells the admins a customer has registered
+func NotifyNewCustomer(db *gorm.DB, user *models.User) {
+	customer := *user
+	Notifications(db).DispatchAsync(Event{
+		Type: "admin_new_customer",
+		Data: map[string]interface{}{"Customer": &customer},
+	})
+}
+
+// NotifyNewReview tells the admins a review is awaiting approval
+func NotifyNewReview(db *gorm.DB, review *models.Review) {
+	snapshot := *review
+	Notifications(db).DispatchAsync(Event{
+		Type: "admin_new_review",
+		Data: map[string]interface{}{"Review": &snapshot},
+		Link: fmt.Sprintf("/product/%d", review.ProductID),
+	})
+}
+
+// NotifyWelcome greets a customer who has just registered
+func NotifyWelcome(db *gorm.DB, user *models.User) {
+	Notifications(db).DispatchAsync(Event{
+		Type:   "welcome",
+		UserID: user.ID,
+		Link:   "/products",
+	})
+}
+
+// SendPasswordReset emails a user the link to reset their password
+func SendPasswordReset(db *gorm.DB, user *models.User, token string) {
+	Notifications(db).DispatchToAsync(Event{
+		Type:    "password_reset",
+		Data:    map[string]interface{}{"ExpiresIn": int(PasswordResetTTL().Minutes())},
+		Link:    "/auth/reset-password?token=" + url.QueryEscape(token),
+		Private: true,
+	}, Recipient{UserID: &user.ID, Name: user.Name, Email: user.Email})
+}
+
+// NotifyPasswordChanged tells a user their password was changed. It is a security
+// notice, so it goes out whatever their preferences.
+func NotifyPasswordChanged(db *gorm.DB, user *models.User) {
+	Notifications(db).DispatchToAsync(Event{
+		Type: "password_changed",
+	}, Recipient{UserID: &user.ID, Name: user.Name, Email: user.Email})
+}
+
+// SendNewsletterWelcome confirms a newsletter subscription to the subscriber
+func SendNewsletterWelcome(db *gorm.DB, email string) {
+	Notifications(db).DispatchToAsync(Event{Type: "newsletter_welcome"}, Recipient{Email: email})
+}
+
+// SendGiftCard emails a gift card's code to its recipient. from is who it is from.
+func SendGiftCard(db *gorm.DB, card *models.GiftCard, from string) {
+	snapshot := *card
+	Notifications(db).DispatchToAsync(Event{
+		Type:    "gift_card",
+		Data:    map[string]interface{}{"Card": &snapshot, "Code": FormatGiftCardCode(card.Code), "From": from},
+		Link:    "/products",
+		Private: true,
+	}, Recipient{Name: card.RecipientName, Email: card.RecipientEmail})
+}
@@ -0,0 +1,135 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"pashmina-backend/models"
+)
+
+func TestOrderEventType(t *testing.T) {
+	tests := []struct {
+		status string
+		want   string
+		wantOK bool
+	}{
+		{"pending_payment", "order_created", true},
+		{"paid", "order_confirmed", true},
+		{"payment_failed", "payment_failed", true},
+		{"shipped", "order_shipped", true},
+		{"expired", "order_expired", true},
+		{"lost", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.status, func(t *testing.T) {
+			got, ok := orderEventType(tt.status)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("orderEventType(%q) = (%q, %v), want (%q, %v)", tt.status, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestDefaultTemplatesRender(t *testing.T) {
+	data := map[string]interface{}{
+		"Order":     &models.Order{ID: 7, Currency: "INR", TotalAmount: 1050, TrackingNumber: "AWB123"},
+		"Customer":  &models.User{Name: "Asha", Email: "asha@example.com"},
+		"Review":    &models.Review{Rating: 5, ProductID: 3},
+		"Name":      "Camel Shawl",
+		"SKU":       "PSH-CAMEL",
+		"Stock":     2,
+		"User":      Recipient{Name: "Asha", Email: "asha@example.com"},
+		"ExpiresIn": 60,
+		"Card":      &models.GiftCard{Currency: "INR", InitialValue: 5000, Message: "Happy birthday"},
+		"Code":      "ABCD-EFGH-JKMN-PQRS",
+		"From":      "Asha",
+	}
+
+	for eventType, tmpl := range defaultTemplates {
+		t.Run(eventType, func(t *testing.T) {
+			for _, channel := range templateChannels(tmpl) {
+				msg, err := renderMessage(tmpl, channel, data)
+				if err != nil {
+					t.Fatalf("renderMessage(%q, %q) error = %v", eventType, channel, err)
+				}
+				if msg.Title == "" || msg.Text == "" {
+					t.Errorf("renderMessage(%q, %q) rendered an empty title or body", eventType, channel)
+				}
+				if channel == ChannelEmail && msg.HTML == "" {
+					t.Errorf("renderMessage(%q, email) rendered no HTML body", eventType)
+				}
+			}
+		})
+	}
+}
+
+func TestTemplateChannels(t *testing.T) {
+	tests := []struct {
+		channel string
+		want    string
+	}{
+		{"all", "in_app,email,push"},
+		{" in_app, email ,,push", "in_app,email,push"},
+		{"in_app,sms", "in_app,sms"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		got := strings.Join(templateChannels(models.NotificationTemplate{Channel: tt.channel}), ",")
+		if got != tt.want {
+			t.Errorf("templateChannels(%q) = %q, want %q", tt.channel, got, tt.want)
+		}
+	}
+
+	// No SMS sender is registered by default, so no default template sends SMS
+	for eventType, tmpl := range defaultTemplates {
+		for _, channel := range templateChannels(tmpl) {
+			if channel == ChannelSMS {
+				t.Errorf("default %s template sends SMS", eventType)
+			}
+		}
+	}
+}
+
+func TestRenderMessageEscapesHTML(t *testing.T) {
+	tmpl := models.NotificationTemplate{Type: "test", Title: "Hi {{.User.Name}}", Body: "<p>Hello {{.User.Name}}</p>"}
+	data := map[string]interface{}{"User": Recipient{Name: "<b>Asha</b>"}}
+
+	msg, err := renderMessage(tmpl, ChannelEmail, data)
+	if err != nil {
+		t.Fatalf("renderMessage error = %v", err)
+	}
+	if !strings.Contains(msg.HTML, "<p>Hello &lt;b&gt;Asha&lt;/b&gt;</p>") {
+		t.Errorf("HTML = %q, want data escaped inside template markup", msg.HTML)
+	}
+	if msg.Subject != msg.Title {
+		t.Errorf("Subject = %q, want it to fall back to the title %q", msg.Subject, msg.Title)
+	}
+}
+
+func TestPreferenceAllows(t *testing.T) {
+	prefs := DefaultNotificationPreference(1)
+	prefs.OrderShipped = false
+	prefs.SMSEnabled = false
+
+	tests := []struct {
+		name string
+		got  bool
+		want bool
+	}{
+		{"shipped turned off", preferenceAllows(&prefs, "order_shipped"), false},
+		{"other order updates", preferenceAllows(&prefs, "order_cancelled"), true},
+		{"marketing off by default", preferenceAllows(&prefs, "marketing"), false},
+		{"in-app always on", channelEnabled(&prefs, ChannelInApp), true},
+		{"sms off", channelEnabled(&prefs, ChannelSMS), false},
+		{"email on", channelEnabled(&prefs, ChannelEmail), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.got != tt.want {
+				t.Errorf("got %v, want %v", tt.got, tt.want)
+			}
+		})
+	}
+}
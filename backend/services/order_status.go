@@ -0,0 +1,133 @@
+package services
+
+import (
+	"fmt"
+	"time"
+
+	"pashmina-backend/models"
+
+	"gorm.io/gorm"
+)
+
+// Sources of an order status change
+const (
+	StatusSourceAdmin    = "admin"
+	StatusSourceCustomer = "customer"
+	StatusSourceWebhook  = "webhook"
+	StatusSourceSystem   = "system"
+)
+
+// orderTransitions lists the statuses an order may move to from each status.
+// Statuses with no entry are final.
+var orderTransitions = map[string][]string{
+	"pending_payment": {"paid", "payment_failed", "expired", "cancelled"},
+	"payment_failed":  {"pending_payment", "paid", "expired", "cancelled"},
+	"expired":         {"paid", "cancelled"},
+	"paid":            {"confirmed", "processing", "cancelled", "refunded"},
+	"confirmed":       {"paid", "processing", "cancelled", "refunded"},
+	"processing":      {"shipped", "cancelled", "refunded"},
+	"shipped":         {"delivered"},
+	"delivered":       {"refunded"},
+	"cancelled":       {"refunded"},
+}
+
+// InvalidTransitionError reports a status change the order lifecycle does not allow
+type InvalidTransitionError struct {
+	From string
+	To   string
+}
+
+func (e *InvalidTransitionError) Error() string {
+	return fmt.Sprintf("cannot move order from %s to %s", e.From, e.To)
+}
+
+// StatusChange describes who moved an order to a new status and why
+type StatusChange struct {
+	Source  string
+	ActorID *uint
+	Reason  string
+}
+
+// CanTransition reports whether an order may move from one status to another
+func CanTransition(from, to string) bool {
+	for _, next := range orderTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// NextStatuses returns the statuses an order may move to from the given status
+func NextStatuses(from string) []string {
+	return orderTransitions[from]
+}
+
+// unpaidStatuses are those of an order that has not been paid for. Only a recorded
+// payment, from Razorpay verification or its webhook, moves it on to paid.
+var unpaidStatuses = map[string]bool{
+	"pending_payment": true,
+	"payment_failed":  true,
+	"expired":         true,
+}
+
+// AdminCanTransition reports whether an admin may move an order from one status to
+// another by hand. An admin can't mark an unpaid order paid.
+func AdminCanTransition(from, to string) bool {
+	if unpaidStatuses[from] && to == "paid" {
+		return false
+	}
+	return CanTransition(from, to)
+}
+
+// AdminNextStatuses returns the statuses an admin may move an order to by hand
+func AdminNextStatuses(from string) []string {
+	var next []string
+	for _, to := range orderTransitions[from] {
+		if AdminCanTransition(from, to) {
+			next = append(next, to)
+		}
+	}
+	return next
+}
+
+// TransitionOrder moves an order to a new status and records the change in its
+// history. The order's status and timestamps are updated in memory; the caller
+// saves the order in the same transaction.
+func TransitionOrder(tx *gorm.DB, order *models.Order, to string, change StatusChange) error {
+	from := order.Status
+	if !CanTransition(from, to) {
+		return &InvalidTransitionError{From: from, To: to}
+	}
+
+	now := time.Now()
+	switch to {
+	case "shipped":
+		order.ShippedAt = &now
+	case "delivered":
+		order.DeliveredAt = &now
+	}
+	order.Status = to
+
+	return RecordOrderStatus(tx, order.ID, from, to, change)
+}
+
+// RecordOrderStatus appends an entry to an order's status history
+func RecordOrderStatus(tx *gorm.DB, orderID uint, from, to string, change StatusChange) error {
+	entry := models.OrderStatusHistory{
+		OrderID:    orderID,
+		FromStatus: from,
+		ToStatus:   to,
+		Source:     change.Source,
+		ActorID:    change.ActorID,
+		Reason:     change.Reason,
+	}
+	return tx.Create(&entry).Error
+}
+
+// OrderTimeline returns an order's status history, oldest first
+func OrderTimeline(db *gorm.DB, orderID uint) ([]models.OrderStatusHistory, error) {
+	var history []models.OrderStatusHistory
+	err := db.Where("order_id = ?", orderID).Order("created_at ASC, id ASC").Find(&history).Error
+	return history, err
+}
@@ -0,0 +1,63 @@
+package services
+
+import "testing"
+
+func TestCanTransition(t *testing.T) {
+	tests := []struct {
+		name string
+		from string
+		to   string
+		want bool
+	}{
+		{"pay pending order", "pending_payment", "paid", true},
+		{"expire pending order", "pending_payment", "expired", true},
+		{"late payment on expired order", "expired", "paid", true},
+		{"ship processing order", "processing", "shipped", true},
+		{"deliver shipped order", "shipped", "delivered", true},
+		{"refund delivered order", "delivered", "refunded", true},
+		{"skip payment", "pending_payment", "shipped", false},
+		{"confirm unpaid order", "pending_payment", "confirmed", false},
+		{"revive cancelled order", "cancelled", "delivered", false},
+		{"cancel shipped order", "shipped", "cancelled", false},
+		{"refunded is final", "refunded", "paid", false},
+		{"same status", "paid", "paid", false},
+		{"unknown status", "lost", "paid", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CanTransition(tt.from, tt.to); got != tt.want {
+				t.Errorf("CanTransition(%q, %q) = %v, want %v", tt.from, tt.to, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAdminCanTransition(t *testing.T) {
+	tests := []struct {
+		name string
+		from string
+		to   string
+		want bool
+	}{
+		{"mark pending order paid", "pending_payment", "paid", false},
+		{"mark failed payment paid", "payment_failed", "paid", false},
+		{"mark expired order paid", "expired", "paid", false},
+		{"cancel pending order", "pending_payment", "cancelled", true},
+		{"confirm paid order", "paid", "confirmed", true},
+		{"ship processing order", "processing", "shipped", true},
+		{"cancel shipped order", "shipped", "cancelled", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := AdminCanTransition(tt.from, tt.to); got != tt.want {
+				t.Errorf("AdminCanTransition(%q, %q) = %v, want %v", tt.from, tt.to, got, tt.want)
+			}
+		})
+	}
+
+	if next := AdminNextStatuses("pending_payment"); len(next) != 3 || next[0] != "payment_failed" {
+		t.Errorf("AdminNextStatuses(pending_payment) = %v, want payment_failed, expired and cancelled", next)
+	}
+}
@@ -0,0 +1,80 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"time"
+
+	"pashmina-backend/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrResetTokenInvalid is returned for reset tokens that are unknown, used or expired
+var ErrResetTokenInvalid = errors.New("invalid or expired password reset token")
+
+// PasswordResetTTL returns how long an emailed reset link stays valid
+func PasswordResetTTL() time.Duration {
+	return time.Duration(envFloat("PASSWORD_RESET_TTL_MINUTES", 60)) * time.Minute
+}
+
+// CreatePasswordReset issues a single-use reset token for a user and returns its raw
+// value. Earlier unused tokens for the user stop working.
+func CreatePasswordReset(db *gorm.DB, userID uint) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	raw := base64.RawURLEncoding.EncodeToString(buf)
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.PasswordResetToken{}).
+			Where("user_id = ? AND used_at IS NULL", userID).
+			Update("used_at", time.Now()).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.PasswordResetToken{
+			UserID:    userID,
+			TokenHash: hashToken(raw),
+			ExpiresAt: time.Now().Add(PasswordResetTTL()),
+		}).Error
+	})
+	if err != nil {
+		return "", err
+	}
+	return raw, nil
+}
+
+// ResetPassword consumes a reset token, stores the new password hash and signs the
+// user out everywhere
+func ResetPassword(db *gorm.DB, raw, passwordHash string) (*models.User, error) {
+	var user models.User
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var token models.PasswordResetToken
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("token_hash = ?", hashToken(raw)).First(&token).Error; err != nil {
+			return ErrResetTokenInvalid
+		}
+		if token.UsedAt != nil || time.Now().After(token.ExpiresAt) {
+			return ErrResetTokenInvalid
+		}
+
+		if err := tx.First(&user, token.UserID).Error; err != nil {
+			return ErrResetTokenInvalid
+		}
+		if err := tx.Model(&user).Update("password", passwordHash).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&token).Update("used_at", time.Now()).Error; err != nil {
+			return err
+		}
+		_, err := RevokeUserSessions(tx, user.ID, "password_reset")
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
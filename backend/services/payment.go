@@ -7,6 +7,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
 	"os"
 	"strconv"
 	"time"
@@ -14,6 +15,8 @@ import (
 	"github.com/razorpay/razorpay-go"
 )
 
+var ErrPaymentMismatch = errors.New("payment does not match the order")
+
 type RazorpayService struct {
 	client *razorpay.Client
 }
@@ -37,7 +40,7 @@ func (s *RazorpayService) CreateOrder(amount float64, currency, receipt string,
 	}
 
 	// Convert amount to paise (smallest currency unit)
-	amountInPaise := int(amount * 100)
+	amountInPaise := FormatAmountForRazorpay(amount)
 
 	data := map[string]interface{}{
 		"amount":   amountInPaise,
@@ -93,7 +96,7 @@ func (s *RazorpayService) CapturePayment(paymentID string, amount float64, curre
 		return nil, errors.New("Razorpay client not initialized")
 	}
 
-	amountInPaise := int(amount * 100)
+	amountInPaise := FormatAmountForRazorpay(amount)
 	data := map[string]interface{}{
 		"amount":   amountInPaise,
 		"currency": currency,
@@ -115,7 +118,7 @@ func (s *RazorpayService) RefundPayment(paymentID string, amount *float64, notes
 
 	var amountInPaise int
 	if amount != nil {
-		amountInPaise = int(*amount * 100)
+		amountInPaise = FormatAmountForRazorpay(*amount)
 	}
 
 	data := map[string]interface{}{
@@ -209,7 +212,41 @@ func (s *RazorpayService) ConvertCurrency(amount float64, fromCurrency, toCurren
 
 // FormatAmountForRazorpay converts amount to paise for Razorpay
 func FormatAmountForRazorpay(amount float64) int {
-	return int(amount * 100)
+	return int(math.Round(amount * 100))
+}
+
+// CheckPayment returns ErrPaymentMismatch unless a Razorpay payment belongs to the
+// Razorpay order razorpayOrderID and is for exactly amount in currency
+func CheckPayment(payment map[string]interface{}, razorpayOrderID string, amount float64, currency string) error {
+	if orderID, _ := payment["order_id"].(string); orderID != razorpayOrderID {
+		return ErrPaymentMismatch
+	}
+	if paymentCurrency, _ := payment["currency"].(string); paymentCurrency != currency {
+		return ErrPaymentMismatch
+	}
+
+	// Amounts are in paise, and decode as float64 from JSON
+	var paise float64
+	switch v := payment["amount"].(type) {
+	case float64:
+		paise = v
+	case int:
+		paise = float64(v)
+	case int64:
+		paise = float64(v)
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return ErrPaymentMismatch
+		}
+		paise = f
+	default:
+		return ErrPaymentMismatch
+	}
+	if int(math.Round(paise)) != FormatAmountForRazorpay(amount) {
+		return ErrPaymentMismatch
+	}
+	return nil
 }
 
 // ParseAmountFromRazorpay converts paise back to decimal
@@ -0,0 +1,33 @@
+package services
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestCheckPayment(t *testing.T) {
+	payment := func(orderID string, amount interface{}, currency string) map[string]interface{} {
+		return map[string]interface{}{"order_id": orderID, "amount": amount, "currency": currency}
+	}
+
+	tests := []struct {
+		name    string
+		payment map[string]interface{}
+		wantErr bool
+	}{
+		{"matches", payment("order_A", float64(249950), "INR"), false},
+		{"json number", payment("order_A", json.Number("249950"), "INR"), false},
+		{"other razorpay order", payment("order_B", float64(249950), "INR"), true},
+		{"cheaper payment", payment("order_A", float64(100), "INR"), true},
+		{"other currency", payment("order_A", float64(249950), "USD"), true},
+		{"missing amount", map[string]interface{}{"order_id": "order_A", "currency": "INR"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckPayment(tt.payment, "order_A", 2499.50, "INR")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CheckPayment error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,421 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"time"
+
+	"pashmina-backend/models"
+	"pashmina-backend/utils"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrCouponNotFound  = errors.New("invalid coupon code")
+	ErrCouponExpired   = errors.New("coupon has expired or reached usage limit")
+	ErrCouponMinimum   = errors.New("order amount does not meet minimum requirement")
+	ErrUnknownShipping = errors.New("unknown shipping method")
+	ErrInvalidQuantity = errors.New("quantity must be greater than 0")
+	ErrNoItemsToPrice  = errors.New("at least one item is required")
+)
+
+// priceMismatchEpsilon is the tolerance used when comparing client totals
+const priceMismatchEpsilon = 0.01
+
+// ProductNotFoundError is returned when a cart line references a product that is
+// missing or not on the storefront
+type ProductNotFoundError struct {
+	ProductID uint
+}
+
+func (e *ProductNotFoundError) Error() string {
+	return fmt.Sprintf("Product %d not found", e.ProductID)
+}
+
+// pricingErrors are the reasons a cart can't be priced, as opposed to failures
+var pricingErrors = []error{ErrNoItemsToPrice, ErrInvalidQuantity, ErrUnknownShipping, ErrVariantNotFound}
+
+// IsPricingError reports whether err says what is wrong with the cart, its coupon or
+// its shipping method, so it can be shown to the customer
+func IsPricingError(err error) bool {
+	var notFound *ProductNotFoundError
+	if errors.As(err, &notFound) || IsCouponError(err) {
+		return true
+	}
+	for _, pricingErr := range pricingErrors {
+		if errors.Is(err, pricingErr) {
+			return true
+		}
+	}
+	return false
+}
+
+// PricingItem is a single cart line submitted for pricing
+type PricingItem struct {
+	ProductID uint
+	VariantID *uint
+	Quantity  int
+	Color     string
+	Size      string
+}
+
+// PricingRequest describes everything the server needs to price an order
+type PricingRequest struct {
+	Items           []PricingItem
+	CouponCode      string
+	ShippingMethod  string
+	ShippingCountry string
+	// UserID is the customer placing the order. When set, the coupon's per-customer
+	// limits are checked as well.
+	UserID uint
+	// Quote prices without locking any rows, for previews that don't place an order
+	Quote bool
+}
+
+// PricedLine is a cart line priced from the catalog
+type PricedLine struct {
+	ProductID uint                   `json:"product_id"`
+	VariantID *uint                  `json:"variant_id,omitempty"`
+	SKU       string                 `json:"sku,omitempty"`
+	Name      string                 `json:"name"`
+	Quantity  int                    `json:"quantity"`
+	UnitPrice float64                `json:"unit_price"`
+	LineTotal float64                `json:"line_total"`
+	Discount  float64                `json:"discount"`
+	Color     string                 `json:"color,omitempty"`
+	Size      string                 `json:"size,omitempty"`
+	Product   *models.Product        `json:"-"`
+	Variant   *models.ProductVariant `json:"-"`
+	// IsGift marks a line added free by the promotion PromotionID
+	IsGift      bool `json:"is_gift,omitempty"`
+	PromotionID uint `json:"promotion_id,omitempty"`
+}
+
+// PriceBreakdown is the server-computed price of an order. DiscountAmount is what
+// promotions and the coupon take off together.
+type PriceBreakdown struct {
+	Lines             []PricedLine       `json:"lines"`
+	Subtotal          float64            `json:"subtotal"`
+	DiscountAmount    float64            `json:"discount_amount"`
+	PromotionDiscount float64            `json:"promotion_discount"`
+	CouponDiscount    float64            `json:"coupon_discount"`
+	ShippingCost      float64            `json:"shipping_cost"`
+	TaxAmount         float64            `json:"tax_amount"`
+	TotalAmount       float64            `json:"total_amount"`
+	Currency          string             `json:"currency"`
+	CouponCode        string             `json:"coupon_code,omitempty"`
+	ShippingMethod    string             `json:"shipping_method"`
+	FreeShipping      bool               `json:"free_shipping,omitempty"`
+	Promotions        []AppliedPromotion `json:"promotions"`
+	Coupon            *models.Coupon     `json:"-"`
+}
+
+// SubmittedTotals holds the totals a client claims for an order. Nil fields were not submitted.
+type SubmittedTotals struct {
+	TotalAmount    *float64
+	DiscountAmount *float64
+	ShippingCost   *float64
+	TaxAmount      *float64
+	LinePrices     map[int]float64 // keyed by item index
+}
+
+// PriceMismatch reports a client-submitted value that differs from the server price
+type PriceMismatch struct {
+	Field     string  `json:"field"`
+	Submitted float64 `json:"submitted"`
+	Computed  float64 `json:"computed"`
+}
+
+// PriceOrder prices the request against the catalog inside tx. Unless the request
+// is a quote, product and coupon rows are locked for update so the caller can
+// safely check and decrement stock and redeem the coupon afterwards.
+func PriceOrder(tx *gorm.DB, req PricingRequest) (*PriceBreakdown, error) {
+	if len(req.Items) == 0 {
+		return nil, ErrNoItemsToPrice
+	}
+
+	breakdown := &PriceBreakdown{
+		Lines:    make([]PricedLine, 0, len(req.Items)),
+		Currency: "INR",
+	}
+
+	now := time.Now()
+	rows := newCatalogRows(!req.Quote)
+	for _, item := range req.Items {
+		line, err := rows.priceLine(tx, item, now)
+		if err != nil {
+			return nil, err
+		}
+		breakdown.Lines = append(breakdown.Lines, line)
+		breakdown.Subtotal += line.LineTotal
+	}
+	breakdown.Subtotal = RoundMoney(breakdown.Subtotal)
+
+	promotions, err := ActivePromotions(tx, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load promotions: %w", err)
+	}
+	if err := breakdown.applyPromotions(tx, promotions, rows, now); err != nil {
+		return nil, err
+	}
+
+	if code := utils.NormalizeCouponCode(req.CouponCode); code != "" {
+		var coupon models.Coupon
+		if err := forUpdate(tx, !req.Quote).Where("code = ?", code).First(&coupon).Error; err != nil {
+			return nil, ErrCouponNotFound
+		}
+		for _, promotion := range breakdown.Promotions {
+			if !promotion.combinesWithCoupons {
+				return nil, ErrCouponPromotion
+			}
+		}
+
+		// The coupon applies to what promotions left of the lines the customer chose
+		lines, positions := breakdown.couponLines()
+		var subtotal float64
+		for _, line := range lines {
+			subtotal += line.LineTotal
+		}
+		applied, err := ApplyCoupon(tx, &coupon, CouponCart{
+			Lines:    lines,
+			Subtotal: RoundMoney(subtotal),
+			Country:  req.ShippingCountry,
+			UserID:   req.UserID,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if req.UserID != 0 {
+			if err := CheckCouponEligibility(tx, &coupon, req.UserID); err != nil {
+				return nil, err
+			}
+		}
+		for i, position := range positions {
+			breakdown.Lines[position].Discount = RoundMoney(breakdown.Lines[position].Discount + applied.LineDiscounts[i])
+		}
+		breakdown.Coupon = &coupon
+		breakdown.CouponCode = coupon.Code
+		breakdown.CouponDiscount = applied.Discount
+		breakdown.DiscountAmount = RoundMoney(breakdown.DiscountAmount + applied.Discount)
+		breakdown.FreeShipping = applied.FreeShipping
+	}
+
+	method := req.ShippingMethod
+	if method == "" {
+		method = "standard"
+	}
+	shipping, err := ShippingCost(method, breakdown.Subtotal-breakdown.DiscountAmount)
+	if err != nil {
+		return nil, err
+	}
+	if breakdown.FreeShipping {
+		shipping = 0
+	}
+	breakdown.ShippingMethod = method
+	breakdown.ShippingCost = shipping
+
+	breakdown.finalize()
+	return breakdown, nil
+}
+
+// catalogRows holds the product and variant rows loaded while pricing an order.
+// Lines for the same product or variant share one row so stock checks see earlier
+// lines.
+type catalogRows struct {
+	products map[uint]*models.Product
+	variants map[uint]*models.ProductVariant
+	lock     bool
+}
+
+func newCatalogRows(lock bool) *catalogRows {
+	return &catalogRows{products: map[uint]*models.Product{}, variants: map[uint]*models.ProductVariant{}, lock: lock}
+}
+
+// forUpdate locks the rows a query reads when lock is set
+func forUpdate(tx *gorm.DB, lock bool) *gorm.DB {
+	if !lock {
+		return tx
+	}
+	return tx.Clauses(clause.Locking{Strength: "UPDATE"})
+}
+
+// priceLine prices an item from the catalog, locking its product and variant rows
+// unless the rows are only read for a quote
+func (r *catalogRows) priceLine(tx *gorm.DB, item PricingItem, now time.Time) (PricedLine, error) {
+	if item.Quantity <= 0 {
+		return PricedLine{}, ErrInvalidQuantity
+	}
+
+	product, ok := r.products[item.ProductID]
+	if !ok {
+		product = &models.Product{}
+		// Products off the storefront can't be bought, so they aren't found either
+		err := VisibleProducts(forUpdate(tx, r.lock), now).First(product, item.ProductID).Error
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return PricedLine{}, &ProductNotFoundError{ProductID: item.ProductID}
+			}
+			return PricedLine{}, fmt.Errorf("failed to load product %d: %w", item.ProductID, err)
+		}
+		r.products[item.ProductID] = product
+	}
+
+	variant, err := FindVariant(tx, r.lock, product.ID, item.VariantID, item.Color, item.Size)
+	if err != nil {
+		return PricedLine{}, fmt.Errorf("%s (%s / %s): %w", product.Name, item.Color, item.Size, err)
+	}
+
+	line := PricedLine{
+		ProductID: product.ID,
+		Name:      product.Name,
+		Quantity:  item.Quantity,
+		UnitPrice: product.Price,
+		Color:     item.Color,
+		Size:      item.Size,
+		Product:   product,
+	}
+
+	if variant != nil {
+		if shared, ok := r.variants[variant.ID]; ok {
+			variant = shared
+		} else {
+			r.variants[variant.ID] = variant
+		}
+		line.Variant = variant
+		line.VariantID = &variant.ID
+		line.SKU = variant.SKU
+		line.Color = variant.Color
+		line.Size = variant.Size
+		line.UnitPrice = variant.EffectivePrice(product)
+	}
+
+	line.LineTotal = RoundMoney(line.UnitPrice * float64(item.Quantity))
+	return line, nil
+}
+
+// couponLines returns the lines a coupon can discount, priced at what promotions
+// left of them, with each one's position in b.Lines. Free gifts are left out.
+func (b *PriceBreakdown) couponLines() ([]PricedLine, []int) {
+	var lines []PricedLine
+	var positions []int
+	for i, line := range b.Lines {
+		if line.IsGift {
+			continue
+		}
+		line.LineTotal = RoundMoney(line.LineTotal - line.Discount)
+		line.UnitPrice = RoundMoney(line.LineTotal / float64(line.Quantity))
+		line.Discount = 0
+		lines = append(lines, line)
+		positions = append(positions, i)
+	}
+	return lines, positions
+}
+
+// finalize computes tax and the grand total from the already-priced components
+func (b *PriceBreakdown) finalize() {
+	taxable := b.Subtotal - b.DiscountAmount
+	if taxable < 0 {
+		taxable = 0
+	}
+	b.TaxAmount = RoundMoney(taxable * TaxRate() / 100)
+	b.TotalAmount = RoundMoney(taxable + b.ShippingCost + b.TaxAmount)
+}
+
+// Mismatches compares client-submitted totals against the server breakdown
+func (b *PriceBreakdown) Mismatches(submitted SubmittedTotals) []PriceMismatch {
+	var mismatches []PriceMismatch
+
+	check := func(field string, claimed *float64, computed float64) {
+		if claimed != nil && math.Abs(*claimed-computed) > priceMismatchEpsilon {
+			mismatches = append(mismatches, PriceMismatch{Field: field, Submitted: *claimed, Computed: computed})
+		}
+	}
+
+	check("total_amount", submitted.TotalAmount, b.TotalAmount)
+	check("discount_amount", submitted.DiscountAmount, b.DiscountAmount)
+	check("shipping_cost", submitted.ShippingCost, b.ShippingCost)
+	check("tax_amount", submitted.TaxAmount, b.TaxAmount)
+
+	for i, line := range b.Lines {
+		if price, ok := submitted.LinePrices[i]; ok {
+			check(fmt.Sprintf("items[%d].price", i), &price, line.UnitPrice)
+		}
+	}
+
+	return mismatches
+}
+
+// CouponDiscount quotes the discount a coupon grants on an order amount alone.
+// Coupons that depend on the cart, and free shipping, come to nothing here;
+// ApplyCoupon prices those.
+func CouponDiscount(coupon *models.Coupon, subtotal float64) (float64, error) {
+	if !coupon.IsValid() {
+		return 0, ErrCouponExpired
+	}
+	if coupon.MinOrderAmount > 0 && subtotal < coupon.MinOrderAmount {
+		return 0, ErrCouponMinimum
+	}
+	if CouponNeedsCart(coupon) || coupon.DiscountType == DiscountFreeShipping {
+		return 0, nil
+	}
+
+	discount := coupon.DiscountValue
+	if coupon.DiscountType == DiscountPercentage {
+		discount = subtotal * (coupon.DiscountValue / 100)
+		if coupon.MaxDiscountAmount > 0 && discount > coupon.MaxDiscountAmount {
+			discount = coupon.MaxDiscountAmount
+		}
+	}
+	if discount > subtotal {
+		discount = subtotal
+	}
+
+	return RoundMoney(discount), nil
+}
+
+// ShippingRates returns the server-side shipping rate table keyed by service type
+func ShippingRates() map[string]float64 {
+	return map[string]float64{
+		"standard": envFloat("SHIPPING_STANDARD_RATE", 150),
+		"express":  envFloat("SHIPPING_EXPRESS_RATE", 300),
+	}
+}
+
+// ShippingCost returns the shipping charge for a method and discounted subtotal
+func ShippingCost(method string, subtotal float64) (float64, error) {
+	rate, ok := ShippingRates()[method]
+	if !ok {
+		return 0, ErrUnknownShipping
+	}
+
+	threshold := envFloat("FREE_SHIPPING_THRESHOLD", 0)
+	if method == "standard" && threshold > 0 && subtotal >= threshold {
+		return 0, nil
+	}
+
+	return rate, nil
+}
+
+// TaxRate returns the configured tax rate as a percentage
+func TaxRate() float64 {
+	return envFloat("TAX_RATE", 0)
+}
+
+// RoundMoney rounds an amount to two decimal places
+func RoundMoney(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+func envFloat(key string, defaultValue float64) float64 {
+	if v := os.Getenv(key); v != "" {
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return f
+		}
+	}
+	return defaultValue
+}
@@ -0,0 +1,165 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"pashmina-backend/models"
+)
+
+func activeCoupon(discountType string, value float64) *models.Coupon {
+	return &models.Coupon{
+		Code:          "TEST10",
+		DiscountType:  discountType,
+		DiscountValue: value,
+		ValidFrom:     time.Now().Add(-time.Hour),
+		ValidUntil:    time.Now().Add(time.Hour),
+		IsActive:      true,
+	}
+}
+
+func TestCouponDiscount(t *testing.T) {
+	capped := activeCoupon("percentage", 50)
+	capped.MaxDiscountAmount = 100
+
+	minimum := activeCoupon("fixed", 50)
+	minimum.MinOrderAmount = 500
+
+	expired := activeCoupon("fixed", 50)
+	expired.ValidUntil = time.Now().Add(-time.Minute)
+
+	tests := []struct {
+		name     string
+		coupon   *models.Coupon
+		subtotal float64
+		want     float64
+		wantErr  error
+	}{
+		{"percentage", activeCoupon("percentage", 10), 890, 89, nil},
+		{"percentage capped", capped, 890, 100, nil},
+		{"fixed", activeCoupon("fixed", 75), 890, 75, nil},
+		{"fixed larger than subtotal", activeCoupon("fixed", 1000), 890, 890, nil},
+		{"below minimum", minimum, 450, 0, ErrCouponMinimum},
+		{"expired", expired, 890, 0, ErrCouponExpired},
+		{"free shipping", activeCoupon(DiscountFreeShipping, 0), 890, 0, nil},
+		{"needs the cart", activeCoupon(DiscountBuyXGetY, 100), 890, 0, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CouponDiscount(tt.coupon, tt.subtotal)
+			if err != tt.wantErr {
+				t.Fatalf("CouponDiscount() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("CouponDiscount() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestShippingCost(t *testing.T) {
+	t.Setenv("SHIPPING_STANDARD_RATE", "150")
+	t.Setenv("SHIPPING_EXPRESS_RATE", "300")
+	t.Setenv("FREE_SHIPPING_THRESHOLD", "1000")
+
+	tests := []struct {
+		name     string
+		method   string
+		subtotal float64
+		want     float64
+		wantErr  bool
+	}{
+		{"standard", "standard", 450, 150, false},
+		{"standard free over threshold", "standard", 1200, 0, false},
+		{"express never free", "express", 1200, 300, false},
+		{"unknown method", "teleport", 450, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ShippingCost(tt.method, tt.subtotal)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ShippingCost(%q) error = %v, wantErr %v", tt.method, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ShippingCost(%q) = %v, want %v", tt.method, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPriceBreakdownFinalize(t *testing.T) {
+	t.Setenv("TAX_RATE", "12")
+
+	b := &PriceBreakdown{Subtotal: 890, DiscountAmount: 89, ShippingCost: 150}
+	b.finalize()
+
+	if b.TaxAmount != 96.12 {
+		t.Errorf("TaxAmount = %v, want 96.12", b.TaxAmount)
+	}
+	if b.TotalAmount != 1047.12 {
+		t.Errorf("TotalAmount = %v, want 1047.12", b.TotalAmount)
+	}
+}
+
+func TestPriceBreakdownMismatches(t *testing.T) {
+	b := &PriceBreakdown{
+		Lines:        []PricedLine{{ProductID: 4, Quantity: 1, UnitPrice: 890, LineTotal: 890}},
+		Subtotal:     890,
+		ShippingCost: 150,
+		TotalAmount:  1040,
+	}
+
+	tampered := 1.0
+	exact := 1040.0
+	rounding := 1040.004
+
+	tests := []struct {
+		name      string
+		submitted SubmittedTotals
+		want      int
+	}{
+		{"nothing submitted", SubmittedTotals{}, 0},
+		{"matching total", SubmittedTotals{TotalAmount: &exact}, 0},
+		{"within rounding tolerance", SubmittedTotals{TotalAmount: &rounding}, 0},
+		{"tampered total", SubmittedTotals{TotalAmount: &tampered}, 1},
+		{"tampered line price", SubmittedTotals{LinePrices: map[int]float64{0: 1}}, 1},
+		{"tampered total and line", SubmittedTotals{TotalAmount: &tampered, LinePrices: map[int]float64{0: 1}}, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := b.Mismatches(tt.submitted)
+			if len(got) != tt.want {
+				t.Errorf("Mismatches() returned %d mismatches, want %d: %+v", len(got), tt.want, got)
+			}
+		})
+	}
+}
+
+func TestIsPricingError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"no items", ErrNoItemsToPrice, true},
+		{"bad quantity", ErrInvalidQuantity, true},
+		{"unknown shipping", ErrUnknownShipping, true},
+		{"missing variant", fmt.Errorf("Shawl (Red / M): %w", ErrVariantNotFound), true},
+		{"missing product", &ProductNotFoundError{ProductID: 4}, true},
+		{"coupon can't be used", ErrCouponFirstOrder, true},
+		{"database failure", fmt.Errorf("failed to load promotions: %w", errors.New("connection refused")), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsPricingError(tt.err); got != tt.want {
+				t.Errorf("IsPricingError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,836 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"pashmina-backend/models"
+	"pashmina-backend/utils"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Import job statuses
+const (
+	ImportValidated = "validated" // dry run that found no problems
+	ImportInvalid   = "invalid"   // validation found problems; nothing was written
+	ImportPending   = "pending"
+	ImportRunning   = "running"
+	ImportCompleted = "completed"
+	ImportFailed    = "failed"
+)
+
+// ProductSheetColumns are the columns of a product import or export file. Each row
+// is one variant, or one product when it has no variants; rows sharing a
+// product_sku make up one product and repeat (or leave blank) its columns.
+var ProductSheetColumns = []string{
+	"id", "product_sku", "name", "description", "category", "price", "colors", "sizes", "stock",
+	"is_featured", "status", "images",
+	"variant_sku", "variant_color", "variant_size", "variant_price", "variant_stock", "variant_weight",
+	"variant_barcode", "variant_active",
+}
+
+// legacyImportColumns maps columns of files exported by earlier versions to the
+// ones that replaced them
+var legacyImportColumns = map[string]string{"is_active": "status"}
+
+// requiredImportColumns must be present in the header of an import file
+var requiredImportColumns = []string{"product_sku", "name", "category", "price"}
+
+// maxImportIssues caps the problems kept on a job so a badly broken file doesn't
+// produce a huge record
+const maxImportIssues = 500
+
+// MaxImportSize returns the largest accepted import file in bytes
+func MaxImportSize() int64 {
+	return int64(envFloat("IMPORT_MAX_UPLOAD_MB", 20) * 1024 * 1024)
+}
+
+// ImportVariant is a variant row of an import file
+type ImportVariant struct {
+	Row      int
+	SKU      string
+	Color    string
+	Size     string
+	Price    *float64
+	Stock    int
+	Weight   float64
+	Barcode  string
+	IsActive bool
+}
+
+// ImportProduct is a product assembled from the rows of an import file that share
+// its SKU
+type ImportProduct struct {
+	Row          int // first row of the product
+	ID           uint
+	SKU          string
+	Name         string
+	Description  string
+	CategorySlug string
+	CategoryID   uint
+	Price        float64
+	Colors       []string
+	Sizes        []string
+	Stock        int
+	IsFeatured   bool
+	Status       string
+	Images       []string
+	Variants     []ImportVariant
+
+	// existingID is the product the row updates, or 0 to create one
+	existingID uint
+}
+
+// ImportPlan is a parsed and validated import file
+type ImportPlan struct {
+	Rows     int
+	Products []*ImportProduct
+	Issues   models.ImportIssues
+}
+
+func (p *ImportPlan) addIssue(row int, column, format string, args ...interface{}) {
+	p.Issues = append(p.Issues, models.ImportIssue{Row: row, Column: column, Message: fmt.Sprintf(format, args...)})
+}
+
+// Counts returns how many products the plan creates and how many it updates
+func (p *ImportPlan) Counts() (creates, updates int) {
+	for _, product := range p.Products {
+		if product.existingID == 0 {
+			creates++
+		} else {
+			updates++
+		}
+	}
+	return creates, updates
+}
+
+// PlanProductImport reads an import file and checks every row against the
+// catalog without writing anything. The error is only for unreadable files;
+// problems with rows are reported in the plan's issues.
+func PlanProductImport(db *gorm.DB, data []byte, format string) (*ImportPlan, error) {
+	rows, err := ReadSheet(data, format)
+	if err != nil {
+		return nil, err
+	}
+	plan := ParseProductSheet(rows)
+	if err := resolveImportPlan(db, plan); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// ParseProductSheet validates the rows of an import file on their own, grouping
+// them into products. Row numbers in issues are spreadsheet rows, counting the
+// header as row 1.
+func ParseProductSheet(rows [][]string) *ImportPlan {
+	plan := &ImportPlan{}
+	if len(rows) == 0 {
+		plan.addIssue(0, "", "file is empty")
+		return plan
+	}
+
+	columns := map[string]int{}
+	known := map[string]bool{}
+	for _, name := range ProductSheetColumns {
+		known[name] = true
+	}
+	for i, name := range rows[0] {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		if name == "" {
+			continue
+		}
+		if replacement, ok := legacyImportColumns[name]; ok {
+			name = replacement
+		}
+		if !known[name] {
+			plan.addIssue(1, name, "unknown column")
+			continue
+		}
+		if _, dup := columns[name]; dup {
+			plan.addIssue(1, name, "column appears twice")
+			continue
+		}
+		columns[name] = i
+	}
+	for _, name := range requiredImportColumns {
+		if _, ok := columns[name]; !ok {
+			plan.addIssue(1, name, "required column is missing")
+		}
+	}
+	if len(plan.Issues) > 0 {
+		return plan
+	}
+
+	bySKU := map[string]*ImportProduct{}
+	firstValues := map[string]map[string]string{}
+	variantRows := map[string]int{}
+
+	for i, record := range rows[1:] {
+		line := i + 2
+		get := func(column string) string {
+			idx, ok := columns[column]
+			if !ok || idx >= len(record) {
+				return ""
+			}
+			return unescapeCell(strings.TrimSpace(record[idx]))
+		}
+		if isBlankRecord(record) {
+			continue
+		}
+		plan.Rows++
+
+		sku := strings.ToUpper(get("product_sku"))
+		if err := utils.ValidateSKU(sku); err != nil {
+			plan.addIssue(line, "product_sku", "%s", err.Error())
+			continue
+		}
+
+		product, seen := bySKU[sku]
+		if !seen {
+			product = parseImportProduct(plan, line, sku, get)
+			bySKU[sku] = product
+			plan.Products = append(plan.Products, product)
+			firstValues[sku] = map[string]string{}
+			for _, column := range ProductSheetColumns[:12] {
+				firstValues[sku][column] = get(column)
+			}
+		} else {
+			for _, column := range ProductSheetColumns[:12] {
+				if value := get(column); value != "" && !sameCell(column, value, firstValues[sku][column]) {
+					plan.addIssue(line, column, "differs from row %d; every row of a product must repeat or leave blank its columns", product.Row)
+				}
+			}
+		}
+
+		variantSKU := strings.ToUpper(get("variant_sku"))
+		if variantSKU == "" {
+			for _, column := range ProductSheetColumns[13:] {
+				if get(column) != "" {
+					plan.addIssue(line, "variant_sku", "variant_sku is required when other variant columns are set")
+					break
+				}
+			}
+			continue
+		}
+		if first, dup := variantRows[variantSKU]; dup {
+			plan.addIssue(line, "variant_sku", "variant %s already appears on row %d", variantSKU, first)
+			continue
+		}
+		variantRows[variantSKU] = line
+		if variant, ok := parseImportVariant(plan, line, variantSKU, get); ok {
+			product.Variants = append(product.Variants, variant)
+		}
+	}
+
+	if plan.Rows == 0 {
+		plan.addIssue(0, "", "file has no product rows")
+	}
+	return plan
+}
+
+func parseImportProduct(plan *ImportPlan, line int, sku string, get func(string) string) *ImportProduct {
+	product := &ImportProduct{Row: line, SKU: sku}
+
+	if id := get("id"); id != "" {
+		n, err := strconv.ParseUint(id, 10, 64)
+		if err != nil || n == 0 {
+			plan.addIssue(line, "id", "must be a product ID")
+		}
+		product.ID = uint(n)
+	}
+
+	product.Name = get("name")
+	if err := utils.ValidateProductName(product.Name); err != nil {
+		plan.addIssue(line, "name", "%s", err.Error())
+	}
+	product.Name = utils.SanitizeString(product.Name, 255)
+	product.Description = utils.SanitizeString(get("description"), 2000)
+
+	product.CategorySlug = strings.ToLower(get("category"))
+	if err := utils.ValidateSlug(product.CategorySlug); err != nil {
+		plan.addIssue(line, "category", "%s", err.Error())
+	}
+
+	if price, err := strconv.ParseFloat(get("price"), 64); err != nil {
+		plan.addIssue(line, "price", "must be a number")
+	} else if err := utils.ValidatePrice(price); err != nil {
+		plan.addIssue(line, "price", "%s", err.Error())
+	} else {
+		product.Price = price
+	}
+
+	product.Colors = splitCellList(get("colors"), 100)
+	product.Sizes = splitCellList(get("sizes"), 100)
+
+	if stock := get("stock"); stock != "" {
+		if n, err := strconv.Atoi(stock); err != nil {
+			plan.addIssue(line, "stock", "must be a whole number")
+		} else if err := utils.ValidateStock(n); err != nil {
+			plan.addIssue(line, "stock", "%s", err.Error())
+		} else {
+			product.Stock = n
+		}
+	}
+
+	product.IsFeatured = parseCellBool(plan, line, "is_featured", get("is_featured"), false)
+	product.Status = parseCellStatus(plan, line, get("status"))
+
+	for _, image := range splitCellList(get("images"), 2048) {
+		if err := validateImportURL(image); err != nil {
+			plan.addIssue(line, "images", "%s: %s", image, err.Error())
+			continue
+		}
+		product.Images = append(product.Images, image)
+	}
+	return product
+}
+
+func parseImportVariant(plan *ImportPlan, line int, sku string, get func(string) string) (ImportVariant, bool) {
+	issues := len(plan.Issues)
+	variant := ImportVariant{
+		Row:     line,
+		SKU:     sku,
+		Color:   utils.SanitizeString(get("variant_color"), 100),
+		Size:    utils.SanitizeString(get("variant_size"), 100),
+		Barcode: get("variant_barcode"),
+	}
+	if err := utils.ValidateSKU(sku); err != nil {
+		plan.addIssue(line, "variant_sku", "%s", err.Error())
+	}
+	if price := get("variant_price"); price != "" {
+		if n, err := strconv.ParseFloat(price, 64); err != nil {
+			plan.addIssue(line, "variant_price", "must be a number")
+		} else if err := utils.ValidatePrice(n); err != nil {
+			plan.addIssue(line, "variant_price", "%s", err.Error())
+		} else {
+			variant.Price = &n
+		}
+	}
+	if stock := get("variant_stock"); stock != "" {
+		if n, err := strconv.Atoi(stock); err != nil {
+			plan.addIssue(line, "variant_stock", "must be a whole number")
+		} else if err := utils.ValidateStock(n); err != nil {
+			plan.addIssue(line, "variant_stock", "%s", err.Error())
+		} else {
+			variant.Stock = n
+		}
+	}
+	if weight := get("variant_weight"); weight != "" {
+		if n, err := strconv.ParseFloat(weight, 64); err != nil {
+			plan.addIssue(line, "variant_weight", "must be a number")
+		} else if err := utils.ValidateWeight(n); err != nil {
+			plan.addIssue(line, "variant_weight", "%s", err.Error())
+		} else {
+			variant.Weight = n
+		}
+	}
+	variant.IsActive = parseCellBool(plan, line, "variant_active", get("variant_active"), true)
+	return variant, len(plan.Issues) == issues
+}
+
+// resolveImportPlan checks a parsed plan against the database: categories must
+// exist, and SKUs must not belong to other products
+func resolveImportPlan(db *gorm.DB, plan *ImportPlan) error {
+	if len(plan.Products) == 0 {
+		return nil
+	}
+
+	var slugs, skus, variantSKUs []string
+	var ids []uint
+	for _, p := range plan.Products {
+		slugs = append(slugs, p.CategorySlug)
+		skus = append(skus, p.SKU)
+		if p.ID != 0 {
+			ids = append(ids, p.ID)
+		}
+		for _, v := range p.Variants {
+			variantSKUs = append(variantSKUs, v.SKU)
+		}
+	}
+
+	var categories []models.Category
+	if err := db.Where("slug IN ?", slugs).Find(&categories).Error; err != nil {
+		return err
+	}
+	categoryIDs := map[string]uint{}
+	for _, c := range categories {
+		categoryIDs[c.Slug] = c.ID
+	}
+
+	// Soft-deleted products and variants still hold their SKUs; importing them
+	// again restores them
+	var bySKU []models.Product
+	if err := db.Unscoped().Select("id", "sku").Where("sku IN ?", skus).Find(&bySKU).Error; err != nil {
+		return err
+	}
+	productIDs := map[string]uint{}
+	for _, p := range bySKU {
+		productIDs[*p.SKU] = p.ID
+	}
+
+	byID := []models.Product{}
+	if len(ids) > 0 {
+		if err := db.Unscoped().Select("id", "sku").Where("id IN ?", ids).Find(&byID).Error; err != nil {
+			return err
+		}
+	}
+	productSKUs := map[uint]*string{}
+	for _, p := range byID {
+		productSKUs[p.ID] = p.SKU
+	}
+
+	var variants []models.ProductVariant
+	if len(variantSKUs) > 0 {
+		if err := db.Unscoped().Select("sku", "product_id").Where("sku IN ?", variantSKUs).Find(&variants).Error; err != nil {
+			return err
+		}
+	}
+	variantOwners := map[string]uint{}
+	for _, v := range variants {
+		variantOwners[v.SKU] = v.ProductID
+	}
+
+	seenIDs := map[uint]int{}
+	for _, p := range plan.Products {
+		if id, ok := categoryIDs[p.CategorySlug]; ok {
+			p.CategoryID = id
+		} else if p.CategorySlug != "" {
+			plan.addIssue(p.Row, "category", "category %q does not exist", p.CategorySlug)
+		}
+
+		p.existingID = productIDs[p.SKU]
+		if p.ID != 0 {
+			sku, ok := productSKUs[p.ID]
+			first, dup := seenIDs[p.ID]
+			seenIDs[p.ID] = p.Row
+			switch {
+			case dup:
+				plan.addIssue(p.Row, "id", "product %d is also imported on row %d", p.ID, first)
+			case !ok:
+				plan.addIssue(p.Row, "id", "product %d does not exist", p.ID)
+			case p.existingID != 0 && p.existingID != p.ID:
+				plan.addIssue(p.Row, "product_sku", "%s belongs to product %d", p.SKU, p.existingID)
+			case sku != nil && *sku != p.SKU:
+				plan.addIssue(p.Row, "product_sku", "product %d already has SKU %s", p.ID, *sku)
+			default:
+				p.existingID = p.ID
+			}
+		}
+
+		for _, v := range p.Variants {
+			if owner, ok := variantOwners[v.SKU]; ok && owner != p.existingID {
+				plan.addIssue(v.Row, "variant_sku", "variant %s belongs to product %d", v.SKU, owner)
+			}
+		}
+	}
+	return nil
+}
+
+// NewImportJob records a planned import. Dry runs and plans with problems are
+// finished as soon as they are recorded.
+func NewImportJob(db *gorm.DB, plan *ImportPlan, filename, format string, dryRun bool, createdBy *uint) (*models.ImportJob, error) {
+	job := models.ImportJob{
+		Filename:      filename,
+		Format:        format,
+		DryRun:        dryRun,
+		Status:        ImportPending,
+		TotalRows:     plan.Rows,
+		TotalProducts: len(plan.Products),
+		Issues:        truncateIssues(plan.Issues),
+		CreatedBy:     createdBy,
+	}
+	job.Created, job.Updated = plan.Counts()
+	if job.Issues == nil {
+		job.Issues = models.ImportIssues{}
+	}
+	if dryRun || len(plan.Issues) > 0 {
+		now := time.Now()
+		job.FinishedAt = &now
+		job.Status = ImportValidated
+		if len(plan.Issues) > 0 {
+			job.Status = ImportInvalid
+		}
+	} else {
+		// Counted again as products are written
+		job.Created, job.Updated = 0, 0
+	}
+	if err := db.Create(&job).Error; err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// RunProductImport writes a validated plan, one product per transaction, keeping
+// the job's progress up to date. A product that fails is reported on the job and
+// the import carries on with the next. Images are fetched into media storage.
+func RunProductImport(ctx context.Context, db *gorm.DB, storage Storage, images *ImagePipeline, job *models.ImportJob, plan *ImportPlan) {
+	issues := models.ImportIssues{}
+	finish := func(status string) {
+		now := time.Now()
+		job.Status, job.FinishedAt, job.Issues = status, &now, truncateIssues(issues)
+		if err := db.Model(job).Select("status", "processed", "created", "updated", "failed", "issues", "finished_at").
+			Updates(job).Error; err != nil {
+			utils.Error("Failed to save import job", map[string]interface{}{"job_id": job.ID, "error": err.Error()})
+		}
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			utils.Error("Product import panicked", map[string]interface{}{"job_id": job.ID, "panic": fmt.Sprint(r)})
+			issues = append(issues, models.ImportIssue{Message: "import stopped unexpectedly"})
+			finish(ImportFailed)
+		}
+		InvalidateFacets()
+		Suggestions(db).Refresh()
+	}()
+
+	db.Model(job).Update("status", ImportRunning)
+	utils.Info("Product import started", map[string]interface{}{"job_id": job.ID, "products": len(plan.Products)})
+
+	for _, p := range plan.Products {
+		id, err := importProduct(db, p)
+		if err != nil {
+			job.Failed++
+			issues = append(issues, models.ImportIssue{Row: p.Row, Message: "failed to save product: " + err.Error()})
+		} else {
+			if p.existingID == 0 {
+				job.Created++
+			} else {
+				job.Updated++
+			}
+			for _, source := range p.Images {
+				if err := importProductImage(ctx, db, storage, images, id, source, job.CreatedBy); err != nil {
+					issues = append(issues, models.ImportIssue{Row: p.Row, Column: "images", Message: source + ": " + err.Error()})
+				}
+			}
+		}
+		job.Processed++
+		db.Model(job).Select("processed", "created", "updated", "failed").Updates(job)
+	}
+
+	finish(ImportCompleted)
+	utils.Info("Product import finished", map[string]interface{}{
+		"job_id": job.ID, "created": job.Created, "updated": job.Updated, "failed": job.Failed,
+	})
+}
+
+// importProduct upserts a product and its variants by SKU and returns its ID.
+// Variants already on the product that the file doesn't mention are left alone.
+func importProduct(db *gorm.DB, p *ImportProduct) (uint, error) {
+	var product models.Product
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if p.existingID != 0 {
+			if err := tx.Unscoped().Clauses(clause.Locking{Strength: "UPDATE"}).First(&product, p.existingID).Error; err != nil {
+				return err
+			}
+		}
+		sku := p.SKU
+		product.SKU = &sku
+		product.Name = p.Name
+		product.Description = p.Description
+		product.CategoryID = p.CategoryID
+		product.Price = p.Price
+		product.IsFeatured = p.IsFeatured
+		product.Status = p.Status
+		product.DeletedAt = gorm.DeletedAt{}
+		// Stock, colors and sizes of products with variants come from the variants
+		if len(p.Variants) == 0 || product.ID == 0 {
+			product.Colors = p.Colors
+			product.Sizes = p.Sizes
+			product.Stock = p.Stock
+		}
+
+		if product.ID == 0 {
+			if err := tx.Create(&product).Error; err != nil {
+				return err
+			}
+		} else if err := tx.Unscoped().Omit(clause.Associations).Save(&product).Error; err != nil {
+			return err
+		}
+		for _, v := range p.Variants {
+			var variant models.ProductVariant
+			err := tx.Unscoped().Where("sku = ?", v.SKU).First(&variant).Error
+			if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+				return err
+			}
+			variant.ProductID = product.ID
+			variant.SKU = v.SKU
+			variant.Color = v.Color
+			variant.Size = v.Size
+			variant.Price = v.Price
+			variant.Stock = v.Stock
+			variant.Weight = v.Weight
+			variant.Barcode = v.Barcode
+			variant.IsActive = v.IsActive
+			variant.DeletedAt = gorm.DeletedAt{}
+			if variant.ID == 0 {
+				err = tx.Create(&variant).Error
+			} else {
+				err = tx.Unscoped().Save(&variant).Error
+			}
+			if err != nil {
+				return err
+			}
+			if !v.IsActive {
+				if err := tx.Model(&variant).Update("is_active", false).Error; err != nil {
+					return err
+				}
+			}
+		}
+		// A row without variants sets the stock of a product that has no active ones
+		if len(p.Variants) == 0 {
+			count, err := activeVariantCount(tx, product.ID)
+			if err != nil || count == 0 {
+				return err
+			}
+		}
+		return SyncProductFromVariants(tx, product.ID)
+	})
+	return product.ID, err
+}
+
+// importProductImage adds an image to a product's gallery unless it is already
+// there. URLs of existing media, or ones imported before, are not fetched again.
+func importProductImage(ctx context.Context, db *gorm.DB, storage Storage, images *ImagePipeline, productID uint, source string, createdBy *uint) error {
+	var media models.Media
+	err := db.Where("url = ? OR source_url = ?", source, source).First(&media).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		data, err := fetchImportImage(ctx, source)
+		if err != nil {
+			return err
+		}
+		stored, err := StoreMedia(ctx, db, storage, data, createdBy)
+		if err != nil {
+			return err
+		}
+		if stored.SourceURL == "" {
+			db.Model(stored).Update("source_url", source)
+		}
+		if images != nil {
+			images.Pregenerate(ctx, stored)
+		}
+		media = *stored
+	} else if err != nil {
+		return err
+	}
+
+	var count int64
+	db.Model(&models.ProductImage{}).Where("product_id = ? AND media_id = ?", productID, media.ID).Count(&count)
+	if count > 0 {
+		return nil
+	}
+	_, err = AddProductImage(db, productID, &media, "", false)
+	return err
+}
+
+// importHTTPClient fetches import images. It refuses private and loopback
+// addresses so an import file can't be used to reach internal services.
+var importHTTPClient = &http.Client{
+	Timeout: 30 * time.Second,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{Timeout: 10 * time.Second, Control: publicAddressOnly}).DialContext,
+	},
+}
+
+func publicAddressOnly(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsUnspecified() {
+		return errors.New("refusing to fetch from a private address")
+	}
+	return nil
+}
+
+func fetchImportImage(ctx context.Context, source string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := importHTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("download failed with status %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, MaxUploadSize()+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > MaxUploadSize() {
+		return nil, ErrMediaTooLarge
+	}
+	return data, nil
+}
+
+// FailInterruptedImports marks imports that were running when the server stopped
+// as failed; their plans only lived in memory
+func FailInterruptedImports(db *gorm.DB) {
+	now := time.Now()
+	issues := models.ImportIssues{{Message: "interrupted by a server restart; upload the file again to finish"}}
+	db.Model(&models.ImportJob{}).Where("status IN ?", []string{ImportPending, ImportRunning}).
+		Updates(map[string]interface{}{"status": ImportFailed, "issues": issues, "finished_at": now})
+}
+
+// ExportProducts writes the catalog in the import file layout, so an export can be
+// edited and imported again
+func ExportProducts(db *gorm.DB, w io.Writer, format string) error {
+	var products []models.Product
+	err := db.Preload("Category").
+		Preload("Variants", func(db *gorm.DB) *gorm.DB { return db.Order("id ASC") }).
+		Preload("Images", func(db *gorm.DB) *gorm.DB { return db.Order("position ASC") }).
+		Order("id ASC").Find(&products).Error
+	if err != nil {
+		return err
+	}
+
+	rows := [][]string{ProductSheetColumns}
+	for _, p := range products {
+		sku := ""
+		if p.SKU != nil {
+			sku = *p.SKU
+		}
+		var images []string
+		for _, image := range p.Images {
+			images = append(images, image.URL)
+		}
+		if len(images) == 0 && p.Image != "" {
+			images = append(images, p.Image)
+		}
+		base := []string{
+			strconv.FormatUint(uint64(p.ID), 10), sku, escapeCell(p.Name), escapeCell(p.Description), p.Category.Slug,
+			formatCellFloat(p.Price), escapeCell(strings.Join(p.Colors, "|")), escapeCell(strings.Join(p.Sizes, "|")),
+			strconv.Itoa(p.Stock), strconv.FormatBool(p.IsFeatured), p.Status, strings.Join(images, "|"),
+		}
+
+		if len(p.Variants) == 0 {
+			rows = append(rows, append(base, make([]string, len(ProductSheetColumns)-len(base))...))
+			continue
+		}
+		for _, v := range p.Variants {
+			price := ""
+			if v.Price != nil {
+				price = formatCellFloat(*v.Price)
+			}
+			row := append(append([]string{}, base...),
+				v.SKU, escapeCell(v.Color), escapeCell(v.Size), price, strconv.Itoa(v.Stock),
+				formatCellFloat(v.Weight), escapeCell(v.Barcode), strconv.FormatBool(v.IsActive))
+			rows = append(rows, row)
+		}
+	}
+	return WriteSheet(w, format, rows)
+}
+
+// escapeCell stops spreadsheet programs from running text that looks like a
+// formula; unescapeCell undoes it on import
+func escapeCell(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+func unescapeCell(value string) string {
+	if len(value) > 1 && value[0] == '\'' && strings.ContainsRune("=+-@\t\r", rune(value[1])) {
+		return value[1:]
+	}
+	return value
+}
+
+// sameCell compares two values of a product column the way they are parsed
+func sameCell(column, a, b string) bool {
+	switch column {
+	case "product_sku", "category", "status":
+		return strings.EqualFold(a, b)
+	}
+	return a == b
+}
+
+func formatCellFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// splitCellList splits a pipe-separated cell into trimmed, non-empty values
+func splitCellList(value string, max int) []string {
+	var items []string
+	for _, item := range strings.Split(value, "|") {
+		if item = utils.SanitizeString(strings.TrimSpace(item), max); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func parseCellBool(plan *ImportPlan, line int, column, value string, fallback bool) bool {
+	switch strings.ToLower(value) {
+	case "":
+		return fallback
+	case "true", "yes", "y", "1":
+		return true
+	case "false", "no", "n", "0":
+		return false
+	}
+	plan.addIssue(line, column, "must be true or false")
+	return fallback
+}
+
+// parseCellStatus reads a product status. Files exported before products had a
+// status have is_active instead, whose true and false mean published and draft.
+func parseCellStatus(plan *ImportPlan, line int, value string) string {
+	switch status := strings.ToLower(value); status {
+	case "":
+		return ProductPublished
+	case ProductDraft, ProductPublished, ProductArchived:
+		return status
+	case "true", "yes", "y", "1":
+		return ProductPublished
+	case "false", "no", "n", "0":
+		return ProductDraft
+	}
+	plan.addIssue(line, "status", "%s", ErrInvalidProductStatus.Error())
+	return ProductPublished
+}
+
+func validateImportURL(value string) error {
+	if err := utils.ValidateURL(value); err != nil {
+		return err
+	}
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("image URLs must be absolute http or https URLs")
+	}
+	return nil
+}
+
+func isBlankRecord(record []string) bool {
+	for _, value := range record {
+		if strings.TrimSpace(value) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+func truncateIssues(issues models.ImportIssues) models.ImportIssues {
+	if len(issues) > maxImportIssues {
+		dropped := len(issues) - maxImportIssues
+		issues = append(issues[:maxImportIssues:maxImportIssues], models.ImportIssue{
+			Message: fmt.Sprintf("%d more problems not shown", dropped),
+		})
+	}
+	return issues
+}
@@ -0,0 +1,199 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"pashmina-backend/models"
+)
+
+var importHeader = []string{"product_sku", "name", "category", "price", "stock", "images", "variant_sku", "variant_color", "variant_size", "variant_price", "variant_stock"}
+
+func TestParseProductSheet(t *testing.T) {
+	rows := [][]string{
+		importHeader,
+		{"kani-1", "Kani Shawl", "Heritage", "620", "", "https://cdn.example.com/kani.jpg|https://cdn.example.com/kani-2.jpg", "KANI-1-GRY", "Grey", "Standard", "", "4"},
+		{"KANI-1", "", "", "", "", "", "KANI-1-NVY", "Navy", "Standard", "640", "2"},
+		{"", "", "", "", "", "", "", "", "", "", ""},
+		{"SOLID-1", "Solid Wrap", "classic", "380", "20", "", "", "", "", "", ""},
+	}
+	plan := ParseProductSheet(rows)
+	if len(plan.Issues) > 0 {
+		t.Fatalf("issues = %+v", plan.Issues)
+	}
+	if plan.Rows != 3 || len(plan.Products) != 2 {
+		t.Fatalf("parsed %d rows into %d products, want 3 rows and 2 products", plan.Rows, len(plan.Products))
+	}
+
+	kani := plan.Products[0]
+	if kani.SKU != "KANI-1" || kani.CategorySlug != "heritage" || kani.Price != 620 || kani.Status != ProductPublished || kani.IsFeatured {
+		t.Errorf("kani = %+v", kani)
+	}
+	if len(kani.Images) != 2 || len(kani.Variants) != 2 {
+		t.Fatalf("kani has %d images and %d variants, want 2 and 2", len(kani.Images), len(kani.Variants))
+	}
+	if v := kani.Variants[0]; v.SKU != "KANI-1-GRY" || v.Price != nil || v.Stock != 4 || v.Row != 2 {
+		t.Errorf("first variant = %+v", v)
+	}
+	if v := kani.Variants[1]; v.Price == nil || *v.Price != 640 {
+		t.Errorf("second variant price = %v, want 640", v.Price)
+	}
+	if solid := plan.Products[1]; solid.Stock != 20 || solid.Row != 5 || len(solid.Variants) != 0 {
+		t.Errorf("solid = %+v", solid)
+	}
+}
+
+func TestParseProductSheetIssues(t *testing.T) {
+	tests := []struct {
+		name   string
+		rows   [][]string
+		row    int
+		column string
+		want   string
+	}{
+		{
+			name:   "missing required column",
+			rows:   [][]string{{"product_sku", "name", "price"}},
+			row:    1,
+			column: "category",
+			want:   "required column is missing",
+		},
+		{
+			name:   "unknown column",
+			rows:   [][]string{append([]string{"colour"}, importHeader...)},
+			row:    1,
+			column: "colour",
+			want:   "unknown column",
+		},
+		{
+			name:   "bad product sku",
+			rows:   [][]string{importHeader, {"kani 1", "Kani Shawl", "heritage", "620"}},
+			row:    2,
+			column: "product_sku",
+			want:   "invalid sku format",
+		},
+		{
+			name:   "short name",
+			rows:   [][]string{importHeader, {"KANI-1", "K", "heritage", "620"}},
+			row:    2,
+			column: "name",
+			want:   "at least 2 characters",
+		},
+		{
+			name:   "price not a number",
+			rows:   [][]string{importHeader, {"KANI-1", "Kani Shawl", "heritage", "six hundred"}},
+			row:    2,
+			column: "price",
+			want:   "must be a number",
+		},
+		{
+			name:   "negative stock",
+			rows:   [][]string{importHeader, {"KANI-1", "Kani Shawl", "heritage", "620", "-1"}},
+			row:    2,
+			column: "stock",
+			want:   "cannot be negative",
+		},
+		{
+			name:   "relative image URL",
+			rows:   [][]string{importHeader, {"KANI-1", "Kani Shawl", "heritage", "620", "", "/uploads/kani.jpg"}},
+			row:    2,
+			column: "images",
+			want:   "absolute http",
+		},
+		{
+			name: "conflicting product columns",
+			rows: [][]string{importHeader,
+				{"KANI-1", "Kani Shawl", "heritage", "620", "", "", "KANI-1-GRY"},
+				{"KANI-1", "Kani Wrap", "heritage", "620", "", "", "KANI-1-NVY"},
+			},
+			row:    3,
+			column: "name",
+			want:   "differs from row 2",
+		},
+		{
+			name: "duplicate variant sku",
+			rows: [][]string{importHeader,
+				{"KANI-1", "Kani Shawl", "heritage", "620", "", "", "KANI-1-GRY"},
+				{"KANI-1", "", "", "", "", "", "kani-1-gry"},
+			},
+			row:    3,
+			column: "variant_sku",
+			want:   "already appears on row 2",
+		},
+		{
+			name:   "variant columns without a sku",
+			rows:   [][]string{importHeader, {"KANI-1", "Kani Shawl", "heritage", "620", "", "", "", "Grey"}},
+			row:    2,
+			column: "variant_sku",
+			want:   "variant_sku is required",
+		},
+		{
+			name:   "unknown status",
+			rows:   [][]string{{"product_sku", "name", "category", "price", "status"}, {"KANI-1", "Kani Shawl", "heritage", "620", "hidden"}},
+			row:    2,
+			column: "status",
+			want:   "draft, published or archived",
+		},
+		{
+			name: "no rows",
+			rows: [][]string{importHeader, {"", ""}},
+			want: "no product rows",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan := ParseProductSheet(tt.rows)
+			for _, issue := range plan.Issues {
+				if issue.Row == tt.row && issue.Column == tt.column && strings.Contains(issue.Message, tt.want) {
+					return
+				}
+			}
+			t.Errorf("issues = %+v, want row %d %s: %q", plan.Issues, tt.row, tt.column, tt.want)
+		})
+	}
+}
+
+func TestParseProductSheetStatus(t *testing.T) {
+	tests := []struct {
+		header, value, want string
+	}{
+		{"status", "", ProductPublished},
+		{"status", "Draft", ProductDraft},
+		{"status", "archived", ProductArchived},
+		// Files exported before products had a status have is_active instead
+		{"is_active", "false", ProductDraft},
+		{"is_active", "true", ProductPublished},
+	}
+	for _, tt := range tests {
+		plan := ParseProductSheet([][]string{
+			{"product_sku", "name", "category", "price", tt.header},
+			{"KANI-1", "Kani Shawl", "heritage", "620", tt.value},
+		})
+		if len(plan.Issues) > 0 || len(plan.Products) != 1 {
+			t.Errorf("%s=%q: issues = %+v", tt.header, tt.value, plan.Issues)
+			continue
+		}
+		if got := plan.Products[0].Status; got != tt.want {
+			t.Errorf("%s=%q: status = %q, want %q", tt.header, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestCellEscaping(t *testing.T) {
+	for _, value := range []string{"=HYPERLINK(\"x\")", "+1", "-shawl", "@sum", "plain", "", "'quoted"} {
+		if got := unescapeCell(escapeCell(value)); got != value {
+			t.Errorf("unescapeCell(escapeCell(%q)) = %q", value, got)
+		}
+	}
+	if got := escapeCell("=1+1"); got != "'=1+1" {
+		t.Errorf("escapeCell(=1+1) = %q", got)
+	}
+}
+
+func TestTruncateIssues(t *testing.T) {
+	issues := make(models.ImportIssues, maxImportIssues+20)
+	got := truncateIssues(issues)
+	if len(got) != maxImportIssues+1 || !strings.Contains(got[maxImportIssues].Message, "20 more") {
+		t.Errorf("truncated to %d issues ending %q", len(got), got[len(got)-1].Message)
+	}
+}
@@ -0,0 +1,320 @@
+package services
+
+import (
+	"errors"
+	"math"
+	"time"
+
+	"pashmina-backend/models"
+
+	"gorm.io/gorm"
+)
+
+// Promotion types, besides the percentage and fixed discounts they share with
+// coupons, which promotions take off each covered item
+const (
+	PromotionTieredSpend = "tiered_spend"
+	PromotionFreeGift    = "free_gift"
+)
+
+var ErrCouponPromotion = errors.New("coupon can't be combined with the promotions on this order")
+
+// PromotionSummary is what the storefront shows of a promotion
+type PromotionSummary struct {
+	ID          uint   `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Type        string `json:"type"`
+}
+
+// AppliedPromotion is a promotion applied to an order and what it took off,
+// counting the value of any free gift
+type AppliedPromotion struct {
+	PromotionSummary
+	Discount float64 `json:"discount"`
+
+	combinesWithCoupons bool
+}
+
+// ProductOffer is what the storefront shows of the promotions running on a product.
+// SalePrice is set when a single item costs less than its list price.
+type ProductOffer struct {
+	SalePrice  *float64           `json:"sale_price,omitempty"`
+	Promotions []PromotionSummary `json:"promotions,omitempty"`
+}
+
+func summarizePromotion(p *models.Promotion) PromotionSummary {
+	return PromotionSummary{ID: p.ID, Name: p.Name, Description: p.Description, Type: p.Type}
+}
+
+// ActivePromotions returns the promotions running at now, highest priority first
+func ActivePromotions(db *gorm.DB, now time.Time) ([]models.Promotion, error) {
+	var promotions []models.Promotion
+	err := db.Where("is_active = ? AND (starts_at IS NULL OR starts_at <= ?) AND (ends_at IS NULL OR ends_at > ?)", true, now, now).
+		Order("priority DESC, id ASC").Find(&promotions).Error
+	return promotions, err
+}
+
+func loadPromotionScopes(db *gorm.DB, promotions []models.Promotion) ([]productScope, error) {
+	scopes := make([]productScope, len(promotions))
+	for i, p := range promotions {
+		scope, err := loadProductScope(db, p.IncludedCategories, p.ExcludedCategories, p.IncludedProducts, p.ExcludedProducts)
+		if err != nil {
+			return nil, err
+		}
+		scopes[i] = scope
+	}
+	return scopes, nil
+}
+
+// promotionOutcome is what promotions take off a cart's lines and the gifts they add
+type promotionOutcome struct {
+	applied       []AppliedPromotion
+	lineDiscounts []float64
+	gifts         []PricedLine
+}
+
+// evaluatePromotions tries promotions in order against lines. Each works on what
+// earlier ones left of the lines. A promotion that isn't stackable only applies
+// when no other has, and then no others do. giveGift prices a free_gift
+// promotion's gift, returning nil when it can't be given.
+func evaluatePromotions(promotions []models.Promotion, scopes []productScope, lines []PricedLine,
+	giveGift func(*models.Promotion) (*PricedLine, error)) (promotionOutcome, error) {
+	out := promotionOutcome{lineDiscounts: make([]float64, len(lines))}
+	remaining := make([]float64, len(lines))
+	for i, line := range lines {
+		remaining[i] = line.LineTotal
+	}
+
+	exclusive := false
+	for i := range promotions {
+		p := &promotions[i]
+		if exclusive || (len(out.applied) > 0 && !p.Stackable) {
+			continue
+		}
+		discounts, ok := promotionDiscounts(p, scopes[i], lines, remaining)
+		if !ok {
+			continue
+		}
+
+		var total float64
+		if p.Type == PromotionFreeGift {
+			if giveGift == nil {
+				continue
+			}
+			gift, err := giveGift(p)
+			if err != nil {
+				return out, err
+			}
+			if gift == nil {
+				continue
+			}
+			out.gifts = append(out.gifts, *gift)
+			total = gift.LineTotal
+		}
+		for j, d := range discounts {
+			remaining[j] -= d
+			out.lineDiscounts[j] = RoundMoney(out.lineDiscounts[j] + d)
+			total += d
+		}
+		out.applied = append(out.applied, AppliedPromotion{
+			PromotionSummary:    summarizePromotion(p),
+			Discount:            RoundMoney(total),
+			combinesWithCoupons: p.CombinesWithCoupons,
+		})
+		exclusive = !p.Stackable
+	}
+	return out, nil
+}
+
+// promotionDiscounts works out what a promotion takes off each line, given what is
+// left of each line's total. ok is false when the promotion doesn't apply, including
+// when less than its minimum spend goes on the items it covers.
+func promotionDiscounts(p *models.Promotion, scope productScope, lines []PricedLine, remaining []float64) ([]float64, bool) {
+	discounts := make([]float64, len(lines))
+	weights := make([]float64, len(lines))
+	var spend float64
+	for i, line := range lines {
+		if remaining[i] > 0 && scope.covers(line) {
+			weights[i] = remaining[i]
+			spend += remaining[i]
+		}
+	}
+	if spend == 0 || spend < p.MinSpend {
+		return nil, false
+	}
+
+	switch p.Type {
+	case DiscountPercentage:
+		for i, w := range weights {
+			discounts[i] = RoundMoney(w * p.DiscountValue / 100)
+		}
+	case DiscountFixed:
+		for i, w := range weights {
+			if w > 0 {
+				discounts[i] = RoundMoney(math.Min(w, p.DiscountValue*float64(lines[i].Quantity)))
+			}
+		}
+	case PromotionTieredSpend:
+		tier, ok := spendTier(p.Tiers, spend)
+		if !ok {
+			return nil, false
+		}
+		discount := tier.DiscountValue
+		if tier.DiscountType == DiscountPercentage {
+			discount = spend * tier.DiscountValue / 100
+		}
+		discounts = allocateDiscount(RoundMoney(math.Min(discount, spend)), weights)
+	case PromotionFreeGift:
+		// The gift is the discount; the lines themselves are left alone
+		return discounts, true
+	default:
+		return nil, false
+	}
+
+	for _, d := range discounts {
+		if d > 0 {
+			return discounts, true
+		}
+	}
+	return nil, false
+}
+
+// spendTier returns the tier with the highest minimum that spend reaches
+func spendTier(tiers models.PromotionTiers, spend float64) (models.PromotionTier, bool) {
+	var best models.PromotionTier
+	found := false
+	for _, tier := range tiers {
+		if spend >= tier.MinSpend && (!found || tier.MinSpend > best.MinSpend) {
+			best, found = tier, true
+		}
+	}
+	return best, found
+}
+
+// applyPromotions applies the running promotions to the priced lines, adding free
+// gifts as lines of their own with their whole price discounted
+func (b *PriceBreakdown) applyPromotions(tx *gorm.DB, promotions []models.Promotion, rows *catalogRows, now time.Time) error {
+	if len(promotions) == 0 {
+		return nil
+	}
+	scopes, err := loadPromotionScopes(tx, promotions)
+	if err != nil {
+		return err
+	}
+
+	// Gifts are only given while there is stock for them after the cart's own items
+	claimed := map[uint]int{}
+	claimedVariants := map[uint]int{}
+	for _, line := range b.Lines {
+		if line.VariantID != nil {
+			claimedVariants[*line.VariantID] += line.Quantity
+		} else {
+			claimed[line.ProductID] += line.Quantity
+		}
+	}
+	giveGift := func(p *models.Promotion) (*PricedLine, error) {
+		if p.GiftProductID == nil || p.GiftQuantity < 1 {
+			return nil, nil
+		}
+		gift, err := rows.priceLine(tx, PricingItem{
+			ProductID: *p.GiftProductID,
+			VariantID: p.GiftVariantID,
+			Quantity:  p.GiftQuantity,
+		}, now)
+		var notFound *ProductNotFoundError
+		if errors.As(err, &notFound) || errors.Is(err, ErrVariantNotFound) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if gift.Variant != nil {
+			if gift.Variant.Stock < claimedVariants[gift.Variant.ID]+gift.Quantity {
+				return nil, nil
+			}
+			claimedVariants[gift.Variant.ID] += gift.Quantity
+		} else {
+			if gift.Product.Stock < claimed[gift.ProductID]+gift.Quantity {
+				return nil, nil
+			}
+			claimed[gift.ProductID] += gift.Quantity
+		}
+		gift.IsGift = true
+		gift.PromotionID = p.ID
+		gift.Discount = gift.LineTotal
+		return &gift, nil
+	}
+
+	out, err := evaluatePromotions(promotions, scopes, b.Lines, giveGift)
+	if err != nil {
+		return err
+	}
+	for i, d := range out.lineDiscounts {
+		b.Lines[i].Discount = d
+	}
+	for _, gift := range out.gifts {
+		b.Lines = append(b.Lines, gift)
+		b.Subtotal = RoundMoney(b.Subtotal + gift.LineTotal)
+	}
+	for _, applied := range out.applied {
+		b.PromotionDiscount += applied.Discount
+	}
+	b.PromotionDiscount = RoundMoney(b.PromotionDiscount)
+	b.DiscountAmount = RoundMoney(b.DiscountAmount + b.PromotionDiscount)
+	b.Promotions = out.applied
+	return nil
+}
+
+// ProductOffers works out what the storefront shows of the running promotions on
+// each product. Sale prices come from the percentage and fixed promotions a single
+// item gets on its own; spend tiers and gifts depend on the rest of the cart, so
+// those are only listed.
+func ProductOffers(db *gorm.DB, promotions []models.Promotion, products []models.Product) (map[uint]ProductOffer, error) {
+	offers := make(map[uint]ProductOffer, len(products))
+	if len(promotions) == 0 || len(products) == 0 {
+		return offers, nil
+	}
+	scopes, err := loadPromotionScopes(db, promotions)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range products {
+		product := &products[i]
+		line := PricedLine{
+			ProductID: product.ID,
+			Quantity:  1,
+			UnitPrice: product.Price,
+			LineTotal: product.Price,
+			Product:   product,
+		}
+		offers[product.ID] = productOffer(promotions, scopes, line)
+	}
+	return offers, nil
+}
+
+func productOffer(promotions []models.Promotion, scopes []productScope, line PricedLine) ProductOffer {
+	var offer ProductOffer
+	var itemPromotions []models.Promotion
+	var itemScopes []productScope
+	for i := range promotions {
+		p := &promotions[i]
+		if !scopes[i].covers(line) {
+			continue
+		}
+		offer.Promotions = append(offer.Promotions, summarizePromotion(p))
+		if p.Type == DiscountPercentage || p.Type == DiscountFixed {
+			itemPromotions = append(itemPromotions, *p)
+			itemScopes = append(itemScopes, scopes[i])
+		}
+	}
+
+	out, _ := evaluatePromotions(itemPromotions, itemScopes, []PricedLine{line}, nil)
+	if discount := out.lineDiscounts[0]; discount > 0 {
+		price := RoundMoney(line.UnitPrice - discount)
+		offer.SalePrice = &price
+	}
+	return offer
+}
@@ -0,0 +1,260 @@
+package services
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"pashmina-backend/models"
+)
+
+func promotion(id uint, promotionType string, value float64, priority int, stackable bool) models.Promotion {
+	return models.Promotion{
+		ID:            id,
+		Name:          promotionType,
+		Type:          promotionType,
+		DiscountValue: value,
+		Priority:      priority,
+		Stackable:     stackable,
+		IsActive:      true,
+	}
+}
+
+func appliedIDs(applied []AppliedPromotion) []uint {
+	ids := []uint{}
+	for _, a := range applied {
+		ids = append(ids, a.ID)
+	}
+	return ids
+}
+
+func TestEvaluatePromotions(t *testing.T) {
+	lines := []PricedLine{
+		cartLine(1, 10, 1000, 2), // shawl
+		cartLine(2, 20, 500, 1),  // stole
+	}
+	shawls := productScope{includeCategories: idSet([]uint{10})}
+
+	tiered := promotion(5, PromotionTieredSpend, 0, 0, true)
+	tiered.Tiers = models.PromotionTiers{
+		{MinSpend: 1000, DiscountType: DiscountFixed, DiscountValue: 100},
+		{MinSpend: 2000, DiscountType: DiscountPercentage, DiscountValue: 10},
+		{MinSpend: 5000, DiscountType: DiscountFixed, DiscountValue: 1000},
+	}
+
+	minSpend := promotion(6, DiscountPercentage, 10, 2, false)
+	minSpend.MinSpend = 2500
+
+	tests := []struct {
+		name       string
+		promotions []models.Promotion
+		scopes     []productScope
+		wantIDs    []uint
+		wantLines  []float64
+	}{
+		{
+			"percentage on a category",
+			[]models.Promotion{promotion(1, DiscountPercentage, 10, 0, false)},
+			[]productScope{shawls},
+			[]uint{1}, []float64{200, 0},
+		},
+		{
+			"fixed per item, capped at the line",
+			[]models.Promotion{promotion(1, DiscountFixed, 600, 0, false)},
+			[]productScope{{}},
+			[]uint{1}, []float64{1200, 500},
+		},
+		{
+			"stackable promotions compound",
+			[]models.Promotion{promotion(1, DiscountPercentage, 10, 2, true), promotion(2, DiscountFixed, 50, 1, true)},
+			[]productScope{{}, {}},
+			[]uint{1, 2}, []float64{300, 100},
+		},
+		{
+			"exclusive promotion shuts out the rest",
+			[]models.Promotion{promotion(1, DiscountPercentage, 10, 2, false), promotion(2, DiscountFixed, 50, 1, true)},
+			[]productScope{{}, {}},
+			[]uint{1}, []float64{200, 50},
+		},
+		{
+			"exclusive promotion skipped once another applied",
+			[]models.Promotion{promotion(1, DiscountPercentage, 10, 2, true), promotion(2, DiscountFixed, 50, 1, false)},
+			[]productScope{{}, {}},
+			[]uint{1}, []float64{200, 50},
+		},
+		{
+			"promotion that covers nothing doesn't block others",
+			[]models.Promotion{promotion(1, DiscountPercentage, 50, 2, false), promotion(2, DiscountPercentage, 10, 1, false)},
+			[]productScope{{includeProducts: idSet([]uint{99})}, {}},
+			[]uint{2}, []float64{200, 50},
+		},
+		{
+			"spend tier",
+			[]models.Promotion{tiered},
+			[]productScope{{}},
+			[]uint{5}, []float64{200, 50},
+		},
+		{
+			"spend tier on covered items only",
+			[]models.Promotion{tiered},
+			[]productScope{shawls},
+			[]uint{5}, []float64{200, 0},
+		},
+		{
+			"minimum spend reached",
+			[]models.Promotion{minSpend},
+			[]productScope{{}},
+			[]uint{6}, []float64{200, 50},
+		},
+		{
+			"minimum spend counts covered items only",
+			[]models.Promotion{minSpend, promotion(2, DiscountFixed, 50, 1, false)},
+			[]productScope{shawls, {}},
+			[]uint{2}, []float64{100, 50},
+		},
+		{
+			"spend tier not reached",
+			[]models.Promotion{tiered},
+			[]productScope{{includeCategories: idSet([]uint{20})}},
+			[]uint{}, []float64{0, 0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := evaluatePromotions(tt.promotions, tt.scopes, lines, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := appliedIDs(out.applied); !reflect.DeepEqual(got, tt.wantIDs) {
+				t.Errorf("applied = %v, want %v", got, tt.wantIDs)
+			}
+			if !reflect.DeepEqual(out.lineDiscounts, tt.wantLines) {
+				t.Errorf("line discounts = %v, want %v", out.lineDiscounts, tt.wantLines)
+			}
+		})
+	}
+}
+
+func TestEvaluatePromotionsGifts(t *testing.T) {
+	lines := []PricedLine{cartLine(1, 10, 1000, 2)}
+	gift := promotion(3, PromotionFreeGift, 0, 0, true)
+	gift.MinSpend = 1500
+	giftLine := cartLine(7, 30, 250, 1)
+
+	t.Run("gift given", func(t *testing.T) {
+		out, err := evaluatePromotions([]models.Promotion{gift}, []productScope{{}}, lines,
+			func(*models.Promotion) (*PricedLine, error) { return &giftLine, nil })
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(out.gifts) != 1 || out.applied[0].Discount != 250 {
+			t.Errorf("gifts = %v, applied = %v, want one gift worth 250", out.gifts, out.applied)
+		}
+		if out.lineDiscounts[0] != 0 {
+			t.Errorf("line discount = %v, want 0", out.lineDiscounts[0])
+		}
+	})
+
+	t.Run("spend not reached", func(t *testing.T) {
+		short := gift
+		short.MinSpend = 5000
+		out, _ := evaluatePromotions([]models.Promotion{short}, []productScope{{}}, lines,
+			func(*models.Promotion) (*PricedLine, error) { return &giftLine, nil })
+		if len(out.applied) != 0 || len(out.gifts) != 0 {
+			t.Errorf("applied = %v, want nothing", out.applied)
+		}
+	})
+
+	t.Run("gift out of stock lets the next promotion apply", func(t *testing.T) {
+		exclusiveGift := gift
+		exclusiveGift.Stackable = false
+		out, _ := evaluatePromotions(
+			[]models.Promotion{exclusiveGift, promotion(4, DiscountPercentage, 10, 0, false)},
+			[]productScope{{}, {}}, lines,
+			func(*models.Promotion) (*PricedLine, error) { return nil, nil })
+		if got := appliedIDs(out.applied); !reflect.DeepEqual(got, []uint{4}) {
+			t.Errorf("applied = %v, want [4]", got)
+		}
+	})
+
+	t.Run("gift lookup error", func(t *testing.T) {
+		lookup := errors.New("connection refused")
+		_, err := evaluatePromotions([]models.Promotion{gift}, []productScope{{}}, lines,
+			func(*models.Promotion) (*PricedLine, error) { return nil, lookup })
+		if !errors.Is(err, lookup) {
+			t.Errorf("error = %v, want %v", err, lookup)
+		}
+	})
+}
+
+func TestSpendTier(t *testing.T) {
+	tiers := models.PromotionTiers{
+		{MinSpend: 5000, DiscountValue: 500},
+		{MinSpend: 2000, DiscountValue: 150},
+	}
+	tests := []struct {
+		spend  float64
+		want   float64
+		wantOK bool
+	}{
+		{1999, 0, false},
+		{2000, 150, true},
+		{4999.99, 150, true},
+		{12000, 500, true},
+	}
+	for _, tt := range tests {
+		tier, ok := spendTier(tiers, tt.spend)
+		if ok != tt.wantOK || tier.DiscountValue != tt.want {
+			t.Errorf("spendTier(%v) = %v, %v; want %v, %v", tt.spend, tier.DiscountValue, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestProductOffer(t *testing.T) {
+	gift := promotion(3, PromotionFreeGift, 0, 3, true)
+	promotions := []models.Promotion{
+		gift,
+		promotion(1, DiscountPercentage, 10, 2, true),
+		promotion(2, DiscountFixed, 100, 1, true),
+	}
+	shawls := productScope{includeCategories: idSet([]uint{10})}
+	scopes := []productScope{{}, shawls, shawls}
+
+	offer := productOffer(promotions, scopes, cartLine(1, 10, 1000, 1))
+	if offer.SalePrice == nil || *offer.SalePrice != 800 {
+		t.Errorf("sale price = %v, want 800", offer.SalePrice)
+	}
+	if len(offer.Promotions) != 3 {
+		t.Errorf("promotions = %v, want all three", offer.Promotions)
+	}
+
+	offer = productOffer(promotions, scopes, cartLine(2, 20, 500, 1))
+	if offer.SalePrice != nil {
+		t.Errorf("sale price = %v, want none", *offer.SalePrice)
+	}
+	if len(offer.Promotions) != 1 || offer.Promotions[0].ID != 3 {
+		t.Errorf("promotions = %v, want only the gift", offer.Promotions)
+	}
+}
+
+func TestCouponLines(t *testing.T) {
+	b := PriceBreakdown{Lines: []PricedLine{
+		cartLine(1, 10, 1000, 2),
+		cartLine(2, 20, 500, 1),
+		cartLine(7, 30, 250, 1),
+	}}
+	b.Lines[0].Discount = 200
+	b.Lines[2].IsGift = true
+	b.Lines[2].Discount = 250
+
+	lines, positions := b.couponLines()
+	if !reflect.DeepEqual(positions, []int{0, 1}) {
+		t.Fatalf("positions = %v, want [0 1]", positions)
+	}
+	if lines[0].LineTotal != 1800 || lines[0].UnitPrice != 900 || lines[0].Discount != 0 {
+		t.Errorf("first line = %+v, want 1800 at 900 each", lines[0])
+	}
+	if lines[1].LineTotal != 500 {
+		t.Errorf("second line total = %v, want 500", lines[1].LineTotal)
+	}
+}
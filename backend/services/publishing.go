@@ -0,0 +1,133 @@
+package services
+
+import (
+	"errors"
+	"time"
+
+	"pashmina-backend/models"
+	"pashmina-backend/utils"
+
+	"gorm.io/gorm"
+)
+
+// Product statuses
+const (
+	ProductDraft     = "draft"
+	ProductPublished = "published"
+	ProductArchived  = "archived"
+)
+
+// Reasons a product is not on the storefront
+const (
+	HiddenDraft       = "draft"
+	HiddenArchived    = "archived"
+	HiddenScheduled   = "scheduled"
+	HiddenUnpublished = "unpublished"
+	HiddenCategory    = "category_hidden"
+)
+
+var (
+	// ErrInvalidProductStatus is returned for a status other than draft, published or archived
+	ErrInvalidProductStatus = errors.New("status must be draft, published or archived")
+	// ErrInvalidPublishWindow is returned when a product would be unpublished before it is published
+	ErrInvalidPublishWindow = errors.New("unpublish_at must be after publish_at")
+)
+
+// visibleCategoriesSQL selects the categories the storefront shows: active ones
+// whose ancestors are all active too
+const visibleCategoriesSQL = `WITH RECURSIVE visible AS (
+		SELECT id FROM categories WHERE parent_id IS NULL AND is_active AND deleted_at IS NULL
+		UNION
+		SELECT c.id FROM categories c JOIN visible v ON c.parent_id = v.id WHERE c.is_active AND c.deleted_at IS NULL
+	) SELECT id FROM visible`
+
+// ValidateProductStatus checks a product status and its publishing window
+func ValidateProductStatus(status string, publishAt, unpublishAt *time.Time) error {
+	switch status {
+	case ProductDraft, ProductPublished, ProductArchived:
+	default:
+		return ErrInvalidProductStatus
+	}
+	if publishAt != nil && unpublishAt != nil && !unpublishAt.After(*publishAt) {
+		return ErrInvalidPublishWindow
+	}
+	return nil
+}
+
+// VisibleProducts limits a product query to what the storefront shows at now:
+// published products inside their publishing window, in a visible category
+func VisibleProducts(query *gorm.DB, now time.Time) *gorm.DB {
+	return query.Where(`products.status = ?
+		AND (products.publish_at IS NULL OR products.publish_at <= ?)
+		AND (products.unpublish_at IS NULL OR products.unpublish_at > ?)
+		AND products.category_id IN (`+visibleCategoriesSQL+`)`, ProductPublished, now, now)
+}
+
+// VisibleCategories limits a category query to active categories under active parents
+func VisibleCategories(query *gorm.DB) *gorm.DB {
+	return query.Where("categories.id IN (" + visibleCategoriesSQL + ")")
+}
+
+// VisibleCatalogues limits a catalogue query to active catalogues
+func VisibleCatalogues(query *gorm.DB) *gorm.DB {
+	return query.Where("catalogues.status = ?", true)
+}
+
+// ProductVisibility says whether the storefront shows a product and, if not, why
+type ProductVisibility struct {
+	Visible bool   `json:"visible"`
+	Reason  string `json:"reason,omitempty"`
+	// Until is when the product next appears or disappears on its own
+	Until *time.Time `json:"until,omitempty"`
+}
+
+// CheckProductVisibility works out whether the storefront shows a product at now
+func CheckProductVisibility(db *gorm.DB, product *models.Product, now time.Time) (ProductVisibility, error) {
+	var count int64
+	err := VisibleCategories(db.Model(&models.Category{})).Where("categories.id = ?", product.CategoryID).Count(&count).Error
+	if err != nil {
+		return ProductVisibility{}, err
+	}
+	return productVisibility(product, now, count > 0), nil
+}
+
+func productVisibility(product *models.Product, now time.Time, categoryVisible bool) ProductVisibility {
+	switch {
+	case product.Status == ProductDraft:
+		return ProductVisibility{Reason: HiddenDraft}
+	case product.Status != ProductPublished:
+		return ProductVisibility{Reason: HiddenArchived}
+	case product.PublishAt != nil && product.PublishAt.After(now):
+		return ProductVisibility{Reason: HiddenScheduled, Until: product.PublishAt}
+	case product.UnpublishAt != nil && !product.UnpublishAt.After(now):
+		return ProductVisibility{Reason: HiddenUnpublished}
+	case !categoryVisible:
+		return ProductVisibility{Reason: HiddenCategory}
+	}
+	return ProductVisibility{Visible: true, Until: product.UnpublishAt}
+}
+
+// StartPublishingSweeper watches for scheduled products going on or off the
+// storefront and clears the search caches when one does. It blocks, so run it in
+// its own goroutine.
+func StartPublishingSweeper(db *gorm.DB, interval time.Duration) {
+	last := time.Now()
+	for {
+		time.Sleep(interval)
+		now := time.Now()
+		var count int64
+		err := db.Model(&models.Product{}).
+			Where("(publish_at > ? AND publish_at <= ?) OR (unpublish_at > ? AND unpublish_at <= ?)", last, now, last, now).
+			Count(&count).Error
+		if err != nil {
+			utils.Error("Publishing sweep failed", map[string]interface{}{"error": err.Error()})
+			continue
+		}
+		last = now
+		if count > 0 {
+			InvalidateFacets()
+			Suggestions(db).Refresh()
+			utils.Info("Scheduled products changed visibility", map[string]interface{}{"count": count})
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"pashmina-backend/models"
+)
+
+func TestValidateProductStatus(t *testing.T) {
+	now := time.Now()
+	later := now.Add(time.Hour)
+	tests := []struct {
+		name               string
+		status             string
+		publish, unpublish *time.Time
+		want               error
+	}{
+		{"published", ProductPublished, nil, nil, nil},
+		{"scheduled window", ProductDraft, &now, &later, nil},
+		{"unknown status", "hidden", nil, nil, ErrInvalidProductStatus},
+		{"empty status", "", nil, nil, ErrInvalidProductStatus},
+		{"window ends before it starts", ProductPublished, &later, &now, ErrInvalidPublishWindow},
+		{"empty window", ProductPublished, &now, &now, ErrInvalidPublishWindow},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ValidateProductStatus(tt.status, tt.publish, tt.unpublish); got != tt.want {
+				t.Errorf("ValidateProductStatus = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProductVisibility(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	past, future := now.Add(-time.Hour), now.Add(time.Hour)
+	tests := []struct {
+		name            string
+		product         models.Product
+		categoryVisible bool
+		wantVisible     bool
+		wantReason      string
+		wantUntil       *time.Time
+	}{
+		{"published", models.Product{Status: ProductPublished}, true, true, "", nil},
+		{"draft", models.Product{Status: ProductDraft}, true, false, HiddenDraft, nil},
+		{"archived", models.Product{Status: ProductArchived}, true, false, HiddenArchived, nil},
+		{"scheduled", models.Product{Status: ProductPublished, PublishAt: &future}, true, false, HiddenScheduled, &future},
+		{"publish time passed", models.Product{Status: ProductPublished, PublishAt: &past, UnpublishAt: &future}, true, true, "", &future},
+		{"unpublished", models.Product{Status: ProductPublished, UnpublishAt: &past}, true, false, HiddenUnpublished, nil},
+		{"unpublished at now", models.Product{Status: ProductPublished, UnpublishAt: &now}, true, false, HiddenUnpublished, nil},
+		{"draft with a past publish time", models.Product{Status: ProductDraft, PublishAt: &past}, true, false, HiddenDraft, nil},
+		{"hidden category", models.Product{Status: ProductPublished}, false, false, HiddenCategory, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := productVisibility(&tt.product, now, tt.categoryVisible)
+			if got.Visible != tt.wantVisible || got.Reason != tt.wantReason {
+				t.Errorf("visibility = %+v, want visible %v reason %q", got, tt.wantVisible, tt.wantReason)
+			}
+			if (got.Until == nil) != (tt.wantUntil == nil) || (got.Until != nil && !got.Until.Equal(*tt.wantUntil)) {
+				t.Errorf("until = %v, want %v", got.Until, tt.wantUntil)
+			}
+		})
+	}
+}
@@ -0,0 +1,247 @@
+package services
+
+import (
+	"errors"
+	"time"
+
+	"pashmina-backend/models"
+	"pashmina-backend/utils"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	ReservationHeld      = "held"
+	ReservationCommitted = "committed"
+	ReservationReleased  = "released"
+)
+
+var ErrReservationReleased = errors.New("stock reservation has already been released")
+
+// ReservationWindow returns how long stock is held for an unpaid order
+func ReservationWindow() time.Duration {
+	minutes := envFloat("STOCK_RESERVATION_MINUTES", 30)
+	return time.Duration(minutes * float64(time.Minute))
+}
+
+// ReserveStock takes each priced line out of available stock and records a hold
+// against the order that expires after ReservationWindow
+func ReserveStock(tx *gorm.DB, orderID uint, lines []PricedLine) (time.Time, error) {
+	expiresAt := time.Now().Add(ReservationWindow())
+
+	for _, line := range lines {
+		if err := DecrementStock(tx, line); err != nil {
+			return time.Time{}, err
+		}
+
+		reservation := models.StockReservation{
+			OrderID:   orderID,
+			ProductID: line.ProductID,
+			VariantID: line.VariantID,
+			Quantity:  line.Quantity,
+			Status:    ReservationHeld,
+			ExpiresAt: expiresAt,
+		}
+		if err := tx.Create(&reservation).Error; err != nil {
+			return time.Time{}, err
+		}
+	}
+
+	return expiresAt, nil
+}
+
+// CommitReservations turns an order's holds into a permanent decrement. It returns
+// ErrReservationReleased when the holds were already given back to stock.
+func CommitReservations(tx *gorm.DB, orderID uint) error {
+	var released int64
+	tx.Model(&models.StockReservation{}).
+		Where("order_id = ? AND status = ?", orderID, ReservationReleased).
+		Count(&released)
+	if released > 0 {
+		return ErrReservationReleased
+	}
+
+	return tx.Model(&models.StockReservation{}).
+		Where("order_id = ? AND status = ?", orderID, ReservationHeld).
+		Update("status", ReservationCommitted).Error
+}
+
+// ReacquireStock takes an order's items out of stock again after its holds were
+// released, committing the new holds immediately
+func ReacquireStock(tx *gorm.DB, order *models.Order) error {
+	for _, item := range order.Items {
+		line := PricedLine{
+			ProductID: item.ProductID,
+			VariantID: item.VariantID,
+			Name:      item.Product.Name,
+			Quantity:  item.Quantity,
+			Product:   &models.Product{},
+		}
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(line.Product, item.ProductID).Error; err != nil {
+			return err
+		}
+		if item.VariantID != nil {
+			line.Variant = &models.ProductVariant{}
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(line.Variant, *item.VariantID).Error; err != nil {
+				return err
+			}
+		}
+		if err := DecrementStock(tx, line); err != nil {
+			return err
+		}
+	}
+
+	return tx.Model(&models.StockReservation{}).
+		Where("order_id = ? AND status = ?", order.ID, ReservationReleased).
+		Update("status", ReservationCommitted).Error
+}
+
+// ClaimReservedStock settles an order's stock once it has been paid for. Holds are
+// committed; if they already expired the items are taken from stock again, which
+// fails with InsufficientStockError when they have since sold out.
+func ClaimReservedStock(tx *gorm.DB, order *models.Order) error {
+	err := CommitReservations(tx, order.ID)
+	if !errors.Is(err, ErrReservationReleased) {
+		return err
+	}
+
+	var items []models.OrderItem
+	if err := tx.Preload("Product").Where("order_id = ?", order.ID).Find(&items).Error; err != nil {
+		return err
+	}
+	order.Items = items
+
+	return ReacquireStock(tx, order)
+}
+
+// ReleaseReservations returns an order's outstanding holds to stock and reports
+// how many holds were released
+func ReleaseReservations(tx *gorm.DB, orderID uint) (int, error) {
+	var held []models.StockReservation
+	if err := tx.Where("order_id = ? AND status = ?", orderID, ReservationHeld).Find(&held).Error; err != nil {
+		return 0, err
+	}
+
+	for _, r := range held {
+		item := models.OrderItem{ProductID: r.ProductID, VariantID: r.VariantID, Quantity: r.Quantity}
+		if err := RestockItem(tx, item); err != nil {
+			return 0, err
+		}
+		if err := tx.Model(&r).Update("status", ReservationReleased).Error; err != nil {
+			return 0, err
+		}
+	}
+
+	return len(held), nil
+}
+
+// RestoreOrderStock puts a cancelled order's items back on the shelf. Outstanding
+// holds are released; otherwise the committed quantities are restocked.
+func RestoreOrderStock(tx *gorm.DB, order *models.Order) error {
+	released, err := ReleaseReservations(tx, order.ID)
+	if err != nil || released > 0 {
+		return err
+	}
+
+	var releasedBefore int64
+	tx.Model(&models.StockReservation{}).
+		Where("order_id = ? AND status = ?", order.ID, ReservationReleased).
+		Count(&releasedBefore)
+	if releasedBefore > 0 {
+		return nil
+	}
+
+	for _, item := range order.Items {
+		if err := RestockItem(tx, item); err != nil {
+			return err
+		}
+	}
+
+	return tx.Model(&models.StockReservation{}).
+		Where("order_id = ? AND status = ?", order.ID, ReservationCommitted).
+		Update("status", ReservationReleased).Error
+}
+
+// ExpireReservations releases holds whose window has passed, along with any coupon
+// and credit the order used, and moves their unpaid orders to expired. Unpaid
+// orders that hold no stock, such as gift card orders, expire once they are older
+// than the window. Orders locked by another worker are skipped.
+func ExpireReservations(db *gorm.DB) (int, error) {
+	now := time.Now()
+	var orderIDs []uint
+	if err := db.Model(&models.StockReservation{}).
+		Where("status = ? AND expires_at < ?", ReservationHeld, now).
+		Distinct().Pluck("order_id", &orderIDs).Error; err != nil {
+		return 0, err
+	}
+
+	var unreserved []uint
+	if err := db.Model(&models.Order{}).
+		Where("status IN ? AND created_at < ?", []string{"pending_payment", "payment_failed"}, now.Add(-ReservationWindow())).
+		Where("NOT EXISTS (SELECT 1 FROM stock_reservations r WHERE r.order_id = orders.id)").
+		Pluck("id", &unreserved).Error; err != nil {
+		return 0, err
+	}
+	orderIDs = append(orderIDs, unreserved...)
+
+	expired := 0
+	for _, orderID := range orderIDs {
+		didExpire := false
+		var order models.Order
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+				First(&order, orderID).Error; err != nil {
+				return err
+			}
+			// An order whose payment failed still holds its stock until it is paid
+			if order.Status != "pending_payment" && order.Status != "payment_failed" {
+				return nil
+			}
+
+			if _, err := ReleaseReservations(tx, order.ID); err != nil {
+				return err
+			}
+			if err := ReleaseCouponRedemption(tx, order.ID); err != nil {
+				return err
+			}
+			if err := ReleaseOrderCredit(tx, &order); err != nil {
+				return err
+			}
+
+			if err := TransitionOrder(tx, &order, "expired", StatusChange{
+				Source: StatusSourceSystem,
+				Reason: "Payment not received in time",
+			}); err != nil {
+				return err
+			}
+
+			didExpire = true
+			return tx.Model(&order).Update("status", order.Status).Error
+		})
+		if err == nil && didExpire {
+			expired++
+			NotifyOrderStatus(db, &order)
+		} else if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			utils.Error("Failed to expire stock reservation", map[string]interface{}{
+				"order_id": orderID,
+				"error":    err.Error(),
+			})
+		}
+	}
+
+	return expired, nil
+}
+
+// StartReservationSweeper periodically expires unpaid orders. It blocks, so run it
+// in its own goroutine.
+func StartReservationSweeper(db *gorm.DB, interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		if n, err := ExpireReservations(db); err != nil {
+			utils.Error("Reservation sweep failed", map[string]interface{}{"error": err.Error()})
+		} else if n > 0 {
+			utils.Info("Expired unpaid orders", map[string]interface{}{"count": n})
+		}
+	}
+}
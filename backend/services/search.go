@@ -0,0 +1,238 @@
+package services
+
+import (
+	"html"
+	"strings"
+	"time"
+
+	"pashmina-backend/models"
+	"pashmina-backend/utils"
+
+	"gorm.io/gorm"
+)
+
+// Markers ts_headline wraps matches in. Product text has no control characters, so
+// they survive HTML escaping and are swapped for <mark> tags afterwards.
+const (
+	highlightStart = "\x02"
+	highlightStop  = "\x03"
+)
+
+// SearchParams are a product search query and the filters applied with it
+type SearchParams struct {
+	Query      string
+	CategoryID uint
+	MinPrice   *float64
+	MaxPrice   *float64
+	Colors     []string
+	Sizes      []string
+	InStock    bool
+	Page       int
+	Limit      int
+}
+
+// SearchHit is a matching product with its relevance and highlighted text. Snippet
+// and NameHighlight are HTML-escaped with matches wrapped in <mark>.
+type SearchHit struct {
+	models.Product
+	Rank          float64 `json:"rank"`
+	Snippet       string  `json:"snippet"`
+	NameHighlight string  `json:"name_highlight"`
+}
+
+// SearchResults is one page of search hits
+type SearchResults struct {
+	Hits  []SearchHit
+	Total int64
+	// Fuzzy is set when nothing matched the full-text query and the hits are
+	// trigram matches on the product name instead
+	Fuzzy bool
+}
+
+type searchRow struct {
+	ID            uint
+	Rank          float64
+	Snippet       string
+	NameHighlight string
+}
+
+// SearchProducts runs a ranked full-text search over active products with the
+// filters applied in the same query. When the text matches nothing, product names
+// are matched by trigram similarity so typos still find something.
+func SearchProducts(db *gorm.DB, params SearchParams) (*SearchResults, error) {
+	offset := (params.Page - 1) * params.Limit
+	base := applySearchFilters(VisibleProducts(db.Model(&models.Product{}), time.Now()), params)
+
+	fullText := base.Session(&gorm.Session{}).
+		Where("products.search_vector @@ websearch_to_tsquery('english', ?)", params.Query)
+
+	results := &SearchResults{}
+	if err := fullText.Session(&gorm.Session{}).Count(&results.Total).Error; err != nil {
+		return nil, err
+	}
+
+	var rows []searchRow
+	if results.Total > 0 {
+		markers := "StartSel=" + highlightStart + ", StopSel=" + highlightStop
+		err := fullText.Session(&gorm.Session{}).
+			Select(`products.id,
+				ts_rank_cd(products.search_vector, websearch_to_tsquery('english', ?), 32) AS rank,
+				ts_headline('english', coalesce(products.description, ''), websearch_to_tsquery('english', ?), ?) AS snippet,
+				ts_headline('english', products.name, websearch_to_tsquery('english', ?), ?) AS name_highlight`,
+				params.Query,
+				params.Query, markers+", MaxWords=35, MinWords=15, MaxFragments=2",
+				params.Query, markers+", HighlightAll=true").
+			Order("rank DESC, products.id DESC").
+			Offset(offset).Limit(params.Limit).
+			Scan(&rows).Error
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		results.Fuzzy = true
+		fuzzy := base.Session(&gorm.Session{}).Where("? <% products.name", params.Query)
+		if err := fuzzy.Session(&gorm.Session{}).Count(&results.Total).Error; err != nil {
+			return nil, err
+		}
+		err := fuzzy.Session(&gorm.Session{}).
+			Select("products.id, word_similarity(?, products.name) AS rank, left(coalesce(products.description, ''), 200) AS snippet, products.name AS name_highlight", params.Query).
+			Order("rank DESC, products.id DESC").
+			Offset(offset).Limit(params.Limit).
+			Scan(&rows).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(rows) == 0 {
+		results.Hits = []SearchHit{}
+		return results, nil
+	}
+
+	ids := make([]uint, len(rows))
+	for i, row := range rows {
+		ids[i] = row.ID
+	}
+	var products []models.Product
+	if err := db.Preload("Category").Where("id IN ?", ids).Find(&products).Error; err != nil {
+		return nil, err
+	}
+	byID := make(map[uint]models.Product, len(products))
+	for _, p := range products {
+		byID[p.ID] = p
+	}
+
+	results.Hits = make([]SearchHit, 0, len(rows))
+	for _, row := range rows {
+		product, ok := byID[row.ID]
+		if !ok {
+			continue
+		}
+		results.Hits = append(results.Hits, SearchHit{
+			Product:       product,
+			Rank:          row.Rank,
+			Snippet:       highlightSnippet(row.Snippet),
+			NameHighlight: highlightSnippet(row.NameHighlight),
+		})
+	}
+	return results, nil
+}
+
+// applySearchFilters adds the category, price, color, size and stock filters. The
+// category matches its subcategories too. Colors and sizes match the product's own
+// lists or any of its active variants.
+func applySearchFilters(query *gorm.DB, params SearchParams) *gorm.DB {
+	if params.CategoryID != 0 {
+		query = InCategory(query, params.CategoryID)
+	}
+	if params.MinPrice != nil {
+		query = query.Where("products.price >= ?", *params.MinPrice)
+	}
+	if params.MaxPrice != nil {
+		query = query.Where("products.price <= ?", *params.MaxPrice)
+	}
+	if len(params.Colors) > 0 {
+		query = query.Where(jsonListFilter("colors", "color"), lowerAll(params.Colors), lowerAll(params.Colors))
+	}
+	if len(params.Sizes) > 0 {
+		query = query.Where(jsonListFilter("sizes", "size"), lowerAll(params.Sizes), lowerAll(params.Sizes))
+	}
+	if params.InStock {
+		query = query.Where(`(products.stock > 0 OR EXISTS (
+			SELECT 1 FROM product_variants v
+			WHERE v.product_id = products.id AND v.is_active AND v.deleted_at IS NULL AND v.stock > 0))`)
+	}
+	return query
+}
+
+// jsonListFilter matches a value in a product's JSON list column or on one of its
+// active variants
+func jsonListFilter(column, variantColumn string) string {
+	return `(EXISTS (
+			SELECT 1 FROM jsonb_array_elements_text(
+				CASE WHEN jsonb_typeof(products.` + column + `) = 'array' THEN products.` + column + ` ELSE '[]'::jsonb END) AS value
+			WHERE lower(value) IN ?)
+		OR EXISTS (
+			SELECT 1 FROM product_variants v
+			WHERE v.product_id = products.id AND v.is_active AND v.deleted_at IS NULL AND lower(v.` + variantColumn + `) IN ?))`
+}
+
+func lowerAll(values []string) []string {
+	lowered := make([]string, len(values))
+	for i, v := range values {
+		lowered[i] = strings.ToLower(strings.TrimSpace(v))
+	}
+	return lowered
+}
+
+// highlightSnippet escapes text from ts_headline and turns its match markers into
+// <mark> tags
+func highlightSnippet(text string) string {
+	escaped := html.EscapeString(text)
+	escaped = strings.ReplaceAll(escaped, highlightStart, "<mark>")
+	return strings.ReplaceAll(escaped, highlightStop, "</mark>")
+}
+
+// SearchTermStat summarises how often a search term was used
+type SearchTermStat struct {
+	Query          string    `json:"query"`
+	Searches       int64     `json:"searches"`
+	AvgResults     float64   `json:"avg_results"`
+	LastSearchedAt time.Time `json:"last_searched_at"`
+}
+
+// LogSearch records a search term and how many results it found. Terms are stored
+// normalized so the same search typed differently is counted once.
+func LogSearch(db *gorm.DB, query string, resultCount int64, fuzzy bool) {
+	term := normalizeSuggestText(query)
+	if term == "" {
+		return
+	}
+	if err := db.Create(&models.SearchLog{Query: term, ResultCount: int(resultCount), Fuzzy: fuzzy}).Error; err != nil {
+		utils.Warn("Failed to log search", map[string]interface{}{"error": err.Error()})
+	}
+}
+
+// PopularSearches returns the most used search terms within the window that found
+// something
+func PopularSearches(db *gorm.DB, window time.Duration, limit int) []SearchTermStat {
+	return searchTermStats(db.Where("result_count > 0"), window, limit)
+}
+
+// ZeroResultSearches returns the most used search terms within the window that found
+// nothing, even with the fuzzy fallback
+func ZeroResultSearches(db *gorm.DB, window time.Duration, limit int) []SearchTermStat {
+	return searchTermStats(db.Where("result_count = 0"), window, limit)
+}
+
+func searchTermStats(query *gorm.DB, window time.Duration, limit int) []SearchTermStat {
+	stats := []SearchTermStat{}
+	query.Model(&models.SearchLog{}).
+		Select("query, COUNT(*) AS searches, AVG(result_count) AS avg_results, MAX(created_at) AS last_searched_at").
+		Where("created_at >= ?", time.Now().Add(-window)).
+		Group("query").
+		Order("searches DESC, last_searched_at DESC").
+		Limit(limit).
+		Scan(&stats)
+	return stats
+}
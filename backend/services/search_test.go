@@ -0,0 +1,30 @@
+package services
+
+import "testing"
+
+func TestHighlightSnippet(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"plain", "Hand-woven shawl", "Hand-woven shawl"},
+		{"match", "Pure \x02pashmina\x03 wrap", "Pure <mark>pashmina</mark> wrap"},
+		{"escapes markup", "<script>\x02shawl\x03</script> & more", "&lt;script&gt;<mark>shawl</mark>&lt;/script&gt; &amp; more"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := highlightSnippet(tt.in); got != tt.want {
+				t.Errorf("highlightSnippet(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLowerAll(t *testing.T) {
+	got := lowerAll([]string{" Camel", "NAVY "})
+	if len(got) != 2 || got[0] != "camel" || got[1] != "navy" {
+		t.Errorf("lowerAll = %q", got)
+	}
+}
@@ -0,0 +1,211 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"pashmina-backend/models"
+	"pashmina-backend/utils"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrRefreshTokenInvalid = errors.New("invalid or expired refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token has already been used")
+	ErrSessionRevoked      = errors.New("session has been revoked")
+)
+
+// RefreshTokenTTL returns how long a refresh token, and so an idle session, stays valid
+func RefreshTokenTTL() time.Duration {
+	days := envFloat("REFRESH_TOKEN_TTL_DAYS", 30)
+	return time.Duration(days * float64(24*time.Hour))
+}
+
+// CreateSession starts a session for a device and returns it with its first refresh token
+func CreateSession(db *gorm.DB, userID uint, userAgent, ip string) (*models.UserSession, string, error) {
+	now := time.Now()
+	session := models.UserSession{
+		UserID:     userID,
+		UserAgent:  utils.SanitizeString(userAgent, 255),
+		IPAddress:  ip,
+		LastUsedAt: now,
+		ExpiresAt:  now.Add(RefreshTokenTTL()),
+	}
+
+	var raw string
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&session).Error; err != nil {
+			return err
+		}
+		var err error
+		raw, err = issueRefreshToken(tx, &session)
+		return err
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return &session, raw, nil
+}
+
+// RotateRefreshToken exchanges a refresh token for a new one. A token that was already
+// rotated is treated as stolen: its whole session is revoked and ErrRefreshTokenReused
+// is returned.
+func RotateRefreshToken(db *gorm.DB, raw, userAgent, ip string) (*models.UserSession, string, error) {
+	var (
+		session models.UserSession
+		newRaw  string
+		reused  bool
+	)
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var token models.RefreshToken
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("token_hash = ?", hashToken(raw)).First(&token).Error; err != nil {
+			return ErrRefreshTokenInvalid
+		}
+
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&session, token.SessionID).Error; err != nil {
+			return ErrRefreshTokenInvalid
+		}
+
+		now := time.Now()
+		if err := checkRefreshToken(&token, &session, now); err != nil {
+			if errors.Is(err, ErrRefreshTokenReused) {
+				reused = true
+				return revokeSession(tx, &session, "refresh token reuse detected")
+			}
+			return err
+		}
+
+		if err := tx.Model(&token).Update("used_at", now).Error; err != nil {
+			return err
+		}
+
+		session.LastUsedAt = now
+		session.ExpiresAt = now.Add(RefreshTokenTTL())
+		session.IPAddress = ip
+		if userAgent != "" {
+			session.UserAgent = utils.SanitizeString(userAgent, 255)
+		}
+		if err := tx.Save(&session).Error; err != nil {
+			return err
+		}
+
+		var err error
+		newRaw, err = issueRefreshToken(tx, &session)
+		return err
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	if reused {
+		utils.Warn("Refresh token reuse detected", map[string]interface{}{
+			"user_id":    session.UserID,
+			"session_id": session.ID,
+			"ip":         ip,
+		})
+		return nil, "", ErrRefreshTokenReused
+	}
+
+	return &session, newRaw, nil
+}
+
+// FindSessionByRefreshToken returns the session a refresh token belongs to
+func FindSessionByRefreshToken(db *gorm.DB, raw string) (*models.UserSession, error) {
+	var token models.RefreshToken
+	if err := db.Where("token_hash = ?", hashToken(raw)).First(&token).Error; err != nil {
+		return nil, ErrRefreshTokenInvalid
+	}
+
+	var session models.UserSession
+	if err := db.First(&session, token.SessionID).Error; err != nil {
+		return nil, ErrRefreshTokenInvalid
+	}
+	return &session, nil
+}
+
+// RevokeSession ends one of a user's sessions
+func RevokeSession(db *gorm.DB, sessionID, userID uint, reason string) error {
+	var session models.UserSession
+	if err := db.Where("id = ? AND user_id = ?", sessionID, userID).First(&session).Error; err != nil {
+		return err
+	}
+	if session.RevokedAt != nil {
+		return nil
+	}
+	return revokeSession(db, &session, reason)
+}
+
+// RevokeUserSessions ends every active session of a user and reports how many were ended
+func RevokeUserSessions(db *gorm.DB, userID uint, reason string) (int64, error) {
+	result := db.Model(&models.UserSession{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Updates(map[string]interface{}{"revoked_at": time.Now(), "revoked_reason": reason})
+	return result.RowsAffected, result.Error
+}
+
+// ActiveSessions lists a user's sessions that can still be refreshed, most recent first
+func ActiveSessions(db *gorm.DB, userID uint) ([]models.UserSession, error) {
+	var sessions []models.UserSession
+	err := db.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("last_used_at DESC").Find(&sessions).Error
+	return sessions, err
+}
+
+// checkRefreshToken reports why a refresh token can't be rotated at now, if it can't.
+// ErrRefreshTokenReused means it was rotated already, so its session must be revoked.
+func checkRefreshToken(token *models.RefreshToken, session *models.UserSession, now time.Time) error {
+	if session.RevokedAt != nil {
+		return ErrSessionRevoked
+	}
+	if token.UsedAt != nil {
+		return ErrRefreshTokenReused
+	}
+	if now.After(token.ExpiresAt) || now.After(session.ExpiresAt) {
+		return ErrRefreshTokenInvalid
+	}
+	return nil
+}
+
+func revokeSession(tx *gorm.DB, session *models.UserSession, reason string) error {
+	now := time.Now()
+	session.RevokedAt = &now
+	session.RevokedReason = reason
+	return tx.Model(session).Updates(map[string]interface{}{
+		"revoked_at":     now,
+		"revoked_reason": reason,
+	}).Error
+}
+
+// issueRefreshToken stores a new refresh token for the session and returns its raw value
+func issueRefreshToken(tx *gorm.DB, session *models.UserSession) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	raw := base64.RawURLEncoding.EncodeToString(buf)
+
+	token := models.RefreshToken{
+		SessionID: session.ID,
+		UserID:    session.UserID,
+		TokenHash: hashToken(raw),
+		ExpiresAt: session.ExpiresAt,
+	}
+	if err := tx.Create(&token).Error; err != nil {
+		return "", err
+	}
+	return raw, nil
+}
+
+func hashToken(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,74 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"pashmina-backend/models"
+)
+
+func TestHashToken(t *testing.T) {
+	raw := "b3V0LW9mLWJhbmQtcmVmcmVzaC10b2tlbg"
+	hash := hashToken(raw)
+
+	if len(hash) != 64 || strings.Trim(hash, "0123456789abcdef") != "" {
+		t.Fatalf("hashToken(%q) = %q, want 64 hex characters", raw, hash)
+	}
+	if strings.Contains(hash, raw) {
+		t.Errorf("hashToken(%q) = %q, contains the raw token", raw, hash)
+	}
+	if again := hashToken(raw); again != hash {
+		t.Errorf("hashToken is not stable: %q then %q", hash, again)
+	}
+	if other := hashToken(raw + "x"); other == hash {
+		t.Errorf("hashToken gives %q for two different tokens", hash)
+	}
+}
+
+func TestCheckRefreshToken(t *testing.T) {
+	now := time.Now()
+	earlier := now.Add(-time.Minute)
+	later := now.Add(time.Hour)
+
+	tests := []struct {
+		name    string
+		token   models.RefreshToken
+		session models.UserSession
+		want    error
+	}{
+		{"fresh token", models.RefreshToken{ExpiresAt: later}, models.UserSession{ExpiresAt: later}, nil},
+		{"rotated token presented again", models.RefreshToken{ExpiresAt: later, UsedAt: &earlier}, models.UserSession{ExpiresAt: later}, ErrRefreshTokenReused},
+		{"rotated and expired token", models.RefreshToken{ExpiresAt: earlier, UsedAt: &earlier}, models.UserSession{ExpiresAt: later}, ErrRefreshTokenReused},
+		{"expired token", models.RefreshToken{ExpiresAt: earlier}, models.UserSession{ExpiresAt: later}, ErrRefreshTokenInvalid},
+		{"expired session", models.RefreshToken{ExpiresAt: later}, models.UserSession{ExpiresAt: earlier}, ErrRefreshTokenInvalid},
+		{"revoked session", models.RefreshToken{ExpiresAt: later}, models.UserSession{ExpiresAt: later, RevokedAt: &earlier}, ErrSessionRevoked},
+		{"rotated token of a revoked session", models.RefreshToken{ExpiresAt: later, UsedAt: &earlier}, models.UserSession{ExpiresAt: later, RevokedAt: &earlier}, ErrSessionRevoked},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkRefreshToken(&tt.token, &tt.session, now); err != tt.want {
+				t.Errorf("checkRefreshToken() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRevokeSession(t *testing.T) {
+	db := dryRunDB(t)
+
+	session := models.UserSession{ID: 7, ExpiresAt: time.Now().Add(time.Hour)}
+	if err := revokeSession(db, &session, "refresh token reuse detected"); err != nil {
+		t.Fatal(err)
+	}
+	if session.RevokedAt == nil || session.RevokedReason != "refresh token reuse detected" {
+		t.Errorf("session after revokeSession = %+v, want it revoked for reuse", session)
+	}
+
+	// Once revoked, no token of the session can be rotated, including ones issued
+	// after the token that was reused
+	next := models.RefreshToken{ExpiresAt: time.Now().Add(time.Hour)}
+	if err := checkRefreshToken(&next, &session, time.Now()); err != ErrSessionRevoked {
+		t.Errorf("checkRefreshToken() after revoking = %v, want %v", err, ErrSessionRevoked)
+	}
+}
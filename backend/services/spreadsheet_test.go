@@ -0,0 +1,100 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestSheetRoundTrip(t *testing.T) {
+	rows := [][]string{
+		{"product_sku", "name", "price", "barcode"},
+		{"SHAWL-1", `Rose & "Gold" <wrap>`, "450", "00123"},
+		{"SHAWL-2", "Ivory\nshawl", "12.5", ""},
+		{"SHAWL-3"},
+	}
+	for _, format := range []string{SheetCSV, SheetXLSX} {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := WriteSheet(&buf, format, rows); err != nil {
+				t.Fatalf("WriteSheet: %v", err)
+			}
+			if got := DetectSheetFormat(buf.Bytes()); got != format {
+				t.Errorf("DetectSheetFormat = %q, want %q", got, format)
+			}
+			got, err := ReadSheet(buf.Bytes(), format)
+			if err != nil {
+				t.Fatalf("ReadSheet: %v", err)
+			}
+			if !reflect.DeepEqual(got, rows) {
+				t.Errorf("round trip = %q, want %q", got, rows)
+			}
+		})
+	}
+}
+
+// TestReadXLSXSharedStrings reads a workbook laid out the way spreadsheet programs
+// save them: shared strings, rich text runs, booleans, sparse cells and a sheet
+// that isn't named sheet1
+func TestReadXLSXSharedStrings(t *testing.T) {
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for name, body := range map[string]string{
+		"xl/workbook.xml":            `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="Products" sheetId="1" r:id="rId7"/></sheets></workbook>`,
+		"xl/_rels/workbook.xml.rels": `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId7" Target="worksheets/products.xml"/></Relationships>`,
+		"xl/sharedStrings.xml":       `<sst xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><si><t>name</t></si><si><r><t>Kani </t></r><r><t>Shawl</t></r></si></sst>`,
+		"xl/worksheets/products.xml": `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>` +
+			`<row r="1"><c r="A1" t="s"><v>0</v></c><c r="C1" t="str"><v>price</v></c></row>` +
+			`<row r="2"><c r="A2" t="s"><v>1</v></c><c r="B2" t="b"><v>1</v></c><c r="C2"><v>4.5000000000000001</v></c></row>` +
+			`</sheetData></worksheet>`,
+	} {
+		part, _ := archive.Create(name)
+		part.Write([]byte(body))
+	}
+	archive.Close()
+
+	rows, err := ReadSheet(buf.Bytes(), SheetXLSX)
+	if err != nil {
+		t.Fatalf("ReadSheet: %v", err)
+	}
+	want := [][]string{{"name", "", "price"}, {"Kani Shawl", "true", "4.5"}}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("rows = %q, want %q", rows, want)
+	}
+}
+
+func TestReadSheetStripsBOM(t *testing.T) {
+	rows, err := ReadSheet([]byte("\xef\xbb\xbfproduct_sku,name\nA,B\n"), SheetCSV)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows[0][0] != "product_sku" {
+		t.Errorf("header = %q, want the BOM stripped", rows[0][0])
+	}
+}
+
+func TestColumnNames(t *testing.T) {
+	tests := []struct {
+		col  int
+		name string
+	}{
+		{0, "A"},
+		{25, "Z"},
+		{26, "AA"},
+		{51, "AZ"},
+		{701, "ZZ"},
+		{702, "AAA"},
+	}
+	for _, tt := range tests {
+		if got := columnName(tt.col); got != tt.name {
+			t.Errorf("columnName(%d) = %q, want %q", tt.col, got, tt.name)
+		}
+		if got, err := columnIndex(tt.name + "12"); err != nil || got != tt.col {
+			t.Errorf("columnIndex(%s12) = %d, %v, want %d", tt.name, got, err, tt.col)
+		}
+	}
+	if _, err := columnIndex("12"); err == nil {
+		t.Error("columnIndex(12) succeeded, want an error")
+	}
+}
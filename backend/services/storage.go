@@ -0,0 +1,129 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"pashmina-backend/utils"
+)
+
+var (
+	// ErrObjectNotFound is returned by Storage.Get for keys that hold nothing
+	ErrObjectNotFound = errors.New("object not found")
+	// ErrInvalidKey is returned for keys that are empty or climb out of the storage
+	ErrInvalidKey = errors.New("invalid storage key")
+)
+
+// Storage keeps uploaded files under slash-separated keys and knows the public URL
+// each one is served from
+type Storage interface {
+	Put(ctx context.Context, key, contentType string, data []byte) error
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Delete removes a key; deleting a missing key is not an error
+	Delete(ctx context.Context, key string) error
+	URL(key string) string
+}
+
+// NewStorage returns the media storage configured by STORAGE_DRIVER: "s3" for an
+// S3-compatible bucket, otherwise the local uploads directory
+func NewStorage() Storage {
+	switch strings.ToLower(strings.TrimSpace(os.Getenv("STORAGE_DRIVER"))) {
+	case "s3":
+		s3 := &S3Storage{
+			Endpoint:  os.Getenv("S3_ENDPOINT"),
+			Region:    os.Getenv("S3_REGION"),
+			Bucket:    os.Getenv("S3_BUCKET"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_SECRET_KEY"),
+			PublicURL: os.Getenv("S3_PUBLIC_URL"),
+			PathStyle: os.Getenv("S3_PATH_STYLE") != "false",
+		}
+		if s3.Endpoint == "" || s3.Bucket == "" || s3.AccessKey == "" || s3.SecretKey == "" {
+			utils.Warn("S3 storage is missing S3_ENDPOINT, S3_BUCKET or credentials; uploads will fail", nil)
+		}
+		return s3
+	default:
+		baseURL := os.Getenv("MEDIA_PUBLIC_URL")
+		if baseURL == "" {
+			baseURL = "http://localhost:8080/uploads"
+		}
+		return &LocalStorage{Dir: "uploads", BaseURL: baseURL}
+	}
+}
+
+// LocalStorage keeps files in a directory served at BaseURL
+type LocalStorage struct {
+	Dir     string
+	BaseURL string
+}
+
+func (s *LocalStorage) Put(ctx context.Context, key, contentType string, data []byte) error {
+	file, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so a reader never sees half a file
+	tmp, err := os.CreateTemp(filepath.Dir(file), ".upload-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+func (s *LocalStorage) Get(ctx context.Context, key string) ([]byte, error) {
+	file, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrObjectNotFound
+	}
+	return data, err
+}
+
+func (s *LocalStorage) Delete(ctx context.Context, key string) error {
+	file, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(file); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (s *LocalStorage) URL(key string) string {
+	return strings.TrimRight(s.BaseURL, "/") + "/" + key
+}
+
+// path maps a key into the storage directory, refusing keys that would leave it
+func (s *LocalStorage) path(key string) (string, error) {
+	clean := path.Clean("/" + key)
+	if clean == "/" || clean != "/"+key {
+		return "", fmt.Errorf("%w: %q", ErrInvalidKey, key)
+	}
+	return filepath.Join(s.Dir, filepath.FromSlash(clean[1:])), nil
+}
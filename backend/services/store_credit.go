@@ -0,0 +1,211 @@
+package services
+
+import (
+	"errors"
+	"math"
+
+	"pashmina-backend/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Kinds of store credit and gift card ledger entries
+const (
+	LedgerIssue        = "issue"
+	LedgerRefund       = "refund"
+	LedgerOrderPayment = "order_payment"
+	LedgerOrderRelease = "order_release"
+	LedgerAdjustment   = "adjustment"
+	LedgerVoid         = "void"
+)
+
+var ErrInsufficientBalance = errors.New("balance is too low for this change")
+
+// LedgerChange is an entry to add to a store credit or gift card ledger
+type LedgerChange struct {
+	Amount  float64
+	Kind    string
+	OrderID *uint
+	Reason  string
+	ActorID *uint
+	// Overdraw lets the change take the balance below zero
+	Overdraw bool
+}
+
+// nextBalance returns the balance after adding amount to it
+func nextBalance(balance, amount float64, overdraw bool) (float64, error) {
+	after := RoundMoney(balance + amount)
+	if after < 0 && amount < 0 && !overdraw {
+		return balance, ErrInsufficientBalance
+	}
+	return after, nil
+}
+
+// AmountDue is what is left of an order's total after gift card and store credit,
+// and what Razorpay is charged
+func AmountDue(order *models.Order) float64 {
+	return math.Max(RoundMoney(order.TotalAmount-order.GiftCardAmount-order.StoreCreditAmount), 0)
+}
+
+// StoreCreditBalance returns a customer's store credit
+func StoreCreditBalance(db *gorm.DB, userID uint) (float64, error) {
+	var entry models.StoreCreditEntry
+	err := db.Where("user_id = ?", userID).Order("id DESC").Take(&entry).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, nil
+	}
+	return entry.BalanceAfter, err
+}
+
+// StoreCreditLedger returns a customer's store credit entries, newest first
+func StoreCreditLedger(db *gorm.DB, userID uint, limit int) ([]models.StoreCreditEntry, error) {
+	var entries []models.StoreCreditEntry
+	err := db.Where("user_id = ?", userID).Order("id DESC").Limit(limit).Find(&entries).Error
+	return entries, err
+}
+
+// AddStoreCredit adds an entry to a customer's ledger. The customer's row is
+// locked so concurrent changes each see the balance the one before left.
+func AddStoreCredit(tx *gorm.DB, userID uint, change LedgerChange) (*models.StoreCreditEntry, error) {
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").
+		First(&models.User{}, userID).Error; err != nil {
+		return nil, err
+	}
+	balance, err := StoreCreditBalance(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	after, err := nextBalance(balance, change.Amount, change.Overdraw)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := models.StoreCreditEntry{
+		UserID:       userID,
+		Amount:       RoundMoney(change.Amount),
+		BalanceAfter: after,
+		Kind:         change.Kind,
+		OrderID:      change.OrderID,
+		Reason:       change.Reason,
+		ActorID:      change.ActorID,
+	}
+	if err := tx.Create(&entry).Error; err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// ApplyStoreCredit pays as much of what is due on a new order as the customer's
+// store credit covers
+func ApplyStoreCredit(tx *gorm.DB, order *models.Order) error {
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").
+		First(&models.User{}, order.UserID).Error; err != nil {
+		return err
+	}
+	balance, err := StoreCreditBalance(tx, order.UserID)
+	if err != nil {
+		return err
+	}
+	amount := RoundMoney(math.Min(balance, AmountDue(order)))
+	if amount <= 0 {
+		return nil
+	}
+
+	if _, err := AddStoreCredit(tx, order.UserID, LedgerChange{
+		Amount:  -amount,
+		Kind:    LedgerOrderPayment,
+		OrderID: &order.ID,
+	}); err != nil {
+		return err
+	}
+	order.StoreCreditAmount = amount
+	return nil
+}
+
+// heldForOrder returns how much of a ledger an order holds: what paying for it took
+// out, less what has been released since
+func heldForOrder(query *gorm.DB, orderID uint) (float64, error) {
+	var net float64
+	err := query.Where("order_id = ? AND kind IN ?", orderID, []string{LedgerOrderPayment, LedgerOrderRelease}).
+		Select("COALESCE(SUM(amount), 0)").Scan(&net).Error
+	return RoundMoney(-net), err
+}
+
+// ReleaseOrderCredit gives back the gift card balance and store credit an order
+// was paid with when it is cancelled or expires
+func ReleaseOrderCredit(tx *gorm.DB, order *models.Order) error {
+	if order.StoreCreditAmount > 0 {
+		held, err := heldForOrder(tx.Model(&models.StoreCreditEntry{}).Where("user_id = ?", order.UserID), order.ID)
+		if err != nil {
+			return err
+		}
+		if held > 0 {
+			if _, err := AddStoreCredit(tx, order.UserID, LedgerChange{
+				Amount:  held,
+				Kind:    LedgerOrderRelease,
+				OrderID: &order.ID,
+			}); err != nil {
+				return err
+			}
+		}
+	}
+
+	if order.GiftCardID != nil && order.GiftCardAmount > 0 {
+		held, err := heldForOrder(tx.Model(&models.GiftCardEntry{}).Where("gift_card_id = ?", *order.GiftCardID), order.ID)
+		if err != nil {
+			return err
+		}
+		if held > 0 {
+			if _, err := AdjustGiftCard(tx, *order.GiftCardID, LedgerChange{
+				Amount:  held,
+				Kind:    LedgerOrderRelease,
+				OrderID: &order.ID,
+			}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ReclaimOrderCredit takes the gift card balance and store credit an expired order
+// was paid with again when it is paid after all. Like ReclaimCouponRedemption it
+// doesn't check balances: the customer has already paid the rest, so the balance
+// may go below zero.
+func ReclaimOrderCredit(tx *gorm.DB, order *models.Order) error {
+	if order.StoreCreditAmount > 0 {
+		held, err := heldForOrder(tx.Model(&models.StoreCreditEntry{}).Where("user_id = ?", order.UserID), order.ID)
+		if err != nil {
+			return err
+		}
+		if missing := RoundMoney(order.StoreCreditAmount - held); missing > 0 {
+			if _, err := AddStoreCredit(tx, order.UserID, LedgerChange{
+				Amount:   -missing,
+				Kind:     LedgerOrderPayment,
+				OrderID:  &order.ID,
+				Overdraw: true,
+			}); err != nil {
+				return err
+			}
+		}
+	}
+
+	if order.GiftCardID != nil && order.GiftCardAmount > 0 {
+		held, err := heldForOrder(tx.Model(&models.GiftCardEntry{}).Where("gift_card_id = ?", *order.GiftCardID), order.ID)
+		if err != nil {
+			return err
+		}
+		if missing := RoundMoney(order.GiftCardAmount - held); missing > 0 {
+			if _, err := AdjustGiftCard(tx, *order.GiftCardID, LedgerChange{
+				Amount:   -missing,
+				Kind:     LedgerOrderPayment,
+				OrderID:  &order.ID,
+				Overdraw: true,
+			}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package services
+
+import (
+	"testing"
+
+	"pashmina-backend/models"
+)
+
+func TestNextBalance(t *testing.T) {
+	tests := []struct {
+		name     string
+		balance  float64
+		amount   float64
+		overdraw bool
+		want     float64
+		wantErr  error
+	}{
+		{"credit", 100, 50.005, false, 150.01, nil},
+		{"debit", 100, -40, false, 60, nil},
+		{"debit to zero", 100, -100, false, 0, nil},
+		{"debit past zero", 100, -100.01, false, 100, ErrInsufficientBalance},
+		{"overdraw allowed", 100, -150, true, -50, nil},
+		{"credit to an overdrawn balance", -50, 20, false, -30, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := nextBalance(tt.balance, tt.amount, tt.overdraw)
+			if err != tt.wantErr {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("nextBalance(%v, %v) = %v, want %v", tt.balance, tt.amount, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAmountDue(t *testing.T) {
+	tests := []struct {
+		name  string
+		order models.Order
+		want  float64
+	}{
+		{"nothing applied", models.Order{TotalAmount: 2499.5}, 2499.5},
+		{"gift card and store credit", models.Order{TotalAmount: 2499.5, GiftCardAmount: 1000, StoreCreditAmount: 499.25}, 1000.25},
+		{"paid in full", models.Order{TotalAmount: 800, GiftCardAmount: 500, StoreCreditAmount: 300}, 0},
+		{"never negative", models.Order{TotalAmount: 800, GiftCardAmount: 900}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := AmountDue(&tt.order); got != tt.want {
+				t.Errorf("AmountDue = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
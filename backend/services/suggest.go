@@ -0,0 +1,199 @@
+package services
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"pashmina-backend/models"
+	"pashmina-backend/utils"
+
+	"gorm.io/gorm"
+)
+
+// Suggestion types
+const (
+	SuggestProduct   = "product"
+	SuggestCategory  = "category"
+	SuggestCatalogue = "catalogue"
+	SuggestQuery     = "query"
+)
+
+// Suggestion is one type-ahead result
+type Suggestion struct {
+	Type string `json:"type"`
+	ID   uint   `json:"id,omitempty"`
+	Text string `json:"text"`
+	Slug string `json:"slug,omitempty"`
+	// weight orders suggestions that match equally well
+	weight int
+}
+
+type suggestEntry struct {
+	key   string // normalized text from one word onwards
+	start bool   // key is the start of the text
+	item  int    // index into suggestIndex.items
+}
+
+// suggestIndex finds suggestions whose text has a word starting with a prefix. It is
+// immutable once built.
+type suggestIndex struct {
+	items   []Suggestion
+	entries []suggestEntry // sorted by key
+}
+
+func buildSuggestIndex(items []Suggestion) *suggestIndex {
+	idx := &suggestIndex{items: items}
+	for i, item := range items {
+		text := normalizeSuggestText(item.Text)
+		for pos := 0; pos < len(text); pos++ {
+			if pos == 0 || text[pos-1] == ' ' {
+				idx.entries = append(idx.entries, suggestEntry{key: text[pos:], start: pos == 0, item: i})
+			}
+		}
+	}
+	sort.Slice(idx.entries, func(i, j int) bool { return idx.entries[i].key < idx.entries[j].key })
+	return idx
+}
+
+// lookup returns up to limit suggestions matching the prefix. Matches at the start of
+// the text come first, then heavier items, then shorter text.
+func (idx *suggestIndex) lookup(prefix string, limit int) []Suggestion {
+	prefix = normalizeSuggestText(prefix)
+	if prefix == "" {
+		return nil
+	}
+
+	first := sort.Search(len(idx.entries), func(i int) bool { return idx.entries[i].key >= prefix })
+	atStart := make(map[int]bool)
+	var matched []int
+	for i := first; i < len(idx.entries) && strings.HasPrefix(idx.entries[i].key, prefix); i++ {
+		entry := idx.entries[i]
+		if _, seen := atStart[entry.item]; !seen {
+			matched = append(matched, entry.item)
+		}
+		atStart[entry.item] = atStart[entry.item] || entry.start
+	}
+
+	sort.SliceStable(matched, func(i, j int) bool {
+		a, b := matched[i], matched[j]
+		if atStart[a] != atStart[b] {
+			return atStart[a]
+		}
+		if idx.items[a].weight != idx.items[b].weight {
+			return idx.items[a].weight > idx.items[b].weight
+		}
+		return len(idx.items[a].Text) < len(idx.items[b].Text)
+	})
+
+	if len(matched) > limit {
+		matched = matched[:limit]
+	}
+	suggestions := make([]Suggestion, len(matched))
+	for i, item := range matched {
+		suggestions[i] = idx.items[item]
+	}
+	return suggestions
+}
+
+// normalizeSuggestText lowercases text and reduces it to words separated by single spaces
+func normalizeSuggestText(text string) string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return strings.Join(words, " ")
+}
+
+// Suggester serves type-ahead suggestions from an in-memory index of product,
+// category and catalogue names and popular searches. Refresh rebuilds it in the
+// background after catalog changes.
+type Suggester struct {
+	db      *gorm.DB
+	mu      sync.RWMutex
+	index   *suggestIndex
+	refresh chan struct{}
+}
+
+var (
+	suggester     *Suggester
+	suggesterOnce sync.Once
+)
+
+// Suggestions returns the shared suggester, building its index on first use and
+// rebuilding it whenever Refresh is called or the refresh interval passes
+func Suggestions(db *gorm.DB) *Suggester {
+	suggesterOnce.Do(func() {
+		suggester = &Suggester{db: db, refresh: make(chan struct{}, 1)}
+		suggester.rebuild()
+		go suggester.run(10 * time.Minute)
+	})
+	return suggester
+}
+
+// Suggest returns up to limit suggestions for a prefix
+func (s *Suggester) Suggest(prefix string, limit int) []Suggestion {
+	s.mu.RLock()
+	idx := s.index
+	s.mu.RUnlock()
+	return idx.lookup(prefix, limit)
+}
+
+// Refresh asks for the index to be rebuilt. Calls made while a rebuild is pending
+// are coalesced.
+func (s *Suggester) Refresh() {
+	select {
+	case s.refresh <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Suggester) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.refresh:
+		case <-ticker.C:
+		}
+		s.rebuild()
+	}
+}
+
+func (s *Suggester) rebuild() {
+	var items []Suggestion
+
+	var products []models.Product
+	VisibleProducts(s.db.Select("id, name, is_featured"), time.Now()).Find(&products)
+	for _, p := range products {
+		weight := 1
+		if p.IsFeatured {
+			weight = 2
+		}
+		items = append(items, Suggestion{Type: SuggestProduct, ID: p.ID, Text: p.Name, weight: weight})
+	}
+
+	var categories []models.Category
+	VisibleCategories(s.db.Select("id, name, slug")).Find(&categories)
+	for _, c := range categories {
+		items = append(items, Suggestion{Type: SuggestCategory, ID: c.ID, Text: c.Name, Slug: c.Slug, weight: 3})
+	}
+
+	var catalogues []models.Catalogue
+	VisibleCatalogues(s.db.Select("id, name")).Find(&catalogues)
+	for _, c := range catalogues {
+		items = append(items, Suggestion{Type: SuggestCatalogue, ID: c.ID, Text: c.Name, weight: 3})
+	}
+
+	for _, q := range PopularSearches(s.db, 30*24*time.Hour, 200) {
+		items = append(items, Suggestion{Type: SuggestQuery, Text: q.Query, weight: 0})
+	}
+
+	idx := buildSuggestIndex(items)
+	s.mu.Lock()
+	s.index = idx
+	s.mu.Unlock()
+
+	utils.Debug("Rebuilt search suggestions", map[string]interface{}{"items": len(items)})
+}
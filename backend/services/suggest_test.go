@@ -0,0 +1,40 @@
+package services
+
+import "testing"
+
+func TestSuggestIndexLookup(t *testing.T) {
+	idx := buildSuggestIndex([]Suggestion{
+		{Type: SuggestProduct, ID: 1, Text: "Classic Pashmina Shawl", weight: 1},
+		{Type: SuggestProduct, ID: 2, Text: "Embroidered Floral Shawl", weight: 2},
+		{Type: SuggestCategory, ID: 3, Text: "Embroidered", weight: 3},
+		{Type: SuggestProduct, ID: 4, Text: "Kani Checkered Shawl", weight: 1},
+		{Type: SuggestQuery, Text: "pashmina stole"},
+	})
+
+	tests := []struct {
+		prefix string
+		limit  int
+		want   []string
+	}{
+		{"emb", 10, []string{"Embroidered", "Embroidered Floral Shawl"}},
+		{"PASH", 10, []string{"pashmina stole", "Classic Pashmina Shawl"}},
+		{"shawl", 2, []string{"Embroidered Floral Shawl", "Kani Checkered Shawl"}},
+		{"pashmina  sh", 10, []string{"Classic Pashmina Shawl"}},
+		{"silk", 10, nil},
+		{"  ", 10, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.prefix, func(t *testing.T) {
+			got := idx.lookup(tt.prefix, tt.limit)
+			if len(got) != len(tt.want) {
+				t.Fatalf("lookup(%q) = %v, want %v", tt.prefix, got, tt.want)
+			}
+			for i := range tt.want {
+				if got[i].Text != tt.want[i] {
+					t.Errorf("lookup(%q)[%d] = %q, want %q", tt.prefix, i, got[i].Text, tt.want[i])
+				}
+			}
+		})
+	}
+}
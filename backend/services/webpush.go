@@ -0,0 +1,206 @@
+package services
+
+import (
+	"crypto/ecdh"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"pashmina-backend/models"
+	"pashmina-backend/utils"
+
+	"github.com/SherClockHolmes/webpush-go"
+	"gorm.io/gorm"
+)
+
+// ErrSubscriptionGone is returned when the push service reports a subscription no
+// longer exists. The subscription has been deleted.
+var ErrSubscriptionGone = errors.New("push subscription has expired or been removed")
+
+const pushTTL = 24 * time.Hour
+
+// VAPIDKeys identify this server to browser push services (RFC 8292)
+type VAPIDKeys struct {
+	PublicKey  string // uncompressed P-256 point, base64url
+	PrivateKey string // P-256 scalar, base64url
+	Subject    string // mailto: or https: contact for the push service operator
+}
+
+// GenerateVAPIDKeys creates a new VAPID key pair encoded the way browsers and
+// VAPID_PUBLIC_KEY / VAPID_PRIVATE_KEY expect
+func GenerateVAPIDKeys() (publicKey, privateKey string, err error) {
+	privateKey, publicKey, err = webpush.GenerateVAPIDKeys()
+	return publicKey, privateKey, err
+}
+
+// LoadVAPIDKeys reads the VAPID key pair from the environment and checks that the
+// public key belongs to the private key
+func LoadVAPIDKeys() (*VAPIDKeys, error) {
+	keys := &VAPIDKeys{
+		PublicKey:  strings.TrimSpace(os.Getenv("VAPID_PUBLIC_KEY")),
+		PrivateKey: strings.TrimSpace(os.Getenv("VAPID_PRIVATE_KEY")),
+		Subject:    strings.TrimSpace(os.Getenv("VAPID_SUBJECT")),
+	}
+	if keys.PublicKey == "" || keys.PrivateKey == "" {
+		return nil, errors.New("VAPID_PUBLIC_KEY and VAPID_PRIVATE_KEY are not configured")
+	}
+	if keys.Subject == "" {
+		keys.Subject = "mailto:support@pashmiya.com"
+	}
+
+	scalar, err := decodeBase64URL(keys.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid VAPID_PRIVATE_KEY: %w", err)
+	}
+	private, err := ecdh.P256().NewPrivateKey(scalar)
+	if err != nil {
+		return nil, fmt.Errorf("invalid VAPID_PRIVATE_KEY: %w", err)
+	}
+
+	public := base64.RawURLEncoding.EncodeToString(private.PublicKey().Bytes())
+	if public != strings.TrimRight(keys.PublicKey, "=") {
+		return nil, errors.New("VAPID_PUBLIC_KEY does not match VAPID_PRIVATE_KEY")
+	}
+	return keys, nil
+}
+
+// PushService sends web push messages to stored browser subscriptions
+type PushService struct {
+	db     *gorm.DB
+	keys   *VAPIDKeys
+	client *http.Client
+}
+
+// NewPushService returns a push service, or nil when VAPID keys are not configured
+func NewPushService(db *gorm.DB) *PushService {
+	keys, err := LoadVAPIDKeys()
+	if err != nil {
+		utils.Warn("Web push disabled", map[string]interface{}{"reason": err.Error()})
+		return nil
+	}
+	return &PushService{db: db, keys: keys, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// PublicKey returns the VAPID public key browsers subscribe with
+func (s *PushService) PublicKey() string {
+	return s.keys.PublicKey
+}
+
+// PushPayload is the JSON the service worker receives
+type PushPayload struct {
+	Title string `json:"title"`
+	Body  string `json:"body"`
+	URL   string `json:"url,omitempty"`
+	Tag   string `json:"tag,omitempty"`
+	ID    uint   `json:"id,omitempty"`
+}
+
+// SendToSubscription encrypts and delivers a payload to one subscription. Subscriptions
+// the push service reports as gone (404/410) are deleted and ErrSubscriptionGone is
+// returned.
+func (s *PushService) SendToSubscription(sub *models.PushSubscription, payload []byte) error {
+	resp, err := webpush.SendNotification(payload, &webpush.Subscription{
+		Endpoint: sub.Endpoint,
+		Keys:     webpush.Keys{P256dh: sub.P256DH, Auth: sub.Auth},
+	}, &webpush.Options{
+		HTTPClient: s.client,
+		// The library adds mailto: to anything that isn't an https: URL
+		Subscriber:      strings.TrimPrefix(s.keys.Subject, "mailto:"),
+		TTL:             int(pushTTL.Seconds()),
+		Urgency:         webpush.UrgencyNormal,
+		VAPIDPublicKey:  s.keys.PublicKey,
+		VAPIDPrivateKey: s.keys.PrivateKey,
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		s.db.Delete(sub)
+		utils.Info("Pruned expired push subscription", map[string]interface{}{
+			"subscription_id": sub.ID,
+			"user_id":         sub.UserID,
+		})
+		return ErrSubscriptionGone
+	case resp.StatusCode >= 300:
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("push service returned %d: %s", resp.StatusCode, strings.TrimSpace(string(detail)))
+	}
+	return nil
+}
+
+// SendToUser delivers a payload to every subscription of a user and reports how many
+// deliveries succeeded
+func (s *PushService) SendToUser(userID uint, payload PushPayload) (int, error) {
+	var subs []models.PushSubscription
+	if err := s.db.Where("user_id = ?", userID).Find(&subs).Error; err != nil {
+		return 0, err
+	}
+	if len(subs) == 0 {
+		return 0, errors.New("user has no push subscriptions")
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	var lastErr error
+	for i := range subs {
+		if err := s.SendToSubscription(&subs[i], data); err != nil {
+			lastErr = err
+			continue
+		}
+		sent++
+	}
+
+	if sent == 0 {
+		return 0, lastErr
+	}
+	return sent, nil
+}
+
+// Send implements Sender for the push channel
+func (s *PushService) Send(to Recipient, msg Message) error {
+	if to.UserID == nil {
+		return errors.New("push notifications need a user")
+	}
+	_, err := s.SendToUser(*to.UserID, PushPayload{
+		Title: msg.Title,
+		Body:  msg.Text,
+		URL:   msg.Link,
+		Tag:   msg.Type,
+		ID:    msg.NotificationID,
+	})
+	return err
+}
+
+// decodeBase64URL accepts the padded or unpadded base64url keys browsers hand out
+func decodeBase64URL(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+// ValidatePushKeys checks a subscription's keys can be used for encryption
+func ValidatePushKeys(p256dh, authSecret string) error {
+	key, err := decodeBase64URL(p256dh)
+	if err != nil {
+		return errors.New("p256dh must be base64url encoded")
+	}
+	if _, err := ecdh.P256().NewPublicKey(key); err != nil {
+		return errors.New("p256dh is not a P-256 public key")
+	}
+	auth, err := decodeBase64URL(authSecret)
+	if err != nil || len(auth) != 16 {
+		return errors.New("auth must be a 16 byte base64url secret")
+	}
+	return nil
+}
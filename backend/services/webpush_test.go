@@ -0,0 +1,190 @@
+package services
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"pashmina-backend/models"
+
+	"github.com/SherClockHolmes/webpush-go"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// decryptPushPayload plays the browser's part of RFC 8291
+func decryptPushPayload(t *testing.T, ua *ecdh.PrivateKey, auth, body []byte) []byte {
+	t.Helper()
+
+	salt := body[:16]
+	if rs := binary.BigEndian.Uint32(body[16:20]); rs != webpush.MaxRecordSize {
+		t.Fatalf("record size = %d, want %d", rs, webpush.MaxRecordSize)
+	}
+	idLen := int(body[20])
+	asPublicBytes := body[21 : 21+idLen]
+	ciphertext := body[21+idLen:]
+
+	asPublic, err := ecdh.P256().NewPublicKey(asPublicBytes)
+	if err != nil {
+		t.Fatalf("invalid sender key: %v", err)
+	}
+	shared, err := ua.ECDH(asPublic)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyInfo := append([]byte("WebPush: info\x00"), ua.PublicKey().Bytes()...)
+	keyInfo = append(keyInfo, asPublicBytes...)
+	ikm, _ := hkdf.Key(sha256.New, shared, auth, string(keyInfo), 32)
+	cek, _ := hkdf.Key(sha256.New, ikm, salt, "Content-Encoding: aes128gcm\x00", 16)
+	nonce, _ := hkdf.Key(sha256.New, ikm, salt, "Content-Encoding: nonce\x00", 12)
+
+	block, _ := aes.NewCipher(cek)
+	gcm, _ := cipher.NewGCM(block)
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		t.Fatalf("decrypt: %v", err)
+	}
+
+	// The record is padded with zeros after the 0x02 delimiter
+	plaintext = bytes.TrimRight(plaintext, "\x00")
+	if len(plaintext) == 0 || plaintext[len(plaintext)-1] != 0x02 {
+		t.Fatalf("missing last record delimiter")
+	}
+	return plaintext[:len(plaintext)-1]
+}
+
+func TestSendToSubscription(t *testing.T) {
+	publicKey, privateKey, err := GenerateVAPIDKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("VAPID_PUBLIC_KEY", publicKey)
+	t.Setenv("VAPID_PRIVATE_KEY", privateKey)
+	t.Setenv("VAPID_SUBJECT", "mailto:ops@example.com")
+
+	keys, err := LoadVAPIDKeys()
+	if err != nil {
+		t.Fatalf("LoadVAPIDKeys error = %v", err)
+	}
+
+	// A dry run lets the gone case delete the subscription without a database
+	db := dryRunDB(t)
+
+	point, _ := decodeBase64URL(publicKey)
+	vapidPublicKey := &ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(point[1:33]),
+		Y:     new(big.Int).SetBytes(point[33:]),
+	}
+
+	ua, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := make([]byte, 16)
+	rand.Read(auth)
+
+	payload := []byte(`{"title":"Order shipped","body":"Your order #7 is on its way."}`)
+
+	var status int
+	var received *http.Request
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	push := &PushService{db: db, keys: keys, client: server.Client()}
+	sub := &models.PushSubscription{
+		ID:       3,
+		Endpoint: server.URL + "/push/abc123",
+		P256DH:   base64.RawURLEncoding.EncodeToString(ua.PublicKey().Bytes()),
+		Auth:     base64.URLEncoding.EncodeToString(auth), // padded, as some browsers send it
+	}
+
+	tests := []struct {
+		name    string
+		status  int
+		wantErr error
+	}{
+		{"delivered", http.StatusCreated, nil},
+		{"subscription expired", http.StatusGone, ErrSubscriptionGone},
+		{"subscription unknown", http.StatusNotFound, ErrSubscriptionGone},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status = tt.status
+			if err := push.SendToSubscription(sub, payload); err != tt.wantErr {
+				t.Fatalf("SendToSubscription error = %v, want %v", err, tt.wantErr)
+			}
+
+			if got := received.Header.Get("Content-Encoding"); got != "aes128gcm" {
+				t.Errorf("Content-Encoding = %q, want aes128gcm", got)
+			}
+			if got := decryptPushPayload(t, ua, auth, body); string(got) != string(payload) {
+				t.Errorf("decrypted payload = %q, want %q", got, payload)
+			}
+
+			header := received.Header.Get("Authorization")
+			parts := strings.SplitN(strings.TrimPrefix(header, "vapid t="), ", k=", 2)
+			if len(parts) != 2 || parts[1] != publicKey {
+				t.Fatalf("Authorization = %q, want vapid t=<jwt>, k=<public key>", header)
+			}
+
+			claims := &jwt.RegisteredClaims{}
+			_, err := jwt.ParseWithClaims(parts[0], claims, func(*jwt.Token) (interface{}, error) {
+				return vapidPublicKey, nil
+			}, jwt.WithValidMethods([]string{"ES256"}), jwt.WithAudience(server.URL))
+			if err != nil {
+				t.Fatalf("VAPID token did not verify: %v", err)
+			}
+			if claims.Subject != "mailto:ops@example.com" {
+				t.Errorf("sub = %q, want the configured subject", claims.Subject)
+			}
+		})
+	}
+
+	status = http.StatusBadRequest
+	if err := push.SendToSubscription(sub, payload); err == nil {
+		t.Error("SendToSubscription ignored an error from the push service")
+	}
+}
+
+func TestLoadVAPIDKeys(t *testing.T) {
+	publicKey, privateKey, err := GenerateVAPIDKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("VAPID_PUBLIC_KEY", publicKey)
+	t.Setenv("VAPID_PRIVATE_KEY", privateKey)
+	t.Setenv("VAPID_SUBJECT", "")
+
+	keys, err := LoadVAPIDKeys()
+	if err != nil {
+		t.Fatalf("LoadVAPIDKeys error = %v", err)
+	}
+	if !strings.HasPrefix(keys.Subject, "mailto:") {
+		t.Errorf("default subject = %q, want a mailto: address", keys.Subject)
+	}
+
+	t.Setenv("VAPID_PUBLIC_KEY", strings.Repeat("A", len(publicKey)))
+	if _, err := LoadVAPIDKeys(); err == nil {
+		t.Error("LoadVAPIDKeys accepted a public key that does not match the private key")
+	}
+}
@@ -0,0 +1,79 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// PatchField is one field of a JSON merge patch (RFC 7396). It tells a field that
+// was left out, which leaves the value alone, from one sent as null, which clears it.
+type PatchField[T any] struct {
+	Set   bool
+	Null  bool
+	Value T
+}
+
+// UnmarshalJSON is only called for fields present in the patch
+func (f *PatchField[T]) UnmarshalJSON(data []byte) error {
+	f.Set = true
+	if string(data) == "null" {
+		var zero T
+		f.Null, f.Value = true, zero
+		return nil
+	}
+	f.Null = false
+	return json.Unmarshal(data, &f.Value)
+}
+
+// Present reports whether the field was sent with a value
+func (f PatchField[T]) Present() bool {
+	return f.Set && !f.Null
+}
+
+// Required returns an error if a field that can't be cleared was sent as null
+func (f PatchField[T]) Required(name string) error {
+	if f.Set && f.Null {
+		return fmt.Errorf("%s cannot be null", name)
+	}
+	return nil
+}
+
+// DecodePatch reads a merge patch into v, a struct of PatchFields. Fields v doesn't
+// list are refused, so a patch can only touch what the struct allows.
+func DecodePatch(r io.Reader, v interface{}) error {
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		if errors.Is(err, io.EOF) {
+			return errors.New("request body is required")
+		}
+		return errors.New(strings.TrimPrefix(err.Error(), "json: "))
+	}
+	if decoder.More() {
+		return errors.New("request body must be a single JSON object")
+	}
+	return nil
+}
+
+// VersionTag is the ETag of a record last changed at updatedAt. Postgres keeps
+// microseconds, so the tag is the same before and after a round trip.
+func VersionTag(updatedAt time.Time) string {
+	return fmt.Sprintf(`"%d"`, updatedAt.Round(time.Microsecond).UnixMicro())
+}
+
+// MatchesVersion reports whether an If-Match header value names the version of a
+// record last changed at updatedAt
+func MatchesVersion(ifMatch string, updatedAt time.Time) bool {
+	want := VersionTag(updatedAt)
+	for _, tag := range strings.Split(ifMatch, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == want {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,95 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+type testPatch struct {
+	Name     PatchField[string]  `json:"name"`
+	Image    PatchField[string]  `json:"image"`
+	IsActive PatchField[bool]    `json:"is_active"`
+	ParentID PatchField[uint]    `json:"parent_id"`
+	Price    PatchField[float64] `json:"price"`
+}
+
+func TestDecodePatch(t *testing.T) {
+	var patch testPatch
+	if err := DecodePatch(strings.NewReader(`{"name":"Shawl","image":null,"is_active":false,"parent_id":null}`), &patch); err != nil {
+		t.Fatalf("DecodePatch: %v", err)
+	}
+
+	state := func(set, null, present bool) [3]bool { return [3]bool{set, null, present} }
+	tests := []struct {
+		field     string
+		got, want [3]bool
+	}{
+		{"name", state(patch.Name.Set, patch.Name.Null, patch.Name.Present()), state(true, false, true)},
+		{"image", state(patch.Image.Set, patch.Image.Null, patch.Image.Present()), state(true, true, false)},
+		{"is_active", state(patch.IsActive.Set, patch.IsActive.Null, patch.IsActive.Present()), state(true, false, true)},
+		{"parent_id", state(patch.ParentID.Set, patch.ParentID.Null, patch.ParentID.Present()), state(true, true, false)},
+		{"price", state(patch.Price.Set, patch.Price.Null, patch.Price.Present()), state(false, false, false)},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s: set/null/present = %v, want %v", tt.field, tt.got, tt.want)
+		}
+	}
+	if patch.Name.Value != "Shawl" || patch.IsActive.Value {
+		t.Errorf("values = %q, %v", patch.Name.Value, patch.IsActive.Value)
+	}
+	if err := patch.ParentID.Required("parent_id"); err == nil {
+		t.Error("Required accepted null")
+	}
+	if err := patch.Price.Required("price"); err != nil {
+		t.Errorf("Required refused a missing field: %v", err)
+	}
+}
+
+func TestDecodePatchErrors(t *testing.T) {
+	tests := []struct {
+		name, body, want string
+	}{
+		{"unknown field", `{"name":"x","user_id":3}`, `unknown field "user_id"`},
+		{"wrong type", `{"price":"cheap"}`, "cannot unmarshal"},
+		{"empty body", ``, "request body is required"},
+		{"two objects", `{} {}`, "single JSON object"},
+		{"not an object", `[1]`, "cannot unmarshal"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var patch testPatch
+			err := DecodePatch(strings.NewReader(tt.body), &patch)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("DecodePatch(%s) error = %v, want %q", tt.body, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchesVersion(t *testing.T) {
+	updatedAt := time.Date(2024, 3, 1, 12, 0, 0, 123456700, time.UTC)
+	tag := VersionTag(updatedAt)
+	if tag != VersionTag(updatedAt.Round(time.Microsecond)) {
+		t.Errorf("tag changes when stored with microseconds")
+	}
+
+	tests := []struct {
+		ifMatch string
+		want    bool
+	}{
+		{tag, true},
+		{"W/" + tag, true},
+		{`"1", ` + tag, true},
+		{"*", true},
+		{`"1"`, false},
+		{"", false},
+		{VersionTag(updatedAt.Add(time.Second)), false},
+	}
+	for _, tt := range tests {
+		if got := MatchesVersion(tt.ifMatch, updatedAt); got != tt.want {
+			t.Errorf("MatchesVersion(%q) = %v, want %v", tt.ifMatch, got, tt.want)
+		}
+	}
+}
@@ -12,6 +12,7 @@ var (
 	phoneRegex  = regexp.MustCompile(`^\+?[1-9]\d{6,14}$`)
 	postalRegex = regexp.MustCompile(`^[a-zA-Z0-9\s-]{3,10}$`)
 	slugRegex   = regexp.MustCompile(`^[a-z0-9]+(?:-[a-z0-9]+)*$`)
+	skuRegex    = regexp.MustCompile(`^[A-Z0-9]+(?:[-_][A-Z0-9]+)*$`)
 )
 
 var allowedSortColumns = map[string]bool{
@@ -107,6 +108,29 @@ func ValidateStock(stock int) error {
 	return nil
 }
 
+func ValidateSKU(sku string) error {
+	if sku == "" {
+		return fmt.Errorf("sku is required")
+	}
+	if len(sku) > 64 {
+		return fmt.Errorf("sku is too long")
+	}
+	if !skuRegex.MatchString(sku) {
+		return fmt.Errorf("invalid sku format (use uppercase letters, numbers, hyphens and underscores)")
+	}
+	return nil
+}
+
+func ValidateWeight(weight float64) error {
+	if weight < 0 {
+		return fmt.Errorf("weight cannot be negative")
+	}
+	if weight > 100 {
+		return fmt.Errorf("weight is too high")
+	}
+	return nil
+}
+
 func ValidateQuantity(quantity int) error {
 	if quantity <= 0 {
 		return fmt.Errorf("quantity must be greater than 0")
@@ -208,6 +232,12 @@ func ValidateRating(rating int) error {
 	return nil
 }
 
+// NormalizeCouponCode puts a coupon code in the form it is stored in, so codes
+// match however a customer types them
+func NormalizeCouponCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
 func ValidateCouponCode(code string) error {
 	if code == "" {
 		return fmt.Errorf("coupon code is required")
@@ -253,6 +283,7 @@ func ValidateOrderStatus(status string) error {
 		"cancelled":       true,
 		"refunded":        true,
 		"payment_failed":  true,
+		"expired":         true,
 	}
 	if !validStatuses[status] {
 		return fmt.Errorf("invalid order status: %s", status)
@@ -119,6 +119,31 @@ func TestValidateStock(t *testing.T) {
 	}
 }
 
+func TestValidateSKU(t *testing.T) {
+	tests := []struct {
+		name    string
+		sku     string
+		wantErr bool
+	}{
+		{"valid sku", "PSH-CAMEL-LG", false},
+		{"underscore separator", "PSH_001", false},
+		{"empty", "", true},
+		{"lowercase", "psh-camel", true},
+		{"trailing separator", "PSH-", true},
+		{"spaces", "PSH CAMEL", true},
+		{"too long", "A" + string(make([]byte, 64)), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateSKU(tt.sku)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateSKU(%q) error = %v, wantErr %v", tt.sku, err, tt.wantErr)
+			}
+		})
+	}
+}
+
 func TestValidateQuantity(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -273,3 +298,26 @@ func TestSanitizeString(t *testing.T) {
 		})
 	}
 }
+
+func TestNormalizeCouponCode(t *testing.T) {
+	tests := []struct {
+		code string
+		want string
+	}{
+		{"DIWALI20", "DIWALI20"},
+		{"diwali20", "DIWALI20"},
+		{"  Diwali20\n", "DIWALI20"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := NormalizeCouponCode(tt.code); got != tt.want {
+			t.Errorf("NormalizeCouponCode(%q) = %q, want %q", tt.code, got, tt.want)
+		}
+		if tt.want != "" {
+			if err := ValidateCouponCode(NormalizeCouponCode(tt.code)); err != nil {
+				t.Errorf("ValidateCouponCode(NormalizeCouponCode(%q)) = %v", tt.code, err)
+			}
+		}
+	}
+}
@@ -21,7 +21,7 @@ interface Product {
   sizes: string[]
   stock: number
   is_featured: boolean
-  is_active: boolean
+  status: 'draft' | 'published' | 'archived'
 }
 
 interface Catalogue {
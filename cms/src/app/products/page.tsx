@@ -26,7 +26,7 @@ interface Product {
   sizes: string[]
   stock: number
   is_featured: boolean
-  is_active: boolean
+  status: 'draft' | 'published' | 'archived'
 }
 
 export default function Products() {
@@ -50,7 +50,7 @@ export default function Products() {
     sizes: [] as string[],
     stock: 0,
     is_featured: false,
-    is_active: true,
+    status: 'published' as Product['status'],
   })
 
   useEffect(() => {
@@ -107,7 +107,7 @@ export default function Products() {
         sizes: formData.sizes,
         stock: Number(formData.stock),
         is_featured: formData.is_featured,
-        is_active: formData.is_active,
+        status: formData.status,
       }
 
       const res = await fetch(url, {
@@ -143,7 +143,7 @@ export default function Products() {
       sizes: product.sizes || [],
       stock: product.stock || 0,
       is_featured: product.is_featured || false,
-      is_active: product.is_active !== false,
+      status: product.status || 'published',
     })
     setShowModal(true)
   }
@@ -179,20 +179,21 @@ export default function Products() {
   }
 
   const handleToggleActive = async (product: Product) => {
-    const newValue = !product.is_active
+    const previous = product.status
+    const newValue: Product['status'] = previous === 'published' ? 'draft' : 'published'
     setProducts(prev => prev.map(p => 
-      p.id === product.id ? { ...p, is_active: newValue } : p
+      p.id === product.id ? { ...p, status: newValue } : p
     ))
     try {
       await fetch(`${API_URL}/products/${product.id}`, {
         method: 'PUT',
         headers: { 'Content-Type': 'application/json' },
-        body: JSON.stringify({ is_active: newValue }),
+        body: JSON.stringify({ status: newValue }),
       })
     } catch (err) {
-      console.error('Error toggling active:', err)
+      console.error('Error toggling status:', err)
       setProducts(prev => prev.map(p => 
-        p.id === product.id ? { ...p, is_active: !newValue } : p
+        p.id === product.id ? { ...p, status: previous } : p
       ))
     }
   }
@@ -209,7 +210,7 @@ export default function Products() {
       sizes: [],
       stock: 0,
       is_featured: false,
-      is_active: true,
+      status: 'published',
     })
     setShowModal(true)
   }
@@ -227,7 +228,7 @@ export default function Products() {
       sizes: [],
       stock: 0,
       is_featured: false,
-      is_active: true,
+      status: 'published',
     })
   }
 
@@ -254,7 +255,7 @@ export default function Products() {
       product.category?.name.toLowerCase().includes(searchTerm.toLowerCase())
     
     if (filterFeatured !== null && product.is_featured !== filterFeatured) return false
-    if (filterActive !== null && (product.is_active !== false) !== filterActive) return false
+    if (filterActive !== null && (product.status === 'published') !== filterActive) return false
     
     return matchesSearch
   })
@@ -388,9 +389,9 @@ export default function Products() {
                     </td>
                     <td className="px-6 py-4">
                       <span className={`inline-flex items-center px-2.5 py-1 rounded-full text-xs font-medium ${
-                        product.is_active !== false ? 'bg-green-100 text-green-700' : 'bg-gray-100 text-gray-500'
+                        product.status === 'published' ? 'bg-green-100 text-green-700' : 'bg-gray-100 text-gray-500'
                       }`}>
-                        {product.is_active !== false ? 'Active' : 'Inactive'}
+                        {product.status === 'published' ? 'Published' : product.status === 'archived' ? 'Archived' : 'Draft'}
                       </span>
                     </td>
                     <td className="px-6 py-4">
@@ -553,14 +554,17 @@ export default function Products() {
               />
               <span className="text-sm text-gray-700">Featured</span>
             </label>
-            <label className="flex items-center gap-2 cursor-pointer">
-              <input
-                type="checkbox"
-                checked={formData.is_active}
-                onChange={e => setFormData({ ...formData, is_active: e.target.checked })}
-                className="rounded border-gray-300 text-amber-600"
-              />
-              <span className="text-sm text-gray-700">Active</span>
+            <label className="flex items-center gap-2">
+              <span className="text-sm text-gray-700">Status</span>
+              <select
+                value={formData.status}
+                onChange={e => setFormData({ ...formData, status: e.target.value as Product['status'] })}
+                className="rounded border-gray-300 text-sm"
+              >
+                <option value="draft">Draft</option>
+                <option value="published">Published</option>
+                <option value="archived">Archived</option>
+              </select>
             </label>
           </div>
 
@@ -12,7 +12,7 @@ export interface Product {
   sizes: string[];
   stock: number;
   is_featured: boolean;
-  is_active: boolean;
+  status: 'draft' | 'published' | 'archived';
 }
 
 export interface Catalogue {
@@ -44,7 +44,7 @@ export interface CreateCatalogueInput {
     sizes?: string[];
     stock?: number;
     is_featured?: boolean;
-    is_active?: boolean;
+    status?: 'draft' | 'published' | 'archived';
   }[];
 }
 
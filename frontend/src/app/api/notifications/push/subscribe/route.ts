@@ -10,6 +10,7 @@ export async function POST(request: Request) {
       method: 'POST',
       headers: {
         'Content-Type': 'application/json',
+        Authorization: request.headers.get('Authorization') ?? '',
       },
       body: JSON.stringify(body),
     });
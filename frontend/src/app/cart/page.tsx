@@ -3,6 +3,7 @@
 import Link from 'next/link';
 import { useCart } from '@/context/CartContext';
 import { useCurrency } from '@/context/CurrencyContext';
+import { imageUrl } from '@/lib/api';
 import styles from './page.module.css';
 
 const PLACEHOLDER_IMAGE = "data:image/svg+xml,%3Csvg xmlns='http://www.w3.org/2000/svg' width='100' height='100' viewBox='0 0 24 24' fill='none' stroke='%23d1d5db' stroke-width='1.5' stroke-linecap='round' stroke-linejoin='round'%3E%3Crect x='3' y='3' width='18' height='18' rx='2' ry='2'/%3E%3Ccircle cx='8.5' cy='8.5' r='1.5'/%3E%3Cpolyline points='21 15 16 10 5 21'/%3E%3C/svg%3E"
@@ -34,7 +35,7 @@ export default function CartPage() {
               <div key={`${item.product.id}-${item.selectedSize}-${item.selectedColor}`} className={styles.item}>
                 <div className={styles.itemImage}>
                   <img 
-                    src={item.product.image || PLACEHOLDER_IMAGE} 
+                    src={imageUrl(item.product.image, 200) || PLACEHOLDER_IMAGE} 
                     alt={item.product.name}
                     onError={(e) => {
                       (e.target as HTMLImageElement).src = PLACEHOLDER_IMAGE
@@ -6,7 +6,7 @@ import Link from 'next/link';
 import Script from 'next/script';
 import { useCart, CartItem } from '@/context/CartContext';
 import { useCurrency } from '@/context/CurrencyContext';
-import { api } from '@/lib/api';
+import { api, imageUrl } from '@/lib/api';
 import styles from './checkout.module.css';
 
 const PLACEHOLDER_IMAGE = "data:image/svg+xml,%3Csvg xmlns='http://www.w3.org/2000/svg' width='100' height='100' viewBox='0 0 24 24' fill='none' stroke='%23d1d5db' stroke-width='1.5' stroke-linecap='round' stroke-linejoin='round'%3E%3Crect x='3' y='3' width='18' height='18' rx='2' ry='2'/%3E%3Ccircle cx='8.5' cy='8.5' r='1.5'/%3E%3Cpolyline points='21 15 16 10 5 21'/%3E%3C/svg%3E";
@@ -172,10 +172,10 @@ function CheckoutContent() {
     try {
       // Step 1: Create order in backend
       const orderData = {
-        user_id: null, // Will be set if user is logged in
         status: 'pending_payment',
         total_amount: finalTotal,
         shipping_cost: selectedRate?.rate || 0,
+        shipping_method: selectedRate?.service_type,
         currency: currency,
         shipping_name: form.name,
         shipping_email: form.email,
@@ -209,13 +209,9 @@ function CheckoutContent() {
         throw new Error('Payment configuration error');
       }
 
-      // Convert amount to paise for Razorpay
-      const amountInPaise = Math.round(finalTotal * 100);
-
       // Create Razorpay order
       const paymentIntent = await api.createPaymentIntent(
-        finalTotal,
-        currency.code,
+        orderId,
         `order_${orderId}`,
         { order_id: orderId }
       );
@@ -227,8 +223,9 @@ function CheckoutContent() {
       // Step 3: Initialize Razorpay checkout
       const options = {
         key: razorpayKey,
-        amount: amountInPaise,
-        currency: currency,
+        // Amount and currency come from the server-priced Razorpay order
+        amount: paymentIntent.amount,
+        currency: paymentIntent.currency,
         name: 'Pashmiya',
         description: `Order #${orderId}`,
         order_id: paymentIntent.id,
@@ -515,7 +512,7 @@ function CheckoutContent() {
                     <div key={`${item.product.id}-${item.selectedSize}-${item.selectedColor}`} className={styles.item}>
                       <div className={styles.itemImage}>
                         <img 
-                          src={item.product.image || PLACEHOLDER_IMAGE} 
+                          src={imageUrl(item.product.image, 200) || PLACEHOLDER_IMAGE} 
                           alt={item.product.name}
                           onError={(e) => {
                             (e.target as HTMLImageElement).src = PLACEHOLDER_IMAGE;
@@ -2,7 +2,7 @@
 
 import { useState, useEffect } from 'react';
 import { useParams, useRouter } from 'next/navigation';
-import { api, Product } from '@/lib/api';
+import { api, imageUrl, Product } from '@/lib/api';
 import { useCart } from '@/context/CartContext';
 import { useCurrency } from '@/context/CurrencyContext';
 import Link from 'next/link';
@@ -72,7 +72,7 @@ export default function ProductPage() {
           <div className={styles.imageWrapper}>
             {product.image ? (
               <img 
-                src={product.image} 
+                src={imageUrl(product.image, 1200)} 
                 alt={product.name}
                 onError={(e) => {
                   (e.target as HTMLImageElement).src = PLACEHOLDER_IMAGE
@@ -90,7 +90,15 @@ export default function ProductPage() {
         <div className={styles.details}>
           <span className={styles.category}>{product.category?.name}</span>
           <h1>{product.name}</h1>
-          <p className={styles.price}>{formatPrice(product.price)}</p>
+          <p className={styles.price}>
+            {product.sale_price !== undefined && product.sale_price < product.price && (
+              <span className={styles.listPrice}>{formatPrice(product.price)}</span>
+            )}
+            {formatPrice(product.sale_price ?? product.price)}
+          </p>
+          {product.promotions?.map(promotion => (
+            <p key={promotion.id} className={styles.promotion}>{promotion.name}</p>
+          ))}
           {isOutOfStock && (
             <p className={styles.outOfStock}>Out of Stock</p>
           )}
@@ -17,6 +17,7 @@ interface NotificationPreferences {
   email_enabled: boolean;
   sms_enabled: boolean;
   push_enabled: boolean;
+  updated_at?: string;
 }
 
 function urlBase64ToUint8Array(base64String: string): BufferSource {
@@ -48,7 +49,15 @@ export default function NotificationSettingsPage() {
     if (!preferences) return;
     setSaving(true);
     try {
-      await api.updateNotificationPreferences(preferences);
+      // Send only the preferences: the server refuses fields it doesn't let users change
+      const {
+        order_created, order_shipped, order_delivered, order_status, low_stock, product_updates,
+        newsletter, marketing, email_enabled, sms_enabled, push_enabled, updated_at,
+      } = preferences;
+      setPreferences(await api.updateNotificationPreferences({
+        order_created, order_shipped, order_delivered, order_status, low_stock, product_updates,
+        newsletter, marketing, email_enabled, sms_enabled, push_enabled, updated_at,
+      }));
       setSaved(true);
       setTimeout(() => setSaved(false), 3000);
     } catch (error) {
@@ -87,7 +96,10 @@ export default function NotificationSettingsPage() {
       const subJson = subscription.toJSON();
       await fetch('/api/notifications/push/subscribe', {
         method: 'POST',
-        headers: { 'Content-Type': 'application/json' },
+        headers: {
+          'Content-Type': 'application/json',
+          Authorization: `Bearer ${localStorage.getItem('token') ?? ''}`,
+        },
         body: JSON.stringify({
           endpoint: subscription.endpoint,
           p256dh: subJson.keys?.p256dh,
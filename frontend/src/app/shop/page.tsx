@@ -3,7 +3,7 @@
 import { useState, useMemo, useEffect, Suspense } from 'react';
 import { useSearchParams } from 'next/navigation';
 import Link from 'next/link';
-import { api, Product, Category, FilterOptions, getColorHex } from '@/lib/api';
+import { api, Product, Category, FilterOptions, getColorHex, descendantIds } from '@/lib/api';
 import ProductCard from '@/components/ProductCard';
 import CustomSelect from '@/components/CustomSelect';
 import { useCurrency } from '@/context/CurrencyContext';
@@ -74,7 +74,8 @@ function ShopContent() {
     let result = [...products];
 
     if (selectedCategory) {
-      result = result.filter(p => p.category_id === parseInt(selectedCategory) || p.category?.id === parseInt(selectedCategory));
+      const ids = descendantIds(categories, parseInt(selectedCategory));
+      result = result.filter(p => ids.has(p.category_id) || (p.category !== undefined && ids.has(p.category.id)));
     }
 
     if (selectedColor) {
@@ -98,7 +99,7 @@ function ShopContent() {
     }
 
     return result;
-  }, [products, selectedCategory, selectedColor, priceInput, sortBy]);
+  }, [products, categories, selectedCategory, selectedColor, priceInput, sortBy]);
 
   const totalPages = Math.ceil(filteredProducts.length / PRODUCTS_PER_PAGE);
   const paginatedProducts = useMemo(() => {
@@ -5,7 +5,7 @@ import { usePathname, useRouter } from 'next/navigation';
 import { useCart } from '@/context/CartContext';
 import { useCurrency } from '@/context/CurrencyContext';
 import { useState, useEffect, useRef } from 'react';
-import { api, Product, Category, Catalogue } from '@/lib/api';
+import { api, imageUrl, Product, Suggestion } from '@/lib/api';
 import styles from './Header.module.css';
 
 const PLACEHOLDER_IMAGE = "data:image/svg+xml,%3Csvg xmlns='http://www.w3.org/2000/svg' width='40' height='40' viewBox='0 0 24 24' fill='none' stroke='%23d1d5db' stroke-width='1.5' stroke-linecap='round' stroke-linejoin='round'%3E%3Crect x='3' y='3' width='18' height='18' rx='2' ry='2'/%3E%3Ccircle cx='8.5' cy='8.5' r='1.5'/%3E%3Cpolyline points='21 15 16 10 5 21'/%3E%3C/svg%3E"
@@ -94,34 +94,29 @@ export default function Header() {
           });
         }
 
-        const categories = await api.getCategories() as Category[];
-        if (categories) {
-          const matchingCategories = categories.filter((c: Category) =>
-            c.name.toLowerCase().includes(searchQuery.toLowerCase())
-          );
-          matchingCategories.slice(0, 3).forEach((category: Category) => {
+        const { suggestions } = await api.suggest(searchQuery);
+        suggestions
+          .filter((s: Suggestion) => s.type === 'category')
+          .slice(0, 3)
+          .forEach((s: Suggestion) => {
             results.push({
               type: 'category',
-              title: category.name,
+              title: s.text,
               subtitle: 'Category',
-              image: category.image,
-              link: `/shop?category=${category.id}`,
+              link: `/shop?category=${s.id}`,
             });
           });
-        }
-
-        const catalogues = await api.getCatalogues({ search: searchQuery }) as Catalogue[];
-        if (catalogues && catalogues.length > 0) {
-          catalogues.slice(0, 3).forEach((catalogue: Catalogue) => {
+        suggestions
+          .filter((s: Suggestion) => s.type === 'catalogue')
+          .slice(0, 3)
+          .forEach((s: Suggestion) => {
             results.push({
               type: 'collection',
-              title: catalogue.name,
+              title: s.text,
               subtitle: 'Collection',
-              image: catalogue.image,
-              link: `/catalogue/${catalogue.id}`,
+              link: `/catalogue/${s.id}`,
             });
           });
-        }
 
         const staticPages = [
           { title: 'Shop', link: '/shop', keywords: ['shop', 'products', 'buy', 'store', 'pashmina', 'shawl', 'scarf'] },
@@ -297,7 +292,7 @@ export default function Header() {
                       {result.image && (
                         <div className={styles.resultImage}>
                           <img 
-                            src={result.image} 
+                            src={imageUrl(result.image, 200)} 
                             alt={result.title}
                             onError={(e) => {
                               (e.target as HTMLImageElement).src = PLACEHOLDER_IMAGE
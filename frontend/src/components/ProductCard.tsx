@@ -5,6 +5,7 @@ import Link from 'next/link';
 import { useRouter } from 'next/navigation';
 import { useCurrency } from '@/context/CurrencyContext';
 import { useCart } from '@/context/CartContext';
+import { imageUrl } from '@/lib/api';
 import styles from './ProductCard.module.css';
 
 const PLACEHOLDER_IMAGE = "data:image/svg+xml,%3Csvg xmlns='http://www.w3.org/2000/svg' width='400' height='400' viewBox='0 0 24 24' fill='none' stroke='%23d1d5db' stroke-width='1.5' stroke-linecap='round' stroke-linejoin='round'%3E%3Crect x='3' y='3' width='18' height='18' rx='2' ry='2'/%3E%3Ccircle cx='8.5' cy='8.5' r='1.5'/%3E%3Cpolyline points='21 15 16 10 5 21'/%3E%3C/svg%3E"
@@ -13,6 +14,7 @@ interface Product {
   id: number | string;
   name: string;
   price: number;
+  sale_price?: number;
   description?: string;
   image: string;
   category?: string | { name: string };
@@ -37,7 +39,7 @@ export default function ProductCard({ product }: ProductCardProps) {
   
   const isOutOfStock = !product.stock || product.stock <= 0;
 
-  const imageSrc = product.image || PLACEHOLDER_IMAGE;
+  const imageSrc = imageUrl(product.image, 400) || PLACEHOLDER_IMAGE;
 
   const handleAddToCart = (e: React.MouseEvent) => {
     e.preventDefault();
@@ -120,7 +122,14 @@ export default function ProductCard({ product }: ProductCardProps) {
       <div className={styles.content}>
         <h3 className={styles.name}>{product.name}</h3>
         <p className={styles.price}>
-          {formatPrice(product.price)}
+          {product.sale_price !== undefined && product.sale_price < product.price ? (
+            <>
+              <span className={styles.listPrice}>{formatPrice(product.price)}</span>
+              {formatPrice(product.sale_price)}
+            </>
+          ) : (
+            formatPrice(product.price)
+          )}
         </p>
       </div>
     </Link>
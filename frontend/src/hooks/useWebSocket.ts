@@ -136,7 +136,10 @@ export function usePushNotifications() {
 
         await fetch('/api/notifications/push/subscribe', {
           method: 'POST',
-          headers: { 'Content-Type': 'application/json' },
+          headers: {
+            'Content-Type': 'application/json',
+            Authorization: `Bearer ${localStorage.getItem('token') ?? ''}`,
+          },
           body: JSON.stringify({
             endpoint: sub.endpoint,
             p256dh: sub.toJSON().keys?.p256dh,
@@ -2,20 +2,65 @@ const API_URL = process.env.NEXT_PUBLIC_API_URL || 'http://localhost:8080/api';
 
 export interface Product {
   id: number;
+  sku?: string;
   name: string;
   slug?: string;
   price: number;
   compare_price?: number;
   description: string;
   image: string;
-  images?: string[];
+  images?: ProductImage[];
   category_id: number;
   category?: { id: number; name: string };
   colors: string[];
   sizes: string[];
   stock: number;
   is_featured: boolean;
-  is_active: boolean;
+  status: 'draft' | 'published' | 'archived';
+  publish_at?: string | null;
+  unpublish_at?: string | null;
+  // Set on storefront listings when a running promotion lowers the price
+  sale_price?: number;
+  promotions?: PromotionSummary[];
+}
+
+export interface PromotionSummary {
+  id: number;
+  name: string;
+  description?: string;
+  type: 'percentage' | 'fixed' | 'tiered_spend' | 'free_gift';
+}
+
+export interface ProductImage {
+  id: number;
+  product_id: number;
+  media_id: number;
+  url: string;
+  alt_text: string;
+  position: number;
+  is_primary: boolean;
+}
+
+export interface SearchHit extends Product {
+  rank: number;
+  snippet: string;
+  name_highlight: string;
+}
+
+export interface SearchResponse {
+  products: SearchHit[];
+  total: number;
+  page: number;
+  limit: number;
+  query: string;
+  fuzzy: boolean;
+}
+
+export interface Suggestion {
+  type: 'product' | 'category' | 'catalogue' | 'query';
+  id?: number;
+  text: string;
+  slug?: string;
 }
 
 export interface Category {
@@ -25,6 +70,50 @@ export interface Category {
   description?: string;
   image?: string;
   is_active?: boolean;
+  parent_id?: number | null;
+  sort_order?: number;
+  children?: Category[];
+}
+
+export interface CategoryCrumb {
+  id: number;
+  name: string;
+  slug: string;
+}
+
+export interface CategoryDetail extends Category {
+  breadcrumb: CategoryCrumb[];
+}
+
+// descendantIds returns a category's ID and those of every category below it
+export function descendantIds(categories: Category[], id: number): Set<number> {
+  const ids = new Set([id]);
+  let added = true;
+  while (added) {
+    added = false;
+    for (const c of categories) {
+      if (c.parent_id && ids.has(c.parent_id) && !ids.has(c.id)) {
+        ids.add(c.id);
+        added = true;
+      }
+    }
+  }
+  return ids;
+}
+
+export interface FacetValue {
+  value: string;
+  count: number;
+}
+
+export interface Facets {
+  total: number;
+  colors: FacetValue[];
+  sizes: FacetValue[];
+  categories: { id: number; name: string; slug: string; count: number }[];
+  price_buckets: { min: number; max: number; count: number }[];
+  min_price: number;
+  max_price: number;
 }
 
 export interface FilterOptions {
@@ -32,6 +121,17 @@ export interface FilterOptions {
   sizes: string[];
   min_price: number;
   max_price: number;
+  facets: Facets;
+}
+
+export interface FilterParams {
+  q?: string;
+  category?: number;
+  color?: string[];
+  size?: string[];
+  min_price?: number;
+  max_price?: number;
+  in_stock?: boolean;
 }
 
 export interface User {
@@ -45,6 +145,9 @@ export interface User {
 export interface AuthResponse {
   user: User;
   token: string;
+  refresh_token: string;
+  expires_in: number;
+  session_id: number;
 }
 
 export interface Order {
@@ -63,7 +166,46 @@ export interface Order {
   shipping_zip: string;
   shipping_phone?: string;
   coupon_code?: string;
+  gift_card_amount?: number;
+  store_credit_amount?: number;
   items: OrderItem[];
+  status_history?: OrderStatusHistory[];
+  created_at: string;
+}
+
+export interface GiftCard {
+  id: number;
+  code: string;
+  initial_value: number;
+  balance: number;
+  currency: string;
+  status: 'pending' | 'active' | 'disabled' | 'cancelled';
+  expires_at?: string;
+  order_id?: number;
+  recipient_name: string;
+  recipient_email: string;
+  message: string;
+  created_at: string;
+}
+
+export interface StoreCreditEntry {
+  id: number;
+  amount: number;
+  balance_after: number;
+  kind: 'refund' | 'order_payment' | 'order_release' | 'adjustment';
+  order_id?: number;
+  reason?: string;
+  created_at: string;
+}
+
+export interface OrderStatusHistory {
+  id: number;
+  order_id: number;
+  from_status: string;
+  to_status: string;
+  source: 'admin' | 'customer' | 'webhook' | 'system';
+  actor_id?: number;
+  reason?: string;
   created_at: string;
 }
 
@@ -129,9 +271,10 @@ export interface ShippingRate {
 
 export interface Coupon {
   code: string;
-  discount_type: 'percentage' | 'fixed';
+  discount_type: 'percentage' | 'fixed' | 'buy_x_get_y' | 'free_shipping';
   discount_value: number;
   discount_amount: number;
+  free_shipping?: boolean;
   min_order_amount: number;
   max_discount_amount?: number;
 }
@@ -175,6 +318,7 @@ export interface NotificationPreference {
   email_enabled: boolean;
   sms_enabled: boolean;
   push_enabled: boolean;
+  updated_at?: string;
 }
 
 export const colorMap: Record<string, string> = {
@@ -217,6 +361,13 @@ export function getColorHex(colorName: string): string {
   return colorMap[colorName] || '#808080';
 }
 
+// imageUrl asks for an uploaded image scaled to a display width. Images hosted
+// elsewhere are returned unchanged.
+export function imageUrl(src: string, width: number): string {
+  if (!src || !src.includes('/uploads/')) return src;
+  return `${src}${src.includes('?') ? '&' : '?'}w=${width}`;
+}
+
 class ApiError extends Error {
   status: number;
   constructor(message: string, status: number) {
@@ -236,9 +387,20 @@ const setAuthToken = (token: string) => {
   localStorage.setItem('token', token);
 };
 
+const getRefreshToken = (): string | null => {
+  if (typeof window === 'undefined') return null;
+  return localStorage.getItem('refresh_token');
+};
+
+const setRefreshToken = (token: string) => {
+  if (typeof window === 'undefined') return;
+  localStorage.setItem('refresh_token', token);
+};
+
 const removeAuthToken = () => {
   if (typeof window === 'undefined') return;
   localStorage.removeItem('token');
+  localStorage.removeItem('refresh_token');
   localStorage.removeItem('user');
 };
 
@@ -247,6 +409,37 @@ const getAuthHeaders = (): Record<string, string> => {
   return token ? { Authorization: `Bearer ${token}` } : {};
 };
 
+// Requests that fail together share one refresh, since each refresh token can
+// only be used once
+let refreshing: Promise<string | null> | null = null;
+
+const refreshSession = (): Promise<string | null> => {
+  if (!refreshing) {
+    refreshing = authApi
+      .refreshToken()
+      .then((data) => data.token || null)
+      .catch(() => null)
+      .finally(() => {
+        refreshing = null;
+      });
+  }
+  return refreshing;
+};
+
+// Access tokens are short-lived, so a signed-in request that comes back 401 gets
+// the session refreshed and is retried once with the new token
+const authFetch = async (url: string, init: RequestInit = {}): Promise<Response> => {
+  const res = await fetch(url, init);
+  if (res.status !== 401 || !getRefreshToken()) return res;
+
+  const token = await refreshSession();
+  if (!token) return res;
+
+  const headers = new Headers(init.headers);
+  headers.set('Authorization', `Bearer ${token}`);
+  return fetch(url, { ...init, headers });
+};
+
 const handleResponse = async (res: Response) => {
   if (res.status === 401) {
     removeAuthToken();
@@ -279,6 +472,7 @@ export const authApi = {
     const data = await handleResponse(res);
     if (data.token) {
       setAuthToken(data.token);
+      setRefreshToken(data.refresh_token);
       localStorage.setItem('user', JSON.stringify(data.user));
     }
     return data;
@@ -293,43 +487,47 @@ export const authApi = {
     const data = await handleResponse(res);
     if (data.token) {
       setAuthToken(data.token);
+      setRefreshToken(data.refresh_token);
       localStorage.setItem('user', JSON.stringify(data.user));
     }
     return data;
   },
 
-  async logout(): Promise<void> {
+  async logout(allDevices = false): Promise<void> {
     try {
       await fetch(`${API_URL}/auth/logout`, {
         method: 'POST',
-        headers: { ...getAuthHeaders() },
+        headers: { 'Content-Type': 'application/json', ...getAuthHeaders() },
+        body: JSON.stringify({ refresh_token: getRefreshToken(), all_devices: allDevices }),
       });
     } finally {
       removeAuthToken();
     }
   },
 
-  async refreshToken(): Promise<{ token: string }> {
+  async refreshToken(): Promise<{ token: string; refresh_token: string; expires_in: number }> {
     const res = await fetch(`${API_URL}/auth/refresh`, {
       method: 'POST',
-      headers: { ...getAuthHeaders() },
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ refresh_token: getRefreshToken() }),
     });
     const data = await handleResponse(res);
     if (data.token) {
       setAuthToken(data.token);
+      setRefreshToken(data.refresh_token);
     }
     return data;
   },
 
   async getCurrentUser(): Promise<User> {
-    const res = await fetch(`${API_URL}/user/me`, {
+    const res = await authFetch(`${API_URL}/user/me`, {
       headers: { ...getAuthHeaders() },
     });
     return handleResponse(res);
   },
 
   async updateCurrentUser(data: { name?: string; phone?: string }): Promise<User> {
-    const res = await fetch(`${API_URL}/user/me`, {
+    const res = await authFetch(`${API_URL}/user/me`, {
       method: 'PUT',
       headers: { 
         'Content-Type': 'application/json',
@@ -376,8 +574,16 @@ export const api = {
     return handleResponse(res);
   },
 
-  async searchProducts(query: string): Promise<Product[]> {
-    const res = await fetch(`${API_URL}/products/search?q=${encodeURIComponent(query)}`);
+  async searchProducts(query: string, filters?: Record<string, string>): Promise<SearchHit[]> {
+    const params = new URLSearchParams({ ...filters, q: query });
+    const res = await fetch(`${API_URL}/products/search?${params}`);
+    const data: SearchResponse = await handleResponse(res);
+    return data.products;
+  },
+
+  async suggest(query: string, limit = 8): Promise<{ query: string; suggestions: Suggestion[] }> {
+    const params = new URLSearchParams({ q: query, limit: limit.toString() });
+    const res = await fetch(`${API_URL}/products/suggest?${params}`);
     return handleResponse(res);
   },
 
@@ -386,8 +592,23 @@ export const api = {
     return handleResponse(res);
   },
 
-  async getFilterOptions(): Promise<FilterOptions> {
-    const res = await fetch(`${API_URL}/filters`);
+  async getCategoryTree(): Promise<Category[]> {
+    const res = await fetch(`${API_URL}/categories/tree`);
+    return handleResponse(res);
+  },
+
+  async getCategory(slug: string): Promise<CategoryDetail> {
+    const res = await fetch(`${API_URL}/categories/${encodeURIComponent(slug)}`);
+    return handleResponse(res);
+  },
+
+  async getFilterOptions(filters?: FilterParams): Promise<FilterOptions> {
+    const params = new URLSearchParams();
+    Object.entries(filters ?? {}).forEach(([key, value]) => {
+      if (value === undefined || value === '' || value === false) return;
+      params.set(key, Array.isArray(value) ? value.join(',') : String(value));
+    });
+    const res = await fetch(`${API_URL}/filters?${params}`);
     return handleResponse(res);
   },
 
@@ -425,7 +646,7 @@ export const api = {
   },
 
   async createReview(data: { product_id: number; rating: number; title?: string; comment?: string }): Promise<Review> {
-    const res = await fetch(`${API_URL}/reviews`, {
+    const res = await authFetch(`${API_URL}/reviews`, {
       method: 'POST',
       headers: { 
         'Content-Type': 'application/json',
@@ -437,7 +658,7 @@ export const api = {
   },
 
   async updateReview(id: number, data: { rating?: number; title?: string; comment?: string }): Promise<Review> {
-    const res = await fetch(`${API_URL}/reviews/${id}`, {
+    const res = await authFetch(`${API_URL}/reviews/${id}`, {
       method: 'PUT',
       headers: { 
         'Content-Type': 'application/json',
@@ -449,7 +670,7 @@ export const api = {
   },
 
   async deleteReview(id: number): Promise<void> {
-    const res = await fetch(`${API_URL}/reviews/${id}`, {
+    const res = await authFetch(`${API_URL}/reviews/${id}`, {
       method: 'DELETE',
       headers: { ...getAuthHeaders() },
     });
@@ -457,14 +678,14 @@ export const api = {
   },
 
   async getWishlist(): Promise<WishlistItem[]> {
-    const res = await fetch(`${API_URL}/wishlist`, {
+    const res = await authFetch(`${API_URL}/wishlist`, {
       headers: { ...getAuthHeaders() },
     });
     return handleResponse(res);
   },
 
   async addToWishlist(productId: number): Promise<{ message: string }> {
-    const res = await fetch(`${API_URL}/wishlist`, {
+    const res = await authFetch(`${API_URL}/wishlist`, {
       method: 'POST',
       headers: { 
         'Content-Type': 'application/json',
@@ -476,7 +697,7 @@ export const api = {
   },
 
   async removeFromWishlist(productId: number): Promise<{ message: string }> {
-    const res = await fetch(`${API_URL}/wishlist/${productId}`, {
+    const res = await authFetch(`${API_URL}/wishlist/${productId}`, {
       method: 'DELETE',
       headers: { ...getAuthHeaders() },
     });
@@ -484,14 +705,14 @@ export const api = {
   },
 
   async getAddresses(): Promise<Address[]> {
-    const res = await fetch(`${API_URL}/addresses`, {
+    const res = await authFetch(`${API_URL}/addresses`, {
       headers: { ...getAuthHeaders() },
     });
     return handleResponse(res);
   },
 
   async createAddress(address: Omit<Address, 'id' | 'user_id'>): Promise<Address> {
-    const res = await fetch(`${API_URL}/addresses`, {
+    const res = await authFetch(`${API_URL}/addresses`, {
       method: 'POST',
       headers: { 
         'Content-Type': 'application/json',
@@ -503,7 +724,7 @@ export const api = {
   },
 
   async updateAddress(id: number, address: Partial<Address>): Promise<Address> {
-    const res = await fetch(`${API_URL}/addresses/${id}`, {
+    const res = await authFetch(`${API_URL}/addresses/${id}`, {
       method: 'PUT',
       headers: { 
         'Content-Type': 'application/json',
@@ -515,7 +736,7 @@ export const api = {
   },
 
   async deleteAddress(id: number): Promise<{ message: string }> {
-    const res = await fetch(`${API_URL}/addresses/${id}`, {
+    const res = await authFetch(`${API_URL}/addresses/${id}`, {
       method: 'DELETE',
       headers: { ...getAuthHeaders() },
     });
@@ -526,25 +747,26 @@ export const api = {
     const query = new URLSearchParams();
     if (params?.status) query.set('status', params.status);
     
-    const res = await fetch(`${API_URL}/orders?${query}`, {
+    const res = await authFetch(`${API_URL}/orders?${query}`, {
       headers: { ...getAuthHeaders() },
     });
     return handleResponse(res);
   },
 
   async getOrder(id: string): Promise<Order> {
-    const res = await fetch(`${API_URL}/orders/${id}`, {
+    const res = await authFetch(`${API_URL}/orders/${id}`, {
       headers: { ...getAuthHeaders() },
     });
     return handleResponse(res);
   },
 
   async createOrder(orderData: {
-    items: { product_id: number; quantity: number; price: number; color?: string; size?: string }[];
-    total_amount: number;
+    items: { product_id: number; quantity: number; price?: number; color?: string; size?: string }[];
+    total_amount?: number;
     discount_amount?: number;
     shipping_cost?: number;
     tax_amount?: number;
+    shipping_method?: string;
     shipping_name: string;
     shipping_address: string;
     shipping_city: string;
@@ -554,9 +776,19 @@ export const api = {
     shipping_phone: string;
     shipping_email?: string;
     coupon_code?: string;
+    gift_card_code?: string;
+    use_store_credit?: boolean;
     notes?: string;
-  }): Promise<{ order_id: number; status: string; message: string }> {
-    const res = await fetch(`${API_URL}/orders`, {
+  }): Promise<{
+    order_id: number;
+    status: string;
+    message: string;
+    pricing?: { total_amount: number };
+    gift_card_amount: number;
+    store_credit_amount: number;
+    amount_due: number;
+  }> {
+    const res = await authFetch(`${API_URL}/orders`, {
       method: 'POST',
       headers: {
         'Content-Type': 'application/json',
@@ -567,16 +799,72 @@ export const api = {
     return handleResponse(res);
   },
 
-  async cancelOrder(orderId: string): Promise<{ order_id: number; status: string; message: string }> {
-    const res = await fetch(`${API_URL}/orders/${orderId}/cancel`, {
+  async cancelOrder(
+    orderId: string,
+    refundMethod: 'original' | 'store_credit' = 'original'
+  ): Promise<{ order_id: number; status: string; refunded_as_credit: number; message: string }> {
+    const res = await authFetch(`${API_URL}/orders/${orderId}/cancel`, {
       method: 'POST',
+      headers: {
+        'Content-Type': 'application/json',
+        ...getAuthHeaders()
+      },
+      body: JSON.stringify({ refund_method: refundMethod }),
+    });
+    return handleResponse(res);
+  },
+
+  async getStoreCredit(): Promise<{ balance: number; entries: StoreCreditEntry[] }> {
+    const res = await authFetch(`${API_URL}/user/store-credit`, {
       headers: { ...getAuthHeaders() },
     });
     return handleResponse(res);
   },
 
+  async getMyGiftCards(): Promise<GiftCard[]> {
+    const res = await authFetch(`${API_URL}/gift-cards`, {
+      headers: { ...getAuthHeaders() },
+    });
+    return handleResponse(res);
+  },
+
+  async purchaseGiftCard(data: {
+    amount: number;
+    recipient_name: string;
+    recipient_email: string;
+    message?: string;
+  }): Promise<{ order_id: number; status: string; amount_due: number; gift_card: GiftCard; message: string }> {
+    const res = await authFetch(`${API_URL}/gift-cards`, {
+      method: 'POST',
+      headers: {
+        'Content-Type': 'application/json',
+        ...getAuthHeaders()
+      },
+      body: JSON.stringify(data),
+    });
+    return handleResponse(res);
+  },
+
+  async checkGiftCard(code: string): Promise<{
+    valid: boolean;
+    balance: number;
+    currency: string;
+    expires_at?: string;
+    error?: string;
+  }> {
+    const res = await authFetch(`${API_URL}/gift-cards/check`, {
+      method: 'POST',
+      headers: {
+        'Content-Type': 'application/json',
+        ...getAuthHeaders()
+      },
+      body: JSON.stringify({ code }),
+    });
+    return handleResponse(res);
+  },
+
   async getOrderTracking(orderId: string) {
-    const res = await fetch(`${API_URL}/orders/${orderId}/tracking`, {
+    const res = await authFetch(`${API_URL}/orders/${orderId}/tracking`, {
       headers: { ...getAuthHeaders() },
     });
     return handleResponse(res);
@@ -595,18 +883,20 @@ export const api = {
     const query = new URLSearchParams({ code });
     if (amount) query.set('amount', amount.toString());
     
-    const res = await fetch(`${API_URL}/coupons/validate?${query}`);
+    const res = await authFetch(`${API_URL}/coupons/validate?${query}`, {
+      headers: { ...getAuthHeaders() },
+    });
     return handleResponse(res);
   },
 
-  async createPaymentIntent(amount: number, currency: string, receipt?: string, notes?: Record<string, any>) {
-    const res = await fetch(`${API_URL}/payments/create-intent`, {
+  async createPaymentIntent(orderId: number, receipt?: string, notes?: Record<string, any>) {
+    const res = await authFetch(`${API_URL}/payments/create-intent`, {
       method: 'POST',
       headers: { 
         'Content-Type': 'application/json',
         ...getAuthHeaders() 
       },
-      body: JSON.stringify({ amount, currency, receipt, notes }),
+      body: JSON.stringify({ order_id: orderId, receipt, notes }),
     });
     return handleResponse(res);
   },
@@ -621,21 +911,21 @@ export const api = {
   },
 
   async getPaymentStatus(paymentId: string) {
-    const res = await fetch(`${API_URL}/payments/${paymentId}/status`, {
+    const res = await authFetch(`${API_URL}/payments/${paymentId}/status`, {
       headers: { ...getAuthHeaders() },
     });
     return handleResponse(res);
   },
 
   async getNotificationPreferences(): Promise<NotificationPreference> {
-    const res = await fetch(`${API_URL}/notifications/preferences`, {
+    const res = await authFetch(`${API_URL}/notifications/preferences`, {
       headers: { ...getAuthHeaders() },
     });
     return handleResponse(res);
   },
 
   async updateNotificationPreferences(preferences: Partial<NotificationPreference>): Promise<NotificationPreference> {
-    const res = await fetch(`${API_URL}/notifications/preferences`, {
+    const res = await authFetch(`${API_URL}/notifications/preferences`, {
       method: 'PUT',
       headers: { 
         'Content-Type': 'application/json',
@@ -647,14 +937,14 @@ export const api = {
   },
 
   async getNotifications(): Promise<{ notifications: Notification[]; unread_count: number }> {
-    const res = await fetch(`${API_URL}/notifications/user`, {
+    const res = await authFetch(`${API_URL}/notifications/user`, {
       headers: { ...getAuthHeaders() },
     });
     return handleResponse(res);
   },
 
   async markNotificationAsRead(id: number): Promise<void> {
-    const res = await fetch(`${API_URL}/notifications/${id}/read`, {
+    const res = await authFetch(`${API_URL}/notifications/${id}/read`, {
       method: 'PUT',
       headers: { ...getAuthHeaders() },
     });
@@ -666,7 +956,7 @@ export const api = {
   },
 
   async markAllNotificationsAsRead(): Promise<void> {
-    const res = await fetch(`${API_URL}/notifications/read-all`, {
+    const res = await authFetch(`${API_URL}/notifications/read-all`, {
       method: 'PUT',
       headers: { ...getAuthHeaders() },
     });
@@ -678,7 +968,7 @@ export const api = {
   },
 
   async deleteNotification(id: number): Promise<void> {
-    const res = await fetch(`${API_URL}/notifications/${id}`, {
+    const res = await authFetch(`${API_URL}/notifications/${id}`, {
       method: 'DELETE',
       headers: { ...getAuthHeaders() },
     });
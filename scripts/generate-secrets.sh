@@ -13,10 +13,13 @@ echo "JWT_SECRET=$JWT_SECRET"
 echo ""
 
 # Generate VAPID Keys (for push notifications)
-# Note: In production, use web-push generate-vapid-keys
-echo "VAPID Keys (generate using: npx web-push generate-vapid-keys)"
-echo "VAPID_PUBLIC_KEY=YOUR_VAPID_PUBLIC_KEY"
-echo "VAPID_PRIVATE_KEY=YOUR_VAPID_PRIVATE_KEY"
+if command -v go >/dev/null 2>&1; then
+  (cd "$(dirname "$0")/../backend" && go run ./cmd/vapid)
+else
+  echo "VAPID Keys (generate using: cd backend && go run ./cmd/vapid)"
+  echo "VAPID_PUBLIC_KEY=YOUR_VAPID_PUBLIC_KEY"
+  echo "VAPID_PRIVATE_KEY=YOUR_VAPID_PRIVATE_KEY"
+fi
 echo ""
 
 # Generate Razorpay Webhook Secret